@@ -168,6 +168,9 @@ func HandleAdmin(w http.ResponseWriter, r *http.Request) {
 		{ID: "server", Title: "Server", Icon: "🖥️", Method: "grain_updateserver", Config: cfg.Server},
 		{ID: "whitelist", Title: "Whitelist", Icon: "✅", Method: "grain_updatewhitelistconfig", Config: wl},
 		{ID: "blacklist", Title: "Blacklist", Icon: "⛔", Method: "grain_updateblacklistconfig", Config: cfg.Blacklist},
+		{ID: "database", Title: "Database", Icon: "🗄️", Method: "", Config: nil},
+		{ID: "reports", Title: "Reports", Icon: "🚩", Method: "", Config: nil},
+		{ID: "audit_log", Title: "Audit log", Icon: "🧾", Method: "", Config: nil},
 		{ID: "ops", Title: "Operations", Icon: "🛠️", Method: "", Config: nil},
 	}
 
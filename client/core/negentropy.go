@@ -0,0 +1,137 @@
+package core
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/0ceanslim/grain/server/negentropy"
+	nostr "github.com/0ceanslim/grain/server/types"
+	"github.com/0ceanslim/grain/server/utils/log"
+)
+
+// negSyncMaxRounds bounds a single reconciliation. Each round at least
+// halves the disagreeing ranges or drains a frame's worth of IDs, so a
+// legitimate session finishes long before this; hitting it means the
+// peer is misbehaving.
+const negSyncMaxRounds = 10000
+
+// negSyncFrameSizeLimit caps each outgoing NEG-MSG (before hex). Relays
+// bound inbound websocket frames — grain by rate_limit.max_event_size —
+// so the initiator keeps its frames well under typical limits and lets
+// extra round trips absorb the difference.
+const negSyncFrameSizeLimit = 60000
+
+// NegMessage is a NIP-77 reply routed to an open negentropy session.
+// Exactly one of Payload (decoded NEG-MSG body) or Err (NEG-ERR
+// reason) is set.
+type NegMessage struct {
+	RelayURL string
+	Payload  []byte
+	Err      string
+}
+
+// RegisterNegSession opens a routing slot for NEG-MSG / NEG-ERR frames
+// addressed to subID.
+func (mr *MessageRouter) RegisterNegSession(subID string) <-chan NegMessage {
+	ch := make(chan NegMessage, 4)
+	mr.mu.Lock()
+	mr.negSessions[subID] = ch
+	mr.mu.Unlock()
+	return ch
+}
+
+// UnregisterNegSession removes a negentropy routing slot.
+func (mr *MessageRouter) UnregisterNegSession(subID string) {
+	mr.mu.Lock()
+	delete(mr.negSessions, subID)
+	mr.mu.Unlock()
+}
+
+// RouteNegMessage delivers a NIP-77 frame to its session, if any.
+func (mr *MessageRouter) RouteNegMessage(subID string, msg NegMessage) {
+	mr.mu.RLock()
+	ch, exists := mr.negSessions[subID]
+	mr.mu.RUnlock()
+
+	if !exists {
+		log.ClientCore().Debug("No negentropy session for message", "sub_id", subID, "relay", msg.RelayURL)
+		return
+	}
+
+	select {
+	case ch <- msg:
+	default:
+		// The session is strictly request/response, so a full channel
+		// means the peer sent unsolicited frames.
+		log.ClientCore().Warn("Negentropy session channel full", "sub_id", subID, "relay", msg.RelayURL)
+	}
+}
+
+// Reconcile runs a NIP-77 negentropy session against one connected
+// relay. storage holds the local (created_at, id) set for filter and
+// must be sealed. It returns the hex IDs only we have (have) and the
+// IDs only the relay has (need); no events are transferred. Each round
+// waits at most the pool's ReadTimeout for the relay to answer.
+func (rp *RelayPool) Reconcile(ctx context.Context, url string, filter nostr.Filter, storage *negentropy.Vector) (have, need []string, err error) {
+	ne, err := negentropy.New(storage, negSyncFrameSizeLimit)
+	if err != nil {
+		return nil, nil, err
+	}
+	msg, err := ne.Initiate()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	subID := "neg_" + generateSubscriptionID()
+	replies := rp.messageRouter.RegisterNegSession(subID)
+	defer func() {
+		rp.messageRouter.UnregisterNegSession(subID)
+		_ = rp.SendMessage(url, []interface{}{"NEG-CLOSE", subID})
+	}()
+
+	open := []interface{}{"NEG-OPEN", subID, filter.ToSubscriptionFilter(), hex.EncodeToString(msg)}
+	if err := rp.SendMessage(url, open); err != nil {
+		return nil, nil, err
+	}
+
+	for round := 0; ; round++ {
+		if round >= negSyncMaxRounds {
+			return nil, nil, fmt.Errorf("negentropy with %s did not converge after %d rounds", url, round)
+		}
+
+		var reply NegMessage
+		select {
+		case reply = <-replies:
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(rp.config.ReadTimeout):
+			return nil, nil, fmt.Errorf("timeout waiting for negentropy reply from %s", url)
+		}
+		if reply.Err != "" {
+			return nil, nil, fmt.Errorf("relay %s: %s", url, reply.Err)
+		}
+
+		next, h, n, err := ne.ReconcileWithIDs(reply.Payload)
+		if err != nil {
+			return nil, nil, fmt.Errorf("negentropy reconcile with %s: %w", url, err)
+		}
+		for _, id := range h {
+			have = append(have, hex.EncodeToString(id[:]))
+		}
+		for _, id := range n {
+			need = append(need, hex.EncodeToString(id[:]))
+		}
+
+		if next == nil {
+			log.ClientCore().Debug("Negentropy reconciliation complete",
+				"relay", url, "rounds", round+1, "have", len(have), "need", len(need))
+			return have, need, nil
+		}
+
+		if err := rp.SendMessage(url, []interface{}{"NEG-MSG", subID, hex.EncodeToString(next)}); err != nil {
+			return nil, nil, err
+		}
+	}
+}
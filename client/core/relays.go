@@ -1,6 +1,7 @@
 package core
 
 import (
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net"
@@ -46,6 +47,7 @@ type RelayConnection struct {
 // MessageRouter handles routing messages to subscriptions
 type MessageRouter struct {
 	subscriptions map[string]*Subscription
+	negSessions   map[string]chan NegMessage // NIP-77 sessions, see negentropy.go
 	mu            sync.RWMutex
 }
 
@@ -53,6 +55,7 @@ type MessageRouter struct {
 func NewMessageRouter() *MessageRouter {
 	return &MessageRouter{
 		subscriptions: make(map[string]*Subscription),
+		negSessions:   make(map[string]chan NegMessage),
 	}
 }
 
@@ -481,6 +484,26 @@ func (rc *RelayConnection) processMessage(message string) error {
 			}
 			log.ClientCore().Info("Relay notice", "relay", rc.URL, "notice", notice)
 		}
+	case "NEG-MSG", "NEG-ERR":
+		if len(messageArray) >= 3 {
+			subID, ok := messageArray[1].(string)
+			if !ok {
+				return fmt.Errorf("invalid subscription ID in %s", messageType)
+			}
+			body, _ := messageArray[2].(string)
+
+			msg := NegMessage{RelayURL: rc.URL}
+			if messageType == "NEG-ERR" {
+				msg.Err = body
+			} else {
+				payload, err := hex.DecodeString(body)
+				if err != nil {
+					msg.Err = "invalid: NEG-MSG payload is not hex"
+				}
+				msg.Payload = payload
+			}
+			rc.messageRouter.RouteNegMessage(subID, msg)
+		}
 	case "OK":
 		if len(messageArray) >= 3 {
 			log.ClientCore().Debug("Received OK message", "relay", rc.URL)
@@ -19,6 +19,7 @@ import (
 	"strings"
 
 	"github.com/0ceanslim/grain/client/core/tools"
+	"github.com/0ceanslim/grain/server/audit"
 	"github.com/0ceanslim/grain/server/utils"
 	"github.com/0ceanslim/grain/server/utils/log"
 )
@@ -81,7 +82,16 @@ func handleSetupPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	claim := audit.Entry{
+		Source:  audit.SourceSetup,
+		Method:  "claim",
+		Signer:  hexPub,
+		IP:      utils.GetClientIP(r),
+		Section: "relay_metadata.json",
+	}
 	if err := utils.SetRelayOwner(hexPub); err != nil {
+		claim.Error = err.Error()
+		audit.Record(claim)
 		if errors.Is(err, utils.ErrOwnerAlreadySet) {
 			// Race lost (or operator double-clicked). Return the
 			// current claimant so the JS can swap to the
@@ -102,6 +112,9 @@ func handleSetupPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	claim.Changes = audit.Diff(map[string]any{"pubkey": nil}, map[string]any{"pubkey": hexPub})
+	audit.Record(claim)
+
 	log.ClientAPI().Info("Relay ownership claimed via /setup",
 		"client_ip", utils.GetClientIP(r),
 		"pubkey", hexPub)
@@ -38,6 +38,13 @@ func CheckBlacklistCached(pubkey, eventContent string) (bool, string) {
 		return true, "blocked: pubkey is temporarily blacklisted"
 	}
 
+	// With moderation.hold_ban_words on, a ban-word match holds the
+	// event for review (HandleEvent, via MatchBanWord) instead of
+	// banning the author here.
+	if sc := GetConfig(); sc != nil && sc.Moderation.HoldBanWords {
+		return false, ""
+	}
+
 	// Check for permanent ban based on content (wordlist)
 	for _, word := range blacklistConfig.PermanentBanWords {
 		if strings.Contains(eventContent, word) {
@@ -81,6 +88,23 @@ func CheckBlacklistCached(pubkey, eventContent string) (bool, string) {
 	return false, ""
 }
 
+// MatchBanWord returns the first permanent or temporary ban word in
+// content, or "" if none matches or the blacklist is off.
+func MatchBanWord(content string) string {
+	blacklistConfig := GetBlacklistConfig()
+	if blacklistConfig == nil || !blacklistConfig.Enabled {
+		return ""
+	}
+	for _, words := range [][]string{blacklistConfig.PermanentBanWords, blacklistConfig.TempBanWords} {
+		for _, word := range words {
+			if word != "" && strings.Contains(content, word) {
+				return word
+			}
+		}
+	}
+	return ""
+}
+
 // Checks if a pubkey is temporarily blacklisted
 func isPubKeyTemporarilyBlacklisted(pubkey string) bool {
 	mu.Lock()
@@ -278,6 +278,21 @@ func GetBlockedIPs() []string {
 	return out
 }
 
+// IPBanCounts returns the number of permanently blocked CIDRs and of
+// IPs currently serving a temp ban. Used for /metrics.
+func IPBanCounts() (permanent, temp int) {
+	ipMu.Lock()
+	defer ipMu.Unlock()
+
+	now := time.Now()
+	for _, entry := range ipTempBans {
+		if now.Before(entry.unbanTime) {
+			temp++
+		}
+	}
+	return len(permanentPrefixes), temp
+}
+
 // IsIPBlocked returns (true, reason) if the given IP string matches any
 // permanent CIDR or has an active temp ban. The reason is suitable for
 // log attribution.
@@ -140,6 +140,9 @@ func UpdateRateLimitConfig(rl cfgType.RateLimitConfig) error {
 // UpdateEventPurgeConfig stages a new event-purge configuration.
 // Purge timers stay on the old schedule until reload.
 func UpdateEventPurgeConfig(ep cfgType.EventPurgeConfig) error {
+	if err := ep.ValidateRules(); err != nil {
+		return err
+	}
 	ConfigMu.Lock()
 	defer ConfigMu.Unlock()
 	c := GetConfig()
@@ -193,29 +196,73 @@ func UpdateAuthConfig(au cfgType.AuthConfig) error {
 }
 
 // UpdateBackupRelayConfig stages the backup-relay forwarding
-// settings. The backup-relay goroutine reads these at startup; a
-// reload reinitializes it.
+// settings. The replication manager reads these at startup; a
+// reload reinitializes it (queued events survive — the outbox is
+// on disk, keyed by URL).
 //
-// Hard validation: when Enabled is true, URLs must be non-empty
-// and every entry must be a WebSocket URL (ws:// or wss://).
-// SendToBackupRelay does a websocket.Dial per URL and will log +
-// return an error every event if any URL is malformed; we reject
-// the write up-front so the operator finds out at save time, not
-// in a flood of relay logs.
+// Hard validation: when Enabled is true, at least one destination
+// (plain URL or filtered target) must be set, and every URL must be
+// a WebSocket URL (ws:// or wss://). A bad URL would otherwise sit
+// in a dial/backoff loop forever with its outbox growing; we reject
+// the write up-front so the operator finds out at save time, not in
+// a flood of relay logs. Target authors must be 64-char hex and
+// kinds non-negative, and a URL can't be listed twice — two outboxes
+// for one relay would just deliver everything twice.
 func UpdateBackupRelayConfig(br cfgType.BackupRelayConfig) error {
+	seen := make(map[string]bool)
+	checkURL := func(u string) error {
+		if !strings.HasPrefix(u, "ws://") && !strings.HasPrefix(u, "wss://") {
+			return fmt.Errorf("each url must start with ws:// or wss:// (got %q)", u)
+		}
+		if seen[u] {
+			return fmt.Errorf("url %q is listed more than once", u)
+		}
+		seen[u] = true
+		return nil
+	}
+
 	cleaned := br.URLs[:0]
 	for _, u := range br.URLs {
 		u = strings.TrimSpace(u)
 		if u == "" {
 			continue
 		}
-		if !strings.HasPrefix(u, "ws://") && !strings.HasPrefix(u, "wss://") {
-			return fmt.Errorf("each url must start with ws:// or wss:// (got %q)", u)
+		if err := checkURL(u); err != nil {
+			return err
 		}
 		cleaned = append(cleaned, u)
 	}
 	br.URLs = cleaned
-	if br.Enabled && len(br.URLs) == 0 {
+
+	targets := br.Targets[:0]
+	for _, t := range br.Targets {
+		t.URL = strings.TrimSpace(t.URL)
+		if t.URL == "" {
+			continue
+		}
+		if err := checkURL(t.URL); err != nil {
+			return err
+		}
+		for _, k := range t.Kinds {
+			if k < 0 {
+				return fmt.Errorf("target %s: kinds must be non-negative (got %d)", t.URL, k)
+			}
+		}
+		for i, a := range t.Authors {
+			a = strings.ToLower(strings.TrimSpace(a))
+			if len(a) != 64 || strings.Trim(a, "0123456789abcdef") != "" {
+				return fmt.Errorf("target %s: authors must be 64-char hex pubkeys (got %q)", t.URL, a)
+			}
+			t.Authors[i] = a
+		}
+		targets = append(targets, t)
+	}
+	br.Targets = targets
+
+	if br.MaxQueueMB < 0 {
+		return fmt.Errorf("max_queue_mb must be >= 0 (0 = default)")
+	}
+	if br.Enabled && len(br.URLs) == 0 && len(br.Targets) == 0 {
 		return fmt.Errorf("at least one url must be set when backup relay is enabled")
 	}
 	ConfigMu.Lock()
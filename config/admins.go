@@ -0,0 +1,95 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	cfgType "github.com/0ceanslim/grain/config/types"
+	"github.com/0ceanslim/grain/server/utils/log"
+)
+
+// AdminRole returns the role config.yml's admins list grants pubkey,
+// or "" if it has none. It doesn't know about the relay owner in
+// relay_metadata.json; server/api adds that on top.
+func AdminRole(pubkey string) string {
+	ConfigMu.Lock()
+	defer ConfigMu.Unlock()
+	sc := GetConfig()
+	if sc == nil {
+		return ""
+	}
+	for _, a := range sc.Admins {
+		if strings.EqualFold(a.Pubkey, pubkey) {
+			return a.Role
+		}
+	}
+	return ""
+}
+
+// ListAdmins returns a copy of config.yml's admins list.
+func ListAdmins() []cfgType.AdminEntry {
+	ConfigMu.Lock()
+	defer ConfigMu.Unlock()
+	sc := GetConfig()
+	if sc == nil {
+		return []cfgType.AdminEntry{}
+	}
+	return append([]cfgType.AdminEntry{}, sc.Admins...)
+}
+
+// SetAdmin grants pubkey role, replacing any role it already had, and
+// saves config.yml. Takes effect on the next NIP-86 request; no reload
+// needed.
+func SetAdmin(pubkey, role string) error {
+	pubkey = strings.ToLower(pubkey)
+	if len(pubkey) != 64 || strings.Trim(pubkey, "0123456789abcdef") != "" {
+		return fmt.Errorf("invalid pubkey %q", pubkey)
+	}
+	if !cfgType.ValidAdminRole(role) {
+		return fmt.Errorf("unknown role %q (want owner, moderator or viewer)", role)
+	}
+
+	ConfigMu.Lock()
+	defer ConfigMu.Unlock()
+
+	sc := GetConfig()
+	if sc == nil {
+		return fmt.Errorf("server configuration is not loaded")
+	}
+
+	admins := make([]cfgType.AdminEntry, 0, len(sc.Admins)+1)
+	for _, a := range sc.Admins {
+		if !strings.EqualFold(a.Pubkey, pubkey) {
+			admins = append(admins, a)
+		}
+	}
+	sc.Admins = append(admins, cfgType.AdminEntry{Pubkey: pubkey, Role: role})
+	log.Config().Info("Set relay admin", "pubkey", pubkey, "role", role)
+	return saveServerConfig(*sc)
+}
+
+// RemoveAdmin takes pubkey off the admins list and saves config.yml.
+// It's an error if pubkey wasn't on it, so a typo doesn't read as
+// success.
+func RemoveAdmin(pubkey string) error {
+	ConfigMu.Lock()
+	defer ConfigMu.Unlock()
+
+	sc := GetConfig()
+	if sc == nil {
+		return fmt.Errorf("server configuration is not loaded")
+	}
+
+	kept := make([]cfgType.AdminEntry, 0, len(sc.Admins))
+	for _, a := range sc.Admins {
+		if !strings.EqualFold(a.Pubkey, pubkey) {
+			kept = append(kept, a)
+		}
+	}
+	if len(kept) == len(sc.Admins) {
+		return fmt.Errorf("%s is not an admin", pubkey)
+	}
+	sc.Admins = kept
+	log.Config().Info("Removed relay admin", "pubkey", pubkey)
+	return saveServerConfig(*sc)
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	cfgType "github.com/0ceanslim/grain/config/types"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestAdmins_SetChangeRemovePersist(t *testing.T) {
+	dir := t.TempDir()
+	prevDir := GetDataDir()
+	SetDataDir(dir)
+	prevCfg := GetConfig()
+	SetConfigForTesting(&cfgType.ServerConfig{})
+	t.Cleanup(func() {
+		SetDataDir(prevDir)
+		SetConfigForTesting(prevCfg)
+	})
+
+	mod := strings.Repeat("ab", 32)
+	if err := SetAdmin(strings.ToUpper(mod), cfgType.AdminRoleModerator); err != nil {
+		t.Fatal(err)
+	}
+	if got := AdminRole(mod); got != cfgType.AdminRoleModerator {
+		t.Fatalf("role = %q, want moderator", got)
+	}
+	// Setting again changes the role rather than adding a duplicate.
+	if err := SetAdmin(mod, cfgType.AdminRoleViewer); err != nil {
+		t.Fatal(err)
+	}
+	if admins := ListAdmins(); len(admins) != 1 || admins[0].Role != cfgType.AdminRoleViewer {
+		t.Fatalf("admins = %+v, want one viewer", admins)
+	}
+
+	raw, err := os.ReadFile(filepath.Join(dir, "config.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var onDisk cfgType.ServerConfig
+	if err := yaml.Unmarshal(raw, &onDisk); err != nil {
+		t.Fatal(err)
+	}
+	if len(onDisk.Admins) != 1 || onDisk.Admins[0].Pubkey != mod {
+		t.Fatalf("config.yml admins = %+v", onDisk.Admins)
+	}
+
+	if err := RemoveAdmin(mod); err != nil {
+		t.Fatal(err)
+	}
+	if got := AdminRole(mod); got != "" {
+		t.Fatalf("role after remove = %q", got)
+	}
+	if err := RemoveAdmin(mod); err == nil {
+		t.Fatal("removing a non-admin should fail")
+	}
+}
+
+func TestAdmins_RejectsBadInput(t *testing.T) {
+	prevCfg := GetConfig()
+	SetConfigForTesting(&cfgType.ServerConfig{})
+	t.Cleanup(func() { SetConfigForTesting(prevCfg) })
+
+	if err := SetAdmin("nothex", cfgType.AdminRoleViewer); err == nil {
+		t.Fatal("expected invalid pubkey error")
+	}
+	if err := SetAdmin(strings.Repeat("a", 64), "superuser"); err == nil {
+		t.Fatal("expected unknown role error")
+	}
+	if len(ListAdmins()) != 0 {
+		t.Fatal("rejected input must not be stored")
+	}
+}
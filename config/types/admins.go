@@ -0,0 +1,27 @@
+package config
+
+// Admin roles, from most to least privileged. What each may call over
+// NIP-86 is decided in server/api (nip86_roles.go).
+const (
+	AdminRoleOwner     = "owner"     // everything
+	AdminRoleModerator = "moderator" // reads, pubkey and event bans, IP blocks, reports, audit log
+	AdminRoleViewer    = "viewer"    // reads only
+)
+
+// AdminEntry grants a pubkey access to the NIP-86 management API.
+// The relay owner in relay_metadata.json is always an owner and
+// doesn't need an entry.
+type AdminEntry struct {
+	Pubkey string `yaml:"pubkey" json:"pubkey"` // hex
+	Role   string `yaml:"role" json:"role"`
+}
+
+// ValidAdminRole reports whether role is one of the AdminRole*
+// constants.
+func ValidAdminRole(role string) bool {
+	switch role {
+	case AdminRoleOwner, AdminRoleModerator, AdminRoleViewer:
+		return true
+	}
+	return false
+}
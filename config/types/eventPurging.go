@@ -1,5 +1,7 @@
 package config
 
+import "fmt"
+
 type EventPurgeConfig struct {
 	Enabled              bool            `yaml:"enabled" json:"enabled"`
 	DisableAtStartup     bool            `yaml:"disable_at_startup" json:"disable_at_startup"`
@@ -9,4 +11,82 @@ type EventPurgeConfig struct {
 	PurgeByKindEnabled   bool            `yaml:"purge_by_kind_enabled" json:"purge_by_kind_enabled"`
 	KindsToPurge         []int           `yaml:"kinds_to_purge" json:"kinds_to_purge"`
 	ExcludeWhitelisted   bool            `yaml:"exclude_whitelisted" json:"exclude_whitelisted"`
+	// Rules, when set, replace keep_interval_hours and the category,
+	// kind and whitelist gates above: the first rule an event matches
+	// decides how long it's kept, and an event no rule matches is
+	// kept forever.
+	Rules []RetentionRule `yaml:"rules,omitempty" json:"rules"`
+	// DryRun makes the scheduled purge log what each rule would
+	// delete without deleting anything.
+	DryRun bool `yaml:"dry_run" json:"dry_run"`
+}
+
+// Values of RetentionRule.Authors.
+const (
+	RetentionAuthorsAny            = ""
+	RetentionAuthorsWhitelisted    = "whitelisted"
+	RetentionAuthorsNonWhitelisted = "non_whitelisted"
+)
+
+// RetentionRule is one event_purge rule. The selectors narrow which
+// events it applies to (empty = any); an event it applies to is
+// purged once it's older than KeepDays plus KeepHours, or never with
+// Forever.
+type RetentionRule struct {
+	Name       string   `yaml:"name,omitempty" json:"name,omitempty"`
+	Kinds      []int    `yaml:"kinds,omitempty" json:"kinds,omitempty"`
+	Categories []string `yaml:"categories,omitempty" json:"categories,omitempty"`
+	Authors    string   `yaml:"authors,omitempty" json:"authors,omitempty"`
+	KeepDays   int      `yaml:"keep_days,omitempty" json:"keep_days,omitempty"`
+	KeepHours  int      `yaml:"keep_hours,omitempty" json:"keep_hours,omitempty"`
+	Forever    bool     `yaml:"forever,omitempty" json:"forever,omitempty"`
+}
+
+// KeepSeconds is how long the rule keeps an event; meaningless with
+// Forever.
+func (r RetentionRule) KeepSeconds() int64 {
+	return int64(r.KeepDays)*86400 + int64(r.KeepHours)*3600
+}
+
+// retentionCategories are the names RetentionRule.Categories accepts:
+// purge_by_category's, plus the v0.5 "addressable" alias.
+var retentionCategories = map[string]bool{
+	"regular":                   true,
+	"replaceable":               true,
+	"parameterized_replaceable": true,
+	"addressable":               true,
+	"ephemeral":                 true,
+	"deprecated":                true,
+	"unknown":                   true,
+}
+
+// ValidateRules checks the retention rules.
+func (ep EventPurgeConfig) ValidateRules() error {
+	for i, r := range ep.Rules {
+		name := r.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+		}
+		switch {
+		case r.Authors != RetentionAuthorsAny && r.Authors != RetentionAuthorsWhitelisted && r.Authors != RetentionAuthorsNonWhitelisted:
+			return fmt.Errorf("event_purge.rules %s: unknown authors %q (want whitelisted or non_whitelisted)", name, r.Authors)
+		case r.KeepDays < 0 || r.KeepHours < 0:
+			return fmt.Errorf("event_purge.rules %s: keep_days and keep_hours must be non-negative", name)
+		case r.Forever && r.KeepSeconds() > 0:
+			return fmt.Errorf("event_purge.rules %s: forever can't be combined with keep_days or keep_hours", name)
+		case !r.Forever && r.KeepSeconds() == 0:
+			return fmt.Errorf("event_purge.rules %s: set keep_days, keep_hours or forever", name)
+		}
+		for _, k := range r.Kinds {
+			if k < 0 {
+				return fmt.Errorf("event_purge.rules %s: kind %d is negative", name, k)
+			}
+		}
+		for _, c := range r.Categories {
+			if !retentionCategories[c] {
+				return fmt.Errorf("event_purge.rules %s: unknown category %q", name, c)
+			}
+		}
+	}
+	return nil
 }
@@ -0,0 +1,12 @@
+package config
+
+// GroupsConfig enables NIP-29 relay-based groups. Group state lives
+// in groups.json in the data directory; the relay publishes it as
+// kind 39000-39003 events signed with its own key (relay_key). See
+// server/groups.
+type GroupsConfig struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Creators may create groups (kind 9007). Empty lets anyone who
+	// can write to the relay create one; the relay owner always can.
+	Creators []string `yaml:"creators" json:"creators"` // hex pubkeys
+}
@@ -0,0 +1,22 @@
+package config
+
+// ModerationConfig controls the moderation queue behind NIP-86's
+// listeventsneedingmoderation / allowevent / banevent. Banned event
+// ids and the queue itself live in moderation.json in the data
+// directory; see server/moderation.
+type ModerationConfig struct {
+	// HoldBanWords queues events matching blacklist.yml's ban words
+	// for review instead of banning their author.
+	HoldBanWords bool `yaml:"hold_ban_words" json:"hold_ban_words"`
+	// QueueReports queues events named in NIP-56 reports (kind 1984).
+	// They stay visible until a moderator bans them.
+	QueueReports bool `yaml:"queue_reports" json:"queue_reports"`
+	// MaxQueue caps the queue (0 = 1000). Events that would be held
+	// past it are rejected instead.
+	MaxQueue int `yaml:"max_queue" json:"max_queue"`
+	// QuarantineThreshold quarantines a reported event or pubkey once
+	// this many distinct trusted reporters (relay admins or
+	// whitelisted pubkeys) have reported it. 0 turns it off; reports
+	// are still indexed for grain_listreports.
+	QuarantineThreshold int `yaml:"quarantine_threshold" json:"quarantine_threshold"`
+}
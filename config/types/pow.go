@@ -0,0 +1,36 @@
+package config
+
+// PowConfig sets how much NIP-13 proof of work an event needs: the
+// number of leading zero bits in its id. An event needs the highest
+// of the minimums that apply to it. 0 means none.
+type PowConfig struct {
+	// MinDifficulty applies to every event. It's what NIP-11
+	// advertises as limitation.min_pow_difficulty.
+	MinDifficulty int `yaml:"min_difficulty" json:"min_difficulty"`
+	// NonWhitelisted applies to pubkeys not on the whitelist, whether
+	// or not the whitelist is enforced.
+	NonWhitelisted int `yaml:"non_whitelisted" json:"non_whitelisted"`
+	// Kinds applies per event kind.
+	Kinds []KindPowConfig `yaml:"kinds" json:"kinds"`
+}
+
+// KindPowConfig is one kind's minimum.
+type KindPowConfig struct {
+	Kind       int `yaml:"kind" json:"kind"`
+	Difficulty int `yaml:"difficulty" json:"difficulty"`
+}
+
+// Required is the difficulty an event of kind by a pubkey that is or
+// isn't whitelisted needs.
+func (p PowConfig) Required(kind int, whitelisted bool) int {
+	need := p.MinDifficulty
+	if !whitelisted && p.NonWhitelisted > need {
+		need = p.NonWhitelisted
+	}
+	for _, k := range p.Kinds {
+		if k.Kind == kind && k.Difficulty > need {
+			need = k.Difficulty
+		}
+	}
+	return need
+}
@@ -0,0 +1,13 @@
+package config
+
+// QueryConfig bounds what answering a REQ with stored events may cost.
+// A REQ that runs out of budget gets what was read so far, then an
+// EOSE or a CLOSED "rate-limited:" as OnExceeded says. Zero values
+// take the defaults.
+type QueryConfig struct {
+	MaxScanned                 int    `yaml:"max_scanned" json:"max_scanned"`                                     // Stored events one REQ may read (default: 100000)
+	MaxMillis                  int    `yaml:"max_millis" json:"max_millis"`                                       // Milliseconds from REQ to EOSE (default: 10000)
+	OnExceeded                 string `yaml:"on_exceeded" json:"on_exceeded"`                                     // "eose" (default) or "closed"
+	MaxConcurrentPerConnection int    `yaml:"max_concurrent_per_connection" json:"max_concurrent_per_connection"` // REQs one connection may have reading at once (default: 4)
+	MaxConcurrent              int    `yaml:"max_concurrent" json:"max_concurrent"`                               // REQs reading at once relay-wide; more wait their turn (default: 64)
+}
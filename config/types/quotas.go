@@ -0,0 +1,23 @@
+package config
+
+// QuotaConfig caps what a single pubkey may keep on the relay; see
+// server/quota. Rate limits bound how fast a pubkey can publish, a
+// quota bounds how much of it stays. Bytes are the events' JSON size,
+// the measure grain_stats_database reports.
+type QuotaConfig struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Default applies to every pubkey not on the whitelist.
+	Default QuotaTier `yaml:"default" json:"default"`
+	// Whitelisted applies to pubkeys on the whitelist, whether or not
+	// the whitelist is enforced.
+	Whitelisted QuotaTier `yaml:"whitelisted" json:"whitelisted"`
+	// EvictOldest makes room by deleting the author's oldest events
+	// instead of rejecting the new one.
+	EvictOldest bool `yaml:"evict_oldest" json:"evict_oldest"`
+}
+
+// QuotaTier is one set of per-pubkey limits. 0 means unlimited.
+type QuotaTier struct {
+	MaxEvents int   `yaml:"max_events" json:"max_events"`
+	MaxBytes  int64 `yaml:"max_bytes" json:"max_bytes"`
+}
@@ -0,0 +1,16 @@
+package config
+
+// ReadPolicyConfig enables the built-in read rules — checks applied to
+// every event before it goes out to a reader, both in REQ / COUNT /
+// NEG-OPEN results and in live broadcasts. Each rule looks at the
+// event and the pubkey the connection AUTHed as (NIP-42); events a
+// rule refuses are silently left out. See server/policy.
+type ReadPolicyConfig struct {
+	// DMPrivacy serves direct messages only to their author or a
+	// p-tagged recipient, and only once the connection has AUTHed.
+	DMPrivacy bool  `yaml:"dm_privacy" json:"dm_privacy"`
+	DMKinds   []int `yaml:"dm_kinds" json:"dm_kinds"` // Kinds DMPrivacy covers (empty = 4 and 1059)
+	// AuthRequiredKinds are served to any AUTHed connection and to no
+	// one else.
+	AuthRequiredKinds []int `yaml:"auth_required_kinds" json:"auth_required_kinds"`
+}
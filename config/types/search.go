@@ -0,0 +1,9 @@
+package config
+
+// SearchConfig extends NIP-50 search beyond the kinds nostrdb indexes
+// itself (1 and 30023). Kinds listed here are indexed by grain, in
+// search_index.jsonl in the data directory; kind 0 by its name,
+// display_name and about.
+type SearchConfig struct {
+	IndexedKinds []int `yaml:"indexed_kinds" json:"indexed_kinds"` // Extra kinds to make searchable, e.g. [0, 30024]
+}
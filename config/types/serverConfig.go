@@ -16,8 +16,32 @@ type LogConfig struct {
 // write helpers can name a type (anonymous struct fields can't
 // cross package boundaries cleanly).
 type DatabaseConfig struct {
-	Path      string `yaml:"path" json:"path"`               // Directory for nostrdb data files (default: ./data)
-	MapSizeMB int    `yaml:"map_size_mb" json:"map_size_mb"` // Max database size in MB (default: 4096 = 4GB)
+	Path      string                `yaml:"path" json:"path"`               // Directory for nostrdb data files (default: ./data)
+	MapSizeMB int                   `yaml:"map_size_mb" json:"map_size_mb"` // Max database size in MB (default: 4096 = 4GB)
+	Backup    DatabaseBackupConfig  `yaml:"backup" json:"backup"`
+	Storage   DatabaseStorageConfig `yaml:"storage" json:"storage"`
+}
+
+// DatabaseBackupConfig schedules online copies of the nostrdb files
+// (see server/backup). The same copies come from `grain --backup`
+// and NIP-86 grain_backup whether or not the schedule is on.
+type DatabaseBackupConfig struct {
+	Enabled       bool   `yaml:"enabled" json:"enabled"`               // Take backups on a schedule
+	Dir           string `yaml:"dir" json:"dir"`                       // Where backups go, relative to the data dir (default: backups)
+	IntervalHours int    `yaml:"interval_hours" json:"interval_hours"` // Hours between scheduled backups (default: 24)
+	Retain        int    `yaml:"retain" json:"retain"`                 // Backups to keep; older ones are deleted (0 = keep all)
+	Compact       bool   `yaml:"compact" json:"compact"`               // Compact while copying: smaller, slower
+}
+
+// DatabaseStorageConfig watches how full the LMDB map and the disk
+// under it are (see server/storage). Percentages are of the map size.
+type DatabaseStorageConfig struct {
+	CheckIntervalSec int   `yaml:"check_interval_sec" json:"check_interval_sec"` // Seconds between checks (default: 30)
+	WarnPercent      []int `yaml:"warn_percent" json:"warn_percent"`             // Log a warning as usage crosses each (default: [75, 90])
+	GrowAtPercent    int   `yaml:"grow_at_percent" json:"grow_at_percent"`       // Reopen with a larger map at this usage (default: 85)
+	MaxMapSizeMB     int   `yaml:"max_map_size_mb" json:"max_map_size_mb"`       // Largest map to grow to (0 = never grow)
+	FullAtPercent    int   `yaml:"full_at_percent" json:"full_at_percent"`       // Reject new events at this usage (default: 98)
+	MinFreeDiskMB    int   `yaml:"min_free_disk_mb" json:"min_free_disk_mb"`     // Reject new events below this much free disk (default: 256)
 }
 
 // ServerSettings is the HTTP server block (timeouts, connection
@@ -41,9 +65,26 @@ type ServerSettings struct {
 // accepted events to. Renamed from a single-URL field as of the
 // admin dashboard work — operators commonly want both a public
 // blaster + a private archival relay simultaneously.
+//
+// URLs receive every accepted event; Targets carry per-destination
+// kind/author filters for the archive-only-my-notes style setups.
+// A URL may appear in one list or the other, not both. Delivery goes
+// through the disk-backed outbox in server/replication, capped at
+// MaxQueueMB per target (0 = 1024).
 type BackupRelayConfig struct {
-	Enabled bool     `yaml:"enabled" json:"enabled"`
-	URLs    []string `yaml:"urls" json:"urls"`
+	Enabled    bool                `yaml:"enabled" json:"enabled"`
+	URLs       []string            `yaml:"urls" json:"urls"`
+	Targets    []BackupRelayTarget `yaml:"targets" json:"targets"`
+	MaxQueueMB int                 `yaml:"max_queue_mb" json:"max_queue_mb"`
+}
+
+// BackupRelayTarget is one filtered replication destination. Empty
+// Kinds / Authors mean "no restriction" on that field; when both are
+// set an event has to match both, same as a NIP-01 filter.
+type BackupRelayTarget struct {
+	URL     string   `yaml:"url" json:"url"`
+	Kinds   []int    `yaml:"kinds" json:"kinds"`
+	Authors []string `yaml:"authors" json:"authors"` // hex pubkeys
 }
 
 type ServerConfig struct {
@@ -58,4 +99,13 @@ type ServerConfig struct {
 	EventPurge           EventPurgeConfig     `yaml:"event_purge" json:"event_purge"`
 	EventTimeConstraints EventTimeConstraints `yaml:"event_time_constraints" json:"event_time_constraints"`
 	BackupRelay          BackupRelayConfig    `yaml:"backup_relay" json:"backup_relay"`
+	WritePolicy          WritePolicyConfig    `yaml:"write_policy" json:"write_policy"`
+	ReadPolicy           ReadPolicyConfig     `yaml:"read_policy" json:"read_policy"`
+	Groups               GroupsConfig         `yaml:"groups" json:"groups"`
+	Admins               []AdminEntry         `yaml:"admins" json:"admins"`
+	Moderation           ModerationConfig     `yaml:"moderation" json:"moderation"`
+	Quotas               QuotaConfig          `yaml:"quotas" json:"quotas"`
+	Pow                  PowConfig            `yaml:"pow" json:"pow"`
+	Query                QueryConfig          `yaml:"query" json:"query"`
+	Search               SearchConfig         `yaml:"search" json:"search"`
 }
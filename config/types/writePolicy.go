@@ -0,0 +1,19 @@
+package config
+
+// WritePolicyConfig configures the external write-policy plugin — a
+// long-running program that sees every signature-verified EVENT and
+// answers accept / reject / shadowReject over line-delimited JSON on
+// stdin/stdout. The protocol is strfry's, so existing strfry plugins
+// work unchanged. See server/policy.
+type WritePolicyConfig struct {
+	Enabled bool     `yaml:"enabled" json:"enabled"`
+	Command string   `yaml:"command" json:"command"` // Program to run; bare names use PATH, other relative paths resolve against the data directory
+	Args    []string `yaml:"args" json:"args"`
+	// TimeoutMs bounds the wait for one verdict (0 = 2000). A plugin
+	// that times out three times in a row is killed and restarted.
+	TimeoutMs int `yaml:"timeout_ms" json:"timeout_ms"`
+	// FailOpen decides what happens while the plugin is down or slow:
+	// true accepts the event, false (default) rejects it with
+	// `error: write policy unavailable`.
+	FailOpen bool `yaml:"fail_open" json:"fail_open"`
+}
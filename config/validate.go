@@ -83,6 +83,71 @@ func ValidateAndApplyDefaults(cfg *cfgType.ServerConfig) (warnings []string, err
 	if !strings.HasPrefix(cfg.Server.Port, ":") {
 		err = fmt.Errorf("server.port %q is invalid: must start with \":\" (e.g. \":8181\")", cfg.Server.Port)
 	}
+	if err == nil && cfg.WritePolicy.Enabled && strings.TrimSpace(cfg.WritePolicy.Command) == "" {
+		err = fmt.Errorf("write_policy.command must be set when write_policy.enabled is true")
+	}
+	for _, kinds := range [][]int{cfg.ReadPolicy.DMKinds, cfg.ReadPolicy.AuthRequiredKinds} {
+		for _, k := range kinds {
+			if err == nil && k < 0 {
+				err = fmt.Errorf("read_policy: kind %d is invalid: kinds must be non-negative", k)
+			}
+		}
+	}
+	for _, pk := range cfg.Groups.Creators {
+		if err == nil && (len(pk) != 64 || strings.Trim(pk, "0123456789abcdef") != "") {
+			err = fmt.Errorf("groups.creators: %q is not a 64-character hex pubkey", pk)
+		}
+	}
+	for _, a := range cfg.Admins {
+		if err == nil && (len(a.Pubkey) != 64 || strings.Trim(a.Pubkey, "0123456789abcdef") != "") {
+			err = fmt.Errorf("admins: %q is not a 64-character hex pubkey", a.Pubkey)
+		}
+		if err == nil && !cfgType.ValidAdminRole(a.Role) {
+			err = fmt.Errorf("admins: %s has unknown role %q (want owner, moderator or viewer)", a.Pubkey, a.Role)
+		}
+	}
+	if err == nil && (cfg.Database.Backup.IntervalHours < 0 || cfg.Database.Backup.Retain < 0) {
+		err = fmt.Errorf("database.backup: interval_hours and retain must be non-negative")
+	}
+	if st := cfg.Database.Storage; err == nil && (st.CheckIntervalSec < 0 || st.MaxMapSizeMB < 0 || st.MinFreeDiskMB < 0) {
+		err = fmt.Errorf("database.storage: check_interval_sec, max_map_size_mb and min_free_disk_mb must be non-negative")
+	}
+	for _, p := range append([]int{cfg.Database.Storage.GrowAtPercent, cfg.Database.Storage.FullAtPercent}, cfg.Database.Storage.WarnPercent...) {
+		if err == nil && (p < 0 || p > 100) {
+			err = fmt.Errorf("database.storage: %d is not a percentage", p)
+		}
+	}
+	for _, tier := range []cfgType.QuotaTier{cfg.Quotas.Default, cfg.Quotas.Whitelisted} {
+		if err == nil && (tier.MaxEvents < 0 || tier.MaxBytes < 0) {
+			err = fmt.Errorf("quotas: max_events and max_bytes must be non-negative")
+		}
+	}
+	pow := []int{cfg.Pow.MinDifficulty, cfg.Pow.NonWhitelisted}
+	for _, k := range cfg.Pow.Kinds {
+		pow = append(pow, k.Difficulty)
+	}
+	for _, d := range pow {
+		if err == nil && (d < 0 || d > 256) {
+			err = fmt.Errorf("pow: difficulty %d is not between 0 and 256", d)
+		}
+	}
+	if q := cfg.Query; err == nil && (q.MaxScanned < 0 || q.MaxMillis < 0 || q.MaxConcurrentPerConnection < 0 || q.MaxConcurrent < 0) {
+		err = fmt.Errorf("query: max_scanned, max_millis, max_concurrent_per_connection and max_concurrent must be non-negative")
+	}
+	for _, k := range cfg.Search.IndexedKinds {
+		if err == nil && (k < 0 || k > 65535) {
+			err = fmt.Errorf("search.indexed_kinds: kind %d is not between 0 and 65535", k)
+		}
+	}
+	if err == nil && cfg.Query.OnExceeded != "" && cfg.Query.OnExceeded != "eose" && cfg.Query.OnExceeded != "closed" {
+		err = fmt.Errorf("query.on_exceeded %q is invalid: want eose or closed", cfg.Query.OnExceeded)
+	}
+	if err == nil {
+		err = cfg.EventPurge.ValidateRules()
+	}
+	if err == nil && (cfg.Moderation.MaxQueue < 0 || cfg.Moderation.QuarantineThreshold < 0) {
+		err = fmt.Errorf("moderation: max_queue and quarantine_threshold must be non-negative")
+	}
 
 	return warnings, err
 }
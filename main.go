@@ -83,6 +83,43 @@ func main() {
 		return
 	}
 
+	// Handle --export flag: stream events (optionally filtered and
+	// compressed) to a JSONL file and exit.
+	if exportFile, filterJSON, compression := parseExportFlags(); exportFile != "" {
+		if err := server.ExportEvents(exportFile, filterJSON, compression); err != nil {
+			fmt.Fprintf(os.Stderr, "Export failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Handle --backup / --restore flags: copy the database out while
+	// the relay may be running, or swap a copy in while it isn't.
+	if dir, compact := parseBackupFlags(); dir != "" {
+		if err := server.BackupDatabase(dir, compact); err != nil {
+			fmt.Printf("Backup failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if path := parseRestoreFlag(); path != "" {
+		if err := server.RestoreBackup(path); err != nil {
+			fmt.Printf("Restore failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Handle --sync flag: reconcile against a remote relay over NIP-77,
+	// ingest only the events we're missing, and exit.
+	if relayURL, filterJSON := parseSyncFlags(); relayURL != "" {
+		if err := server.SyncFromRelay(relayURL, filterJSON); err != nil {
+			fmt.Printf("Sync failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Handle --delete / --delete-file flags: physically remove events and exit.
 	// No signature check — shell access is the authorization boundary, same
 	// trust model as --import.
@@ -94,6 +131,16 @@ func main() {
 		return
 	}
 
+	// Handle --reindex-search: rebuild the search index of the kinds
+	// nostrdb doesn't index, from the events already stored, and exit.
+	if parseReindexSearchFlag() {
+		if err := server.ReindexSearch(); err != nil {
+			fmt.Printf("Reindex failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Start the server
 	if err := server.Run(); err != nil {
 		fmt.Printf("Application failed: %v\n", err)
@@ -121,6 +168,79 @@ func parseImportFlag() string {
 	return ""
 }
 
+// parseExportFlags extracts --export <file>, plus the optional
+// --filter <json> and --compress <gzip|zstd|none> that shape it.
+// Returns an empty file if --export is absent.
+func parseExportFlags() (file, filterJSON, compression string) {
+	for i, arg := range os.Args {
+		if i+1 >= len(os.Args) {
+			break
+		}
+		switch arg {
+		case "--export":
+			file = os.Args[i+1]
+		case "--filter":
+			filterJSON = os.Args[i+1]
+		case "--compress":
+			compression = os.Args[i+1]
+		}
+	}
+	return file, filterJSON, compression
+}
+
+// parseBackupFlags extracts --backup <dir> and whether --compact is
+// set. Returns an empty dir if --backup is absent.
+func parseBackupFlags() (dir string, compact bool) {
+	for i, arg := range os.Args {
+		switch arg {
+		case "--backup":
+			if i+1 < len(os.Args) {
+				dir = os.Args[i+1]
+			}
+		case "--compact":
+			compact = true
+		}
+	}
+	return dir, compact
+}
+
+// parseRestoreFlag extracts --restore value from os.Args, if present.
+func parseRestoreFlag() string {
+	for i, arg := range os.Args {
+		if arg == "--restore" && i+1 < len(os.Args) {
+			return os.Args[i+1]
+		}
+	}
+	return ""
+}
+
+// parseSyncFlags extracts --sync <relay-url> and the optional
+// --filter <json> that scopes it. Returns an empty url if --sync is absent.
+func parseSyncFlags() (relayURL, filterJSON string) {
+	for i, arg := range os.Args {
+		if i+1 >= len(os.Args) {
+			break
+		}
+		switch arg {
+		case "--sync":
+			relayURL = os.Args[i+1]
+		case "--filter":
+			filterJSON = os.Args[i+1]
+		}
+	}
+	return relayURL, filterJSON
+}
+
+// parseReindexSearchFlag reports whether --reindex-search is set.
+func parseReindexSearchFlag() bool {
+	for _, arg := range os.Args {
+		if arg == "--reindex-search" {
+			return true
+		}
+	}
+	return false
+}
+
 // parseDeleteFlags collects ids from --delete <id> (may be repeated) and
 // --delete-file <path> (one hex id per line, # comments). Returns nil if
 // neither flag is present.
@@ -2,8 +2,8 @@
 // is the NIP-98 glue: it pulls a signed kind-27235 event out of the
 // Authorization header, hashes the request body, hands both off to
 // handlers.VerifyNIP98Event, and (for admin endpoints) checks that
-// the authenticated pubkey matches the relay owner in
-// relay_metadata.json.
+// the authenticated pubkey is the relay owner in relay_metadata.json
+// or, for NIP-86, holds a role in config.yml's admins list.
 //
 // Per NIP-98 §Encoding the auth header is `Authorization: Nostr <b64>`
 // where <b64> is the base64-encoded JSON of the event.
@@ -21,6 +21,8 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/0ceanslim/grain/config"
+	cfgType "github.com/0ceanslim/grain/config/types"
 	"github.com/0ceanslim/grain/server/handlers"
 	nostr "github.com/0ceanslim/grain/server/types"
 	"github.com/0ceanslim/grain/server/utils"
@@ -114,11 +116,60 @@ func IsRelayOwner(pubkey string) bool {
 	return strings.EqualFold(owner, pubkey)
 }
 
+// AdminRole returns the management role pubkey holds: owner for the
+// relay owner in relay_metadata.json, otherwise whatever config.yml's
+// admins list grants it, or "" for none.
+func AdminRole(pubkey string) string {
+	if IsRelayOwner(pubkey) {
+		return cfgType.AdminRoleOwner
+	}
+	return config.AdminRole(pubkey)
+}
+
 // RequireOwner is the gate for admin/management endpoints: it
 // authenticates the request via NIP-98 and confirms the signer is the
 // relay owner. On failure it writes the appropriate response and
 // returns ok=false so the handler can simply early-return.
 func RequireOwner(w http.ResponseWriter, r *http.Request) (string, bool) {
+	pubkey, ok := authenticate(w, r)
+	if !ok {
+		return "", false
+	}
+	if !IsRelayOwner(pubkey) {
+		log.RelayAPI().Warn("NIP-98 auth rejected: signer is not relay owner",
+			"client_ip", utils.GetClientIP(r),
+			"signer", pubkey,
+			"method", r.Method,
+			"path", r.URL.Path)
+		http.Error(w, "Forbidden: signer is not relay owner", http.StatusForbidden)
+		return "", false
+	}
+	return pubkey, true
+}
+
+// RequireAdmin is RequireOwner for endpoints that any admin role may
+// reach (NIP-86). It returns the signer's role alongside the pubkey;
+// what that role may actually do is the caller's check.
+func RequireAdmin(w http.ResponseWriter, r *http.Request) (pubkey, role string, ok bool) {
+	pubkey, ok = authenticate(w, r)
+	if !ok {
+		return "", "", false
+	}
+	role = AdminRole(pubkey)
+	if role == "" {
+		log.RelayAPI().Warn("NIP-98 auth rejected: signer is not a relay admin",
+			"client_ip", utils.GetClientIP(r),
+			"signer", pubkey,
+			"method", r.Method,
+			"path", r.URL.Path)
+		http.Error(w, "Forbidden: signer is not a relay admin", http.StatusForbidden)
+		return "", "", false
+	}
+	return pubkey, role, true
+}
+
+// authenticate is the NIP-98 half of RequireOwner / RequireAdmin.
+func authenticate(w http.ResponseWriter, r *http.Request) (string, bool) {
 	pubkey, err := VerifyAPIAuth(r)
 	if err != nil {
 		clientIP := utils.GetClientIP(r)
@@ -135,15 +186,6 @@ func RequireOwner(w http.ResponseWriter, r *http.Request) (string, bool) {
 		http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
 		return "", false
 	}
-	if !IsRelayOwner(pubkey) {
-		log.RelayAPI().Warn("NIP-98 auth rejected: signer is not relay owner",
-			"client_ip", utils.GetClientIP(r),
-			"signer", pubkey,
-			"method", r.Method,
-			"path", r.URL.Path)
-		http.Error(w, "Forbidden: signer is not relay owner", http.StatusForbidden)
-		return "", false
-	}
 	return pubkey, true
 }
 
@@ -246,6 +246,41 @@ func TestRequireOwner_NoAuthHeaderReturns401(t *testing.T) {
 	}
 }
 
+func TestRequireAdmin_RoleFromAdminsList(t *testing.T) {
+	installAuthCfg(t)
+	k := newKP(t)
+	config.GetConfig().Admins = []cfgType.AdminEntry{{Pubkey: k.pub, Role: cfgType.AdminRoleModerator}}
+	url := "https://relay.example/"
+	evt := k.sign(t, "GET", url, "")
+	r := httptest.NewRequest(http.MethodGet, url, nil)
+	r.Header.Set("Authorization", authHeader(t, evt))
+	w := httptest.NewRecorder()
+	pub, role, ok := RequireAdmin(w, r)
+	if !ok || pub != k.pub || role != cfgType.AdminRoleModerator {
+		t.Fatalf("RequireAdmin = %q, %q, %v; want moderator", pub, role, ok)
+	}
+	// The dashboard gate stays owner-only.
+	if _, ok := RequireOwner(httptest.NewRecorder(), r); ok {
+		t.Fatalf("a moderator must not pass RequireOwner")
+	}
+}
+
+func TestRequireAdmin_NoRoleReturns403(t *testing.T) {
+	installAuthCfg(t)
+	k := newKP(t)
+	url := "https://relay.example/"
+	evt := k.sign(t, "GET", url, "")
+	r := httptest.NewRequest(http.MethodGet, url, nil)
+	r.Header.Set("Authorization", authHeader(t, evt))
+	w := httptest.NewRecorder()
+	if _, _, ok := RequireAdmin(w, r); ok {
+		t.Fatalf("expected RequireAdmin to deny a signer with no role")
+	}
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", w.Code)
+	}
+}
+
 func TestHashAndRestoreBody_OversizedRejected(t *testing.T) {
 	big := bytes.Repeat([]byte{'a'}, maxAuthBodyBytes+1)
 	r := httptest.NewRequest(http.MethodPost, "https://relay.example/foo", bytes.NewReader(big))
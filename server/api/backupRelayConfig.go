@@ -5,20 +5,23 @@ import (
 	"net/http"
 
 	"github.com/0ceanslim/grain/config"
+	cfgType "github.com/0ceanslim/grain/config/types"
 	"github.com/0ceanslim/grain/server/utils"
 	"github.com/0ceanslim/grain/server/utils/log"
 )
 
 // BackupRelayConfigResponse represents the backup relay configuration response
 type BackupRelayConfigResponse struct {
-	Enabled bool     `json:"enabled"`
-	URLs    []string `json:"urls"`
+	Enabled    bool                        `json:"enabled"`
+	URLs       []string                    `json:"urls"`
+	Targets    []cfgType.BackupRelayTarget `json:"targets"`
+	MaxQueueMB int                         `json:"max_queue_mb"`
 }
 
 // GetBackupRelayConfig handles the request to return backup relay configuration
 //
 // @Summary      Get backup relay config
-// @Description  Returns the upstream relay grain mirrors accepted events to (URL plus enabled flag).
+// @Description  Returns the upstream relays grain mirrors accepted events to: unfiltered URLs, kind/author-filtered targets, the per-target outbox cap and the enabled flag. Live delivery state is NIP-86 `grain_replicationstatus`.
 // @Tags         relay-config
 // @Produce      json
 // @Success      200  {object}  BackupRelayConfigResponse
@@ -40,8 +43,10 @@ func GetBackupRelayConfig(w http.ResponseWriter, r *http.Request) {
 
 	// Prepare response with backup relay configuration
 	response := BackupRelayConfigResponse{
-		Enabled: cfg.BackupRelay.Enabled,
-		URLs:    cfg.BackupRelay.URLs,
+		Enabled:    cfg.BackupRelay.Enabled,
+		URLs:       cfg.BackupRelay.URLs,
+		Targets:    cfg.BackupRelay.Targets,
+		MaxQueueMB: cfg.BackupRelay.MaxQueueMB,
 	}
 
 	// Set response headers
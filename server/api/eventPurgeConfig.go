@@ -5,26 +5,29 @@ import (
 	"net/http"
 
 	"github.com/0ceanslim/grain/config"
+	cfgType "github.com/0ceanslim/grain/config/types"
 	"github.com/0ceanslim/grain/server/utils"
 	"github.com/0ceanslim/grain/server/utils/log"
 )
 
 // EventPurgeConfigResponse represents the event purging configuration response
 type EventPurgeConfigResponse struct {
-	Enabled              bool            `json:"enabled"`
-	DisableAtStartup     bool            `json:"disable_at_startup"`
-	KeepIntervalHours    int             `json:"keep_interval_hours"`
-	PurgeIntervalMinutes int             `json:"purge_interval_minutes"`
-	PurgeByCategory      map[string]bool `json:"purge_by_category"`
-	PurgeByKindEnabled   bool            `json:"purge_by_kind_enabled"`
-	KindsToPurge         []int           `json:"kinds_to_purge"`
-	ExcludeWhitelisted   bool            `json:"exclude_whitelisted"`
+	Enabled              bool                    `json:"enabled"`
+	DisableAtStartup     bool                    `json:"disable_at_startup"`
+	KeepIntervalHours    int                     `json:"keep_interval_hours"`
+	PurgeIntervalMinutes int                     `json:"purge_interval_minutes"`
+	PurgeByCategory      map[string]bool         `json:"purge_by_category"`
+	PurgeByKindEnabled   bool                    `json:"purge_by_kind_enabled"`
+	KindsToPurge         []int                   `json:"kinds_to_purge"`
+	ExcludeWhitelisted   bool                    `json:"exclude_whitelisted"`
+	Rules                []cfgType.RetentionRule `json:"rules"`
+	DryRun               bool                    `json:"dry_run"`
 }
 
 // GetEventPurgeConfig handles the request to return event purging configuration
 //
 // @Summary      Get event-purge config
-// @Description  Returns retention settings — what's purged, by kind/category, on what interval, and whether whitelisted authors are exempt.
+// @Description  Returns retention settings — what's purged, by kind/category, on what interval, whether whitelisted authors are exempt, the per-kind / per-author retention rules and whether runs are dry.
 // @Tags         relay-config
 // @Produce      json
 // @Success      200  {object}  EventPurgeConfigResponse
@@ -54,6 +57,8 @@ func GetEventPurgeConfig(w http.ResponseWriter, r *http.Request) {
 		PurgeByKindEnabled:   cfg.EventPurge.PurgeByKindEnabled,
 		KindsToPurge:         cfg.EventPurge.KindsToPurge,
 		ExcludeWhitelisted:   cfg.EventPurge.ExcludeWhitelisted,
+		Rules:                cfg.EventPurge.Rules,
+		DryRun:               cfg.EventPurge.DryRun,
 	}
 
 	// Set response headers
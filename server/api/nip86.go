@@ -10,8 +10,10 @@
 //
 // The response is `{"result": <method-specific>, "error": "<string>"}`,
 // where `error` is empty on success. Every method requires NIP-98 auth
-// and (in grain's implementation) signer == relay owner per
-// relay_metadata.json — RequireOwner is the gate.
+// and a signer with an admin role — the relay owner per
+// relay_metadata.json, or an entry in config.yml's admins list.
+// RequireAdmin is the gate; nip86_roles.go decides which methods each
+// role may call.
 //
 // This file ships read-only methods. Mutation methods (banpubkey /
 // allowpubkey / changerelay* / etc.) will land in a follow-up commit
@@ -26,6 +28,7 @@ import (
 	"strconv"
 
 	"github.com/0ceanslim/grain/config"
+	"github.com/0ceanslim/grain/server/replication"
 	"github.com/0ceanslim/grain/server/utils"
 	"github.com/0ceanslim/grain/server/utils/log"
 )
@@ -69,37 +72,39 @@ type nip86IPEntry struct {
 
 // HandleNIP86 is the JSON-RPC entry point. All paths return HTTP 200
 // with a JSON body — method errors live in the response envelope, not
-// the HTTP status. Auth failures (no header, bad signature, non-owner)
-// short-circuit via RequireOwner before any JSON-RPC parsing happens
-// and DO use the right status codes (401 / 403) because they're not
-// JSON-RPC errors — they're HTTP-level access control.
+// the HTTP status. Auth failures (no header, bad signature, no admin
+// role) short-circuit via RequireAdmin before any JSON-RPC parsing
+// happens and DO use the right status codes (401 / 403) because
+// they're not JSON-RPC errors — they're HTTP-level access control. A
+// method the signer's role doesn't cover is an envelope error, like
+// an unknown method.
 //
 // @Summary      NIP-86 relay management
-// @Description  JSON-RPC over a single POST endpoint per [NIP-86](https://github.com/nostr-protocol/nips/blob/master/86.md). Requires NIP-98 HTTP Auth (kind:27235 with `u`, `method`, `payload` tags) and the signer must equal the relay owner pubkey in `relay_metadata.json`. Body is `{"method": "<name>", "params": [...]}`; response is `{"result": ..., "error": ""}`. Errors live in the envelope, not the HTTP status — only auth failures return 401/403.
+// @Description  JSON-RPC over a single POST endpoint per [NIP-86](https://github.com/nostr-protocol/nips/blob/master/86.md). Requires NIP-98 HTTP Auth (kind:27235 with `u`, `method`, `payload` tags) and the signer must be the relay owner pubkey in `relay_metadata.json` or an admin in `config.yml`'s `admins` list. Roles: `owner` (every method), `moderator` (reads, `grain_auditlog`, `banpubkey` / `unbanpubkey`, `blockip` / `unblockip`, `banevent` / `allowevent`, `grain_dismissreports`), `viewer` (reads). `supportedmethods` lists what the caller's role may call; anything else returns a `restricted:` error. Body is `{"method": "<name>", "params": [...]}`; response is `{"result": ..., "error": ""}`. Errors live in the envelope, not the HTTP status — only auth failures return 401/403.
 // @Description
-// @Description **Spec methods (reads):** `supportedmethods`, `listallowedpubkeys`, `listbannedpubkeys`, `listallowedkinds`, `listblockedips`.
+// @Description **Spec methods (reads):** `supportedmethods`, `listallowedpubkeys`, `listbannedpubkeys`, `listallowedkinds`, `listblockedips`, `listbannedevents`, `listeventsneedingmoderation` (entries add `source` — `ban_word` for events held instead of stored, `report` for stored events named in a NIP-56 report, `quarantine` for events held once trusted reporters crossed `moderation.quarantine_threshold` — and, for held events, the `event` itself).
 // @Description
-// @Description **Spec methods (writes):** `banpubkey` / `unbanpubkey` / `allowpubkey` / `unallowpubkey` (params: `[pubkey, reason?]`), `allowkind` / `disallowkind` (params: `[kind:int]`), `blockip` / `unblockip` (params: `[ip-or-cidr, reason?]`), `changerelayname` / `changerelaydescription` / `changerelayicon` (params: `[value:string]`).
+// @Description **Spec methods (writes):** `banpubkey` / `unbanpubkey` / `allowpubkey` / `unallowpubkey` (params: `[pubkey, reason?]`), `allowkind` / `disallowkind` (params: `[kind:int]`), `blockip` / `unblockip` (params: `[ip-or-cidr, reason?]`), `banevent` (params: `[event-id, reason?]` — deletes the event and rejects re-publishes with `blocked:`) / `allowevent` (params: `[event-id, reason?]` — stores a held event, clears a flagged one, or lifts a ban), `changerelayname` / `changerelaydescription` / `changerelayicon` (params: `[value:string]`).
 // @Description
 // @Description **Grain vendor extensions (writes):** `grain_updateserver`, `grain_updateratelimit`, `grain_updateeventpurge`, `grain_updatelogging`, `grain_updateauth`, `grain_updatebackuprelay`, `grain_updateresourcelimits`, `grain_updateeventtimeconstraints`, `grain_updatewhitelistconfig`, `grain_updateblacklistconfig`. Each takes the full section blob as `params[0]` (same shape the matching GET endpoint returns) and stages it to disk; the response is `{ok:true, restart_pending:true}`. Operator clicks Apply → dashboard calls `grain_reloadconfig`.
 // @Description
-// @Description **Grain vendor extensions (ops + reads):** `grain_reloadconfig` (triggers restart), `grain_refreshcache` (synchronous whitelist + blacklist cache refresh), `grain_whitelistconfig` / `grain_blacklistconfig` (full-struct reads — the blacklist read overlays IP fields from config.yml so the dashboard sees one coherent shape), `grain_stats_overview` (server counters + list/cache stats).
+// @Description **Grain vendor extensions (ops + reads):** `grain_reloadconfig` (triggers restart), `grain_refreshcache` (synchronous whitelist + blacklist cache refresh), `grain_whitelistconfig` / `grain_blacklistconfig` (full-struct reads — the blacklist read overlays IP fields from config.yml so the dashboard sees one coherent shape), `grain_stats_overview` (server counters + list/cache stats), `grain_stats_database` (params: `[{limit?}]` — on-disk and map size, per-table entries and bytes from `ndb_stat` (cached, refreshed in the background), event counts and bytes per kind, per category and in total, and the top `limit` (default 20) authors by events and by bytes; counted incrementally, never by scanning), `grain_replicationstatus` (per backup-relay target: connected, queue_depth / queue_bytes, lag_seconds of the oldest unacknowledged event, acked / rejected / dropped / retries counters, last_error), `grain_auditlog` (params: `[{since?, until?, method?, signer?, source?, limit?}]` — newest-first entries from the admin audit log; writes carry the signer, client IP and the fields of the affected config section that changed), `grain_backup` (params: `[{compact?}]` — consistent copy of the database into `database.backup.dir` while the relay keeps serving; returns `{path, created_at, compact, size_bytes, source}` when done), `grain_previewpurge` (params: `[event_purge?]` — dry run of the retention rules, of the running config or of the given `grain_updateeventpurge` blob; returns `{dry_run, cutoff, scanned, purged, failed, rules: [{rule, matched, purged, bytes}], took_ms}`, deleting nothing), `grain_listreports` (params: `[{target_type?, type?, quarantined?, limit?}]` — NIP-56 reports aggregated per reported event or pubkey: counts, trusted reporters, per-type counts, quarantine state and the reports themselves) / `grain_dismissreports` (params: `[event-id-or-pubkey]` — forgets the reports, lifts the quarantine and restores a quarantined event), `grain_listadmins` / `grain_addadmin` (params: `[pubkey, role]`; re-adding changes the role) / `grain_removeadmin` (params: `[pubkey]`) — take effect immediately, no reload.
 // @Description
-// @Description **Out of scope:** event-moderation methods (`allowevent` / `banevent` / `listbannedevents` / `listeventsneedingmoderation`) need a moderation queue that doesn't exist yet — tracked separately. Call `supportedmethods` at runtime for the authoritative list this build advertises.
+// @Description Call `supportedmethods` at runtime for the authoritative list this build advertises.
 // @Tags         nip86
 // @Accept       json
 // @Produce      json
 // @Param        body  body      nip86Request  true  "JSON-RPC envelope"
 // @Success      200   {object}  nip86Response
 // @Failure      401   {string}  string         "Unauthorized — missing/bad NIP-98 header"
-// @Failure      403   {string}  string         "Forbidden — signer is not relay owner"
+// @Failure      403   {string}  string         "Forbidden — signer is not a relay admin"
 // @Security     NostrAuth
 // @Router       / [post]
 func HandleNIP86(w http.ResponseWriter, r *http.Request) {
-	// RequireOwner reads the body for the NIP-98 payload hash and
+	// RequireAdmin reads the body for the NIP-98 payload hash and
 	// restores r.Body on the way out, so the JSON decode below sees
 	// the same bytes the signer hashed.
-	signer, ok := RequireOwner(w, r)
+	signer, role, ok := RequireAdmin(w, r)
 	if !ok {
 		return
 	}
@@ -117,9 +122,22 @@ func HandleNIP86(w http.ResponseWriter, r *http.Request) {
 	log.RelayAPI().Info("NIP-86 method invoked",
 		"client_ip", utils.GetClientIP(r),
 		"signer", signer,
+		"role", role,
 		"method", req.Method)
 
-	result, err := dispatchNIP86(req, signer)
+	if !roleAllows(role, req.Method) {
+		msg := "restricted: role " + role + " may not call " + req.Method
+		log.RelayAPI().Warn("NIP-86 method denied for role",
+			"client_ip", utils.GetClientIP(r),
+			"signer", signer,
+			"role", role,
+			"method", req.Method)
+		auditDenied(req, signer, utils.GetClientIP(r), msg)
+		writeNIP86Error(w, msg)
+		return
+	}
+
+	result, err := dispatchAudited(req, signer, role, utils.GetClientIP(r))
 	if err != "" {
 		writeNIP86Error(w, err)
 		return
@@ -134,15 +152,16 @@ func HandleNIP86(w http.ResponseWriter, r *http.Request) {
 // feature-detect via `supportedmethods` and a subsequent unknown-
 // method response without parsing two different error shapes.
 //
-// `signer` is the relay-owner pubkey RequireOwner returned for this
-// request; write methods log it for audit so admin actions can be
-// traced even though grain doesn't have a structured audit log yet.
-func dispatchNIP86(req nip86Request, signer string) (any, string) {
+// `signer` is the admin pubkey RequireAdmin returned for this
+// request and `role` its role, already checked against the method;
+// write methods log the signer, and dispatchAudited records it in
+// the audit log (nip86_audit.go).
+func dispatchNIP86(req nip86Request, signer, role string) (any, string) {
 	switch req.Method {
 
 	// ─── reads ─────────────────────────────────────────────────
 	case "supportedmethods":
-		return supportedNIP86Methods(), ""
+		return methodsForRole(role), ""
 	case "listallowedpubkeys":
 		return listAllowedPubkeysNIP86(), ""
 	case "listbannedpubkeys":
@@ -151,6 +170,10 @@ func dispatchNIP86(req nip86Request, signer string) (any, string) {
 		return listAllowedKindsNIP86(), ""
 	case "listblockedips":
 		return listBlockedIPsNIP86(), ""
+	case "listbannedevents":
+		return listBannedEventsNIP86(), ""
+	case "listeventsneedingmoderation":
+		return listEventsNeedingModerationNIP86(), ""
 
 	// ─── pubkey writes ────────────────────────────────────────
 	case "banpubkey":
@@ -168,6 +191,12 @@ func dispatchNIP86(req nip86Request, signer string) (any, string) {
 	case "disallowkind":
 		return runDisallowKind(req.Params, signer)
 
+	// ─── event moderation ─────────────────────────────────────
+	case "banevent":
+		return runBanEvent(req.Params, signer)
+	case "allowevent":
+		return runAllowEvent(req.Params, signer)
+
 	// ─── IP writes ────────────────────────────────────────────
 	case "blockip":
 		return runBlockIP(req.Params, signer)
@@ -221,6 +250,28 @@ func dispatchNIP86(req nip86Request, signer string) (any, string) {
 		return runGetBlacklistConfig()
 	case "grain_stats_overview":
 		return gatherStatsOverview(), ""
+	case "grain_stats_database":
+		return runStatsDatabase(req.Params)
+	case "grain_replicationstatus":
+		return replication.Status(), ""
+	case "grain_auditlog":
+		return runAuditLog(req.Params)
+	case "grain_backup":
+		return runBackup(req.Params, signer)
+	case "grain_previewpurge":
+		return runPreviewPurge(req.Params, signer)
+	case "grain_listreports":
+		return runListReports(req.Params)
+	case "grain_dismissreports":
+		return runDismissReports(req.Params, signer)
+
+	// ─── grain_* admin roles ─────────────────────────────────
+	case "grain_listadmins":
+		return runListAdmins()
+	case "grain_addadmin":
+		return runAddAdmin(req.Params, signer)
+	case "grain_removeadmin":
+		return runRemoveAdmin(req.Params, signer)
 
 	default:
 		return nil, "method not supported: " + req.Method
@@ -228,12 +279,10 @@ func dispatchNIP86(req nip86Request, signer string) (any, string) {
 }
 
 // supportedNIP86Methods returns the methods this build actually
-// implements. Spec calls this method out specifically so clients can
+// implements; supportedmethods narrows it to the caller's role
+// (methodsForRole). Spec calls this method out specifically so clients can
 // feature-detect; we treat it as the source of truth and update it in
-// lockstep with new wiring. Event-moderation methods
-// (listeventsneedingmoderation / allowevent / banevent /
-// listbannedevents) are deliberately excluded — they need a
-// moderation queue grain doesn't have yet.
+// lockstep with new wiring.
 func supportedNIP86Methods() []string {
 	return []string{
 		// reads
@@ -242,6 +291,8 @@ func supportedNIP86Methods() []string {
 		"listbannedpubkeys",
 		"listallowedkinds",
 		"listblockedips",
+		"listbannedevents",
+		"listeventsneedingmoderation",
 		// writes
 		"banpubkey",
 		"unbanpubkey",
@@ -251,6 +302,8 @@ func supportedNIP86Methods() []string {
 		"disallowkind",
 		"blockip",
 		"unblockip",
+		"banevent",
+		"allowevent",
 		"changerelayname",
 		"changerelaydescription",
 		"changerelayicon",
@@ -270,6 +323,16 @@ func supportedNIP86Methods() []string {
 		"grain_whitelistconfig",
 		"grain_blacklistconfig",
 		"grain_stats_overview",
+		"grain_stats_database",
+		"grain_replicationstatus",
+		"grain_auditlog",
+		"grain_backup",
+		"grain_previewpurge",
+		"grain_listreports",
+		"grain_dismissreports",
+		"grain_listadmins",
+		"grain_addadmin",
+		"grain_removeadmin",
 	}
 }
 
@@ -0,0 +1,162 @@
+// NIP-86 side of the admin audit log (server/audit).
+//
+// Every write method is listed in auditedNIP86Methods with the config
+// section it changes. HandleNIP86 snapshots that section from disk
+// before dispatch and again after, and records the difference along
+// with the signer and client IP — failed calls included, with their
+// error. Reads aren't audited.
+//
+// Snapshots come from the files, not the in-memory config: the
+// grain_update* family stages changes on disk and leaves the running
+// config alone until grain_reloadconfig, so the file is where the
+// change actually happened.
+
+package api
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/0ceanslim/grain/config"
+	"github.com/0ceanslim/grain/server/audit"
+
+	"gopkg.in/yaml.v3"
+)
+
+// auditedNIP86Methods maps each write method to the section it
+// changes: a file in the data directory, or "file:key" for one
+// top-level key of it. "" means the method changes no config.
+var auditedNIP86Methods = map[string]string{
+	"banpubkey":     "blacklist.yml",
+	"unbanpubkey":   "blacklist.yml",
+	"allowpubkey":   "whitelist.yml",
+	"unallowpubkey": "whitelist.yml",
+	"allowkind":     "whitelist.yml",
+	"disallowkind":  "whitelist.yml",
+	"blockip":       "config.yml:blacklist",
+	"unblockip":     "config.yml:blacklist",
+	"banevent":      "",
+	"allowevent":    "",
+
+	"grain_dismissreports": "",
+
+	"changerelayname":        "relay_metadata.json",
+	"changerelaydescription": "relay_metadata.json",
+	"changerelayicon":        "relay_metadata.json",
+
+	"grain_updateserver":               "config.yml:server",
+	"grain_updateratelimit":            "config.yml:rate_limit",
+	"grain_updateeventpurge":           "config.yml:event_purge",
+	"grain_updatelogging":              "config.yml:logging",
+	"grain_updateauth":                 "config.yml:auth",
+	"grain_updatebackuprelay":          "config.yml:backup_relay",
+	"grain_updateresourcelimits":       "config.yml:resource_limits",
+	"grain_updateeventtimeconstraints": "config.yml:event_time_constraints",
+	"grain_updatewhitelistconfig":      "whitelist.yml",
+	"grain_updateblacklistconfig":      "blacklist.yml",
+
+	"grain_addadmin":    "config.yml:admins",
+	"grain_removeadmin": "config.yml:admins",
+
+	"grain_reloadconfig": "",
+	"grain_backup":       "",
+	"grain_refreshcache": "",
+}
+
+// dispatchAudited is dispatchNIP86 plus the audit entry for writes.
+func dispatchAudited(req nip86Request, signer, role, ip string) (any, string) {
+	section, audited := auditedNIP86Methods[req.Method]
+	if !audited {
+		return dispatchNIP86(req, signer, role)
+	}
+
+	var before any
+	if section != "" {
+		before = snapshotSection(section)
+	}
+	result, errMsg := dispatchNIP86(req, signer, role)
+
+	entry := audit.Entry{
+		Source:  audit.SourceNIP86,
+		Method:  req.Method,
+		Signer:  signer,
+		IP:      ip,
+		Section: section,
+		Error:   errMsg,
+	}
+	// The grain_update* params are the whole section; the diff below
+	// already says what in it changed.
+	if !strings.HasPrefix(req.Method, "grain_update") && len(req.Params) > 0 {
+		entry.Params = req.Params
+	}
+	if section != "" && errMsg == "" {
+		entry.Changes = audit.Diff(before, snapshotSection(section))
+	}
+	audit.Record(entry)
+	return result, errMsg
+}
+
+// auditDenied records a write the signer's role didn't allow. Denied
+// reads aren't worth an entry.
+func auditDenied(req nip86Request, signer, ip, errMsg string) {
+	section, audited := auditedNIP86Methods[req.Method]
+	if !audited {
+		return
+	}
+	audit.Record(audit.Entry{
+		Source:  audit.SourceNIP86,
+		Method:  req.Method,
+		Signer:  signer,
+		IP:      ip,
+		Section: section,
+		Error:   errMsg,
+	})
+}
+
+// snapshotSection reads one section from disk as plain JSON-shaped
+// values, or nil if it can't be read.
+func snapshotSection(section string) any {
+	file, key, _ := strings.Cut(section, ":")
+	raw, err := os.ReadFile(config.ConfigPath(file))
+	if err != nil {
+		return nil
+	}
+
+	var doc any
+	if strings.HasSuffix(file, ".json") {
+		err = json.Unmarshal(raw, &doc)
+	} else {
+		err = yaml.Unmarshal(raw, &doc)
+	}
+	if err != nil {
+		return nil
+	}
+	if key == "" {
+		return doc
+	}
+	if m, ok := doc.(map[string]any); ok {
+		return m[key]
+	}
+	return nil
+}
+
+// runAuditLog is grain_auditlog: params[0] is an optional
+// audit.Query ({since, until, method, signer, source, limit}).
+func runAuditLog(params []any) (any, string) {
+	l := audit.Active()
+	if l == nil {
+		return nil, "audit log is not enabled"
+	}
+	var q audit.Query
+	if len(params) > 0 && params[0] != nil {
+		if err := paramJSON(params, 0, &q); err != nil {
+			return nil, err.Error()
+		}
+	}
+	entries, err := l.Query(q)
+	if err != nil {
+		return nil, err.Error()
+	}
+	return entries, ""
+}
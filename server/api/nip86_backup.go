@@ -0,0 +1,29 @@
+// NIP-86 grain_backup: an online copy of the database, through the
+// same server/backup manager as the schedule.
+
+package api
+
+import (
+	"github.com/0ceanslim/grain/server/backup"
+	"github.com/0ceanslim/grain/server/utils/log"
+)
+
+// runBackup is grain_backup: params[0] is an optional {compact}
+// overriding database.backup.compact. Returns the new backup once the
+// copy is done, so a big database means a long request.
+func runBackup(params []any, signer string) (any, string) {
+	var opts struct {
+		Compact *bool `json:"compact"`
+	}
+	if len(params) > 0 && params[0] != nil {
+		if err := paramJSON(params, 0, &opts); err != nil {
+			return nil, err.Error()
+		}
+	}
+	info, err := backup.Run(opts.Compact)
+	if err != nil {
+		return nil, err.Error()
+	}
+	log.RelayAPI().Info("NIP-86 grain_backup", "signer", signer, "path", info.Path, "size_bytes", info.SizeBytes)
+	return info, ""
+}
@@ -0,0 +1,88 @@
+// NIP-86 event moderation: banevent, allowevent, listbannedevents,
+// listeventsneedingmoderation and the NIP-56 report queue
+// (grain_listreports / grain_dismissreports), all backed by
+// server/moderation.
+
+package api
+
+import (
+	"strings"
+
+	"github.com/0ceanslim/grain/server/moderation"
+	"github.com/0ceanslim/grain/server/utils/log"
+)
+
+// runBanEvent is banevent: params [id, reason?]. Deletes the event
+// and rejects re-publishes of it.
+func runBanEvent(params []any, signer string) (any, string) {
+	id, ok := paramString(params, 0)
+	if !ok || !isHexPubkey(id) {
+		return nil, "invalid event id"
+	}
+	id = strings.ToLower(id)
+	reason, _ := paramString(params, 1)
+	if err := moderation.Ban(id, reason); err != nil {
+		return nil, err.Error()
+	}
+	log.RelayAPI().Info("NIP-86 banevent", "signer", signer, "event_id", id, "reason", reason)
+	return true, ""
+}
+
+// runAllowEvent is allowevent: params [id, reason?]. Stores a held
+// event, clears a flagged one, or lifts a ban.
+func runAllowEvent(params []any, signer string) (any, string) {
+	id, ok := paramString(params, 0)
+	if !ok || !isHexPubkey(id) {
+		return nil, "invalid event id"
+	}
+	id = strings.ToLower(id)
+	reason, _ := paramString(params, 1)
+	if err := moderation.Allow(id); err != nil {
+		return nil, err.Error()
+	}
+	log.RelayAPI().Info("NIP-86 allowevent", "signer", signer, "event_id", id, "reason", reason)
+	return true, ""
+}
+
+// listBannedEventsNIP86 is listbannedevents: the spec's {id, reason}
+// plus when the ban happened.
+func listBannedEventsNIP86() any {
+	return moderation.ListBanned()
+}
+
+// listEventsNeedingModerationNIP86 is listeventsneedingmoderation:
+// the spec's {id, reason} plus where the entry came from and, for
+// held events, the event itself — it isn't anywhere else to look at.
+func listEventsNeedingModerationNIP86() any {
+	return moderation.ListQueue()
+}
+
+// runListReports is grain_listreports: params[0] is an optional
+// {target_type, type, quarantined, limit} filter.
+func runListReports(params []any) (any, string) {
+	var q moderation.ReportQuery
+	if len(params) > 0 && params[0] != nil {
+		if err := paramJSON(params, 0, &q); err != nil {
+			return nil, err.Error()
+		}
+	}
+	if q.TargetType != "" && q.TargetType != moderation.TargetEvent && q.TargetType != moderation.TargetPubkey {
+		return nil, "target_type must be \"event\" or \"pubkey\""
+	}
+	return moderation.ListReports(q), ""
+}
+
+// runDismissReports is grain_dismissreports: params [event id or
+// pubkey]. Forgets the reports about it and lifts its quarantine.
+func runDismissReports(params []any, signer string) (any, string) {
+	target, ok := paramString(params, 0)
+	if !ok || !isHexPubkey(target) {
+		return nil, "invalid target: want a hex event id or pubkey"
+	}
+	target = strings.ToLower(target)
+	if err := moderation.DismissReports(target); err != nil {
+		return nil, err.Error()
+	}
+	log.RelayAPI().Info("NIP-86 grain_dismissreports", "signer", signer, "target", target)
+	return true, ""
+}
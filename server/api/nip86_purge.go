@@ -0,0 +1,70 @@
+// NIP-86 grain_previewpurge: a dry run of the event_purge retention
+// rules, reporting what each would delete.
+
+package api
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/0ceanslim/grain/config"
+	cfgType "github.com/0ceanslim/grain/config/types"
+	"github.com/0ceanslim/grain/server/db/nostrdb"
+	"github.com/0ceanslim/grain/server/utils/log"
+)
+
+// previewMu keeps to one preview at a time: each walks every event
+// older than the shortest retention window.
+var previewMu sync.Mutex
+
+// runPreviewPurge is grain_previewpurge: params[0] is an optional
+// event_purge section (the grain_updateeventpurge shape) to try
+// instead of the running one, so rules can be checked before they're
+// saved. Nothing is deleted; enabled and dry_run are ignored.
+func runPreviewPurge(params []any, signer string) (any, string) {
+	var ep cfgType.EventPurgeConfig
+	if len(params) > 0 && params[0] != nil {
+		if err := paramJSON(params, 0, &ep); err != nil {
+			return nil, err.Error()
+		}
+		if err := ep.ValidateRules(); err != nil {
+			return nil, err.Error()
+		}
+	} else {
+		cfg := config.GetConfig()
+		if cfg == nil {
+			return nil, "config not loaded"
+		}
+		ep = cfg.EventPurge
+	}
+	report, err := previewPurge(&ep)
+	if err != nil {
+		return nil, err.Error()
+	}
+	log.RelayAPI().Info("NIP-86 grain_previewpurge",
+		"signer", signer,
+		"scanned", report.Scanned,
+		"would_purge", report.Purged)
+	return report, ""
+}
+
+func previewPurge(ep *cfgType.EventPurgeConfig) (*nostrdb.PurgeReport, error) {
+	db := nostrdb.GetDB()
+	if db == nil {
+		return nil, errors.New("database not available")
+	}
+	if !previewMu.TryLock() {
+		return nil, errors.New("a purge preview is already running")
+	}
+	defer previewMu.Unlock()
+
+	var whitelisted []string
+	if pc := config.GetPubkeyCache(); pc != nil {
+		whitelisted = pc.GetWhitelistedPubkeys()
+	}
+	report, err := db.Purge(ep, whitelisted, true)
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
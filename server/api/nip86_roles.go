@@ -0,0 +1,141 @@
+// Role-based access to NIP-86. HandleNIP86 admits any pubkey with a
+// role (RequireAdmin) and then checks the method against that role
+// here:
+//
+//	owner      every method
+//	moderator  the reads, the audit log, pubkey bans, IP blocks and
+//	           event moderation
+//	viewer     the reads
+//
+// supportedmethods answers per caller, so a moderator's client only
+// offers what the moderator can actually do.
+
+package api
+
+import (
+	"strings"
+
+	"github.com/0ceanslim/grain/config"
+	cfgType "github.com/0ceanslim/grain/config/types"
+	"github.com/0ceanslim/grain/server/utils"
+	"github.com/0ceanslim/grain/server/utils/log"
+)
+
+// viewerMethods are the reads. None of them returns anything a
+// moderator or owner couldn't see anyway.
+var viewerMethods = map[string]bool{
+	"supportedmethods":        true,
+	"listallowedpubkeys":      true,
+	"listbannedpubkeys":       true,
+	"listallowedkinds":        true,
+	"listblockedips":          true,
+	"grain_whitelistconfig":   true,
+	"grain_blacklistconfig":   true,
+	"grain_stats_overview":    true,
+	"grain_stats_database":    true,
+	"grain_replicationstatus": true,
+	"grain_listadmins":        true,
+
+	"listbannedevents":            true,
+	"listeventsneedingmoderation": true,
+	"grain_listreports":           true,
+}
+
+// moderatorMethods are what a moderator may call on top of the reads:
+// keeping people and events off the relay, and seeing who else has
+// been doing it.
+var moderatorMethods = map[string]bool{
+	"banpubkey":      true,
+	"unbanpubkey":    true,
+	"blockip":        true,
+	"unblockip":      true,
+	"banevent":       true,
+	"allowevent":     true,
+	"grain_auditlog": true,
+
+	"grain_dismissreports": true,
+}
+
+// roleAllows reports whether role may call method.
+func roleAllows(role, method string) bool {
+	switch role {
+	case cfgType.AdminRoleOwner:
+		return true
+	case cfgType.AdminRoleModerator:
+		return viewerMethods[method] || moderatorMethods[method]
+	case cfgType.AdminRoleViewer:
+		return viewerMethods[method]
+	}
+	return false
+}
+
+// methodsForRole is supportedmethods for a caller with role.
+func methodsForRole(role string) []string {
+	all := supportedNIP86Methods()
+	out := make([]string, 0, len(all))
+	for _, m := range all {
+		if roleAllows(role, m) {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// nip86AdminEntry is one row of grain_listadmins.
+type nip86AdminEntry struct {
+	Pubkey string `json:"pubkey"`
+	Role   string `json:"role"`
+}
+
+// runListAdmins is grain_listadmins: the relay owner, then config.yml's
+// admins list.
+func runListAdmins() (any, string) {
+	out := []nip86AdminEntry{}
+	if owner := utils.GetRelayOwnerPubkey(); owner != "" {
+		out = append(out, nip86AdminEntry{Pubkey: owner, Role: cfgType.AdminRoleOwner})
+	}
+	for _, a := range config.ListAdmins() {
+		out = append(out, nip86AdminEntry{Pubkey: a.Pubkey, Role: a.Role})
+	}
+	return out, ""
+}
+
+// runAddAdmin is grain_addadmin: params [pubkey, role]. Adding a
+// pubkey that's already an admin changes its role.
+func runAddAdmin(params []any, signer string) (any, string) {
+	pubkey, ok := paramString(params, 0)
+	if !ok || !isHexPubkey(pubkey) {
+		return nil, "invalid pubkey"
+	}
+	pubkey = strings.ToLower(pubkey)
+	role, _ := paramString(params, 1)
+	if !cfgType.ValidAdminRole(role) {
+		return nil, "invalid role: want owner, moderator or viewer"
+	}
+	if IsRelayOwner(pubkey) {
+		return nil, "pubkey is the relay owner"
+	}
+	if err := config.SetAdmin(pubkey, role); err != nil {
+		return nil, err.Error()
+	}
+	log.RelayAPI().Info("NIP-86 grain_addadmin", "signer", signer, "pubkey", pubkey, "role", role)
+	return true, ""
+}
+
+// runRemoveAdmin is grain_removeadmin: params [pubkey]. The relay
+// owner can't be removed this way; ownership lives in
+// relay_metadata.json.
+func runRemoveAdmin(params []any, signer string) (any, string) {
+	pubkey, ok := paramString(params, 0)
+	if !ok || !isHexPubkey(pubkey) {
+		return nil, "invalid pubkey"
+	}
+	if IsRelayOwner(pubkey) {
+		return nil, "pubkey is the relay owner"
+	}
+	if err := config.RemoveAdmin(pubkey); err != nil {
+		return nil, err.Error()
+	}
+	log.RelayAPI().Info("NIP-86 grain_removeadmin", "signer", signer, "pubkey", pubkey)
+	return true, ""
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"testing"
+
+	cfgType "github.com/0ceanslim/grain/config/types"
+)
+
+func TestRoleAllows(t *testing.T) {
+	cases := []struct {
+		role, method string
+		want         bool
+	}{
+		{cfgType.AdminRoleOwner, "grain_updateauth", true},
+		{cfgType.AdminRoleOwner, "grain_addadmin", true},
+		{cfgType.AdminRoleModerator, "banpubkey", true},
+		{cfgType.AdminRoleModerator, "blockip", true},
+		{cfgType.AdminRoleModerator, "listbannedpubkeys", true},
+		{cfgType.AdminRoleModerator, "grain_updateratelimit", false},
+		{cfgType.AdminRoleModerator, "grain_updateauth", false},
+		{cfgType.AdminRoleModerator, "grain_addadmin", false},
+		{cfgType.AdminRoleViewer, "listblockedips", true},
+		{cfgType.AdminRoleViewer, "banpubkey", false},
+		{cfgType.AdminRoleViewer, "grain_auditlog", false},
+		{"", "supportedmethods", false},
+	}
+	for _, tc := range cases {
+		if got := roleAllows(tc.role, tc.method); got != tc.want {
+			t.Errorf("roleAllows(%q, %q) = %v, want %v", tc.role, tc.method, got, tc.want)
+		}
+	}
+}
+
+func TestMethodsForRole_SubsetOfSupported(t *testing.T) {
+	supported := map[string]bool{}
+	for _, m := range supportedNIP86Methods() {
+		supported[m] = true
+	}
+	// Every method a role set names has to exist, or a typo there
+	// silently grants nothing.
+	for m := range viewerMethods {
+		if !supported[m] {
+			t.Errorf("viewerMethods names unsupported method %q", m)
+		}
+	}
+	for m := range moderatorMethods {
+		if !supported[m] {
+			t.Errorf("moderatorMethods names unsupported method %q", m)
+		}
+	}
+	if n := len(methodsForRole(cfgType.AdminRoleOwner)); n != len(supported) {
+		t.Errorf("owner sees %d methods, want all %d", n, len(supported))
+	}
+	if n := len(methodsForRole(cfgType.AdminRoleViewer)); n != len(viewerMethods) {
+		t.Errorf("viewer sees %d methods, want %d", n, len(viewerMethods))
+	}
+}
@@ -7,10 +7,10 @@
 //   3. Logs the action with the signer pubkey for audit.
 //   4. Returns `(true, "")` on success or `(nil, "<msg>")` on failure.
 //
-// The signer pubkey is the relay-owner pubkey that already passed
-// RequireOwner — every write is gated, so logging it is purely
-// audit. Once grain has a structured audit log (out of scope for
-// phase 1), these logs become the seed for that.
+// The signer pubkey is the admin pubkey that already passed
+// RequireAdmin and whose role covers the method — every write is
+// gated, so logging it is purely informational. The structured
+// record is the audit log (nip86_audit.go).
 
 package api
 
@@ -1,4 +1,4 @@
-// NIP-86 stats helpers (grain_stats_overview).
+// NIP-86 stats helpers (grain_stats_overview, grain_stats_database).
 //
 // Counters that live inside `package server` (currentConnections,
 // totalMessagesSent, uptime since process start) can't be imported
@@ -15,7 +15,10 @@
 package api
 
 import (
+	"errors"
+
 	"github.com/0ceanslim/grain/config"
+	"github.com/0ceanslim/grain/server/db/nostrdb"
 )
 
 // ServerStats is the slice of stats only the server package can
@@ -101,3 +104,46 @@ func gatherStatsOverview() statsOverviewResult {
 
 	return res
 }
+
+const (
+	defaultTopAuthors = 20
+	maxTopAuthors     = 500
+)
+
+// runStatsDatabase is grain_stats_database: params[0] is an optional
+// {limit} for the top-author lists. Everything in the answer is kept
+// up to date as events come and go, or cached, so the call never
+// scans the database.
+func runStatsDatabase(params []any) (any, string) {
+	var opts struct {
+		Limit int `json:"limit"`
+	}
+	if len(params) > 0 && params[0] != nil {
+		if err := paramJSON(params, 0, &opts); err != nil {
+			return nil, err.Error()
+		}
+	}
+	if opts.Limit < 0 {
+		return nil, "limit must be non-negative"
+	}
+	if opts.Limit == 0 {
+		opts.Limit = defaultTopAuthors
+	}
+	stats, err := gatherStatsDatabase(min(opts.Limit, maxTopAuthors))
+	if err != nil {
+		return nil, err.Error()
+	}
+	return stats, ""
+}
+
+func gatherStatsDatabase(topN int) (*nostrdb.DatabaseStats, error) {
+	db := nostrdb.GetDB()
+	if db == nil {
+		return nil, errors.New("database not available")
+	}
+	stats, err := db.DatabaseStats(topN)
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
@@ -0,0 +1,210 @@
+// Package audit keeps the relay's admin audit log: an append-only
+// JSONL file (audit.jsonl in the data directory) with one entry per
+// administrative action.
+//
+// What gets recorded, and by whom:
+//
+//	nip86   every NIP-86 write, by the signer          (server/api)
+//	reload  every config reload, with what it changed (server/startup.go)
+//	cli     grain --delete / --delete-file runs        (server/delete_cli.go)
+//	setup   the first-run /setup ownership claim       (client/setup.go)
+//
+// Config changes carry a list of Changes — the fields of the affected
+// section that differ, with their values before and after — rather
+// than whole copies of the section, so a ban on a 10,000-entry
+// blacklist doesn't log 20,000 pubkeys.
+//
+// The file is never rewritten: entries are appended with O_APPEND and
+// queried by scanning. Admin actions are rare enough that neither
+// needs to be clever.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/0ceanslim/grain/server/utils/log"
+)
+
+// Sources an entry can come from.
+const (
+	SourceNIP86  = "nip86"
+	SourceReload = "reload"
+	SourceCLI    = "cli"
+	SourceSetup  = "setup"
+)
+
+// Entry is one line of the audit log.
+type Entry struct {
+	Time    int64    `json:"time"`   // unix seconds
+	Source  string   `json:"source"` // one of the Source* constants
+	Method  string   `json:"method"` // NIP-86 method, or what the action was ("reload", "delete", "claim")
+	Signer  string   `json:"signer,omitempty"`
+	IP      string   `json:"ip,omitempty"`
+	Params  any      `json:"params,omitempty"`
+	Section string   `json:"section,omitempty"` // config section the action touched
+	Changes []Change `json:"changes,omitempty"`
+	Error   string   `json:"error,omitempty"` // set when the action failed
+}
+
+// Log is an audit file.
+type Log struct {
+	mu   sync.Mutex
+	path string
+}
+
+// New returns the audit log at path. The file is created on the
+// first Append.
+func New(path string) *Log {
+	return &Log{path: path}
+}
+
+// Path is the file the log appends to.
+func (l *Log) Path() string { return l.path }
+
+// Append writes e as one line. A zero Time is set to now.
+func (l *Log) Append(e Entry) error {
+	if e.Time == 0 {
+		e.Time = time.Now().Unix()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("marshal audit entry: %w", err)
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return fmt.Errorf("write audit log: %w", err)
+	}
+	return f.Close()
+}
+
+// Query selects entries from the log. Zero fields don't filter.
+type Query struct {
+	Since  int64  `json:"since"`  // unix seconds, inclusive
+	Until  int64  `json:"until"`  // unix seconds, inclusive
+	Method string `json:"method"` // exact match
+	Signer string `json:"signer"` // exact match
+	Source string `json:"source"` // exact match
+	Limit  int    `json:"limit"`  // default 100, at most 1000
+}
+
+const (
+	defaultQueryLimit = 100
+	maxQueryLimit     = 1000
+)
+
+func (q Query) match(e Entry) bool {
+	return (q.Since == 0 || e.Time >= q.Since) &&
+		(q.Until == 0 || e.Time <= q.Until) &&
+		(q.Method == "" || e.Method == q.Method) &&
+		(q.Signer == "" || e.Signer == q.Signer) &&
+		(q.Source == "" || e.Source == q.Source)
+}
+
+// Query returns the newest entries matching q, newest first. A
+// missing file is an empty log. Lines that don't parse (a torn write
+// after a crash) are skipped.
+func (l *Log) Query(q Query) ([]Entry, error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultQueryLimit
+	}
+	if limit > maxQueryLimit {
+		limit = maxQueryLimit
+	}
+
+	l.mu.Lock()
+	f, err := os.Open(l.path)
+	l.mu.Unlock()
+	if errors.Is(err, os.ErrNotExist) {
+		return []Entry{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open audit log: %w", err)
+	}
+	defer f.Close()
+
+	// Keep the last `limit` matches in a ring; the file is in
+	// append order, so those are the newest.
+	ring := make([]Entry, 0, limit)
+	next := 0
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			var e Entry
+			if json.Unmarshal(line, &e) == nil && q.match(e) {
+				if len(ring) < limit {
+					ring = append(ring, e)
+				} else {
+					ring[next] = e
+					next = (next + 1) % limit
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read audit log: %w", err)
+		}
+	}
+
+	out := make([]Entry, 0, len(ring))
+	for i := len(ring) - 1; i >= 0; i-- {
+		out = append(out, ring[(next+i)%len(ring)])
+	}
+	return out, nil
+}
+
+var (
+	active   *Log
+	activeMu sync.RWMutex
+)
+
+// SetLog installs the process-wide audit log (nil to turn auditing
+// off) and returns the previous one.
+func SetLog(l *Log) *Log {
+	activeMu.Lock()
+	defer activeMu.Unlock()
+	prev := active
+	active = l
+	return prev
+}
+
+// Active returns the process-wide audit log, or nil.
+func Active() *Log {
+	activeMu.RLock()
+	defer activeMu.RUnlock()
+	return active
+}
+
+// Record appends e to the active log. Failing to audit never fails
+// the action being audited; the error is logged instead.
+func Record(e Entry) {
+	l := Active()
+	if l == nil {
+		return
+	}
+	if err := l.Append(e); err != nil {
+		log.Audit().Error("Failed to write audit entry",
+			"source", e.Source,
+			"method", e.Method,
+			"error", err)
+	}
+}
@@ -0,0 +1,134 @@
+package audit
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLog_QueryFiltersNewestFirst(t *testing.T) {
+	l := New(filepath.Join(t.TempDir(), "audit.jsonl"))
+
+	// A missing file is an empty log, not an error.
+	if got, err := l.Query(Query{}); err != nil || len(got) != 0 {
+		t.Fatalf("empty log: %v, %v", got, err)
+	}
+
+	entries := []Entry{
+		{Time: 100, Source: SourceNIP86, Method: "banpubkey", Signer: "alice"},
+		{Time: 200, Source: SourceNIP86, Method: "allowkind", Signer: "bob"},
+		{Time: 300, Source: SourceReload, Method: "reload"},
+		{Time: 400, Source: SourceNIP86, Method: "banpubkey", Signer: "bob"},
+		{Time: 500, Source: SourceCLI, Method: "delete"},
+	}
+	for _, e := range entries {
+		if err := l.Append(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	times := func(es []Entry) []int64 {
+		out := []int64{}
+		for _, e := range es {
+			out = append(out, e.Time)
+		}
+		return out
+	}
+	cases := []struct {
+		name string
+		q    Query
+		want []int64
+	}{
+		{"all", Query{}, []int64{500, 400, 300, 200, 100}},
+		{"method", Query{Method: "banpubkey"}, []int64{400, 100}},
+		{"signer", Query{Signer: "bob"}, []int64{400, 200}},
+		{"source", Query{Source: SourceReload}, []int64{300}},
+		{"window", Query{Since: 200, Until: 400}, []int64{400, 300, 200}},
+		{"limit keeps newest", Query{Limit: 2}, []int64{500, 400}},
+		{"limit after filter", Query{Signer: "bob", Limit: 1}, []int64{400}},
+	}
+	for _, tc := range cases {
+		got, err := l.Query(tc.q)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if !reflect.DeepEqual(times(got), tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, times(got), tc.want)
+		}
+	}
+}
+
+func TestLog_SkipsTornLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l := New(path)
+	if err := l.Append(Entry{Time: 1, Source: SourceNIP86, Method: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	// A crash mid-write leaves half a line behind.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"time":2,"sour`)
+	f.Close()
+	if err := l.Append(Entry{Time: 3, Source: SourceNIP86, Method: "b"}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := l.Query(Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The torn line swallows the next entry's line too — both are
+	// skipped rather than failing the whole query.
+	if len(got) != 1 || got[0].Method != "a" {
+		t.Fatalf("got %+v, want only entry a", got)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	type section struct {
+		Enabled bool     `json:"enabled"`
+		Port    int      `json:"port"`
+		Pubkeys []string `json:"pubkeys"`
+		Limits  struct {
+			Max int `json:"max"`
+		} `json:"limits"`
+	}
+	var before, after section
+	before.Port, after.Port = 8181, 8181
+	before.Pubkeys = []string{"a", "b"}
+	after.Pubkeys = []string{"b", "c"}
+	before.Limits.Max = 10
+	after.Limits.Max = 20
+	after.Enabled = true
+
+	got := Diff(before, after)
+	want := []Change{
+		{Path: "enabled", Before: false, After: true},
+		{Path: "limits.max", Before: float64(10), After: float64(20)},
+		{Path: "pubkeys", Added: []any{"c"}, Removed: []any{"a"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		g, _ := json.Marshal(got)
+		w, _ := json.Marshal(want)
+		t.Fatalf("Diff:\n got %s\nwant %s", g, w)
+	}
+
+	// Typed structs, decoded maps and raw JSON all compare the same;
+	// reordering a list or null vs [] isn't a change.
+	raw := json.RawMessage(`{"enabled":false,"port":8181,"pubkeys":["b","a"],"limits":{"max":10}}`)
+	if d := Diff(before, raw); len(d) != 0 {
+		t.Fatalf("equal snapshots differ: %+v", d)
+	}
+	if d := Diff(map[string]any{"kinds": nil}, map[string]any{"kinds": []any{}}); len(d) != 0 {
+		t.Fatalf("null vs [] differ: %+v", d)
+	}
+
+	// A section that didn't exist before shows up whole.
+	if d := Diff(nil, map[string]any{"x": 1}); len(d) != 1 || d[0].Before != nil {
+		t.Fatalf("created section: %+v", d)
+	}
+}
@@ -0,0 +1,132 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Change is one field that differs between two snapshots. Path is
+// dotted ("server.port", "pubkey_whitelist.pubkeys"). Lists of plain
+// values report what was added and removed; anything else reports
+// the value before and after (absent when the field didn't exist).
+type Change struct {
+	Path    string `json:"path"`
+	Before  any    `json:"before,omitempty"`
+	After   any    `json:"after,omitempty"`
+	Added   []any  `json:"added,omitempty"`
+	Removed []any  `json:"removed,omitempty"`
+}
+
+// Diff compares two snapshots of the same config section, in their
+// JSON form. Either may be nil (section created or removed). Field
+// names are the JSON names, so callers can pass typed config structs,
+// decoded YAML, or raw JSON.
+func Diff(before, after any) []Change {
+	var out []Change
+	diffValue("", normalize(before), normalize(after), &out)
+	return out
+}
+
+// normalize turns v into plain JSON values (map[string]any, []any,
+// float64, string, bool, nil) so differently-typed snapshots compare.
+func normalize(v any) any {
+	if v == nil {
+		return nil
+	}
+	raw, ok := v.(json.RawMessage)
+	if !ok {
+		var err error
+		if raw, err = json.Marshal(v); err != nil {
+			return fmt.Sprintf("<unmarshalable: %v>", err)
+		}
+	}
+	var out any
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return fmt.Sprintf("<invalid: %v>", err)
+	}
+	return out
+}
+
+func diffValue(path string, a, b any, out *[]Change) {
+	am, aIsMap := a.(map[string]any)
+	bm, bIsMap := b.(map[string]any)
+	if aIsMap && bIsMap {
+		keys := make(map[string]bool, len(am)+len(bm))
+		for k := range am {
+			keys[k] = true
+		}
+		for k := range bm {
+			keys[k] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+		for _, k := range sorted {
+			diffValue(join(path, k), am[k], bm[k], out)
+		}
+		return
+	}
+
+	if reflect.DeepEqual(a, b) {
+		return
+	}
+
+	as, aIsList := scalarList(a)
+	bs, bIsList := scalarList(b)
+	if aIsList && bIsList {
+		// Reordering a list, or null vs [], isn't a change worth
+		// recording.
+		added, removed := setDiff(bs, as), setDiff(as, bs)
+		if len(added) > 0 || len(removed) > 0 {
+			*out = append(*out, Change{Path: path, Added: added, Removed: removed})
+		}
+		return
+	}
+	*out = append(*out, Change{Path: path, Before: a, After: b})
+}
+
+// scalarList reports whether v is a list (or nothing, which counts as
+// an empty list) of strings, numbers and bools.
+func scalarList(v any) ([]any, bool) {
+	if v == nil {
+		return nil, true
+	}
+	list, ok := v.([]any)
+	if !ok {
+		return nil, false
+	}
+	for _, item := range list {
+		switch item.(type) {
+		case string, float64, bool:
+		default:
+			return nil, false
+		}
+	}
+	return list, true
+}
+
+// setDiff returns the members of a that aren't in b, in a's order.
+func setDiff(a, b []any) []any {
+	in := make(map[any]bool, len(b))
+	for _, v := range b {
+		in[v] = true
+	}
+	var out []any
+	for _, v := range a {
+		if !in[v] {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,364 @@
+// Package backup takes and manages online copies of the nostrdb
+// files. A backup is a directory:
+//
+//	<dir>/grain-backup-20260102T030405.123Z/
+//	    data.mdb       LMDB copy, taken under a read transaction
+//	    manifest.json  when, how big, compacted or not
+//
+// It's written under a dot-prefixed .partial name and renamed into
+// place once the manifest is down, so anything List sees is complete.
+// The copy itself comes from a Copier (nostrdb.NDB), keeping this
+// package free of cgo.
+//
+// Three things take backups: `grain --backup <dir>`, NIP-86
+// grain_backup and, with database.backup.enabled, a schedule that
+// also prunes down to database.backup.retain. `grain --restore`
+// checks one with Validate before swapping it in.
+package backup
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0ceanslim/grain/config"
+	cfgType "github.com/0ceanslim/grain/config/types"
+	"github.com/0ceanslim/grain/server/utils/log"
+)
+
+// Files in a backup directory.
+const (
+	DataFile     = "data.mdb"
+	ManifestFile = "manifest.json"
+)
+
+const (
+	dirPrefix     = "grain-backup-"
+	partialSuffix = ".partial"
+	nameLayout    = "20060102T150405.000Z" // sorts chronologically
+
+	defaultDir           = "backups"
+	defaultIntervalHours = 24
+)
+
+// lmdbMagic sits right after the page header of an LMDB meta page.
+const (
+	lmdbMagic       = 0xBEEFC0DE
+	lmdbMagicOffset = 16
+)
+
+// Copier writes a consistent copy of the database into an empty
+// directory.
+type Copier interface {
+	CopyTo(dir string, compact bool) error
+}
+
+// Manifest describes one backup.
+type Manifest struct {
+	CreatedAt int64  `json:"created_at"`
+	Compact   bool   `json:"compact"`
+	SizeBytes int64  `json:"size_bytes"` // of data.mdb
+	Source    string `json:"source"`     // database directory it was taken from
+}
+
+// Info is a backup on disk.
+type Info struct {
+	Path string `json:"path"`
+	Manifest
+}
+
+// Create takes a backup of src into a new directory under parent.
+// source is recorded in the manifest.
+func Create(parent string, src Copier, compact bool, source string) (*Info, error) {
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return nil, fmt.Errorf("create backup directory: %w", err)
+	}
+
+	now := time.Now().UTC()
+	final := filepath.Join(parent, dirPrefix+now.Format(nameLayout))
+	for exists(final) {
+		time.Sleep(time.Millisecond)
+		now = time.Now().UTC()
+		final = filepath.Join(parent, dirPrefix+now.Format(nameLayout))
+	}
+	tmp := filepath.Join(parent, "."+filepath.Base(final)+partialSuffix)
+	if err := os.RemoveAll(tmp); err != nil {
+		return nil, err
+	}
+	if err := os.Mkdir(tmp, 0755); err != nil {
+		return nil, fmt.Errorf("create backup directory: %w", err)
+	}
+	fail := func(err error) (*Info, error) {
+		os.RemoveAll(tmp)
+		return nil, err
+	}
+
+	start := time.Now()
+	if err := src.CopyTo(tmp, compact); err != nil {
+		return fail(fmt.Errorf("copy database: %w", err))
+	}
+	st, err := os.Stat(filepath.Join(tmp, DataFile))
+	if err != nil {
+		return fail(fmt.Errorf("copy left no %s: %w", DataFile, err))
+	}
+
+	m := Manifest{CreatedAt: now.Unix(), Compact: compact, SizeBytes: st.Size(), Source: source}
+	raw, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fail(err)
+	}
+	if err := config.AtomicWriteFile(filepath.Join(tmp, ManifestFile), raw, 0644); err != nil {
+		return fail(fmt.Errorf("write manifest: %w", err))
+	}
+	if err := os.Rename(tmp, final); err != nil {
+		return fail(fmt.Errorf("move backup into place: %w", err))
+	}
+
+	log.Backup().Info("Backup created",
+		"path", final,
+		"size_bytes", m.SizeBytes,
+		"compact", compact,
+		"took", time.Since(start).Round(time.Millisecond))
+	return &Info{Path: final, Manifest: m}, nil
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// List returns the complete backups under parent, newest first. A
+// missing parent is no backups.
+func List(parent string) ([]Info, error) {
+	entries, err := os.ReadDir(parent)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Info{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	out := []Info{}
+	for _, e := range entries {
+		if !e.IsDir() || !strings.HasPrefix(e.Name(), dirPrefix) {
+			continue
+		}
+		path := filepath.Join(parent, e.Name())
+		m, err := readManifest(path)
+		if err != nil {
+			log.Backup().Warn("Skipping backup without a readable manifest", "path", path, "error", err)
+			continue
+		}
+		out = append(out, Info{Path: path, Manifest: *m})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path > out[j].Path })
+	return out, nil
+}
+
+// Prune deletes all but the newest keep backups under parent and
+// returns the paths it removed. keep <= 0 keeps everything.
+func Prune(parent string, keep int) ([]string, error) {
+	if keep <= 0 {
+		return nil, nil
+	}
+	all, err := List(parent)
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for _, b := range all[min(keep, len(all)):] {
+		if err := os.RemoveAll(b.Path); err != nil {
+			return removed, fmt.Errorf("remove %s: %w", b.Path, err)
+		}
+		removed = append(removed, b.Path)
+		log.Backup().Info("Old backup removed", "path", b.Path)
+	}
+	return removed, nil
+}
+
+func readManifest(path string) (*Manifest, error) {
+	raw, err := os.ReadFile(filepath.Join(path, ManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", ManifestFile, err)
+	}
+	return &m, nil
+}
+
+// Validate checks that path holds a complete backup: a manifest, and
+// a data.mdb of the recorded size that starts with an LMDB meta page.
+// Whether nostrdb can read it is for the caller to try.
+func Validate(path string) (*Manifest, error) {
+	m, err := readManifest(path)
+	if err != nil {
+		return nil, fmt.Errorf("not a grain backup: %w", err)
+	}
+	f, err := os.Open(filepath.Join(path, DataFile))
+	if err != nil {
+		return nil, fmt.Errorf("backup has no %s: %w", DataFile, err)
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if st.Size() != m.SizeBytes {
+		return nil, fmt.Errorf("%s is %d bytes, manifest says %d: truncated or modified", DataFile, st.Size(), m.SizeBytes)
+	}
+	var head [lmdbMagicOffset + 4]byte
+	if _, err := io.ReadFull(f, head[:]); err != nil {
+		return nil, fmt.Errorf("read %s: %w", DataFile, err)
+	}
+	if binary.LittleEndian.Uint32(head[lmdbMagicOffset:]) != lmdbMagic {
+		return nil, fmt.Errorf("%s is not an LMDB database", DataFile)
+	}
+	return m, nil
+}
+
+// Dir resolves the configured backup directory against the data dir.
+func Dir(cfg cfgType.DatabaseBackupConfig) string {
+	dir := cfg.Dir
+	if dir == "" {
+		dir = defaultDir
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(config.GetDataDir(), dir)
+	}
+	return dir
+}
+
+// Manager takes backups for the running relay, one at a time, and
+// runs the schedule when it's enabled.
+type Manager struct {
+	cfg    cfgType.DatabaseBackupConfig
+	src    Copier
+	source string
+	dir    string
+
+	mu   sync.Mutex // one backup at a time
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewManager backs up src (the database at source) per cfg.
+func NewManager(cfg cfgType.DatabaseBackupConfig, src Copier, source string) *Manager {
+	if cfg.IntervalHours <= 0 {
+		cfg.IntervalHours = defaultIntervalHours
+	}
+	return &Manager{cfg: cfg, src: src, source: source, dir: Dir(cfg)}
+}
+
+// Run takes a backup now and prunes to the retention count.
+// compact overrides the configured setting.
+func (m *Manager) Run(compact bool) (*Info, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	info, err := Create(m.dir, m.src, compact, m.source)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := Prune(m.dir, m.cfg.Retain); err != nil {
+		log.Backup().Warn("Failed to prune old backups", "error", err)
+	}
+	return info, nil
+}
+
+// Start runs the schedule, if enabled, until Stop. The first backup
+// is one interval after the newest existing one, so restarts don't
+// take a backup each time.
+func (m *Manager) Start() {
+	if !m.cfg.Enabled {
+		return
+	}
+	m.stop = make(chan struct{})
+	m.done = make(chan struct{})
+	interval := time.Duration(m.cfg.IntervalHours) * time.Hour
+
+	wait := time.Duration(0)
+	if all, err := List(m.dir); err == nil && len(all) > 0 {
+		wait = time.Until(time.Unix(all[0].CreatedAt, 0).Add(interval))
+		if wait < 0 {
+			wait = 0
+		}
+	}
+	log.Backup().Info("Scheduled backups enabled",
+		"dir", m.dir,
+		"interval_hours", m.cfg.IntervalHours,
+		"retain", m.cfg.Retain,
+		"next_in", wait.Round(time.Second))
+
+	go func() {
+		defer close(m.done)
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		for {
+			select {
+			case <-m.stop:
+				return
+			case <-timer.C:
+				if _, err := m.Run(m.cfg.Compact); err != nil {
+					log.Backup().Error("Scheduled backup failed", "error", err)
+				}
+				timer.Reset(interval)
+			}
+		}
+	}()
+}
+
+// Stop ends the schedule and waits for a backup in progress.
+func (m *Manager) Stop() {
+	if m.stop == nil {
+		return
+	}
+	close(m.stop)
+	<-m.done
+	m.stop = nil
+}
+
+// Compact reports the configured compaction setting.
+func (m *Manager) Compact() bool { return m.cfg.Compact }
+
+var (
+	active   *Manager
+	activeMu sync.RWMutex
+)
+
+// SetManager installs the instance-wide manager (nil to clear) and
+// returns the previous one.
+func SetManager(m *Manager) *Manager {
+	activeMu.Lock()
+	defer activeMu.Unlock()
+	prev := active
+	active = m
+	return prev
+}
+
+func current() *Manager {
+	activeMu.RLock()
+	defer activeMu.RUnlock()
+	return active
+}
+
+// Run takes a backup on the active manager. compact nil means the
+// configured setting.
+func Run(compact *bool) (*Info, error) {
+	m := current()
+	if m == nil {
+		return nil, errors.New("backups are not available: the database isn't open")
+	}
+	c := m.Compact()
+	if compact != nil {
+		c = *compact
+	}
+	return m.Run(c)
+}
@@ -0,0 +1,132 @@
+package backup
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	cfgType "github.com/0ceanslim/grain/config/types"
+)
+
+// fakeCopier writes a data.mdb with an LMDB meta-page magic.
+type fakeCopier struct {
+	size  int
+	fail  bool
+	calls []bool
+}
+
+func (f *fakeCopier) CopyTo(dir string, compact bool) error {
+	f.calls = append(f.calls, compact)
+	if f.fail {
+		return errors.New("disk on fire")
+	}
+	data := make([]byte, f.size)
+	binary.LittleEndian.PutUint32(data[lmdbMagicOffset:], lmdbMagic)
+	return os.WriteFile(filepath.Join(dir, DataFile), data, 0644)
+}
+
+func TestCreateListValidate(t *testing.T) {
+	parent := filepath.Join(t.TempDir(), "backups")
+	src := &fakeCopier{size: 4096}
+
+	info, err := Create(parent, src, true, "/var/lib/grain/data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(filepath.Base(info.Path), dirPrefix) || info.SizeBytes != 4096 || !info.Compact {
+		t.Fatalf("info = %+v", info)
+	}
+	// Two in the same second get distinct names.
+	second, err := Create(parent, src, false, "/var/lib/grain/data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Path == info.Path {
+		t.Fatal("second backup reused the first one's directory")
+	}
+
+	all, err := List(parent)
+	if err != nil || len(all) != 2 {
+		t.Fatalf("List = %+v, %v", all, err)
+	}
+	if all[0].Path != second.Path {
+		t.Fatalf("List not newest first: %s before %s", all[0].Path, second.Path)
+	}
+	if m, err := Validate(info.Path); err != nil || m.Source != "/var/lib/grain/data" {
+		t.Fatalf("Validate = %+v, %v", m, err)
+	}
+
+	// A failed copy leaves nothing behind, not even the .partial dir.
+	if _, err := Create(parent, &fakeCopier{fail: true}, false, ""); err == nil {
+		t.Fatal("expected the copy error")
+	}
+	entries, _ := os.ReadDir(parent)
+	if len(entries) != 2 {
+		t.Fatalf("failed backup left %d entries", len(entries))
+	}
+}
+
+func TestValidateRejects(t *testing.T) {
+	parent := t.TempDir()
+	info, err := Create(parent, &fakeCopier{size: 4096}, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := filepath.Join(info.Path, DataFile)
+
+	if err := os.Truncate(data, 100); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Validate(info.Path); err == nil || !strings.Contains(err.Error(), "truncated") {
+		t.Fatalf("truncated data.mdb: %v", err)
+	}
+
+	if err := os.WriteFile(data, make([]byte, 4096), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Validate(info.Path); err == nil || !strings.Contains(err.Error(), "not an LMDB") {
+		t.Fatalf("zeroed data.mdb: %v", err)
+	}
+
+	if _, err := Validate(t.TempDir()); err == nil {
+		t.Fatal("directory without a manifest validated")
+	}
+}
+
+func TestManagerRunPrunes(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "b")
+	src := &fakeCopier{size: 1024}
+	m := NewManager(cfgType.DatabaseBackupConfig{Dir: dir, Retain: 2, Compact: true}, src, "")
+	SetManager(m)
+	t.Cleanup(func() { SetManager(nil) })
+
+	var last *Info
+	for i := 0; i < 4; i++ {
+		info, err := Run(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		last = info
+	}
+	all, err := List(dir)
+	if err != nil || len(all) != 2 || all[0].Path != last.Path {
+		t.Fatalf("after pruning: %+v, %v", all, err)
+	}
+
+	// nil uses the configured compaction; a value overrides it.
+	off := false
+	if _, err := Run(&off); err != nil {
+		t.Fatal(err)
+	}
+	if got := src.calls; !got[0] || got[len(got)-1] {
+		t.Fatalf("compact flags = %v", got)
+	}
+
+	SetManager(nil)
+	if _, err := Run(nil); err == nil {
+		t.Fatal("Run without a manager should fail")
+	}
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/0ceanslim/grain/config"
+	"github.com/0ceanslim/grain/server/backup"
+	"github.com/0ceanslim/grain/server/db/nostrdb"
+	nostr "github.com/0ceanslim/grain/server/types"
+)
+
+// BackupDatabase is the `grain --backup <dir> [--compact]` entry
+// point: a consistent copy of the database into a new
+// grain-backup-<time> directory under dir. The relay can keep running;
+// LMDB copies under a read transaction.
+func BackupDatabase(dir string, compact bool) error {
+	if err := ensureConfigFiles(); err != nil {
+		return fmt.Errorf("failed to ensure config files: %w", err)
+	}
+
+	cfg, err := config.LoadConfig(config.ConfigPath("config.yml"))
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	dbPath, mapSizeMB := resolveDatabaseSettings(cfg)
+	if _, err := os.Stat(filepath.Join(dbPath, backup.DataFile)); err != nil {
+		return fmt.Errorf("no database at %s: %w", dbPath, err)
+	}
+
+	fmt.Printf("Opening database at %s...\n", dbPath)
+	db, err := nostrdb.Open(dbPath, mapSizeMB, 1)
+	if err != nil {
+		return fmt.Errorf("failed to open nostrdb: %w", err)
+	}
+	defer db.Close()
+
+	fmt.Printf("Copying (compact: %v)...\n", compact)
+	start := time.Now()
+	info, err := backup.Create(dir, db, compact, dbPath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\nBackup complete in %s\n", time.Since(start).Round(time.Millisecond))
+	fmt.Printf("  Path: %s\n", info.Path)
+	fmt.Printf("  Size: %d bytes\n", info.SizeBytes)
+	return nil
+}
+
+// RestoreBackup is the `grain --restore <backup-dir>` entry point. The
+// relay must be stopped. The backup is validated (manifest, size, LMDB
+// header), copied next to the database and opened with nostrdb as a
+// trial before it's swapped in. The database it replaces is kept as
+// <path>.pre-restore-<time> rather than deleted.
+func RestoreBackup(backupDir string) error {
+	if err := ensureConfigFiles(); err != nil {
+		return fmt.Errorf("failed to ensure config files: %w", err)
+	}
+
+	cfg, err := config.LoadConfig(config.ConfigPath("config.yml"))
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	dbPath, mapSizeMB := resolveDatabaseSettings(cfg)
+
+	if databaseInUse(dbPath) {
+		return fmt.Errorf("the database at %s is open in another process; stop the relay before restoring", dbPath)
+	}
+
+	m, err := backup.Validate(backupDir)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Backup from %s (%d bytes, compact: %v)\n",
+		time.Unix(m.CreatedAt, 0).UTC().Format(time.RFC3339), m.SizeBytes, m.Compact)
+
+	staging := filepath.Clean(dbPath) + ".restoring"
+	if err := os.RemoveAll(staging); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(staging, 0755); err != nil {
+		return fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	fail := func(err error) error {
+		os.RemoveAll(staging)
+		return err
+	}
+
+	fmt.Printf("Copying into %s...\n", staging)
+	if err := copyFile(filepath.Join(backupDir, backup.DataFile), filepath.Join(staging, backup.DataFile)); err != nil {
+		return fail(fmt.Errorf("failed to copy backup: %w", err))
+	}
+
+	// Trial open: nostrdb has to be able to read what we're about to
+	// swap in.
+	db, err := nostrdb.Open(staging, mapSizeMB, 1)
+	if err != nil {
+		return fail(fmt.Errorf("backup does not open as a nostrdb database: %w", err))
+	}
+	_, err = db.Query([]nostr.Filter{{}}, 1)
+	db.Close()
+	if err != nil {
+		return fail(fmt.Errorf("backup does not read as a nostrdb database: %w", err))
+	}
+	os.Remove(filepath.Join(staging, "lock.mdb"))
+
+	if _, err := os.Stat(dbPath); err == nil {
+		old := fmt.Sprintf("%s.pre-restore-%s", filepath.Clean(dbPath), time.Now().UTC().Format("20060102T150405Z"))
+		if err := os.Rename(dbPath, old); err != nil {
+			return fail(fmt.Errorf("failed to move the current database aside: %w", err))
+		}
+		fmt.Printf("Current database moved to %s\n", old)
+	}
+	if err := os.Rename(staging, dbPath); err != nil {
+		return fail(fmt.Errorf("failed to move the backup into place: %w", err))
+	}
+
+	fmt.Printf("\nRestore complete: %s\n", dbPath)
+	return nil
+}
+
+// copyFile copies src to dst and syncs it.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
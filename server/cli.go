@@ -50,6 +50,18 @@ func HandleArgs() bool {
 	case "--delete", "--delete-file":
 		// Handled in main.go parseDeleteFlags(); skip here
 		return false
+	case "--sync":
+		// Handled in main.go parseSyncFlags(); skip here
+		return false
+	case "--export":
+		// Handled in main.go parseExportFlags(); skip here
+		return false
+	case "--backup", "--restore":
+		// Handled in main.go parseBackupFlags() / parseRestoreFlag(); skip here
+		return false
+	case "--reindex-search":
+		// Handled in main.go parseReindexSearchFlag(); skip here
+		return false
 	default:
 		// Check for unknown flags
 		if len(os.Args[1]) > 0 && os.Args[1][0] == '-' {
@@ -84,9 +96,17 @@ func printHelp() {
 	fmt.Printf("  --help, -h           Show this help message\n")
 	fmt.Printf("  --config-help        Show configuration file information\n")
 	fmt.Printf("  --data-dir <path>    Set the data directory (configs, database, logs)\n")
-	fmt.Printf("  --import <file>      Import events from a JSONL file into nostrdb and exit\n")
+	fmt.Printf("  --import <file>      Import events from a JSONL file (.gz / .zst too) into nostrdb and exit\n")
+	fmt.Printf("  --export <file>      Stream events newest-first to a JSONL file (\"-\" for stdout) and exit\n")
+	fmt.Printf("  --compress <codec>   gzip, zstd or none for --export (default: by extension, .gz / .zst)\n")
 	fmt.Printf("  --delete <id>        Physically delete an event by hex id (may repeat)\n")
-	fmt.Printf("  --delete-file <path> Delete every hex id listed in the file (one per line)\n\n")
+	fmt.Printf("  --delete-file <path> Delete every hex id listed in the file (one per line)\n")
+	fmt.Printf("  --backup <dir>       Copy the database into a new backup under dir (relay may keep running) and exit\n")
+	fmt.Printf("  --compact            Compact the --backup copy (smaller, slower)\n")
+	fmt.Printf("  --restore <backup>   Validate a backup and swap it in for the database (stop the relay first)\n")
+	fmt.Printf("  --sync <relay-url>   Fetch only the events missing locally from a relay (NIP-77) and exit\n")
+	fmt.Printf("  --filter <json>      Limit --sync or --export to a NIP-01 filter, e.g. '{\"kinds\":[0,1]}'\n")
+	fmt.Printf("  --reindex-search     Rebuild the search index of search.indexed_kinds from stored events (stop the relay first)\n\n")
 	fmt.Printf("Environment Variables:\n")
 	fmt.Printf("  GRAIN_DATA_DIR      Override default data directory path\n")
 	fmt.Printf("  NDB_PATH            Override nostrdb data directory path\n")
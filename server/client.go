@@ -14,6 +14,8 @@ import (
 
 	"github.com/0ceanslim/grain/config"
 	"github.com/0ceanslim/grain/server/handlers"
+	"github.com/0ceanslim/grain/server/metrics"
+	"github.com/0ceanslim/grain/server/policy"
 	nostr "github.com/0ceanslim/grain/server/types"
 	"github.com/0ceanslim/grain/server/utils"
 	"github.com/0ceanslim/grain/server/utils/log"
@@ -286,6 +288,7 @@ func (c *Client) SendMessage(msg interface{}) {
 		return
 	}
 
+	metrics.WriteQueueDepth.Observe(float64(len(c.outgoing)))
 	select {
 	case c.outgoing <- jsonMsg:
 		// Enqueued. The writer goroutine will deliver it.
@@ -301,6 +304,7 @@ func (c *Client) SendMessage(msg interface{}) {
 			"client_id", c.id,
 			"ip", c.ip,
 			"buffer_capacity", clientOutgoingBuffer)
+		metrics.SlowConsumerDisconnects.Inc()
 		c.markDisconnected()
 		go c.CloseClient()
 	}
@@ -510,16 +514,32 @@ func (c *Client) GetSubscriptions() map[string][]nostr.Filter {
 	return cp
 }
 
+// SetSubscription stores the subscription and files it in the
+// broadcast index. The index update happens under subMu so concurrent
+// set/delete of the same sub id can't leave the two out of step.
 func (c *Client) SetSubscription(subID string, filters []nostr.Filter) {
 	c.subMu.Lock()
 	defer c.subMu.Unlock()
 	c.subscriptions[subID] = filters
+	subIndex.set(c, subID, filters)
 }
 
 func (c *Client) DeleteSubscription(subID string) {
 	c.subMu.Lock()
 	defer c.subMu.Unlock()
 	delete(c.subscriptions, subID)
+	subIndex.remove(c, subID)
+}
+
+// clearSubscriptions drops every subscription and its index entries.
+// Called from both connection teardown paths; the second is a no-op.
+func (c *Client) clearSubscriptions() {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+	for subID := range c.subscriptions {
+		delete(c.subscriptions, subID)
+		subIndex.remove(c, subID)
+	}
 }
 
 func (c *Client) SubscriptionCount() int {
@@ -584,11 +604,10 @@ func (c *Client) CloseClient() {
 	connManager.RemoveConnection(c)
 
 	// Clear all subscriptions
-	c.subMu.Lock()
-	for subID := range c.subscriptions {
-		delete(c.subscriptions, subID)
-	}
-	c.subMu.Unlock()
+	c.clearSubscriptions()
+
+	// Drop any NIP-77 negentropy sessions pinned to this connection
+	handlers.ReleaseNegentropySessions(c)
 
 	// Close WebSocket connection
 	if c.ws != nil {
@@ -628,6 +647,9 @@ func clientReader(client *Client) {
 			// already did it.
 			connManager.RemoveConnection(client)
 		}
+		client.clearSubscriptions()
+		handlers.ReleaseNegentropySessions(client)
+		handlers.CancelQueries(client)
 
 		// Close the connection if not already closed (idempotent).
 		ws.Close()
@@ -772,6 +794,14 @@ func clientReader(client *Client) {
 				"client_id", client.id,
 				"message_parts", len(message))
 			handlers.HandleCount(client, message)
+		case "NEG-OPEN":
+			log.RelayClient().Debug("Processing NEG-OPEN message",
+				"client_id", client.id)
+			handlers.HandleNegOpen(client, message)
+		case "NEG-MSG":
+			handlers.HandleNegMsg(client, message)
+		case "NEG-CLOSE":
+			handlers.HandleNegClose(client, message)
 		case "PING":
 			// Application-level keepalive used by some clients (not a
 			// NIP). Reply with PONG so the WARN log doesn't fire and
@@ -822,27 +852,42 @@ func isValidJSON(s string) bool {
 // BroadcastEvent sends an event to all connected clients whose active
 // subscriptions match the event. This is the real-time delivery mechanism
 // required by NIP-01: after EOSE, new matching events are pushed to subscribers.
+//
+// Candidate subscriptions come from the inverted index in
+// subscription_index.go rather than a scan of every client, and the
+// event is marshalled once and reused for every recipient.
 func BroadcastEvent(evt nostr.Event) {
-	clientsMu.Lock()
-	snapshot := make([]*Client, 0, len(clients))
-	for _, c := range clients {
-		snapshot = append(snapshot, c)
+	matches := subIndex.match(evt)
+	if len(matches) == 0 {
+		metrics.BroadcastFanout.Observe(0)
+		return
 	}
-	clientsMu.Unlock()
 
-	for _, c := range snapshot {
-		if !c.IsConnected() {
+	evtJSON, err := json.Marshal(evt)
+	if err != nil {
+		log.RelayClient().Error("Failed to marshal event for broadcast",
+			"event_id", evt.ID,
+			"error", err)
+		return
+	}
+
+	// Only events a read-policy rule judges need each subscriber's
+	// AUTH state; everything else goes straight out.
+	readPolicy := policy.ActiveReadPolicy()
+	gated := readPolicy.GatesEvent(evt)
+
+	delivered := 0
+	for _, m := range matches {
+		if !m.client.IsConnected() {
 			continue
 		}
-		c.ForEachSubscription(func(subID string, filters []nostr.Filter) {
-			for _, f := range filters {
-				if f.MatchesEvent(evt) {
-					c.SendMessage([]interface{}{"EVENT", subID, evt})
-					break
-				}
-			}
-		})
+		if gated && !readPolicy.Allow(handlers.GetAuthedPubkey(m.client), evt) {
+			continue
+		}
+		m.client.SendMessage([]interface{}{"EVENT", m.subID, json.RawMessage(evtJSON)})
+		delivered++
 	}
+	metrics.BroadcastFanout.Observe(float64(delivered))
 }
 
 // Start stats monitoring
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/0ceanslim/grain/config"
+	"github.com/0ceanslim/grain/server/metrics"
 	"github.com/0ceanslim/grain/server/utils"
 	"github.com/0ceanslim/grain/server/utils/log"
 )
@@ -134,6 +135,10 @@ func RecordRejection(category, ip string) {
 	case "blocked":
 		rejAgg.blocked++
 	}
+	switch category {
+	case "max_conn", "rate_limit", "blocked":
+		metrics.ConnectionsRejected.With(category).Inc()
+	}
 	if ip != "" {
 		rejAgg.topIPs[ip]++
 	}
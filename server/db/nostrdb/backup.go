@@ -0,0 +1,59 @@
+package nostrdb
+
+/*
+#include "nostrdb.h"
+#include "lmdb.h"
+#include <stdlib.h>
+
+// grain_env_copy copies the environment behind an open read txn.
+// mdb_env_copy2 takes its own read txn for the copy, so it sees one
+// consistent snapshot while the writer thread keeps committing.
+static int grain_env_copy(struct ndb_txn *txn, const char *path, int compact) {
+	MDB_env *env = mdb_txn_env((MDB_txn *)txn->mdb_txn);
+	return mdb_env_copy2(env, path, compact ? MDB_CP_COMPACT : 0);
+}
+
+static const char *grain_mdb_strerror(int rc) {
+	return mdb_strerror(rc);
+}
+*/
+import "C"
+import (
+	"fmt"
+	"os"
+	"unsafe"
+
+	"github.com/0ceanslim/grain/server/utils/log"
+)
+
+// CopyTo writes a consistent copy of the database to dir, which must
+// exist and be empty: LMDB writes dir/data.mdb, and the relay keeps
+// serving reads and writes while it does. compact leaves out free
+// pages, making a smaller copy for more work.
+func (db *NDB) CopyTo(dir string, compact bool) error {
+	if entries, err := os.ReadDir(dir); err != nil {
+		return fmt.Errorf("backup directory: %w", err)
+	} else if len(entries) > 0 {
+		return fmt.Errorf("backup directory %s is not empty", dir)
+	}
+
+	// The query txn pins the NDB open for the length of the copy.
+	txn, err := db.BeginQuery()
+	if err != nil {
+		return err
+	}
+	defer txn.EndQuery()
+
+	cDir := C.CString(dir)
+	defer C.free(unsafe.Pointer(cDir))
+
+	c := C.int(0)
+	if compact {
+		c = 1
+	}
+	if rc := C.grain_env_copy(&txn.txn, cDir, c); rc != 0 {
+		return fmt.Errorf("mdb_env_copy2: %s", C.GoString(C.grain_mdb_strerror(rc)))
+	}
+	log.GetLogger("db").Info("nostrdb copied", "dir", dir, "compact", compact)
+	return nil
+}
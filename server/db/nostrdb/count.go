@@ -1,7 +1,7 @@
 package nostrdb
 
 import (
-	"time"
+	"encoding/hex"
 
 	nostr "github.com/0ceanslim/grain/server/types"
 	"github.com/0ceanslim/grain/server/utils/log"
@@ -11,97 +11,136 @@ import (
 // Above this we return the cap and report `approximate: true` per NIP-45.
 const countHardCap = 1_000_000
 
-// CountFiltered returns the number of events matching `filters`. The
-// nostrdb query API is capped at maxQueryResults per call, so we page
-// backwards through created_at using the cursor pattern from the NIP-40
-// bootstrap. The bool return is true when the result is approximate:
-//
-//   - hardCap reached, or
-//   - multiple filters supplied (we sum per-filter counts; a multi-filter
-//     union that overlaps in event ids would double-count, and dedupe
-//     would require buffering all matched ids — defeating the purpose
-//     of COUNT vs REQ).
-//
-// Single-filter requests with sane time bounds get an exact count.
+// countUnionCap bounds the ids a multi-filter COUNT remembers to count
+// each event once across filters (32 bytes apiece plus map overhead).
+// Past it, an event matched by more than one filter may be counted
+// twice, and the count is reported approximate.
+const countUnionCap = 200_000
+
+// noteKey is the part of a note counting needs.
+type noteKey struct {
+	id        [32]byte
+	pubkey    [32]byte
+	createdAt int64
+}
+
+// eventKey is e's noteKey.
+func eventKey(e nostr.Event) noteKey {
+	k := noteKey{createdAt: e.CreatedAt}
+	hex.Decode(k.id[:], []byte(e.ID))
+	hex.Decode(k.pubkey[:], []byte(e.PubKey))
+	return k
+}
+
+// CountResult is the answer to a NIP-45 COUNT.
+type CountResult struct {
+	Count int
+	// Approximate is set when the count may be off: it reached
+	// countHardCap, or a multi-filter union outgrew countUnionCap.
+	Approximate bool
+	// HLL holds the NIP-45 HyperLogLog registers of the matching
+	// events' pubkeys, for a single filter that qualifies for one
+	// (see hllOffset); nil otherwise.
+	HLL []byte
+}
+
+// CountFiltered counts the events matching any of filters, each event
+// once. Filters are paged through with QueryPage in one read
+// transaction, reading only each match's id, pubkey and created_at.
+// With more than one filter the ids seen so far are kept to dedupe
+// the union, up to countUnionCap.
 //
-// One known undercount edge case: if a full page (maxQueryResults
-// events) shares the same created_at, the next page's cursor advances
-// by one second and may skip same-second siblings beyond the page. Same
-// trade-off as PurgeOldEvents and the expiration bootstrap.
-func (db *NDB) CountFiltered(filters []nostr.Filter) (int, bool, error) {
+// allow, when non-nil, restricts the count to events it returns true
+// for — HandleCount passes the read policy so a COUNT can't reveal
+// events the reader couldn't REQ. The policy needs whole events, so
+// those counts read them in full.
+func (db *NDB) CountFiltered(filters []nostr.Filter, allow func(nostr.Event) bool) (CountResult, error) {
+	var res CountResult
 	if len(filters) == 0 {
-		return 0, false, nil
+		return res, nil
 	}
 
-	logger := log.GetLogger("db-count")
-	approximate := len(filters) > 1
-	total := 0
+	txn, err := db.BeginQuery()
+	if err != nil {
+		return res, err
+	}
+	defer txn.EndQuery()
 
-	for _, base := range filters {
-		filterTotal, hitCap, err := countSingleFilter(db, base)
-		if err != nil {
-			return 0, false, err
+	offset, withHLL := 0, false
+	if len(filters) == 1 {
+		if offset, withHLL = hllOffset(filters[0]); withHLL {
+			res.HLL = make([]byte, hllRegisters)
 		}
-		total += filterTotal
-		if hitCap {
-			approximate = true
-		}
-		if total >= countHardCap {
-			total = countHardCap
-			approximate = true
-			break
+	}
+	var seen map[[32]byte]struct{}
+	if len(filters) > 1 {
+		seen = make(map[[32]byte]struct{})
+	}
+
+count:
+	for i, f := range filters {
+		// The last filter's ids needn't be remembered: nothing after
+		// it could match them again.
+		last := i == len(filters)-1
+		var cursor *Cursor
+		for {
+			keys, next, err := keyPage(txn, f, cursor, allow)
+			if err != nil {
+				return CountResult{}, err
+			}
+			for _, k := range keys {
+				if seen != nil {
+					if _, dup := seen[k.id]; dup {
+						continue
+					}
+					if !last {
+						if len(seen) < countUnionCap {
+							seen[k.id] = struct{}{}
+						} else {
+							res.Approximate = true
+						}
+					}
+				}
+				res.Count++
+				if withHLL {
+					hllAdd(res.HLL, offset, k.pubkey)
+				}
+				if res.Count >= countHardCap {
+					res.Approximate = true
+					break count
+				}
+			}
+			if next == nil {
+				break
+			}
+			cursor = next
 		}
 	}
 
-	logger.Debug("Count completed",
+	log.GetLogger("db-count").Debug("Count completed",
 		"filter_count", len(filters),
-		"total", total,
-		"approximate", approximate)
-	return total, approximate, nil
+		"total", res.Count,
+		"approximate", res.Approximate,
+		"hll", withHLL)
+	return res, nil
 }
 
-// countSingleFilter pages through one filter and returns its match count
-// plus a flag indicating whether the hard cap was reached for this one.
-func countSingleFilter(db *NDB, base nostr.Filter) (int, bool, error) {
-	const pageSize = maxQueryResults
-
-	cursor := base.Until
-	total := 0
-
-	for {
-		limit := pageSize
-		page := base
-		page.Limit = &limit
-		page.Until = cursor
-
-		events, err := db.Query([]nostr.Filter{page}, pageSize)
-		if err != nil {
-			return 0, false, err
-		}
-		if len(events) == 0 {
-			break
-		}
-		total += len(events)
-		if total >= countHardCap {
-			return countHardCap, true, nil
-		}
-		if len(events) < pageSize {
-			break
-		}
-
-		// nostrdb returns newest-first; advance cursor to (oldest - 1)
-		// to fetch the next page strictly older than this one.
-		oldestTs := events[0].CreatedAt
-		for _, e := range events {
-			if e.CreatedAt < oldestTs {
-				oldestTs = e.CreatedAt
-			}
-		}
-		next := time.Unix(oldestTs-1, 0)
-		if base.Since != nil && next.Before(*base.Since) {
-			break
+// keyPage is f's page after cursor as note keys, through allow when
+// there is one. "&" tags need the events' tags, so a filter with them
+// reads whole events too.
+func keyPage(txn *Txn, f nostr.Filter, cursor *Cursor, allow func(nostr.Event) bool) ([]noteKey, *Cursor, error) {
+	if allow == nil && len(f.AndTags) == 0 {
+		return txn.queryKeysPage(f, cursor, maxQueryResults)
+	}
+	events, next, err := txn.QueryPage(f, cursor, maxQueryResults)
+	if err != nil {
+		return nil, nil, err
+	}
+	keys := make([]noteKey, 0, len(events))
+	for _, e := range events {
+		if allow == nil || allow(e) {
+			keys = append(keys, eventKey(e))
 		}
-		cursor = &next
 	}
-	return total, false, nil
+	return keys, next, nil
 }
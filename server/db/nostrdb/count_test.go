@@ -0,0 +1,93 @@
+package nostrdb
+
+import (
+	"context"
+	"encoding/hex"
+	"reflect"
+	"testing"
+	"time"
+
+	nostr "github.com/0ceanslim/grain/server/types"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+)
+
+func TestCountFiltered_UnionAndHLL(t *testing.T) {
+	db := openTempDB(t)
+	ctx := context.Background()
+	now := time.Now().Unix()
+
+	keys := make([]*btcec.PrivateKey, 4)
+	pubs := make([]string, 4)
+	for i := range keys {
+		keys[i], _ = btcec.NewPrivateKey()
+		pubs[i] = hex.EncodeToString(schnorr.SerializePubKey(keys[i].PubKey()))
+	}
+
+	// Three notes by the first key, reacted to by the other three.
+	var stored []nostr.Event
+	for i := 0; i < 3; i++ {
+		stored = append(stored, signEvent(t, keys[0], pubs[0], 1, "count", [][]string{{"n", string(rune('a' + i))}}, now-int64(i)))
+	}
+	note := stored[0]
+	for i := 1; i < 4; i++ {
+		stored = append(stored, signEvent(t, keys[i], pubs[i], 7, "+", [][]string{{"e", note.ID}, {"p", pubs[0]}}, now))
+	}
+	for _, evt := range stored {
+		if err := db.StoreEvent(ctx, evt); err != nil {
+			t.Fatalf("store: %v", err)
+		}
+	}
+	for _, evt := range stored {
+		waitForIngest(t, db, evt.ID, true)
+	}
+
+	// Overlapping filters count each event once, exactly.
+	res, err := db.CountFiltered([]nostr.Filter{
+		{Authors: []string{pubs[0]}},
+		{Authors: []string{pubs[0]}, Kinds: []int{1}},
+		{IDs: []string{note.ID}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	if res.Count != 3 || res.Approximate || res.HLL != nil {
+		t.Fatalf("union count %+v, want exactly 3 without hll", res)
+	}
+
+	// A reaction count comes with the registers of the reactors.
+	reactions := nostr.Filter{Kinds: []int{7}, Tags: map[string][]string{"e": {note.ID}}}
+	res, err = db.CountFiltered([]nostr.Filter{reactions}, nil)
+	if err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	if res.Count != 3 || res.Approximate || !reflect.DeepEqual(res.HLL, hllOf(t, reactions, pubs[1:])) {
+		t.Fatalf("reaction count %+v, want 3 with the reactors' hll", res)
+	}
+
+	// The read policy narrows both.
+	res, err = db.CountFiltered([]nostr.Filter{reactions}, func(evt nostr.Event) bool { return evt.PubKey != pubs[1] })
+	if err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	if res.Count != 2 || !reflect.DeepEqual(res.HLL, hllOf(t, reactions, pubs[2:])) {
+		t.Fatalf("allowed count %+v, want 2 without the first reactor", res)
+	}
+}
+
+// hllOf is the HLL NIP-45 expects for filter over pubs.
+func hllOf(t *testing.T, filter nostr.Filter, pubs []string) []byte {
+	t.Helper()
+	offset, ok := hllOffset(filter)
+	if !ok {
+		t.Fatal("filter doesn't qualify for an hll")
+	}
+	registers := make([]byte, hllRegisters)
+	for _, pub := range pubs {
+		var pk [32]byte
+		hex.Decode(pk[:], []byte(pub))
+		hllAdd(registers, offset, pk)
+	}
+	return registers
+}
@@ -0,0 +1,229 @@
+package nostrdb
+
+/*
+#include "nostrdb.h"
+*/
+import "C"
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	nostr "github.com/0ceanslim/grain/server/types"
+	"github.com/0ceanslim/grain/server/utils/log"
+)
+
+// indexStatsMaxAge is how stale the cached ndb_stat may get before a
+// caller kicks off a refresh. ndb_stat walks every LMDB table, so it
+// runs in the background and callers get the last result.
+const indexStatsMaxAge = 10 * time.Minute
+
+// IndexStat is one nostrdb table (the notes themselves, or an index)
+// as ndb_stat reports it.
+type IndexStat struct {
+	Name       string `json:"name"`
+	Entries    uint64 `json:"entries"`
+	KeyBytes   uint64 `json:"key_bytes"`
+	ValueBytes uint64 `json:"value_bytes"`
+}
+
+type indexStatsCache struct {
+	mu         sync.Mutex
+	indexes    []IndexStat
+	at         time.Time
+	refreshing bool
+}
+
+// indexStats returns the cached ndb_stat tables and when they were
+// read. With refresh, a missing or stale cache starts a refresh in
+// the background. Empty until the first refresh is done.
+func (db *NDB) indexStats(refresh bool) ([]IndexStat, time.Time) {
+	c := &db.indexCache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if refresh && !c.refreshing && time.Since(c.at) > indexStatsMaxAge {
+		c.refreshing = true
+		go db.refreshIndexStats()
+	}
+	return c.indexes, c.at
+}
+
+func (db *NDB) refreshIndexStats() {
+	start := time.Now()
+	stat, err := db.Stat()
+
+	c := &db.indexCache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refreshing = false
+	if err != nil {
+		log.GetLogger("db").Warn("ndb_stat failed", "error", err)
+		return
+	}
+	indexes := make([]IndexStat, 0, C.NDB_DBS)
+	for i := 0; i < C.NDB_DBS; i++ {
+		s := stat.dbs[i]
+		indexes = append(indexes, IndexStat{
+			Name:       C.GoString(C.ndb_db_name(C.enum_ndb_dbs(i))),
+			Entries:    uint64(s.count),
+			KeyBytes:   uint64(s.key_size),
+			ValueBytes: uint64(s.value_size),
+		})
+	}
+	c.indexes, c.at = indexes, time.Now()
+	log.GetLogger("db").Debug("ndb_stat refreshed", "took", time.Since(start).Round(time.Millisecond))
+}
+
+// DatabaseStats is the grain_stats_database report.
+type DatabaseStats struct {
+	DiskBytes    int64  `json:"disk_bytes"`     // data.mdb on disk
+	MapSizeBytes uint64 `json:"map_size_bytes"` // LMDB map size
+	UsedBytes    uint64 `json:"used_bytes"`     // LMDB pages in use
+
+	Indexes   []IndexStat `json:"indexes"`
+	IndexesAt int64       `json:"indexes_at,omitempty"` // when ndb_stat last ran; 0 = not yet
+
+	// Counted incrementally (see eventStats). Counting is true until
+	// the startup scan is done; the numbers are partial until then.
+	Counting           bool                   `json:"counting"`
+	Total              StatCounter            `json:"total"`
+	Categories         map[string]StatCounter `json:"categories"`
+	Kinds              []KindStat             `json:"kinds"`
+	TopAuthorsByEvents []AuthorStat           `json:"top_authors_by_events"`
+	TopAuthorsByBytes  []AuthorStat           `json:"top_authors_by_bytes"`
+}
+
+// DatabaseStats reports what's in the database without scanning it:
+// the in-memory per-kind and per-author counts, the top n authors,
+// the cached ndb_stat tables and the file size.
+func (db *NDB) DatabaseStats(n int) (DatabaseStats, error) {
+	u, err := db.Usage()
+	if err != nil {
+		return DatabaseStats{}, err
+	}
+	res := DatabaseStats{MapSizeBytes: u.MapSizeBytes, UsedBytes: u.UsedBytes}
+	if st, err := os.Stat(filepath.Join(db.dir, "data.mdb")); err == nil {
+		res.DiskBytes = st.Size()
+	}
+
+	indexes, at := db.indexStats(true)
+	res.Indexes = indexes
+	if res.Indexes == nil {
+		res.Indexes = []IndexStat{}
+	}
+	if !at.IsZero() {
+		res.IndexesAt = at.Unix()
+	}
+
+	var ready bool
+	res.Kinds, res.Categories, res.Total, res.TopAuthorsByEvents, res.TopAuthorsByBytes, ready = db.stats.snapshot(n)
+	res.Counting = !ready
+	return res, nil
+}
+
+// BootstrapStats counts every stored event per kind and author, once
+// at startup, in a single read transaction. Stores and deletes that
+// happen meanwhile are reconciled against that snapshot. It also
+// takes the first ndb_stat reading.
+func (db *NDB) BootstrapStats() error {
+	if db.stats == nil {
+		return nil
+	}
+	logger := log.GetLogger("db")
+	start := time.Now()
+
+	// Hold updates from before the snapshot too; load sorts them out.
+	db.stats.startScan()
+	txn, err := db.BeginQuery()
+	if err != nil {
+		db.stats.abortScan()
+		return err
+	}
+	defer txn.EndQuery()
+
+	kinds := map[int]*StatCounter{}
+	authors := map[string]*StatCounter{}
+	n, err := txn.export(nostr.Filter{}, func(evt nostr.Event) error {
+		size := eventJSONSize(evt)
+		k := kinds[evt.Kind]
+		if k == nil {
+			k = &StatCounter{}
+			kinds[evt.Kind] = k
+		}
+		k.Events++
+		k.Bytes += size
+		a := authors[evt.PubKey]
+		if a == nil {
+			a = &StatCounter{}
+			authors[evt.PubKey] = a
+		}
+		a.Events++
+		a.Bytes += size
+		return nil
+	})
+	if err != nil {
+		db.stats.abortScan()
+		return err
+	}
+	db.stats.load(kinds, authors, func(id string) bool {
+		evt, err := txn.GetNoteByID(id)
+		return err == nil && evt != nil
+	})
+
+	logger.Info("Database stats counted",
+		"events", n,
+		"kinds", len(kinds),
+		"authors", len(authors),
+		"took", time.Since(start).Round(time.Millisecond))
+
+	db.indexStats(true)
+	return nil
+}
+
+// countStored adds a just-ingested event to the stats.
+func (db *NDB) countStored(evt nostr.Event, size int) {
+	db.stats.record(statDelta{id: evt.ID, kind: evt.Kind, pubkey: evt.PubKey, bytes: int64(size), sign: 1})
+}
+
+// lookupForStats fetches the event a delete is about to remove, so
+// the stats can subtract it. nil if it isn't stored (yet).
+func (db *NDB) lookupForStats(id [32]byte) *nostr.Event {
+	if !db.stats.active() {
+		return nil
+	}
+	txn, err := db.BeginQuery()
+	if err != nil {
+		return nil
+	}
+	defer txn.EndQuery()
+	evt, err := txn.GetNoteByID(hex.EncodeToString(id[:]))
+	if err != nil {
+		return nil
+	}
+	return evt
+}
+
+// countDeleted subtracts a deleted event from the stats.
+func (db *NDB) countDeleted(evt *nostr.Event) {
+	if evt == nil {
+		return
+	}
+	db.stats.record(statDelta{id: evt.ID, kind: evt.Kind, pubkey: evt.PubKey, bytes: eventJSONSize(*evt), sign: -1})
+}
+
+// eventJSONSize is the measure of an event's size in the stats.
+func eventJSONSize(evt nostr.Event) int64 {
+	s, err := eventToJSON(evt)
+	if err != nil {
+		return 0
+	}
+	return int64(len(s))
+}
+
+// AuthorUsage is what pubkey has stored, as counted for
+// grain_stats_database. ok is false until the startup count is done.
+func (db *NDB) AuthorUsage(pubkey string) (StatCounter, bool) {
+	return db.stats.author(pubkey)
+}
@@ -30,6 +30,23 @@ func (db *NDB) ProcessDeletion(ctx context.Context, evt nostr.Event) error {
 		"pubkey", evt.PubKey,
 		"tag_count", len(evt.Tags))
 
+	db.DeleteTargets(evt)
+
+	// Store the deletion event itself — per NIP-09 the kind-5 record stays
+	// visible so clients can see the deletion marker.
+	if err := db.ingestEvent(evt); err != nil {
+		return fmt.Errorf("failed to store deletion event: %w", err)
+	}
+
+	log.GetLogger("db-store").Info("Deletion event processed", "event_id", evt.ID)
+	return nil
+}
+
+// DeleteTargets removes what the kind-5 evt asks for, with the same
+// rules as ProcessDeletion, without storing evt. HandleEvent runs it a
+// second time once the tombstones are recorded, for a re-publish that
+// got in between.
+func (db *NDB) DeleteTargets(evt nostr.Event) {
 	for _, tag := range evt.Tags {
 		if len(tag) < 2 {
 			continue
@@ -57,15 +74,13 @@ func (db *NDB) ProcessDeletion(ctx context.Context, evt nostr.Event) error {
 			}
 		}
 	}
+}
 
-	// Store the deletion event itself — per NIP-09 the kind-5 record stays
-	// visible so clients can see the deletion marker.
-	if err := db.ingestEvent(evt); err != nil {
-		return fmt.Errorf("failed to store deletion event: %w", err)
-	}
-
-	log.GetLogger("db-store").Info("Deletion event processed", "event_id", evt.ID)
-	return nil
+// DeleteByID removes one event by hex id. The request goes through the
+// writer queue, so it also takes out an event ingested just before it
+// that reads can't see yet.
+func (db *NDB) DeleteByID(id string) error {
+	return db.deleteByHexID(id)
 }
 
 // verifyAndDeleteByID enforces NIP-09's same-pubkey rule and physically removes
@@ -196,3 +211,104 @@ func (db *NDB) verifyAndDeleteByAddr(coord, requesterPubKey string, deleteCreate
 		"scanned", len(matches))
 	return nil
 }
+
+// DeleteTaggedByID removes the given events, but only those carrying
+// the tag [tagName, tagValue] — a NIP-29 group admin may delete any
+// event posted to their group, and nothing else. Unknown ids and
+// events without the tag are skipped. Returns how many were deleted.
+func (db *NDB) DeleteTaggedByID(ids []string, tagName, tagValue string) (int, error) {
+	txn, err := db.BeginQuery()
+	if err != nil {
+		return 0, err
+	}
+	var targets []string
+	for _, id := range ids {
+		target, err := txn.GetNoteByID(id)
+		if err != nil || target == nil {
+			continue
+		}
+		for _, tag := range target.Tags {
+			if len(tag) >= 2 && tag[0] == tagName && tag[1] == tagValue {
+				targets = append(targets, id)
+				break
+			}
+		}
+	}
+	txn.EndQuery()
+
+	deleted := 0
+	for _, id := range targets {
+		if err := db.deleteByHexID(id); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	log.GetLogger("db-store").Info("Tagged events deleted",
+		"tag", tagName, "value", tagValue, "requested", len(ids), "deleted", deleted)
+	return deleted, nil
+}
+
+// DeleteMatching removes every event matching filter, a QueryPage
+// at a time, each read in its own transaction. Returns how many were
+// deleted.
+func (db *NDB) DeleteMatching(filter nostr.Filter) (int, error) {
+	deleted := 0
+	var cursor *Cursor
+	for {
+		events, next, err := db.QueryPage(filter, cursor, maxQueryResults)
+		if err != nil {
+			return deleted, err
+		}
+		for _, e := range events {
+			if err := db.deleteByHexID(e.ID); err != nil {
+				return deleted, err
+			}
+			deleted++
+		}
+		if next == nil {
+			break
+		}
+		cursor = next
+	}
+
+	log.GetLogger("db-store").Info("Matching events deleted", "deleted", deleted)
+	return deleted, nil
+}
+
+// DeleteOldestByAuthor deletes pubkey's oldest events until at least
+// minEvents events and minBytes bytes (JSON size) are gone, skipping
+// events keep says to keep. It reports what it deleted, which is less
+// than asked when the author runs out of events to give up.
+//
+// Finding the oldest means walking all of the author's events, since
+// nostrdb only pages newest first; only ids, timestamps and sizes are
+// held while it does.
+func (db *NDB) DeleteOldestByAuthor(pubkey string, minEvents int, minBytes int64, keep func(nostr.Event) bool) (int, int64, error) {
+	type candidate struct {
+		id   string
+		size int64
+	}
+	var all []candidate // newest first
+	if _, err := db.Export(nostr.Filter{Authors: []string{pubkey}}, func(evt nostr.Event) error {
+		if keep == nil || !keep(evt) {
+			all = append(all, candidate{id: evt.ID, size: eventJSONSize(evt)})
+		}
+		return nil
+	}); err != nil {
+		return 0, 0, err
+	}
+
+	events, bytes := 0, int64(0)
+	for i := len(all) - 1; i >= 0 && (events < minEvents || bytes < minBytes); i-- {
+		if err := db.deleteByHexID(all[i].id); err != nil {
+			return events, bytes, err
+		}
+		events++
+		bytes += all[i].size
+	}
+	log.GetLogger("db-store").Info("Oldest events of author deleted",
+		"pubkey", pubkey,
+		"events", events,
+		"bytes", bytes)
+	return events, bytes, nil
+}
@@ -0,0 +1,211 @@
+package nostrdb
+
+import (
+	"sort"
+	"sync"
+)
+
+// eventStats counts stored events and their JSON bytes per kind and
+// per author, for grain_stats_database and the storage quotas. nostrdb has no cheap way to
+// answer either (ndb_stat walks every table), so the counts are built
+// by one scan at startup (BootstrapStats) and then kept up to date
+// from the ingest and delete paths.
+//
+// Updates that arrive while the scan runs are held in pending and
+// reconciled against the scan's snapshot afterwards, so an event
+// stored or deleted mid-scan is counted exactly once. Before the scan
+// starts (CLI tools never run one) updates are dropped.
+//
+// The counts are close, not exact: ingest is asynchronous, so an
+// event nostrdb turns away after StoreEvent returned is still
+// counted, and a delete of an event still in the ingest queue isn't
+// subtracted. A restart recounts from scratch.
+type eventStats struct {
+	mu       sync.Mutex
+	scanning bool
+	ready    bool
+	kinds    map[int]*StatCounter
+	authors  map[string]*StatCounter
+	pending  []statDelta
+}
+
+// StatCounter is a number of events and their size as JSON.
+type StatCounter struct {
+	Events int64 `json:"events"`
+	Bytes  int64 `json:"bytes"`
+}
+
+type statDelta struct {
+	id     string
+	kind   int
+	pubkey string
+	bytes  int64
+	sign   int64 // +1 stored, -1 deleted
+}
+
+func newEventStats() *eventStats {
+	return &eventStats{kinds: map[int]*StatCounter{}, authors: map[string]*StatCounter{}}
+}
+
+func (s *eventStats) record(d statDelta) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.ready:
+		s.apply(d)
+	case s.scanning:
+		s.pending = append(s.pending, d)
+	}
+}
+
+// active reports whether updates are being kept.
+func (s *eventStats) active() bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ready || s.scanning
+}
+
+// startScan starts holding updates for load.
+func (s *eventStats) startScan() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scanning = true
+}
+
+// abortScan drops what startScan held; the counts stay unavailable.
+func (s *eventStats) abortScan() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scanning = false
+	s.pending = nil
+}
+
+// apply adds d to the counters. Caller holds mu. A counter that
+// reaches zero is dropped; one that would go below (a delete counted
+// twice) is clamped.
+func (s *eventStats) apply(d statDelta) {
+	bump := func(c *StatCounter) bool {
+		c.Events = max(c.Events+d.sign, 0)
+		c.Bytes = max(c.Bytes+d.sign*d.bytes, 0)
+		return c.Events == 0
+	}
+	k := s.kinds[d.kind]
+	if k == nil {
+		if d.sign < 0 {
+			return
+		}
+		k = &StatCounter{}
+		s.kinds[d.kind] = k
+	}
+	if bump(k) {
+		delete(s.kinds, d.kind)
+	}
+	a := s.authors[d.pubkey]
+	if a == nil {
+		if d.sign < 0 {
+			return
+		}
+		a = &StatCounter{}
+		s.authors[d.pubkey] = a
+	}
+	if bump(a) {
+		delete(s.authors, d.pubkey)
+	}
+}
+
+// load replaces the counters with a scan's and applies what arrived
+// during it. inSnapshot reports whether the scan saw an event: a
+// store the scan already counted is skipped, as is a delete of an
+// event the scan never saw.
+func (s *eventStats) load(kinds map[int]*StatCounter, authors map[string]*StatCounter, inSnapshot func(id string) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.kinds, s.authors = kinds, authors
+	for _, d := range s.pending {
+		if (d.sign > 0) != inSnapshot(d.id) {
+			s.apply(d)
+		}
+	}
+	s.pending = nil
+	s.scanning = false
+	s.ready = true
+}
+
+// author returns one author's counter; ok is false before load.
+func (s *eventStats) author(pubkey string) (StatCounter, bool) {
+	if s == nil {
+		return StatCounter{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ready {
+		return StatCounter{}, false
+	}
+	if c := s.authors[pubkey]; c != nil {
+		return *c, true
+	}
+	return StatCounter{}, true
+}
+
+// AuthorStat is one author's share of the database.
+type AuthorStat struct {
+	Pubkey string `json:"pubkey"`
+	StatCounter
+}
+
+// KindStat is one kind's share of the database.
+type KindStat struct {
+	Kind     int    `json:"kind"`
+	Category string `json:"category"`
+	StatCounter
+}
+
+// snapshot returns the per-kind counts (by kind), per-category totals,
+// the overall total and the top n authors by events and by bytes.
+func (s *eventStats) snapshot(n int) (kinds []KindStat, categories map[string]StatCounter, total StatCounter, byEvents, byBytes []AuthorStat, ready bool) {
+	s.mu.Lock()
+	kinds = make([]KindStat, 0, len(s.kinds))
+	categories = map[string]StatCounter{}
+	for kind, c := range s.kinds {
+		cat := determineEventCategory(kind)
+		kinds = append(kinds, KindStat{Kind: kind, Category: cat, StatCounter: *c})
+		sum := categories[cat]
+		sum.Events += c.Events
+		sum.Bytes += c.Bytes
+		categories[cat] = sum
+		total.Events += c.Events
+		total.Bytes += c.Bytes
+	}
+	all := make([]AuthorStat, 0, len(s.authors))
+	for pk, c := range s.authors {
+		all = append(all, AuthorStat{Pubkey: pk, StatCounter: *c})
+	}
+	ready = s.ready
+	s.mu.Unlock()
+
+	// Sorting a copy keeps the ingest path from waiting on it.
+	sort.Slice(kinds, func(i, j int) bool { return kinds[i].Kind < kinds[j].Kind })
+	top := func(less func(a, b StatCounter) bool) []AuthorStat {
+		sort.Slice(all, func(i, j int) bool {
+			if less(all[i].StatCounter, all[j].StatCounter) {
+				return true
+			}
+			if less(all[j].StatCounter, all[i].StatCounter) {
+				return false
+			}
+			return all[i].Pubkey < all[j].Pubkey
+		})
+		out := make([]AuthorStat, min(n, len(all)))
+		copy(out, all)
+		return out
+	}
+	byEvents = top(func(a, b StatCounter) bool { return a.Events > b.Events })
+	byBytes = top(func(a, b StatCounter) bool { return a.Bytes > b.Bytes })
+	return kinds, categories, total, byEvents, byBytes, ready
+}
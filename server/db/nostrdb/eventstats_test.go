@@ -0,0 +1,52 @@
+package nostrdb
+
+import "testing"
+
+func TestEventStatsReconcilesScan(t *testing.T) {
+	s := newEventStats()
+
+	// Before a scan starts, updates are dropped.
+	s.record(statDelta{id: "early", kind: 1, pubkey: "alice", bytes: 10, sign: 1})
+
+	s.startScan()
+	// Stored during the scan: "seen" made it into the snapshot, "late"
+	// didn't. Deleted during the scan: "gone" was in the snapshot,
+	// "never" wasn't.
+	s.record(statDelta{id: "seen", kind: 1, pubkey: "alice", bytes: 10, sign: 1})
+	s.record(statDelta{id: "late", kind: 7, pubkey: "bob", bytes: 5, sign: 1})
+	s.record(statDelta{id: "gone", kind: 1, pubkey: "alice", bytes: 10, sign: -1})
+	s.record(statDelta{id: "never", kind: 1, pubkey: "alice", bytes: 10, sign: -1})
+
+	snapshot := map[string]bool{"seen": true, "gone": true}
+	s.load(
+		map[int]*StatCounter{1: {Events: 3, Bytes: 30}, 0: {Events: 1, Bytes: 100}},
+		map[string]*StatCounter{"alice": {Events: 4, Bytes: 130}},
+		func(id string) bool { return snapshot[id] },
+	)
+
+	kinds, cats, total, byEvents, byBytes, ready := s.snapshot(1)
+	if !ready {
+		t.Fatal("not ready after load")
+	}
+	if total != (StatCounter{Events: 4, Bytes: 125}) {
+		t.Fatalf("total = %+v", total)
+	}
+	if len(kinds) != 3 || kinds[0].Kind != 0 || kinds[1] != (KindStat{Kind: 1, Category: "regular", StatCounter: StatCounter{Events: 2, Bytes: 20}}) {
+		t.Fatalf("kinds = %+v", kinds)
+	}
+	if cats["replaceable"].Events != 1 || cats["regular"].Events != 3 {
+		t.Fatalf("categories = %+v", cats)
+	}
+	if len(byEvents) != 1 || byEvents[0].Pubkey != "alice" || len(byBytes) != 1 || byBytes[0].Pubkey != "alice" {
+		t.Fatalf("top authors = %+v / %+v", byEvents, byBytes)
+	}
+
+	// After the scan updates apply directly; a counter that empties
+	// is dropped, and a second delete doesn't go negative.
+	s.record(statDelta{id: "late", kind: 7, pubkey: "bob", bytes: 5, sign: -1})
+	s.record(statDelta{id: "late", kind: 7, pubkey: "bob", bytes: 5, sign: -1})
+	kinds, _, _, byEvents, _, _ = s.snapshot(10)
+	if len(kinds) != 2 || len(byEvents) != 1 {
+		t.Fatalf("after deleting bob's only event: kinds %+v, authors %+v", kinds, byEvents)
+	}
+}
@@ -89,6 +89,15 @@ func (t *ExpirationTracker) Len() int {
 	return t.heap.Len()
 }
 
+// PendingExpirations is the number of events the sweeper is waiting
+// to delete.
+func (db *NDB) PendingExpirations() int {
+	if db.expiration == nil {
+		return 0
+	}
+	return db.expiration.Len()
+}
+
 // popDue removes and returns all items with expireAt <= now.
 func (t *ExpirationTracker) popDue(now int64) []expirationItem {
 	t.mu.Lock()
@@ -146,8 +155,7 @@ func (db *NDB) trackIfExpiring(evt nostr.Event) {
 
 // BootstrapExpirations scans the DB once at startup, deletes any events
 // whose expiration has already passed, and populates the in-memory heap
-// with the rest. Pages backwards through created_at to cover the whole
-// history; each page is capped by the nostrdb query limit.
+// with the rest. Walks the whole history a QueryPage at a time.
 func (db *NDB) BootstrapExpirations() error {
 	if db.expiration == nil {
 		return nil
@@ -160,7 +168,7 @@ func (db *NDB) BootstrapExpirations() error {
 	now := time.Now().Unix()
 
 	var (
-		until      *time.Time
+		cursor     *Cursor
 		scanned    int
 		tracked    int
 		expiredDel int
@@ -168,27 +176,16 @@ func (db *NDB) BootstrapExpirations() error {
 	)
 
 	for {
-		limit := pageSize
-		filter := nostr.Filter{Limit: &limit}
-		if until != nil {
-			filter.Until = until
-		}
-
-		events, err := db.Query([]nostr.Filter{filter}, pageSize)
+		events, next, err := db.QueryPage(nostr.Filter{}, cursor, pageSize)
 		if err != nil {
 			return err
 		}
-		if len(events) == 0 {
-			break
+		if len(events) > 0 {
+			pages++
 		}
-		pages++
 		scanned += len(events)
 
-		oldestTs := events[0].CreatedAt
 		for _, evt := range events {
-			if evt.CreatedAt < oldestTs {
-				oldestTs = evt.CreatedAt
-			}
 			ts, ok := expirationFromTags(evt.Tags)
 			if !ok {
 				continue
@@ -213,12 +210,10 @@ func (db *NDB) BootstrapExpirations() error {
 			tracked++
 		}
 
-		if len(events) < pageSize {
+		if next == nil {
 			break
 		}
-		// Page back: anything strictly older than the oldest we just saw.
-		u := time.Unix(oldestTs-1, 0)
-		until = &u
+		cursor = next
 	}
 
 	logger.Info("Bootstrap complete",
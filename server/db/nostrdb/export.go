@@ -0,0 +1,75 @@
+package nostrdb
+
+import (
+	nostr "github.com/0ceanslim/grain/server/types"
+)
+
+// Export calls fn with every event matching filter, newest first, a
+// page at a time, so a dump of the whole database never holds more
+// than one page in memory, plus the keys of a second crowded with more
+// events than a page. fn returning an error stops the walk.
+//
+// All pages come from one read transaction, so the export is a
+// consistent snapshot even while the relay keeps ingesting. Pages are
+// QueryPage's, so no event is missed however many share a second.
+// filter.Limit is ignored.
+func (db *NDB) Export(filter nostr.Filter, fn func(nostr.Event) error) (int, error) {
+	txn, err := db.BeginQuery()
+	if err != nil {
+		return 0, err
+	}
+	defer txn.EndQuery()
+	return txn.export(filter, fn)
+}
+
+// ExportFilters is Export for several filters in turn, all in the one
+// read transaction. An event matching more than one filter is passed
+// to fn only for the first.
+func (db *NDB) ExportFilters(filters []nostr.Filter, fn func(nostr.Event) error) (int, error) {
+	txn, err := db.BeginQuery()
+	if err != nil {
+		return 0, err
+	}
+	defer txn.EndQuery()
+	exported := 0
+	for i, filter := range filters {
+		_, err := txn.export(filter, func(evt nostr.Event) error {
+			for _, prev := range filters[:i] {
+				if prev.MatchesEvent(evt) {
+					return nil
+				}
+			}
+			if err := fn(evt); err != nil {
+				return err
+			}
+			exported++
+			return nil
+		})
+		if err != nil {
+			return exported, err
+		}
+	}
+	return exported, nil
+}
+
+// export is Export inside an open transaction.
+func (txn *Txn) export(filter nostr.Filter, fn func(nostr.Event) error) (int, error) {
+	exported := 0
+	var cursor *Cursor
+	for {
+		events, next, err := txn.QueryPage(filter, cursor, maxQueryResults)
+		if err != nil {
+			return exported, err
+		}
+		for _, e := range events {
+			if err := fn(e); err != nil {
+				return exported, err
+			}
+			exported++
+		}
+		if next == nil {
+			return exported, nil
+		}
+		cursor = next
+	}
+}
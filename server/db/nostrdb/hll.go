@@ -0,0 +1,47 @@
+package nostrdb
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"strconv"
+
+	nostr "github.com/0ceanslim/grain/server/types"
+)
+
+// hllRegisters is the size of a NIP-45 HyperLogLog: 256 one-byte
+// registers, sent hex-encoded as the COUNT response's "hll".
+const hllRegisters = 256
+
+// hllOffset is where in each pubkey NIP-45 reads a filter's HLL. Only
+// a filter with a single tag attribute holding a single value gets
+// one ({"#e": [id], "kinds": [7]}, {"#p": [pubkey], "kinds": [3]}):
+// the offset is that value's 33rd hex digit plus 8, so every relay
+// answering the same filter fills the same registers and a client can
+// merge their answers.
+func hllOffset(f nostr.Filter) (int, bool) {
+	if len(f.Tags) != 1 {
+		return 0, false
+	}
+	for _, values := range f.Tags {
+		if len(values) != 1 || len(values[0]) < 33 {
+			return 0, false
+		}
+		d, err := strconv.ParseUint(values[0][32:33], 16, 8)
+		if err != nil {
+			return 0, false
+		}
+		return int(d) + 8, true
+	}
+	return 0, false
+}
+
+// hllAdd counts pubkey into registers: the byte at offset picks the
+// register, which keeps the highest count of leading zero bits in the
+// 7 bytes after it, plus one.
+func hllAdd(registers []byte, offset int, pubkey [32]byte) {
+	w := binary.BigEndian.Uint64(pubkey[offset : offset+8])
+	zeros := uint8(bits.LeadingZeros64(w<<8)) + 1
+	if ri := pubkey[offset]; zeros > registers[ri] {
+		registers[ri] = zeros
+	}
+}
@@ -0,0 +1,55 @@
+package nostrdb
+
+import (
+	"strings"
+	"testing"
+
+	nostr "github.com/0ceanslim/grain/server/types"
+)
+
+func TestHLLOffset(t *testing.T) {
+	id := strings.Repeat("0", 32) + "c" + strings.Repeat("0", 31)
+	for name, c := range map[string]struct {
+		filter nostr.Filter
+		offset int
+		ok     bool
+	}{
+		"reactions":     {nostr.Filter{Kinds: []int{7}, Tags: map[string][]string{"e": {id}}}, 20, true},
+		"followers":     {nostr.Filter{Kinds: []int{3}, Tags: map[string][]string{"p": {strings.Repeat("f", 64)}}}, 23, true},
+		"no tag":        {nostr.Filter{Kinds: []int{1}}, 0, false},
+		"two values":    {nostr.Filter{Tags: map[string][]string{"e": {id, id}}}, 0, false},
+		"two tags":      {nostr.Filter{Tags: map[string][]string{"e": {id}, "p": {id}}}, 0, false},
+		"short value":   {nostr.Filter{Tags: map[string][]string{"t": {"nostr"}}}, 0, false},
+		"not hex at 32": {nostr.Filter{Tags: map[string][]string{"d": {strings.Repeat("x", 40)}}}, 0, false},
+	} {
+		offset, ok := hllOffset(c.filter)
+		if ok != c.ok || offset != c.offset {
+			t.Errorf("%s: hllOffset = %d, %v; want %d, %v", name, offset, ok, c.offset, c.ok)
+		}
+	}
+}
+
+func TestHLLAdd(t *testing.T) {
+	registers := make([]byte, hllRegisters)
+	var pk [32]byte
+
+	// Register 0x2a, then 0x00 0x10: 8 + 3 leading zeros, plus one.
+	pk[8], pk[9], pk[10] = 0x2a, 0x00, 0x10
+	hllAdd(registers, 8, pk)
+	if registers[0x2a] != 12 {
+		t.Fatalf("register 0x2a = %d, want 12", registers[0x2a])
+	}
+
+	// Fewer zeros never lower a register.
+	pk[9] = 0xff
+	hllAdd(registers, 8, pk)
+	if registers[0x2a] != 12 {
+		t.Fatalf("register 0x2a lowered to %d", registers[0x2a])
+	}
+
+	// The offset moves which bytes are read.
+	hllAdd(registers, 9, pk)
+	if registers[0xff] != 4 {
+		t.Fatalf("register 0xff = %d, want 4", registers[0xff])
+	}
+}
@@ -12,6 +12,7 @@ package nostrdb
 */
 import "C"
 import (
+	"errors"
 	"fmt"
 	"sync"
 	"unsafe"
@@ -22,8 +23,16 @@ import (
 // NDB wraps a nostrdb instance. It is safe for concurrent use.
 type NDB struct {
 	ndb        *C.struct_ndb
-	mu         sync.RWMutex // protects close
+	mu         sync.RWMutex // protects close and Resize
 	expiration *ExpirationTracker
+	stats      *eventStats
+	indexCache indexStatsCache
+
+	// Open settings, kept for Resize.
+	dir           string
+	mapSizeMB     int
+	ingestThreads int
+	flags         int
 }
 
 // NDB open flags. These map 1:1 onto nostrdb.h NDB_FLAG_* bits.
@@ -44,9 +53,31 @@ func Open(dbDir string, mapSizeMB int, ingestThreads int) (*NDB, error) {
 // OpenWithFlags is like Open but forwards an ndb_config_set_flags bitmask to
 // nostrdb. Use FlagSkipNoteVerify for trusted-source bulk imports.
 func OpenWithFlags(dbDir string, mapSizeMB int, ingestThreads int, flags int) (*NDB, error) {
+	ndb, err := initNDB(dbDir, mapSizeMB, ingestThreads, flags)
+	if err != nil {
+		return nil, err
+	}
+
+	log.GetLogger("db").Info("nostrdb opened",
+		"path", dbDir,
+		"map_size_mb", mapSizeMB,
+		"ingest_threads", ingestThreads)
+
+	return &NDB{
+		ndb:           ndb,
+		expiration:    newExpirationTracker(),
+		stats:         newEventStats(),
+		dir:           dbDir,
+		mapSizeMB:     mapSizeMB,
+		ingestThreads: ingestThreads,
+		flags:         flags,
+	}, nil
+}
+
+func initNDB(dbDir string, mapSizeMB int, ingestThreads int, flags int) (*C.struct_ndb, error) {
 	var cfg C.struct_ndb_config
 	C.ndb_default_config(&cfg)
-	C.ndb_config_set_mapsize(&cfg, C.size_t(mapSizeMB*1024*1024))
+	C.ndb_config_set_mapsize(&cfg, C.size_t(mapSizeMB)*1024*1024)
 
 	if ingestThreads > 0 {
 		C.ndb_config_set_ingest_threads(&cfg, C.int(ingestThreads))
@@ -63,13 +94,7 @@ func OpenWithFlags(dbDir string, mapSizeMB int, ingestThreads int, flags int) (*
 	if rc == 0 {
 		return nil, fmt.Errorf("ndb_init failed for directory %s", dbDir)
 	}
-
-	log.GetLogger("db").Info("nostrdb opened",
-		"path", dbDir,
-		"map_size_mb", mapSizeMB,
-		"ingest_threads", ingestThreads)
-
-	return &NDB{ndb: ndb, expiration: newExpirationTracker()}, nil
+	return ndb, nil
 }
 
 // Close shuts down the nostrdb instance and frees resources.
@@ -107,6 +132,10 @@ func (db *NDB) ProcessEvent(json string) error {
 	return nil
 }
 
+// ErrWriterQueueFull is DeleteNoteByID's error when the nostrdb writer
+// has more queued than it accepts; retrying shortly after can succeed.
+var ErrWriterQueueFull = errors.New("ndb_request_delete_note: writer queue full")
+
 // DeleteNoteByID enqueues a real delete of an event from nostrdb by its raw
 // 32-byte ID. The delete is applied by the nostrdb writer thread in FIFO order
 // with ingests — a delete of an in-flight ingest of the same ID is committed
@@ -118,10 +147,13 @@ func (db *NDB) ProcessEvent(json string) error {
 // here. Authorization is the caller's responsibility: this function performs
 // no checks beyond "is the DB open and is the writer queue accepting work".
 //
-// Returns an error only if the DB is closed or the writer inbox is full.
+// Returns an error only if the DB is closed or the writer inbox is full
+// (ErrWriterQueueFull).
 // "Not found" is not an error at this layer — it's logged at C level and the
 // call is a no-op.
 func (db *NDB) DeleteNoteByID(id [32]byte) error {
+	target := db.lookupForStats(id)
+
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
@@ -131,8 +163,9 @@ func (db *NDB) DeleteNoteByID(id [32]byte) error {
 
 	rc := C.ndb_request_delete_note(db.ndb, (*C.uchar)(unsafe.Pointer(&id[0])))
 	if rc == 0 {
-		return fmt.Errorf("ndb_request_delete_note: writer queue full")
+		return ErrWriterQueueFull
 	}
+	db.countDeleted(target)
 	return nil
 }
 
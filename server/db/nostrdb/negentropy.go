@@ -0,0 +1,67 @@
+package nostrdb
+
+import (
+	"encoding/hex"
+	"errors"
+
+	"github.com/0ceanslim/grain/server/negentropy"
+	nostr "github.com/0ceanslim/grain/server/types"
+	"github.com/0ceanslim/grain/server/utils/log"
+)
+
+// ErrNegentropyTooBig is returned by NegentropyStorage when the filter
+// matches more events than the caller is willing to hold in memory.
+var ErrNegentropyTooBig = errors.New("negentropy: filter matches too many events")
+
+// NegentropyStorage collects the (created_at, id) pairs of every event
+// matching `filter` into a sealed negentropy vector. The filter's limit
+// is ignored — NIP-77 reconciles the whole matching set.
+//
+// All pages are read with QueryPage inside a single transaction, so
+// the snapshot is consistent even while new events are being ingested
+// and no same-second sibling is lost on a page boundary. Without a
+// read policy only the note keys are read.
+//
+// allow, when non-nil, drops events the caller may not reveal (the
+// read policy); dropped events don't count towards maxItems.
+func (db *NDB) NegentropyStorage(filter nostr.Filter, maxItems int, allow func(nostr.Event) bool) (*negentropy.Vector, error) {
+	txn, err := db.BeginQuery()
+	if err != nil {
+		return nil, err
+	}
+	defer txn.EndQuery()
+
+	logger := log.GetLogger("db-negentropy")
+	vec := negentropy.NewVector(0)
+	var cursor *Cursor
+	for {
+		keys, next, err := keyPage(txn, filter, cursor, allow)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range keys {
+			if k.createdAt < 0 {
+				logger.Warn("Skipping event with negative created_at in negentropy storage",
+					"event_id", hex.EncodeToString(k.id[:]))
+				continue
+			}
+			if err := vec.Insert(uint64(k.createdAt), k.id); err != nil {
+				return nil, err
+			}
+			if vec.Size() > maxItems {
+				return nil, ErrNegentropyTooBig
+			}
+		}
+		if next == nil {
+			break
+		}
+		cursor = next
+	}
+
+	if err := vec.Seal(); err != nil {
+		return nil, err
+	}
+
+	logger.Debug("Negentropy storage built", "items", vec.Size())
+	return vec, nil
+}
@@ -0,0 +1,319 @@
+package nostrdb
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+
+	nostr "github.com/0ceanslim/grain/server/types"
+	"github.com/0ceanslim/grain/server/utils/log"
+)
+
+// maxSecondResults caps how many events of a single second QueryPage
+// reads the keys of. Past it the rest of that second is skipped, with a
+// warning.
+const maxSecondResults = 100_000
+
+// Cursor is a position in the order QueryPage walks: newest first,
+// events of the same second by lowest id first, as NIP-01 has it.
+// QueryPage hands back the last event of a page as the cursor for the
+// next.
+type Cursor struct {
+	CreatedAt int64  `json:"created_at"`
+	ID        string `json:"id"`
+	// second is the rest of the cursor's second in page order, when
+	// QueryPage had to read that second's keys, so the next page goes
+	// on from it instead of reading the second again. Empty but not
+	// nil once the second is used up. A cursor that lost it (through
+	// JSON, say) only costs that one re-read.
+	second []noteKey
+}
+
+// QueryPage is Txn.QueryPage in a read transaction of its own.
+func (db *NDB) QueryPage(filter nostr.Filter, after *Cursor, n int) ([]nostr.Event, *Cursor, error) {
+	events, next, _, err := db.QueryPageRead(filter, after, n)
+	return events, next, err
+}
+
+// QueryPageRead is Txn.QueryPageRead in a read transaction of its own.
+func (db *NDB) QueryPageRead(filter nostr.Filter, after *Cursor, n int) ([]nostr.Event, *Cursor, int, error) {
+	txn, err := db.BeginQuery()
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	defer txn.EndQuery()
+	return txn.QueryPageRead(filter, after, n)
+}
+
+// QueryPage returns up to n events matching filter that come after
+// cursor after (nil: the newest, or from filter.Until), and the cursor
+// to pass for the next page, nil once there's nothing left.
+// filter.Limit is ignored. Events failing filter's "&" tags are read
+// past, not counted.
+//
+// Paging by until alone either repeats or skips the events of the
+// second a page ends in. A cursor names the exact event, and when a
+// page's nostrdb query stops part way through a second, that second's
+// keys are read whole and sorted once; the cursor carries the rest of
+// them, so the following pages walk that second without reading it
+// again, however many events share the timestamp. Since the cursor is
+// a position rather than a snapshot, consecutive pages can each come
+// from a transaction of their own: an event deleted in between is
+// skipped, one added to a second already being walked is missed.
+func (txn *Txn) QueryPage(filter nostr.Filter, after *Cursor, n int) ([]nostr.Event, *Cursor, error) {
+	events, next, _, err := txn.QueryPageRead(filter, after, n)
+	return events, next, err
+}
+
+// QueryPageRead is QueryPage that also reports how many notes it read
+// to fill the page, including the ones it read past.
+func (txn *Txn) QueryPageRead(filter nostr.Filter, after *Cursor, n int) ([]nostr.Event, *Cursor, int, error) {
+	src := pageSource[nostr.Event]{
+		query: txn.queryEvents,
+		keys:  txn.queryKeys,
+		key: func(e nostr.Event) pageKey {
+			k := pageKey{createdAt: e.CreatedAt}
+			hex.Decode(k.id[:], []byte(e.ID))
+			return k
+		},
+		fetch: func(k noteKey) (nostr.Event, bool, error) {
+			evt, err := txn.GetNoteByID(hex.EncodeToString(k.id[:]))
+			if err != nil || evt == nil {
+				return nostr.Event{}, false, err
+			}
+			return *evt, true, nil
+		},
+	}
+	if len(filter.AndTags) > 0 {
+		src.keep = filter.MatchesAndTags
+	}
+	return readPage(filter, after, n, src)
+}
+
+// queryKeysPage is QueryPage reading only note keys. It can't apply
+// "&" tags: a filter with them goes through QueryPage.
+func (txn *Txn) queryKeysPage(filter nostr.Filter, after *Cursor, n int) ([]noteKey, *Cursor, error) {
+	keys, next, _, err := readPage(filter, after, n, pageSource[noteKey]{
+		query: txn.queryKeys,
+		keys:  txn.queryKeys,
+		key: func(k noteKey) pageKey {
+			return pageKey{createdAt: k.createdAt, id: k.id}
+		},
+		fetch: func(k noteKey) (noteKey, bool, error) { return k, true, nil },
+	})
+	return keys, next, err
+}
+
+// pageKey is where a result sits in page order.
+type pageKey struct {
+	createdAt int64
+	id        [32]byte
+}
+
+// before reports whether k comes before o: newer, or the same second
+// and a lower id.
+func (k pageKey) before(o pageKey) bool {
+	if k.createdAt != o.createdAt {
+		return k.createdAt > o.createdAt
+	}
+	return bytes.Compare(k.id[:], o.id[:]) < 0
+}
+
+// pageSource is what readPage reads results of type T with. query
+// returns them in nostrdb's newest-first order and key places each
+// one; keys reads just the keys of a crowded second, and fetch turns
+// one of those back into a result (false if it's gone since). keep,
+// when not nil, drops the results it returns false for; they still
+// move the page along.
+type pageSource[T any] struct {
+	query func([]nostr.Filter, int) ([]T, error)
+	keys  func([]nostr.Filter, int) ([]noteKey, error)
+	key   func(T) pageKey
+	fetch func(noteKey) (T, bool, error)
+	keep  func(T) bool
+}
+
+// readPage is QueryPage over src. read counts every note read: query
+// results, a crowded second's keys and the events fetched for them.
+func readPage[T any](filter nostr.Filter, after *Cursor, n int, src pageSource[T]) (out []T, next *Cursor, read int, err error) {
+	if n <= 0 {
+		return nil, after, 0, nil
+	}
+	until := filter.Until
+	var from pageKey
+	var second []noteKey // the crowded second being walked, if any
+	if after != nil {
+		if len(after.ID) != 64 {
+			return nil, nil, 0, fmt.Errorf("invalid cursor id %q", after.ID)
+		}
+		if _, err := hex.Decode(from.id[:], []byte(after.ID)); err != nil {
+			return nil, nil, 0, fmt.Errorf("invalid cursor id %q: %w", after.ID, err)
+		}
+		from.createdAt = after.CreatedAt
+		u := time.Unix(after.CreatedAt, 0)
+		until = &u
+		second = after.second
+	}
+	limit := n
+	if src.keep != nil {
+		// Read ahead: some of what's read will be dropped.
+		limit = 4 * n
+		if limit < 256 {
+			limit = 256
+		}
+	}
+	if limit > maxQueryResults {
+		limit = maxQueryResults
+	}
+
+	for {
+		if second != nil {
+			for i, k := range second {
+				r, ok, err := src.fetch(k)
+				read++
+				if err != nil {
+					return nil, nil, read, err
+				}
+				if !ok || (src.keep != nil && !src.keep(r)) {
+					continue
+				}
+				out = append(out, r)
+				if len(out) == n {
+					return out, &Cursor{CreatedAt: k.createdAt, ID: hex.EncodeToString(k.id[:]), second: second[i+1:]}, read, nil
+				}
+			}
+			// On to the seconds before it.
+			u := time.Unix(until.Unix()-1, 0)
+			until = &u
+			second = nil
+		}
+
+		if until != nil && filter.Since != nil && until.Before(*filter.Since) {
+			return out, nil, read, nil
+		}
+		page := filter
+		page.Until = until
+		page.Limit = &limit
+		results, err := src.query([]nostr.Filter{page}, limit)
+		if err != nil {
+			return nil, nil, read, err
+		}
+		read += len(results)
+
+		// A full result stopped somewhere in its oldest second; that
+		// second is walked by its keys instead.
+		complete := len(results) < limit
+		var oldest int64
+		atOldest := 0
+		if !complete {
+			oldest = src.key(results[0]).createdAt
+			for _, r := range results {
+				if ts := src.key(r).createdAt; ts < oldest {
+					oldest = ts
+				}
+			}
+			newer := results[:0]
+			for _, r := range results {
+				if src.key(r).createdAt > oldest {
+					newer = append(newer, r)
+				}
+			}
+			atOldest = len(results) - len(newer)
+			results = newer
+		}
+		keys := sortPage(results, src.key)
+		for i, r := range results {
+			if after != nil && !from.before(keys[i]) {
+				continue // at or before the cursor: an earlier page had it
+			}
+			if src.keep != nil && !src.keep(r) {
+				continue
+			}
+			out = append(out, r)
+			if len(out) == n {
+				if complete && i == len(results)-1 {
+					return out, nil, read, nil
+				}
+				return out, &Cursor{CreatedAt: keys[i].createdAt, ID: hex.EncodeToString(keys[i].id[:])}, read, nil
+			}
+		}
+		if complete {
+			return out, nil, read, nil
+		}
+
+		all, err := readSecond(filter, oldest, 2*atOldest, src.keys)
+		if err != nil {
+			return nil, nil, read, err
+		}
+		read += len(all)
+		i := 0
+		if after != nil {
+			i = sort.Search(len(all), func(j int) bool {
+				return from.before(pageKey{createdAt: all[j].createdAt, id: all[j].id})
+			})
+		}
+		if second = all[i:]; second == nil {
+			second = []noteKey{}
+		}
+		u := time.Unix(oldest, 0)
+		until = &u
+	}
+}
+
+// readSecond reads the keys of every event matching filter created at
+// second at, in page order. The query limit starts at limit and
+// doubles, since nostrdb sizes its result buffer by it; past
+// maxSecondResults the rest of the second is skipped, with a warning.
+func readSecond(filter nostr.Filter, at int64, limit int, query func([]nostr.Filter, int) ([]noteKey, error)) ([]noteKey, error) {
+	second := filter
+	t := time.Unix(at, 0)
+	second.Since, second.Until = &t, &t
+	if limit < 1024 {
+		limit = 1024
+	}
+	for {
+		if limit > maxSecondResults {
+			limit = maxSecondResults
+		}
+		l := limit
+		second.Limit = &l
+		keys, err := query([]nostr.Filter{second}, limit)
+		if err != nil {
+			return nil, err
+		}
+		if len(keys) < limit || limit == maxSecondResults {
+			if len(keys) == maxSecondResults {
+				log.GetLogger("db-query").Warn("Too many events in one second; skipping the rest of it",
+					"created_at", at, "read", len(keys))
+			}
+			sortPage(keys, func(k noteKey) pageKey { return pageKey{createdAt: k.createdAt, id: k.id} })
+			return keys, nil
+		}
+		limit *= 2
+	}
+}
+
+// sortPage puts results in page order and returns their keys, in the
+// same order.
+func sortPage[T any](results []T, key func(T) pageKey) []pageKey {
+	s := pageSorter[T]{results: results, keys: make([]pageKey, len(results))}
+	for i, r := range results {
+		s.keys[i] = key(r)
+	}
+	sort.Sort(s)
+	return s.keys
+}
+
+type pageSorter[T any] struct {
+	results []T
+	keys    []pageKey
+}
+
+func (s pageSorter[T]) Len() int           { return len(s.results) }
+func (s pageSorter[T]) Less(i, j int) bool { return s.keys[i].before(s.keys[j]) }
+func (s pageSorter[T]) Swap(i, j int) {
+	s.results[i], s.results[j] = s.results[j], s.results[i]
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+}
@@ -0,0 +1,141 @@
+package nostrdb
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+	"testing"
+	"time"
+
+	nostr "github.com/0ceanslim/grain/server/types"
+)
+
+// fakeNotes stands in for nostrdb's query: newest first, at most
+// limit results, and same-second events in insertion order rather
+// than by id, the way nostrdb's note keys order them.
+type fakeNotes []pageKey
+
+func (f fakeNotes) query(filters []nostr.Filter, limit int) ([]pageKey, error) {
+	var out []pageKey
+	for _, k := range f {
+		if s := filters[0].Since; s != nil && k.createdAt < s.Unix() {
+			continue
+		}
+		if u := filters[0].Until; u != nil && k.createdAt > u.Unix() {
+			continue
+		}
+		out = append(out, k)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].createdAt > out[j].createdAt })
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+func fakeKey(ts int64, i int) pageKey {
+	var seed [8]byte
+	binary.BigEndian.PutUint64(seed[:], uint64(i))
+	return pageKey{createdAt: ts, id: sha256.Sum256(seed[:])}
+}
+
+// walk pages through notes n at a time, through keep, and returns
+// everything read.
+func walk(t *testing.T, notes fakeNotes, filter nostr.Filter, n int, keep func(pageKey) bool) []pageKey {
+	t.Helper()
+	var all []pageKey
+	var cursor *Cursor
+	for pages := 0; ; pages++ {
+		if pages > len(notes) {
+			t.Fatal("paging doesn't end")
+		}
+		page, next, err := readPage(filter, cursor, n, notes.query, func(k pageKey) pageKey { return k }, keep)
+		if err != nil {
+			t.Fatalf("page: %v", err)
+		}
+		if len(page) > n {
+			t.Fatalf("page of %d, asked for %d", len(page), n)
+		}
+		all = append(all, page...)
+		if next == nil {
+			return all
+		}
+		cursor = next
+	}
+}
+
+func TestReadPage_SameSecond(t *testing.T) {
+	// 12000 events in one second between two quieter ones.
+	var notes fakeNotes
+	for i := 0; i < 30; i++ {
+		notes = append(notes, fakeKey(1001, i))
+	}
+	for i := 30; i < 12030; i++ {
+		notes = append(notes, fakeKey(1000, i))
+	}
+	for i := 12030; i < 12060; i++ {
+		notes = append(notes, fakeKey(999, i))
+	}
+	want := append([]pageKey(nil), notes...)
+	sort.Slice(want, func(i, j int) bool { return want[i].before(want[j]) })
+
+	for _, n := range []int{maxQueryResults, 7000, 500} {
+		got := walk(t, notes, nostr.Filter{}, n, nil)
+		if len(got) != len(want) {
+			t.Fatalf("n=%d: read %d events, want %d", n, len(got), len(want))
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("n=%d: event %d out of order or repeated", n, i)
+			}
+		}
+	}
+}
+
+func TestReadPage_Bounds(t *testing.T) {
+	var notes fakeNotes
+	for i := 0; i < 12000; i++ {
+		notes = append(notes, fakeKey(int64(1000+i%3), i))
+	}
+	since, until := time.Unix(1001, 0), time.Unix(1001, 0)
+	got := walk(t, notes, nostr.Filter{Since: &since, Until: &until}, maxQueryResults, nil)
+	if len(got) != 4000 {
+		t.Fatalf("read %d events of second 1001, want 4000", len(got))
+	}
+	for _, k := range got {
+		if k.createdAt != 1001 {
+			t.Fatalf("event from second %d outside the filter", k.createdAt)
+		}
+	}
+
+	if _, _, err := readPage(nostr.Filter{}, &Cursor{CreatedAt: 1, ID: "nope"}, 10, notes.query, func(k pageKey) pageKey { return k }, nil); err == nil {
+		t.Fatal("malformed cursor accepted")
+	}
+}
+
+func TestReadPage_Keep(t *testing.T) {
+	var notes fakeNotes
+	for i := 0; i < 6000; i++ {
+		notes = append(notes, fakeKey(int64(1000+i/2000), i))
+	}
+	keep := func(k pageKey) bool { return k.id[0] < 16 }
+	var want []pageKey
+	for _, k := range notes {
+		if keep(k) {
+			want = append(want, k)
+		}
+	}
+	sort.Slice(want, func(i, j int) bool { return want[i].before(want[j]) })
+
+	for _, n := range []int{7, 100} {
+		got := walk(t, notes, nostr.Filter{}, n, keep)
+		if len(got) != len(want) {
+			t.Fatalf("n=%d: read %d events, want %d", n, len(got), len(want))
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("n=%d: event %d out of order or repeated", n, i)
+			}
+		}
+	}
+}
@@ -6,6 +6,8 @@ package nostrdb
 */
 import "C"
 import (
+	"errors"
+	"fmt"
 	"time"
 
 	cfgType "github.com/0ceanslim/grain/config/types"
@@ -13,95 +15,160 @@ import (
 	"github.com/0ceanslim/grain/server/utils/log"
 )
 
-// PurgeOldEvents removes events older than the configured retention window.
-// Whitelisted pubkeys (configured members) are excluded when
-// ExcludeWhitelisted is set — this is the "non-member cleanup" knob that
-// keeps member content forever while aging out drive-by events.
+// purgeQueueRetries and purgeQueueBackoff bound how long a purge waits
+// for the nostrdb writer to drain when its queue is full: the purge
+// enqueues a page of deletes at a time, faster than the writer
+// commits them.
+const (
+	purgeQueueRetries = 100
+	purgeQueueBackoff = 50 * time.Millisecond
+)
+
+// PurgeOldEvents runs the configured retention rules and returns how
+// many events were deleted (none in dry-run mode). See Purge.
 func (db *NDB) PurgeOldEvents(cfg *cfgType.EventPurgeConfig, whitelistedPubkeys []string) int {
 	if !cfg.Enabled {
 		log.GetLogger("db-purge").Debug("Event purging is disabled")
 		return 0
 	}
+	report, err := db.Purge(cfg, whitelistedPubkeys, cfg.DryRun)
+	if err != nil {
+		log.GetLogger("db-purge").Error("Purge stopped early", "error", err)
+	}
+	if report.DryRun {
+		return 0
+	}
+	return report.Purged
+}
 
-	currentTime := time.Now().Unix()
-	cutoff := currentTime - int64(cfg.KeepIntervalHours*3600)
-	cutoffTime := time.Unix(cutoff, 0)
+// Purge walks every event older than the shortest retention window,
+// newest first and a page at a time, and deletes those the first
+// matching rule (see retentionRules) says have expired. Whitelisted
+// pubkeys are the configured members that rules select with
+// "authors". With dryRun nothing is deleted and the report says what
+// would have been.
+//
+// Each page is read in its own transaction and its deletes enqueued
+// before the next is read, so a purge of a large backlog neither holds
+// a read transaction open for its whole length nor buffers the
+// candidates in memory.
+func (db *NDB) Purge(cfg *cfgType.EventPurgeConfig, whitelistedPubkeys []string, dryRun bool) (PurgeReport, error) {
+	logger := log.GetLogger("db-purge")
+	start := time.Now()
+	now := start.Unix()
+
+	rules := retentionRules(cfg)
+	report := PurgeReport{DryRun: dryRun, Rules: make([]RulePurge, len(rules))}
+	for i, r := range rules {
+		report.Rules[i].Rule = r.name
+	}
+	until, ok := retentionHorizon(rules, now)
+	if !ok {
+		logger.Info("Every retention rule keeps forever; nothing to purge")
+		return report, nil
+	}
+	report.Cutoff = until
 
-	log.GetLogger("db-purge").Info("Starting event purge",
-		"keep_hours", cfg.KeepIntervalHours,
-		"cutoff_time", cutoffTime.Format(time.RFC3339))
+	logger.Info("Starting event purge",
+		"rules", len(rules),
+		"dry_run", dryRun,
+		"cutoff_time", time.Unix(until, 0).Format(time.RFC3339))
 
-	// Build whitelist exclusion set for fast lookup
+	// Build whitelist set for fast lookup
 	whitelistSet := make(map[string]bool, len(whitelistedPubkeys))
 	for _, pk := range whitelistedPubkeys {
 		whitelistSet[pk] = true
 	}
 
-	// Query old events using until filter
-	untilTime := time.Unix(cutoff, 0)
-	limit := 5000
-
-	filters := []nostr.Filter{{
-		Until: &untilTime,
-		Limit: &limit,
-	}}
-
-	// If purging by specific kinds, add kind filter
-	if cfg.PurgeByKindEnabled && len(cfg.KindsToPurge) > 0 {
-		filters[0].Kinds = cfg.KindsToPurge
-	}
-
-	events, err := db.Query(filters, limit)
-	if err != nil {
-		log.GetLogger("db-purge").Error("Failed to query events for purging", "error", err)
-		return 0
-	}
-
-	purgeCount := 0
-	failCount := 0
-	for _, evt := range events {
-		// Skip whitelisted ("member") pubkeys.
-		if cfg.ExcludeWhitelisted && whitelistSet[evt.PubKey] {
-			continue
+	var err error
+	untilTime := time.Unix(until, 0)
+	filter := nostr.Filter{Until: &untilTime}
+	var cursor *Cursor
+walk:
+	for {
+		var txn *Txn
+		if txn, err = db.BeginQuery(); err != nil {
+			break
+		}
+		events, next, qerr := txn.QueryPage(filter, cursor, maxQueryResults)
+		txn.EndQuery()
+		if qerr != nil {
+			err = qerr
+			break
 		}
 
-		// v0.4 purge_by_category gate: when the map is configured, an
-		// event's category must resolve to an explicit `true` entry or
-		// it's kept. This is the behavior v0.4 operators rely on to
-		// purge only some categories (e.g. regular + deprecated) while
-		// keeping replaceable profiles forever. When the map is empty
-		// or nil, the gate is inactive and every candidate that made it
-		// past the cutoff/kind/whitelist filters is deleted.
-		if len(cfg.PurgeByCategory) > 0 {
-			if !categoryPermitsPurge(evt.Kind, cfg.PurgeByCategory) {
+		for _, evt := range events {
+			report.Scanned++
+			i, expired := retentionDecision(rules, evt.Kind, whitelistSet[evt.PubKey], evt.CreatedAt, now)
+			if i < 0 {
 				continue
 			}
+			report.Rules[i].Matched++
+			if !expired {
+				continue
+			}
+			if !dryRun {
+				if derr := db.purgeDelete(evt.ID); derr != nil {
+					logger.Error("Delete failed during purge",
+						"event_id", evt.ID, "error", derr)
+					report.Failed++
+					if errors.Is(derr, ErrWriterQueueFull) || errors.Is(derr, errMalformedID) {
+						continue
+					}
+					err = derr // the database went away
+					break walk
+				}
+			}
+			report.Rules[i].Purged++
+			report.Rules[i].Bytes += eventJSONSize(evt)
+			report.Purged++
 		}
-
-		idBytes, err := hexToBytes32(evt.ID)
-		if err != nil {
-			log.GetLogger("db-purge").Warn("Skipping malformed event id",
-				"event_id", evt.ID, "error", err)
-			failCount++
-			continue
-		}
-		var id32 [32]byte
-		copy(id32[:], idBytes)
-		if err := db.DeleteNoteByID(id32); err != nil {
-			log.GetLogger("db-purge").Error("Delete failed during purge",
-				"event_id", evt.ID, "error", err)
-			failCount++
-			continue
+		if next == nil {
+			break
 		}
-		purgeCount++
+		cursor = next
+	}
+	report.TookMs = time.Since(start).Milliseconds()
+
+	for _, r := range report.Rules {
+		logger.Info("Retention rule",
+			"rule", r.Rule,
+			"matched", r.Matched,
+			"purged", r.Purged,
+			"bytes", r.Bytes,
+			"dry_run", dryRun)
 	}
+	msg := "Purge completed"
+	if dryRun {
+		msg = "Purge dry run completed"
+	}
+	logger.Info(msg,
+		"events_scanned", report.Scanned,
+		"deleted", report.Purged,
+		"failed", report.Failed,
+		"took_ms", report.TookMs)
+
+	return report, err
+}
 
-	log.GetLogger("db-purge").Info("Purge completed",
-		"events_scanned", len(events),
-		"deleted", purgeCount,
-		"failed", failCount)
+// errMalformedID marks an event id purgeDelete couldn't decode.
+var errMalformedID = errors.New("malformed event id")
 
-	return purgeCount
+// purgeDelete deletes one event, waiting out a full writer queue.
+func (db *NDB) purgeDelete(hexID string) error {
+	idBytes, err := hexToBytes32(hexID)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errMalformedID, err)
+	}
+	var id32 [32]byte
+	copy(id32[:], idBytes)
+	for try := 0; ; try++ {
+		err := db.DeleteNoteByID(id32)
+		if !errors.Is(err, ErrWriterQueueFull) || try == purgeQueueRetries {
+			return err
+		}
+		time.Sleep(purgeQueueBackoff)
+	}
 }
 
 // ScheduleEventPurging runs periodic event purging at the configured interval.
@@ -114,7 +181,9 @@ func (db *NDB) ScheduleEventPurging(cfg *cfgType.ServerConfig, getWhitelistedPub
 	purgeInterval := time.Duration(cfg.EventPurge.PurgeIntervalMinutes) * time.Minute
 	log.GetLogger("db-purge").Info("Starting scheduled event purging",
 		"interval_minutes", cfg.EventPurge.PurgeIntervalMinutes,
-		"keep_hours", cfg.EventPurge.KeepIntervalHours)
+		"keep_hours", cfg.EventPurge.KeepIntervalHours,
+		"rules", len(cfg.EventPurge.Rules),
+		"dry_run", cfg.EventPurge.DryRun)
 
 	ticker := time.NewTicker(purgeInterval)
 	defer ticker.Stop()
@@ -1,6 +1,10 @@
 package nostrdb
 
-import "testing"
+import (
+	"testing"
+
+	cfgType "github.com/0ceanslim/grain/config/types"
+)
 
 // These tests verify the v0.4 purge_by_category compatibility rules.
 // They're pure logic — no NDB open — so they run even when CGO linking
@@ -87,3 +91,95 @@ func TestCategoryPermitsPurge_MissingCategoryKeeps(t *testing.T) {
 		t.Errorf("kind 2 (deprecated) should be kept when only regular:true is configured")
 	}
 }
+
+func TestRetentionRules_LegacyConfig(t *testing.T) {
+	// Without rules, keep_interval_hours and the gates act as one rule.
+	cfg := &cfgType.EventPurgeConfig{
+		KeepIntervalHours:  24,
+		PurgeByCategory:    map[string]bool{"regular": true, "replaceable": false},
+		ExcludeWhitelisted: true,
+	}
+	rules := retentionRules(cfg)
+	const now = 1_000_000
+	old := int64(now - 25*3600)
+	cases := []struct {
+		kind        int
+		whitelisted bool
+		createdAt   int64
+		want        bool
+	}{
+		{1, false, old, true},
+		{1, false, now - 3600, false},   // too young
+		{0, false, old, false},          // replaceable: false
+		{1, true, old, false},           // exclude_whitelisted
+		{1, false, now - 24*3600, true}, // the cutoff is inclusive
+	}
+	for _, c := range cases {
+		if _, got := retentionDecision(rules, c.kind, c.whitelisted, c.createdAt, now); got != c.want {
+			t.Errorf("kind %d whitelisted=%v age=%ds: expired = %v, want %v", c.kind, c.whitelisted, now-c.createdAt, got, c.want)
+		}
+	}
+	if until, ok := retentionHorizon(rules, now); !ok || until != now-24*3600 {
+		t.Errorf("horizon = %d, %v", until, ok)
+	}
+}
+
+func TestRetentionRules_FirstMatchWins(t *testing.T) {
+	// kind 1: 90 days, kind 7: 14 days, kind 30023: forever,
+	// non-whitelisted authors: 7 days.
+	cfg := &cfgType.EventPurgeConfig{
+		KeepIntervalHours: 1, // ignored once rules are set
+		Rules: []cfgType.RetentionRule{
+			{Name: "notes", Kinds: []int{1}, KeepDays: 90},
+			{Name: "reactions", Kinds: []int{7}, KeepDays: 14},
+			{Name: "articles", Kinds: []int{30023}, Forever: true},
+			{Name: "strangers", Authors: cfgType.RetentionAuthorsNonWhitelisted, KeepDays: 7},
+		},
+	}
+	rules := retentionRules(cfg)
+	const now, day = 100_000_000, 86400
+	cases := []struct {
+		kind        int
+		whitelisted bool
+		age         int64
+		rule        int
+		expired     bool
+	}{
+		{1, false, 30 * day, 0, false}, // notes outrank strangers
+		{1, true, 91 * day, 0, true},
+		{7, true, 15 * day, 1, true},
+		{30023, false, 1000 * day, 2, false},
+		{6, false, 8 * day, 3, true},
+		{6, true, 1000 * day, -1, false}, // no rule: kept
+	}
+	for _, c := range cases {
+		rule, expired := retentionDecision(rules, c.kind, c.whitelisted, now-c.age, now)
+		if rule != c.rule || expired != c.expired {
+			t.Errorf("kind %d whitelisted=%v age=%dd: rule %d expired %v, want %d %v",
+				c.kind, c.whitelisted, c.age/day, rule, expired, c.rule, c.expired)
+		}
+	}
+	if until, ok := retentionHorizon(rules, now); !ok || until != now-7*day {
+		t.Errorf("horizon = %d, %v; want the shortest window", until, ok)
+	}
+
+	forever := retentionRules(&cfgType.EventPurgeConfig{Rules: []cfgType.RetentionRule{{Forever: true}}})
+	if _, ok := retentionHorizon(forever, now); ok {
+		t.Error("all-forever rules should have no horizon")
+	}
+	if forever[0].name != "rule 1" {
+		t.Errorf("unnamed rule = %q", forever[0].name)
+	}
+}
+
+func TestRetentionRules_Categories(t *testing.T) {
+	rules := retentionRules(&cfgType.EventPurgeConfig{Rules: []cfgType.RetentionRule{
+		{Categories: []string{"addressable"}, KeepHours: 1},
+	}})
+	if !rules[0].matches(30023, false) {
+		t.Error(`categories ["addressable"] should match kind 30023`)
+	}
+	if rules[0].matches(1, false) {
+		t.Error(`categories ["addressable"] shouldn't match kind 1`)
+	}
+}
@@ -9,8 +9,10 @@ import "C"
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 	"unsafe"
 
+	"github.com/0ceanslim/grain/server/metrics"
 	nostr "github.com/0ceanslim/grain/server/types"
 	"github.com/0ceanslim/grain/server/utils/log"
 )
@@ -31,22 +33,81 @@ func (db *NDB) Query(filters []nostr.Filter, limit int) ([]nostr.Event, error) {
 }
 
 // Query executes NIP-01 filters within an existing transaction.
+// Events failing a filter's "&" tags are dropped after the limit is
+// applied, so such a filter may come back short; QueryPage doesn't.
 func (txn *Txn) Query(filters []nostr.Filter, limit int) ([]nostr.Event, error) {
 	if len(filters) == 0 {
 		return nil, nil
 	}
-
 	if limit <= 0 {
 		limit = 1000
 	}
 	if limit > maxQueryResults {
 		limit = maxQueryResults
 	}
+	events, err := txn.queryEvents(filters, limit)
+	if err != nil || !hasAndTags(filters) {
+		return events, err
+	}
+	kept := events[:0]
+	for _, e := range events {
+		for _, f := range filters {
+			if f.MatchesEvent(e) {
+				kept = append(kept, e)
+				break
+			}
+		}
+	}
+	return kept, nil
+}
+
+// hasAndTags reports whether any filter has NIP-119 "&" tags, which
+// nostrdb can only partly apply.
+func hasAndTags(filters []nostr.Filter) bool {
+	for _, f := range filters {
+		if len(f.AndTags) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// queryEvents is Query without the limit's default and cap.
+func (txn *Txn) queryEvents(filters []nostr.Filter, limit int) ([]nostr.Event, error) {
+	events := []nostr.Event{}
+	err := txn.query(filters, limit, func(note *C.struct_ndb_note) {
+		events = append(events, noteToEventDirect(note))
+	})
+	return events, err
+}
+
+// queryKeys is queryEvents for callers that only need each match's
+// id, pubkey and created_at: they're read straight off the stored
+// note, without copying out its content, tags or signature.
+func (txn *Txn) queryKeys(filters []nostr.Filter, limit int) ([]noteKey, error) {
+	var keys []noteKey
+	err := txn.query(filters, limit, func(note *C.struct_ndb_note) {
+		var k noteKey
+		copy(k.id[:], unsafe.Slice((*byte)(unsafe.Pointer(C.ndb_note_id(note))), 32))
+		copy(k.pubkey[:], unsafe.Slice((*byte)(unsafe.Pointer(C.ndb_note_pubkey(note))), 32))
+		k.createdAt = int64(C.ndb_note_created_at(note))
+		keys = append(keys, k)
+	})
+	return keys, err
+}
+
+// query runs filters and hands up to limit matching notes to fn,
+// newest first. The note is only valid inside the transaction.
+func (txn *Txn) query(filters []nostr.Filter, limit int, fn func(note *C.struct_ndb_note)) error {
+	if len(filters) == 0 || limit <= 0 {
+		return nil
+	}
+	defer metrics.DBQueryDuration.ObserveSince(time.Now())
 
 	// Build nostrdb filters from our Filter type
 	ndbFilters, err := buildNDBFilters(filters)
 	if err != nil {
-		return nil, fmt.Errorf("failed to build ndb filters: %w", err)
+		return fmt.Errorf("failed to build ndb filters: %w", err)
 	}
 	defer func() {
 		for i := range ndbFilters {
@@ -68,7 +129,7 @@ func (txn *Txn) Query(filters []nostr.Filter, limit int) ([]nostr.Event, error)
 	)
 
 	if rc == 0 {
-		return nil, fmt.Errorf("ndb_query failed")
+		return fmt.Errorf("ndb_query failed")
 	}
 
 	log.GetLogger("db-query").Debug("Query executed",
@@ -76,19 +137,12 @@ func (txn *Txn) Query(filters []nostr.Filter, limit int) ([]nostr.Event, error)
 		"results", int(count),
 		"limit", limit)
 
-	// Convert results to Go events
-	events := make([]nostr.Event, 0, int(count))
 	for i := 0; i < int(count); i++ {
-		result := results[i]
-		if result.note == nil {
-			continue
+		if results[i].note != nil {
+			fn(results[i].note)
 		}
-
-		evt := noteToEventDirect(result.note)
-		events = append(events, evt)
 	}
-
-	return events, nil
+	return nil
 }
 
 // GetNoteByID looks up a single event by its hex ID.
@@ -280,6 +334,17 @@ func filterToJSON(f nostr.Filter) (string, error) {
 		}
 	}
 
+	// NIP-119 "&" tag filters have no nostrdb equivalent: a tag field
+	// matches any of its values. One value of each goes in as a field,
+	// unless the tag has a "#" field already, so the index narrows the
+	// scan; Filter.MatchesAndTags checks the rest.
+	for key, values := range f.AndTags {
+		if len(values) == 0 || len(f.Tags[key]) > 0 {
+			continue
+		}
+		m["#"+key] = values[:1]
+	}
+
 	data, err := json.Marshal(m)
 	if err != nil {
 		return "", err
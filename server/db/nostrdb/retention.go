@@ -0,0 +1,140 @@
+package nostrdb
+
+import (
+	"fmt"
+
+	cfgType "github.com/0ceanslim/grain/config/types"
+)
+
+// retentionRule is a compiled event_purge rule.
+type retentionRule struct {
+	name       string
+	kinds      map[int]bool    // nil = any kind
+	categories map[string]bool // nil = any category; see categoryPermitsPurge
+	authors    string          // cfgType.RetentionAuthors*
+	keep       int64           // seconds
+	forever    bool
+}
+
+// retentionRules compiles cfg's rules. Without any, the legacy
+// settings become a single rule — keep_interval_hours for whatever
+// the kind, category and whitelist gates let through — so configs
+// that predate rules purge exactly what they used to.
+func retentionRules(cfg *cfgType.EventPurgeConfig) []retentionRule {
+	if len(cfg.Rules) == 0 {
+		r := retentionRule{name: "default", keep: int64(cfg.KeepIntervalHours) * 3600}
+		if cfg.PurgeByKindEnabled && len(cfg.KindsToPurge) > 0 {
+			r.kinds = make(map[int]bool, len(cfg.KindsToPurge))
+			for _, k := range cfg.KindsToPurge {
+				r.kinds[k] = true
+			}
+		}
+		// v0.4 purge_by_category gate: when the map is configured, an
+		// event's category must resolve to an explicit `true` entry or
+		// it's kept. When the map is empty or nil, the gate is
+		// inactive.
+		if len(cfg.PurgeByCategory) > 0 {
+			r.categories = cfg.PurgeByCategory
+		}
+		if cfg.ExcludeWhitelisted {
+			r.authors = cfgType.RetentionAuthorsNonWhitelisted
+		}
+		return []retentionRule{r}
+	}
+
+	rules := make([]retentionRule, 0, len(cfg.Rules))
+	for i, cr := range cfg.Rules {
+		r := retentionRule{
+			name:    cr.Name,
+			authors: cr.Authors,
+			keep:    cr.KeepSeconds(),
+			forever: cr.Forever,
+		}
+		if r.name == "" {
+			r.name = fmt.Sprintf("rule %d", i+1)
+		}
+		if len(cr.Kinds) > 0 {
+			r.kinds = make(map[int]bool, len(cr.Kinds))
+			for _, k := range cr.Kinds {
+				r.kinds[k] = true
+			}
+		}
+		if len(cr.Categories) > 0 {
+			r.categories = make(map[string]bool, len(cr.Categories))
+			for _, c := range cr.Categories {
+				r.categories[c] = true
+			}
+		}
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// matches reports whether r applies to an event of kind by an author
+// who is (or isn't) whitelisted.
+func (r *retentionRule) matches(kind int, whitelisted bool) bool {
+	if r.kinds != nil && !r.kinds[kind] {
+		return false
+	}
+	if r.categories != nil && !categoryPermitsPurge(kind, r.categories) {
+		return false
+	}
+	switch r.authors {
+	case cfgType.RetentionAuthorsWhitelisted:
+		return whitelisted
+	case cfgType.RetentionAuthorsNonWhitelisted:
+		return !whitelisted
+	}
+	return true
+}
+
+// retentionDecision picks the first rule that applies to an event and
+// reports whether it has expired at now. rule is -1 when none applies
+// and the event is kept.
+func retentionDecision(rules []retentionRule, kind int, whitelisted bool, createdAt, now int64) (rule int, expired bool) {
+	for i := range rules {
+		if !rules[i].matches(kind, whitelisted) {
+			continue
+		}
+		if rules[i].forever {
+			return i, false
+		}
+		return i, createdAt <= now-rules[i].keep
+	}
+	return -1, false
+}
+
+// retentionHorizon is the newest created_at any rule could purge at
+// now; nothing newer needs looking at. ok is false when every rule
+// keeps forever.
+func retentionHorizon(rules []retentionRule, now int64) (until int64, ok bool) {
+	for _, r := range rules {
+		if r.forever {
+			continue
+		}
+		if !ok || now-r.keep > until {
+			until, ok = now-r.keep, true
+		}
+	}
+	return until, ok
+}
+
+// RulePurge is what one retention rule purged (or, in a dry run,
+// would purge) in one run.
+type RulePurge struct {
+	Rule    string `json:"rule"`
+	Matched int    `json:"matched"` // events the rule decided, kept or not
+	Purged  int    `json:"purged"`
+	Bytes   int64  `json:"bytes"` // JSON size of the purged events
+}
+
+// PurgeReport is the outcome of one purge run.
+type PurgeReport struct {
+	DryRun  bool        `json:"dry_run"`
+	Cutoff  int64       `json:"cutoff"` // newest created_at looked at; 0 = nothing to purge
+	Scanned int         `json:"scanned"`
+	Purged  int         `json:"purged"`
+	Failed  int         `json:"failed"`
+	Rules   []RulePurge `json:"rules"`
+	TookMs  int64       `json:"took_ms"`
+}
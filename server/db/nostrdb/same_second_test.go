@@ -0,0 +1,123 @@
+package nostrdb
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	nostr "github.com/0ceanslim/grain/server/types"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+)
+
+// TestSameSecond_MoreThanAPage stores more events at one timestamp
+// than a nostrdb query returns and checks that every paging caller
+// sees each of them exactly once.
+func TestSameSecond_MoreThanAPage(t *testing.T) {
+	db := openTempDB(t)
+	ctx := context.Background()
+
+	priv, _ := btcec.NewPrivateKey()
+	pub := hex.EncodeToString(schnorr.SerializePubKey(priv.PubKey()))
+	ts := time.Now().Unix() - 60
+	const total = maxQueryResults + 2500
+
+	want := make(map[string]bool, total)
+	for i := 0; i < total; i++ {
+		evt := signEvent(t, priv, pub, 1, fmt.Sprintf("same second %d", i), nil, ts)
+		for err := db.StoreEvent(ctx, evt); err != nil; err = db.StoreEvent(ctx, evt) {
+			time.Sleep(10 * time.Millisecond) // writer queue full
+		}
+		want[evt.ID] = true
+	}
+	filter := nostr.Filter{Authors: []string{pub}}
+	deadline := time.Now().Add(60 * time.Second)
+	for {
+		res, err := db.CountFiltered([]nostr.Filter{filter}, nil)
+		if err != nil {
+			t.Fatalf("count: %v", err)
+		}
+		if res.Count == total && !res.Approximate {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("counted %+v, want exactly %d", res, total)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// QueryPage, in pages that end inside the second. The second is
+	// read once, not again for every page resuming inside it.
+	seen := make(map[string]bool, total)
+	var cursor *Cursor
+	read := 0
+	for {
+		page, next, n, err := db.QueryPageRead(filter, cursor, 3000)
+		if err != nil {
+			t.Fatalf("query page: %v", err)
+		}
+		read += n
+		for i, e := range page {
+			if seen[e.ID] || !want[e.ID] {
+				t.Fatalf("event %s repeated or unknown", e.ID)
+			}
+			seen[e.ID] = true
+			if i > 0 && page[i-1].ID >= e.ID {
+				t.Fatal("same-second events not in id order")
+			}
+		}
+		if next == nil {
+			break
+		}
+		cursor = next
+	}
+	if len(seen) != total {
+		t.Fatalf("QueryPage read %d events, want %d", len(seen), total)
+	}
+	if read > 4*total {
+		t.Fatalf("QueryPage read %d notes for %d events", read, total)
+	}
+
+	// A cursor that went through JSON still resumes inside the second.
+	page, next, err := db.QueryPage(filter, nil, 3000)
+	if err != nil || next == nil {
+		t.Fatalf("query page: %v, next %v", err, next)
+	}
+	raw, _ := json.Marshal(next)
+	cursor = new(Cursor)
+	if err := json.Unmarshal(raw, cursor); err != nil {
+		t.Fatalf("unmarshal cursor: %v", err)
+	}
+	rest := len(page)
+	for cursor != nil {
+		page, cursor, err = db.QueryPage(filter, cursor, 3000)
+		if err != nil {
+			t.Fatalf("query page: %v", err)
+		}
+		rest += len(page)
+	}
+	if rest != total {
+		t.Fatalf("paging from a decoded cursor read %d events, want %d", rest, total)
+	}
+
+	if n, err := db.Export(filter, func(nostr.Event) error { return nil }); err != nil || n != total {
+		t.Fatalf("export: %d events, %v; want %d", n, err, total)
+	}
+	overlap := []nostr.Filter{filter, {Authors: []string{pub}, Kinds: []int{1}}}
+	if n, err := db.ExportFilters(overlap, func(nostr.Event) error { return nil }); err != nil || n != total {
+		t.Fatalf("export filters: %d events, %v; want each of %d once", n, err, total)
+	}
+	vec, err := db.NegentropyStorage(filter, total, nil)
+	if err != nil || vec.Size() != total {
+		t.Fatalf("negentropy storage: %v, %v; want %d items", vec, err, total)
+	}
+
+	deleted, err := db.DeleteMatching(filter)
+	if err != nil || deleted != total {
+		t.Fatalf("delete matching: %d, %v; want %d", deleted, err, total)
+	}
+}
@@ -25,10 +25,21 @@ const maxTextSearchResults = 128
 // query string is passed as a separate argument to ndb_text_search_with.
 //
 // Result ordering is descending by created_at (newest-first), matching
-// the rest of grain's read paths. nostrdb only indexes content for
-// kinds 1 and 30023 — searches that filter to other kinds will return
-// nothing even if matching content exists in the DB.
+// the rest of grain's read paths; TextSearchOldest is the other way
+// round. nostrdb only indexes content for kinds 1 and 30023 — searches
+// that filter to other kinds will return nothing even if matching
+// content exists in the DB; server/fulltext indexes the kinds an
+// operator adds in search.indexed_kinds.
 func (txn *Txn) TextSearch(query string, base nostr.Filter, limit int) ([]nostr.Event, error) {
+	return txn.textSearch(query, base, limit, false)
+}
+
+// TextSearchOldest is TextSearch oldest first (NIP-50 sort:old).
+func (txn *Txn) TextSearchOldest(query string, base nostr.Filter, limit int) ([]nostr.Event, error) {
+	return txn.textSearch(query, base, limit, true)
+}
+
+func (txn *Txn) textSearch(query string, base nostr.Filter, limit int, oldestFirst bool) ([]nostr.Event, error) {
 	if query == "" {
 		return nil, nil
 	}
@@ -54,7 +65,11 @@ func (txn *Txn) TextSearch(query string, base nostr.Filter, limit int) ([]nostr.
 
 	var cfg C.struct_ndb_text_search_config
 	C.ndb_default_text_search_config(&cfg)
-	C.ndb_text_search_config_set_order(&cfg, C.NDB_ORDER_DESCENDING)
+	if oldestFirst {
+		C.ndb_text_search_config_set_order(&cfg, C.NDB_ORDER_ASCENDING)
+	} else {
+		C.ndb_text_search_config_set_order(&cfg, C.NDB_ORDER_DESCENDING)
+	}
 	C.ndb_text_search_config_set_limit(&cfg, C.int(limit))
 
 	var results C.struct_ndb_text_search_results
@@ -95,3 +110,13 @@ func (db *NDB) TextSearch(query string, base nostr.Filter, limit int) ([]nostr.E
 	defer txn.EndQuery()
 	return txn.TextSearch(query, base, limit)
 }
+
+// TextSearchOldest is the no-transaction wrapper of Txn.TextSearchOldest.
+func (db *NDB) TextSearchOldest(query string, base nostr.Filter, limit int) ([]nostr.Event, error) {
+	txn, err := db.BeginQuery()
+	if err != nil {
+		return nil, err
+	}
+	defer txn.EndQuery()
+	return txn.TextSearchOldest(query, base, limit)
+}
@@ -66,6 +66,7 @@ func (db *NDB) ingestEvent(evt nostr.Event) error {
 	// NIP-40: register a future expiration with the in-memory tracker.
 	// No-op if the event has no expiration tag or the tracker isn't set.
 	db.trackIfExpiring(evt)
+	db.countStored(evt, len(jsonStr))
 
 	log.GetLogger("db-store").Info("Event stored",
 		"event_id", evt.ID, "kind", evt.Kind, "pubkey", evt.PubKey)
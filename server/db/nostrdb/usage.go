@@ -0,0 +1,146 @@
+package nostrdb
+
+/*
+#include "nostrdb.h"
+#include "lmdb.h"
+
+// grain_env_usage reports the map size and the pages LMDB has handed
+// out (the high-water mark; freed pages are reused below it) for the
+// environment behind an open read txn.
+static int grain_env_usage(struct ndb_txn *txn, size_t *mapsize, size_t *used) {
+	MDB_env *env = mdb_txn_env((MDB_txn *)txn->mdb_txn);
+	MDB_envinfo info;
+	MDB_stat st;
+	int rc;
+
+	if ((rc = mdb_env_info(env, &info)))
+		return rc;
+	if ((rc = mdb_env_stat(env, &st)))
+		return rc;
+	*mapsize = info.me_mapsize;
+	*used = (info.me_last_pgno + 1) * (size_t)st.ms_psize;
+	return 0;
+}
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/0ceanslim/grain/server/utils/log"
+)
+
+// Usage is how full the LMDB map is.
+type Usage struct {
+	MapSizeBytes uint64 // configured map size: the most the database can hold
+	UsedBytes    uint64 // pages in use; what runs into MapSizeBytes
+	DataBytes    uint64 // keys and values across nostrdb's tables, from the cached ndb_stat
+	Notes        uint64 // events stored, from the cached ndb_stat
+}
+
+// Usage reports how much of the map is in use. UsedBytes is the one to
+// watch: LMDB writes fail once it reaches MapSizeBytes, and the
+// failure happens on nostrdb's writer thread where grain never sees
+// it. DataBytes is what the events and indexes themselves take, as of
+// the last ndb_stat (see indexStats), which this doesn't refresh:
+// that's a walk of every table.
+func (db *NDB) Usage() (Usage, error) {
+	var u Usage
+	indexes, _ := db.indexStats(false)
+	for i, idx := range indexes {
+		u.DataBytes += idx.KeyBytes + idx.ValueBytes
+		if i == C.NDB_DB_NOTE {
+			u.Notes = idx.Entries
+		}
+	}
+
+	txn, err := db.BeginQuery()
+	if err != nil {
+		return Usage{}, err
+	}
+	defer txn.EndQuery()
+
+	var mapSize, used C.size_t
+	if rc := C.grain_env_usage(&txn.txn, &mapSize, &used); rc != 0 {
+		return Usage{}, fmt.Errorf("mdb_env_info: %s", C.GoString(C.mdb_strerror(rc)))
+	}
+	u.MapSizeBytes = uint64(mapSize)
+	u.UsedBytes = uint64(used)
+	return u, nil
+}
+
+// ErrResizeBusy is Resize giving up on reads that wouldn't let up.
+var ErrResizeBusy = errors.New("resize: database busy with long reads")
+
+// resizeWait is how long Resize tries for the database before giving
+// up; resizePoll is how often.
+const (
+	resizeWait = 2 * time.Second
+	resizePoll = 10 * time.Millisecond
+)
+
+// tryLock takes db.mu for writing if it's free at some point within
+// wait, without ever blocking readers while it tries.
+func (db *NDB) tryLock(wait time.Duration) bool {
+	deadline := time.Now().Add(wait)
+	for {
+		if db.mu.TryLock() {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(resizePoll)
+	}
+}
+
+// MapSizeMB is the map size the database is open with.
+func (db *NDB) MapSizeMB() int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.mapSizeMB
+}
+
+// Resize reopens the database with a larger map. It holds off new
+// queries and ingests until the reopen is done; nostrdb drains its
+// ingest and writer queues on the way down, so nothing already
+// accepted is lost. On failure it reopens at the old size.
+//
+// It never queues for the database: a waiting Lock would block every
+// new RLock behind whatever long read is open (a backup's copy, a big
+// COUNT or negentropy scan). It tries for resizeWait instead and
+// returns ErrResizeBusy if reads never let up.
+func (db *NDB) Resize(mapSizeMB int) error {
+	if !db.tryLock(resizeWait) {
+		return ErrResizeBusy
+	}
+	defer db.mu.Unlock()
+
+	if db.ndb == nil {
+		return fmt.Errorf("nostrdb is closed")
+	}
+	if mapSizeMB <= db.mapSizeMB {
+		return fmt.Errorf("map size %d MB is not larger than the current %d MB", mapSizeMB, db.mapSizeMB)
+	}
+
+	C.ndb_destroy(db.ndb)
+	db.ndb = nil
+
+	ndb, err := initNDB(db.dir, mapSizeMB, db.ingestThreads, db.flags)
+	if err != nil {
+		var reopenErr error
+		if db.ndb, reopenErr = initNDB(db.dir, db.mapSizeMB, db.ingestThreads, db.flags); reopenErr != nil {
+			return fmt.Errorf("resize to %d MB: %w; reopening at %d MB also failed: %v", mapSizeMB, err, db.mapSizeMB, reopenErr)
+		}
+		return fmt.Errorf("resize to %d MB: %w", mapSizeMB, err)
+	}
+
+	log.GetLogger("db").Info("nostrdb map size increased",
+		"path", db.dir,
+		"from_mb", db.mapSizeMB,
+		"to_mb", mapSizeMB)
+	db.ndb = ndb
+	db.mapSizeMB = mapSizeMB
+	return nil
+}
@@ -0,0 +1,29 @@
+//go:build !windows
+
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// databaseInUse reports whether another process has the LMDB
+// environment at dir open. Every process with it open holds an fcntl
+// read lock on the first byte of lock.mdb, so a write lock there only
+// succeeds when nobody does.
+func databaseInUse(dir string) bool {
+	f, err := os.OpenFile(filepath.Join(dir, "lock.mdb"), os.O_RDWR, 0)
+	if err != nil {
+		return false // no lock file: never opened, or not LMDB
+	}
+	defer f.Close()
+
+	lk := syscall.Flock_t{Type: syscall.F_WRLCK, Whence: 0, Start: 0, Len: 1}
+	if err := syscall.FcntlFlock(f.Fd(), syscall.F_SETLK, &lk); err != nil {
+		return true
+	}
+	lk.Type = syscall.F_UNLCK
+	syscall.FcntlFlock(f.Fd(), syscall.F_SETLK, &lk)
+	return false
+}
@@ -4,10 +4,10 @@ import (
 	"bufio"
 	"fmt"
 	"os"
-	"path/filepath"
 	"strings"
 
 	"github.com/0ceanslim/grain/config"
+	"github.com/0ceanslim/grain/server/audit"
 	"github.com/0ceanslim/grain/server/db/nostrdb"
 )
 
@@ -25,6 +25,10 @@ import (
 // isn't observable from the Go side (the C delete is a no-op on missing
 // ids and returns success), so every well-formed id that enqueues cleanly
 // is reported as deleted. Malformed ids report an error and continue.
+//
+// The run is recorded in the audit log with the ids that were and
+// weren't deleted; shell access is the only identity there is, so the
+// entry has no signer.
 func DeleteEvents(ids []string) error {
 	if err := ensureConfigFiles(); err != nil {
 		return fmt.Errorf("failed to ensure config files: %w", err)
@@ -35,17 +39,7 @@ func DeleteEvents(ids []string) error {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
 
-	dbPath := cfg.Database.Path
-	if dbPath == "" {
-		dbPath = "data"
-	}
-	if !filepath.IsAbs(dbPath) {
-		dbPath = filepath.Join(config.GetDataDir(), dbPath)
-	}
-	mapSizeMB := cfg.Database.MapSizeMB
-	if mapSizeMB <= 0 {
-		mapSizeMB = 4096
-	}
+	dbPath, mapSizeMB := resolveDatabaseSettings(cfg)
 
 	fmt.Printf("Opening database at %s...\n", dbPath)
 	db, err := nostrdb.Open(dbPath, mapSizeMB, 1)
@@ -57,7 +51,9 @@ func DeleteEvents(ids []string) error {
 	// so every enqueued delete is committed before we return.
 	defer db.Close()
 
-	var ok, bad int
+	audit.SetLog(audit.New(config.ConfigPath("audit.jsonl")))
+
+	var deleted, failed []string
 	for _, rawID := range ids {
 		id := strings.TrimSpace(rawID)
 		if id == "" {
@@ -66,24 +62,32 @@ func DeleteEvents(ids []string) error {
 		idBytes, err := hexToBytes32(id)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "  %s: invalid hex id: %v\n", id, err)
-			bad++
+			failed = append(failed, id)
 			continue
 		}
 		var id32 [32]byte
 		copy(id32[:], idBytes)
 		if err := db.DeleteNoteByID(id32); err != nil {
 			fmt.Fprintf(os.Stderr, "  %s: delete enqueue failed: %v\n", id, err)
-			bad++
+			failed = append(failed, id)
 			continue
 		}
 		fmt.Printf("  deleted %s\n", id)
-		ok++
+		deleted = append(deleted, id)
 	}
 
-	fmt.Printf("\nDelete complete: %d enqueued, %d failed\n", ok, bad)
-	if ok == 0 && bad > 0 {
+	fmt.Printf("\nDelete complete: %d enqueued, %d failed\n", len(deleted), len(failed))
+	entry := audit.Entry{
+		Source: audit.SourceCLI,
+		Method: "delete",
+		Params: map[string][]string{"deleted": deleted, "failed": failed},
+	}
+	if len(deleted) == 0 && len(failed) > 0 {
+		entry.Error = "no events deleted"
+		audit.Record(entry)
 		return fmt.Errorf("no events deleted")
 	}
+	audit.Record(entry)
 	return nil
 }
 
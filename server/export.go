@@ -0,0 +1,239 @@
+package server
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/0ceanslim/grain/config"
+	"github.com/0ceanslim/grain/server/db/nostrdb"
+	nostr "github.com/0ceanslim/grain/server/types"
+)
+
+// Export/import compression. gzip is built in; zstd goes through the
+// zstd binary on PATH rather than pulling a codec into the relay.
+const (
+	compressNone = "none"
+	compressGzip = "gzip"
+	compressZstd = "zstd"
+)
+
+// ExportEvents is the `grain --export <file> [--filter <json>]
+// [--compress gzip|zstd|none]` entry point. It streams every event
+// matching the filter (kinds, authors, since/until, tags) to file as
+// JSONL, newest first — the format --import reads back — followed by
+// the same authors' deletion requests that the filter left out. file
+// "-" is stdout. compression "" picks by extension: .gz is gzip, .zst
+// zstd.
+func ExportEvents(filename, filterJSON, compression string) error {
+	if err := ensureConfigFiles(); err != nil {
+		return fmt.Errorf("failed to ensure config files: %w", err)
+	}
+
+	cfg, err := config.LoadConfig(config.ConfigPath("config.yml"))
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	filter, err := parseSyncFilter(filterJSON)
+	if err != nil {
+		return err
+	}
+	if compression == "" {
+		compression = compressionForName(filename)
+	}
+
+	dbPath, mapSizeMB := resolveDatabaseSettings(cfg)
+	if _, err := os.Stat(dbPath); err != nil {
+		return fmt.Errorf("no database at %s: %w", dbPath, err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Opening database at %s...\n", dbPath)
+	db, err := nostrdb.Open(dbPath, mapSizeMB, 1)
+	if err != nil {
+		return fmt.Errorf("failed to open nostrdb: %w", err)
+	}
+	defer db.Close()
+
+	var out io.WriteCloser = os.Stdout
+	if filename != "-" {
+		f, err := os.Create(filename)
+		if err != nil {
+			return fmt.Errorf("failed to create export file: %w", err)
+		}
+		out = f
+	}
+
+	startTime := time.Now()
+	exported, err := exportTo(db, out, filter, compression, true)
+	if closeErr := out.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to close export file: %w", closeErr)
+	}
+	if err != nil {
+		return err
+	}
+
+	elapsed := time.Since(startTime)
+	rate := float64(0)
+	if elapsed.Seconds() > 0 {
+		rate = float64(exported) / elapsed.Seconds()
+	}
+	fmt.Fprintf(os.Stderr, "\nExport complete in %s\n", elapsed.Round(time.Millisecond))
+	fmt.Fprintf(os.Stderr, "  Exported:    %d\n", exported)
+	fmt.Fprintf(os.Stderr, "  Compression: %s\n", compression)
+	fmt.Fprintf(os.Stderr, "  Throughput:  %.0f events/sec\n", rate)
+	return nil
+}
+
+// exportTo does the work of ExportEvents against an open database: one
+// JSON event per line to w, through the compression codec. w isn't
+// closed.
+func exportTo(db *nostrdb.NDB, w io.Writer, filter nostr.Filter, compression string, progress bool) (int, error) {
+	cw, err := newCompressWriter(w, compression)
+	if err != nil {
+		return 0, err
+	}
+	bw := bufio.NewWriterSize(cw, 256*1024)
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+
+	lastRender := time.Now()
+	written := 0
+	write := func(evt nostr.Event) error {
+		if err := enc.Encode(evt); err != nil {
+			return fmt.Errorf("failed to write event %s: %w", evt.ID, err)
+		}
+		written++
+		if progress && time.Since(lastRender) >= 100*time.Millisecond {
+			fmt.Fprintf(os.Stderr, "\rExported %d events (at created_at %d)   ", written, evt.CreatedAt)
+			lastRender = time.Now()
+		}
+		return nil
+	}
+	// Deletion requests go along, so an --import of the file deletes
+	// there what was deleted here. Same transaction, so both walks see
+	// one snapshot.
+	filters := []nostr.Filter{filter}
+	if df, ok := deletionFilter(filter); ok {
+		filters = append(filters, df)
+	}
+	exported, err := db.ExportFilters(filters, write)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if closeErr := cw.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to finish %s stream: %w", compression, closeErr)
+	}
+	return exported, err
+}
+
+// compressionForName picks the codec a file name implies.
+func compressionForName(name string) string {
+	switch {
+	case strings.HasSuffix(name, ".gz"):
+		return compressGzip
+	case strings.HasSuffix(name, ".zst"):
+		return compressZstd
+	default:
+		return compressNone
+	}
+}
+
+// newCompressWriter wraps w in the named codec. Closing the result
+// flushes the codec but leaves w open.
+func newCompressWriter(w io.Writer, compression string) (io.WriteCloser, error) {
+	switch compression {
+	case compressNone, "":
+		return nopWriteCloser{w}, nil
+	case compressGzip:
+		return gzip.NewWriter(w), nil
+	case compressZstd:
+		return newZstdCmd(w, nil, "-q", "-c")
+	default:
+		return nil, fmt.Errorf("unknown compression %q (want gzip, zstd or none)", compression)
+	}
+}
+
+// openEventFile opens a JSONL event file for reading, decompressing
+// .gz and .zst by extension.
+func openEventFile(filename string) (io.ReadCloser, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	switch compressionForName(filename) {
+	case compressGzip:
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to read gzip header: %w", err)
+		}
+		return readCloser{Reader: zr, close: func() error { zr.Close(); return f.Close() }}, nil
+	case compressZstd:
+		pr, pw := io.Pipe()
+		cmd, err := newZstdCmd(pw, f, "-q", "-d", "-c")
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		go func() {
+			pw.CloseWithError(cmd.Close())
+		}()
+		return readCloser{Reader: pr, close: func() error { pr.Close(); return f.Close() }}, nil
+	default:
+		return f, nil
+	}
+}
+
+// zstdCmd runs the zstd binary as a filter. Writes go to its stdin
+// (or stdin is the given reader); its stdout goes to out.
+type zstdCmd struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+}
+
+func newZstdCmd(out io.Writer, in io.Reader, args ...string) (*zstdCmd, error) {
+	path, err := exec.LookPath("zstd")
+	if err != nil {
+		return nil, fmt.Errorf("zstd compression needs the zstd binary on PATH: %w", err)
+	}
+	z := &zstdCmd{cmd: exec.Command(path, args...)}
+	z.cmd.Stdout = out
+	z.cmd.Stderr = os.Stderr
+	if in != nil {
+		z.cmd.Stdin = in
+	} else if z.stdin, err = z.cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	if err := z.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start zstd: %w", err)
+	}
+	return z, nil
+}
+
+func (z *zstdCmd) Write(p []byte) (int, error) { return z.stdin.Write(p) }
+
+// Close ends zstd's input and waits for it to finish writing.
+func (z *zstdCmd) Close() error {
+	if z.stdin != nil {
+		z.stdin.Close()
+	}
+	return z.cmd.Wait()
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r readCloser) Close() error { return r.close() }
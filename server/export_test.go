@@ -0,0 +1,272 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/0ceanslim/grain/server/db/nostrdb"
+	"github.com/0ceanslim/grain/server/tombstone"
+	nostr "github.com/0ceanslim/grain/server/types"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+)
+
+// exportIDs lists what db.Export yields for filter, in order.
+func exportIDs(t *testing.T, db *nostrdb.NDB, filter nostr.Filter) []string {
+	t.Helper()
+	var ids []string
+	if _, err := db.Export(filter, func(evt nostr.Event) error {
+		ids = append(ids, evt.ID)
+		return nil
+	}); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	return ids
+}
+
+// TestExportImport_RoundTrip dumps one nostrdb with exportTo and loads
+// the dump into an empty one with importFrom, for every codec.
+func TestExportImport_RoundTrip(t *testing.T) {
+	src, err := nostrdb.Open(t.TempDir(), 32, 1)
+	if err != nil {
+		t.Fatalf("open source: %v", err)
+	}
+	t.Cleanup(src.Close)
+
+	alice, _ := btcec.NewPrivateKey()
+	bob, _ := btcec.NewPrivateKey()
+	ctx := context.Background()
+	base := time.Now().Unix() - 3600
+
+	// 90 events, three to a second, split between two authors.
+	var all []string
+	var aliceIDs []string
+	for i := 0; i < 90; i++ {
+		priv := alice
+		if i%3 == 2 {
+			priv = bob
+		}
+		evt := syncTestEvent(t, priv, fmt.Sprintf("export %d", i), base+int64(i/3))
+		if err := src.StoreEvent(ctx, evt); err != nil {
+			t.Fatalf("store %d: %v", i, err)
+		}
+		all = append(all, evt.ID)
+		if priv == alice {
+			aliceIDs = append(aliceIDs, evt.ID)
+		}
+	}
+	waitForNotes(t, src, all)
+
+	// Nothing repeated or lost.
+	got := exportIDs(t, src, nostr.Filter{})
+	if len(got) != len(all) {
+		t.Fatalf("exported %d events, want %d", len(got), len(all))
+	}
+	sorted := append([]string(nil), got...)
+	want := append([]string(nil), all...)
+	sort.Strings(sorted)
+	sort.Strings(want)
+	if !reflect.DeepEqual(sorted, want) {
+		t.Fatal("export lost or repeated events")
+	}
+
+	codecs := []string{compressNone, compressGzip}
+	if _, err := exec.LookPath("zstd"); err == nil {
+		codecs = append(codecs, compressZstd)
+	}
+	for _, codec := range codecs {
+		t.Run(codec, func(t *testing.T) {
+			var buf bytes.Buffer
+			n, err := exportTo(src, &buf, nostr.Filter{}, codec, false)
+			if err != nil || n != len(all) {
+				t.Fatalf("exportTo: %d events, %v", n, err)
+			}
+
+			r := bytes.NewReader(buf.Bytes())
+			var lines []byte
+			switch codec {
+			case compressGzip:
+				zr, err := gzip.NewReader(r)
+				if err != nil {
+					t.Fatalf("not gzip: %v", err)
+				}
+				if lines, err = io.ReadAll(zr); err != nil {
+					t.Fatalf("gunzip: %v", err)
+				}
+			case compressZstd:
+				cmd := exec.Command("zstd", "-q", "-d", "-c")
+				cmd.Stdin = r
+				if lines, err = cmd.Output(); err != nil {
+					t.Fatalf("not zstd: %v", err)
+				}
+			default:
+				lines = buf.Bytes()
+			}
+
+			dst, err := nostrdb.Open(t.TempDir(), 32, 1)
+			if err != nil {
+				t.Fatalf("open destination: %v", err)
+			}
+			t.Cleanup(dst.Close)
+			stats, err := importFrom(ctx, dst, bytes.NewReader(lines), 0, false)
+			if err != nil {
+				t.Fatalf("import: %v", err)
+			}
+			if stats.imported != len(all) || stats.skipped != 0 || stats.errors != 0 {
+				t.Fatalf("import stats %+v, want %d imported", stats, len(all))
+			}
+			waitForNotes(t, dst, all)
+			if back := exportIDs(t, dst, nostr.Filter{}); !reflect.DeepEqual(back, got) {
+				t.Fatal("re-export after import differs from the original export")
+			}
+		})
+	}
+
+	// Filters narrow the dump.
+	var aliceEvt nostr.Event
+	var buf bytes.Buffer
+	alicePub := hex.EncodeToString(schnorr.SerializePubKey(alice.PubKey()))
+	if _, err := exportTo(src, &buf, nostr.Filter{Authors: []string{alicePub}}, compressNone, false); err != nil {
+		t.Fatal(err)
+	}
+	dec := json.NewDecoder(&buf)
+	count := 0
+	for dec.More() {
+		if err := dec.Decode(&aliceEvt); err != nil {
+			t.Fatalf("decode export line: %v", err)
+		}
+		count++
+	}
+	if count != len(aliceIDs) {
+		t.Fatalf("author filter exported %d, want %d", count, len(aliceIDs))
+	}
+	since := time.Unix(base+20, 0)
+	until := time.Unix(base+24, 0)
+	if n := len(exportIDs(t, src, nostr.Filter{Since: &since, Until: &until})); n != 15 {
+		t.Fatalf("since/until exported %d, want 15", n)
+	}
+}
+
+func TestCompressionForName(t *testing.T) {
+	for name, want := range map[string]string{
+		"events.jsonl":     compressNone,
+		"events.jsonl.gz":  compressGzip,
+		"events.jsonl.zst": compressZstd,
+		"-":                compressNone,
+	} {
+		if got := compressionForName(name); got != want {
+			t.Errorf("compressionForName(%q) = %q, want %q", name, got, want)
+		}
+	}
+	if _, err := newCompressWriter(&bytes.Buffer{}, "lz4"); err == nil {
+		t.Error("unknown codec accepted")
+	}
+}
+
+// TestExportImport_Deletions checks that a kinds-filtered export still
+// carries the deletion requests covering it, and that importing them
+// removes the deleted event and keeps it out.
+func TestExportImport_Deletions(t *testing.T) {
+	store, err := tombstone.Open(filepath.Join(t.TempDir(), "tombstones.jsonl"))
+	if err != nil {
+		t.Fatalf("open tombstones: %v", err)
+	}
+	tombstone.SetStore(store)
+	t.Cleanup(func() {
+		tombstone.SetStore(nil)
+		store.Close()
+	})
+
+	src, err := nostrdb.Open(t.TempDir(), 32, 1)
+	if err != nil {
+		t.Fatalf("open source: %v", err)
+	}
+	t.Cleanup(src.Close)
+	dst, err := nostrdb.Open(t.TempDir(), 32, 1)
+	if err != nil {
+		t.Fatalf("open destination: %v", err)
+	}
+	t.Cleanup(dst.Close)
+
+	alice, _ := btcec.NewPrivateKey()
+	ctx := context.Background()
+	base := time.Now().Unix() - 3600
+
+	deleted := syncTestEvent(t, alice, "deleted", base)
+	kept := syncTestEvent(t, alice, "kept", base+1)
+	deletion := signedTestEvent(t, alice, tombstone.KindDeletion, [][]string{{"e", deleted.ID}}, "", base+2)
+
+	// The destination has an old copy of the deleted note.
+	for _, evt := range []nostr.Event{deleted, kept} {
+		if err := src.StoreEvent(ctx, evt); err != nil {
+			t.Fatalf("store: %v", err)
+		}
+	}
+	if err := dst.StoreEvent(ctx, deleted); err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	waitForNotes(t, src, []string{deleted.ID, kept.ID})
+	waitForNotes(t, dst, []string{deleted.ID})
+	if err := src.ProcessDeletion(ctx, deletion); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	waitForNotes(t, src, []string{deletion.ID})
+
+	var buf bytes.Buffer
+	n, err := exportTo(src, &buf, nostr.Filter{Kinds: []int{1}}, compressNone, false)
+	if err != nil || n != 2 {
+		t.Fatalf("exportTo: %d events, %v; want the kept note and the deletion", n, err)
+	}
+
+	if _, err := importFrom(ctx, dst, &buf, 0, false); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	waitForNotes(t, dst, []string{kept.ID, deletion.ID})
+	if ids := exportIDs(t, dst, nostr.Filter{IDs: []string{deleted.ID}}); len(ids) != 0 {
+		t.Fatal("deleted note survived the import")
+	}
+
+	backoff := time.Millisecond
+	if storeWithRetry(ctx, dst, deleted, &backoff) {
+		t.Fatal("deleted note stored again")
+	}
+	if !tombstone.Deleted(deleted) || tombstone.Deleted(kept) {
+		t.Fatal("tombstones don't match the deletion")
+	}
+}
+
+func TestDeletionFilter(t *testing.T) {
+	since := time.Unix(1000, 0)
+	for name, c := range map[string]struct {
+		filter nostr.Filter
+		want   bool
+	}{
+		"everything":      {nostr.Filter{}, false},
+		"authors":         {nostr.Filter{Authors: []string{"a"}, Since: &since}, false},
+		"kinds":           {nostr.Filter{Kinds: []int{1}}, true},
+		"kinds with five": {nostr.Filter{Kinds: []int{1, 5}}, false},
+		"ids":             {nostr.Filter{IDs: []string{"x"}}, true},
+		"until":           {nostr.Filter{Until: &since}, true},
+	} {
+		got, ok := deletionFilter(c.filter)
+		if ok != c.want {
+			t.Errorf("%s: ok = %v, want %v", name, ok, c.want)
+			continue
+		}
+		if ok && (!reflect.DeepEqual(got.Kinds, []int{tombstone.KindDeletion}) ||
+			!reflect.DeepEqual(got.Authors, c.filter.Authors) || got.Since != c.filter.Since) {
+			t.Errorf("%s: deletion filter %+v", name, got)
+		}
+	}
+}
@@ -0,0 +1,560 @@
+// Package fulltext is grain's own NIP-50 index, for the kinds
+// nostrdb's fulltext index leaves out: it only covers the content of
+// kinds 1 and 30023. The kinds an operator lists in search.indexed_kinds are
+// tokenized here instead, kind 0 by its name, display_name and about,
+// and searched alongside nostrdb's results.
+//
+// The index is appended to search_index.jsonl in the data directory,
+// one line per indexed or removed event, under a first line naming
+// the kinds it was built for, and held in memory. Like tombstones, it
+// isn't rewritten on every change; Open compacts it when superseded
+// lines pile up. Kinds configured since the file was built are only
+// indexed from then on, until `grain --reindex-search` rebuilds it.
+package fulltext
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/0ceanslim/grain/config"
+	nostr "github.com/0ceanslim/grain/server/types"
+	"github.com/0ceanslim/grain/server/utils/log"
+)
+
+// compactMinLines is how long the file has to get before Open bothers
+// compacting it.
+const compactMinLines = 1024
+
+// settle is how long an event Add indexed may not be readable yet:
+// nostrdb stores on its writer thread. A Hit missing from the database
+// for longer is gone.
+const settle = time.Minute
+
+// Words shorter or longer than these, in runes, aren't indexed.
+const (
+	minTokenRunes = 2
+	maxTokenRunes = 40
+)
+
+// Native reports whether nostrdb indexes kind's content itself.
+func Native(kind int) bool {
+	return kind == 1 || kind == 30023
+}
+
+// Own is kinds without the ones nostrdb indexes itself, sorted and
+// without repeats: the kinds an index opened with them indexes.
+func Own(kinds []int) []int {
+	var out []int
+	for _, k := range kinds {
+		if !Native(k) && !slices.Contains(out, k) {
+			out = append(out, k)
+		}
+	}
+	sort.Ints(out)
+	return out
+}
+
+// Tokens splits text into its distinct words, lower case: runs of
+// letters and digits between minTokenRunes and maxTokenRunes long.
+func Tokens(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	var out []string
+	seen := make(map[string]bool, len(words))
+	for _, w := range words {
+		if n := utf8.RuneCountInString(w); n < minTokenRunes || n > maxTokenRunes || seen[w] {
+			continue
+		}
+		seen[w] = true
+		out = append(out, w)
+	}
+	return out
+}
+
+// Text is what of evt is searchable: a kind 0 profile's name,
+// display_name and about, or any other event's content with its title
+// and summary tags.
+func Text(evt nostr.Event) string {
+	if evt.Kind == 0 {
+		var profile struct {
+			Name        string `json:"name"`
+			DisplayName string `json:"display_name"`
+			About       string `json:"about"`
+		}
+		if json.Unmarshal([]byte(evt.Content), &profile) != nil {
+			return ""
+		}
+		return profile.Name + " " + profile.DisplayName + " " + profile.About
+	}
+	text := evt.Content
+	for _, tag := range evt.Tags {
+		if len(tag) >= 2 && (tag[0] == "title" || tag[0] == "summary") {
+			text += " " + tag[1]
+		}
+	}
+	return text
+}
+
+// entry is one line of search_index.jsonl: the header, an indexed
+// event, or a removal.
+type entry struct {
+	IndexedKinds []int    `json:"indexed_kinds,omitempty"` // header only
+	ID           string   `json:"id,omitempty"`
+	Kind         int      `json:"kind,omitempty"`
+	Pubkey       string   `json:"pubkey,omitempty"`
+	CreatedAt    int64    `json:"created_at,omitempty"`
+	Addr         string   `json:"addr,omitempty"` // replaceable and addressable kinds
+	Tokens       []string `json:"tokens,omitempty"`
+	Removed      bool     `json:"removed,omitempty"`
+}
+
+type doc struct {
+	kind      int
+	pubkey    string
+	createdAt int64
+	addr      string
+	tokens    []string
+	added     time.Time // by Add; zero when loaded
+}
+
+// Index holds the indexed events.
+type Index struct {
+	mu       sync.RWMutex
+	path     string
+	file     *os.File
+	kinds    []int // configured
+	built    []int // the file's header
+	docs     map[string]*doc
+	addrs    map[string]string // address to the id indexed for it
+	postings map[string]map[string]struct{}
+	fresh    bool
+}
+
+// Open loads path (search_index.jsonl) for kinds and keeps it open for
+// appending. A missing file is created; Fresh reports that, so the
+// caller can fill it from the events already stored. kinds nostrdb
+// indexes itself are left out.
+func Open(path string, kinds []int) (*Index, error) {
+	x := &Index{path: path, kinds: Own(kinds)}
+	x.clear()
+
+	lines, bad, torn := 0, 0, false
+	f, err := os.Open(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		x.fresh = true
+	case err != nil:
+		return nil, fmt.Errorf("read search index: %w", err)
+	default:
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+		for scanner.Scan() {
+			if len(scanner.Bytes()) == 0 {
+				continue
+			}
+			lines++
+			var e entry
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				bad++ // a line cut short by a crash
+				continue
+			}
+			if e.ID == "" {
+				x.built = e.IndexedKinds
+				continue
+			}
+			x.apply(e)
+		}
+		err := scanner.Err()
+		if st, serr := f.Stat(); err == nil && serr == nil && st.Size() > 0 {
+			last := make([]byte, 1)
+			if _, rerr := f.ReadAt(last, st.Size()-1); rerr == nil && last[0] != '\n' {
+				torn = true
+			}
+		}
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("read search index %s: %w", path, err)
+		}
+	}
+
+	switch live := x.Len(); {
+	case x.fresh:
+		x.built = x.kinds
+		if err := x.compact(); err != nil {
+			return nil, err
+		}
+	case lines > compactMinLines && lines > 2*live:
+		if err := x.compact(); err != nil {
+			return nil, err
+		}
+		log.Search().Info("Search index compacted", "lines", lines, "kept", live)
+		torn = false
+	}
+
+	x.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open search index: %w", err)
+	}
+	if torn {
+		// End the cut-short line so the next one starts fresh.
+		if _, err := x.file.WriteString("\n"); err != nil {
+			x.file.Close()
+			return nil, fmt.Errorf("repair search index: %w", err)
+		}
+	}
+	log.Search().Info("Search index loaded", "kinds", x.kinds, "events", len(x.docs), "words", len(x.postings), "unreadable", bad)
+	return x, nil
+}
+
+func (x *Index) clear() {
+	x.docs = make(map[string]*doc)
+	x.addrs = make(map[string]string)
+	x.postings = make(map[string]map[string]struct{})
+}
+
+// Fresh reports whether Open created the file.
+func (x *Index) Fresh() bool {
+	return x.fresh
+}
+
+// Kinds are the kinds x indexes.
+func (x *Index) Kinds() []int {
+	return x.kinds
+}
+
+// Missing are the kinds x indexes that the file wasn't built for:
+// their events from before they were configured aren't in it.
+func (x *Index) Missing() []int {
+	var out []int
+	for _, k := range x.kinds {
+		if !slices.Contains(x.built, k) {
+			out = append(out, k)
+		}
+	}
+	return out
+}
+
+// Len is the number of indexed events.
+func (x *Index) Len() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return len(x.docs)
+}
+
+// apply adds or removes e's event and reports whether it changed
+// anything. Of a replaceable or addressable kind, only the newest
+// version of each address is kept.
+func (x *Index) apply(e entry) bool {
+	if e.Removed {
+		d, ok := x.docs[e.ID]
+		if ok {
+			x.unindex(e.ID, d)
+		}
+		return ok
+	}
+	if _, ok := x.docs[e.ID]; ok {
+		return false
+	}
+	if e.Addr != "" {
+		if id, ok := x.addrs[e.Addr]; ok {
+			cur := x.docs[id]
+			if cur.createdAt > e.CreatedAt || (cur.createdAt == e.CreatedAt && id < e.ID) {
+				return false
+			}
+			x.unindex(id, cur)
+		}
+		x.addrs[e.Addr] = e.ID
+	}
+	x.docs[e.ID] = &doc{kind: e.Kind, pubkey: e.Pubkey, createdAt: e.CreatedAt, addr: e.Addr, tokens: e.Tokens}
+	for _, t := range e.Tokens {
+		ids := x.postings[t]
+		if ids == nil {
+			ids = make(map[string]struct{})
+			x.postings[t] = ids
+		}
+		ids[e.ID] = struct{}{}
+	}
+	return true
+}
+
+func (x *Index) unindex(id string, d *doc) {
+	delete(x.docs, id)
+	if d.addr != "" && x.addrs[d.addr] == id {
+		delete(x.addrs, d.addr)
+	}
+	for _, t := range d.tokens {
+		delete(x.postings[t], id)
+		if len(x.postings[t]) == 0 {
+			delete(x.postings, t)
+		}
+	}
+}
+
+// compact rewrites the file with its header and one line per indexed
+// event.
+func (x *Index) compact() error {
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	if err := enc.Encode(entry{IndexedKinds: x.built}); err != nil {
+		return err
+	}
+	for id, d := range x.docs {
+		if err := enc.Encode(entry{ID: id, Kind: d.kind, Pubkey: d.pubkey, CreatedAt: d.createdAt, Addr: d.addr, Tokens: d.tokens}); err != nil {
+			return err
+		}
+	}
+	return config.AtomicWriteFile(x.path, []byte(b.String()), 0644)
+}
+
+// write appends e to the file. The caller holds the lock.
+func (x *Index) write(e entry) error {
+	if x.file == nil {
+		return errors.New("search index is closed")
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := x.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("write search index: %w", err)
+	}
+	return nil
+}
+
+// Add indexes evt if its kind is one x indexes, and reports whether it
+// was new: an event already indexed, or older than the version of its
+// address that is, isn't.
+func (x *Index) Add(evt nostr.Event) (bool, error) {
+	if !slices.Contains(x.kinds, evt.Kind) {
+		return false, nil
+	}
+	e := entry{
+		ID:        evt.ID,
+		Kind:      evt.Kind,
+		Pubkey:    evt.PubKey,
+		CreatedAt: evt.CreatedAt,
+		Addr:      addrOf(evt),
+		Tokens:    Tokens(Text(evt)),
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if !x.apply(e) {
+		return false, nil
+	}
+	x.docs[e.ID].added = time.Now()
+	return true, x.write(e)
+}
+
+// Remove drops the event id from the index, if it's there.
+func (x *Index) Remove(id string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	e := entry{ID: id, Removed: true}
+	if !x.apply(e) {
+		return nil
+	}
+	return x.write(e)
+}
+
+// Reset empties the index, to be filled again from scratch for the
+// kinds it's configured with now.
+func (x *Index) Reset() error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.file != nil {
+		x.file.Close()
+		x.file = nil
+	}
+	x.clear()
+	x.built = x.kinds
+	if err := x.compact(); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(x.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("open search index: %w", err)
+	}
+	x.file = f
+	return nil
+}
+
+// Query is what Search looks for: events with every word of Terms,
+// narrowed by the rest. Zero values don't narrow.
+type Query struct {
+	Terms       string
+	Kinds       []int
+	Authors     []string
+	Since       int64
+	Until       int64
+	OldestFirst bool
+}
+
+// Hit is one event Search found. Settled is true once the event has
+// been indexed long enough that it's gone if the database hasn't got
+// it.
+type Hit struct {
+	ID        string
+	CreatedAt int64
+	Settled   bool
+}
+
+// Search finds the indexed events matching q, newest first (oldest
+// first with q.OldestFirst), ties by id. Words are matched whole; a
+// search with none long enough to be indexed finds nothing.
+func (x *Index) Search(q Query) []Hit {
+	terms := Tokens(q.Terms)
+	if len(terms) == 0 {
+		return nil
+	}
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	// Walk the rarest word's events, checking the others.
+	rarest := x.postings[terms[0]]
+	for _, t := range terms[1:] {
+		if ids := x.postings[t]; len(ids) < len(rarest) {
+			rarest = ids
+		}
+	}
+	var hits []Hit
+	for id := range rarest {
+		d := x.docs[id]
+		if (len(q.Kinds) > 0 && !slices.Contains(q.Kinds, d.kind)) ||
+			(len(q.Authors) > 0 && !slices.Contains(q.Authors, d.pubkey)) ||
+			(q.Since != 0 && d.createdAt < q.Since) ||
+			(q.Until != 0 && d.createdAt > q.Until) {
+			continue
+		}
+		all := true
+		for _, t := range terms {
+			if _, ok := x.postings[t][id]; !ok {
+				all = false
+				break
+			}
+		}
+		if all {
+			hits = append(hits, Hit{ID: id, CreatedAt: d.createdAt, Settled: time.Since(d.added) > settle})
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		a, b := hits[i], hits[j]
+		if a.CreatedAt != b.CreatedAt {
+			return (a.CreatedAt > b.CreatedAt) != q.OldestFirst
+		}
+		return a.ID < b.ID
+	})
+	return hits
+}
+
+// Close syncs and closes the file.
+func (x *Index) Close() error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.file == nil {
+		return nil
+	}
+	err := x.file.Sync()
+	if cerr := x.file.Close(); err == nil {
+		err = cerr
+	}
+	x.file = nil
+	return err
+}
+
+func isReplaceable(kind int) bool {
+	return kind == 0 || kind == 3 || (kind >= 10000 && kind < 20000)
+}
+
+func isAddressable(kind int) bool {
+	return kind >= 30000 && kind < 40000
+}
+
+// addrOf is evt's address, or "" for a kind that has none.
+func addrOf(evt nostr.Event) string {
+	switch {
+	case isReplaceable(evt.Kind):
+		return strconv.Itoa(evt.Kind) + ":" + evt.PubKey + ":"
+	case isAddressable(evt.Kind):
+		d := ""
+		for _, tag := range evt.Tags {
+			if len(tag) >= 2 && tag[0] == "d" {
+				d = tag[1]
+				break
+			}
+		}
+		return strconv.Itoa(evt.Kind) + ":" + evt.PubKey + ":" + d
+	}
+	return ""
+}
+
+var (
+	active   *Index
+	activeMu sync.RWMutex
+)
+
+// SetIndex installs the instance-wide index (nil to clear) and returns
+// the previous one.
+func SetIndex(x *Index) *Index {
+	activeMu.Lock()
+	defer activeMu.Unlock()
+	prev := active
+	active = x
+	return prev
+}
+
+func current() *Index {
+	activeMu.RLock()
+	defer activeMu.RUnlock()
+	return active
+}
+
+// Add indexes a stored event on the active index, if any. A failure
+// to write is logged: the event is stored regardless.
+func Add(evt nostr.Event) {
+	x := current()
+	if x == nil {
+		return
+	}
+	if _, err := x.Add(evt); err != nil {
+		log.Search().Error("Failed to index event", "event_id", evt.ID, "error", err)
+	}
+}
+
+// Remove drops id from the active index, if any.
+func Remove(id string) {
+	x := current()
+	if x == nil {
+		return
+	}
+	if err := x.Remove(id); err != nil {
+		log.Search().Error("Failed to remove event from search index", "event_id", id, "error", err)
+	}
+}
+
+// Kinds are the kinds the active index searches; none without one.
+func Kinds() []int {
+	if x := current(); x != nil {
+		return x.Kinds()
+	}
+	return nil
+}
+
+// Search asks the active index; with none, nothing matches.
+func Search(q Query) []Hit {
+	if x := current(); x != nil {
+		return x.Search(q)
+	}
+	return nil
+}
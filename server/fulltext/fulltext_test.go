@@ -0,0 +1,172 @@
+package fulltext
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	nostr "github.com/0ceanslim/grain/server/types"
+)
+
+var (
+	alice = strings.Repeat("a", 64)
+	bob   = strings.Repeat("b", 64)
+)
+
+func id(n int) string {
+	return strings.Repeat(string(rune('0'+n)), 64)
+}
+
+func profile(n int, pubkey string, createdAt int64, content string) nostr.Event {
+	return nostr.Event{ID: id(n), PubKey: pubkey, Kind: 0, CreatedAt: createdAt, Content: content}
+}
+
+func open(t *testing.T, path string, kinds ...int) *Index {
+	t.Helper()
+	x, err := Open(path, kinds)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { x.Close() })
+	return x
+}
+
+func ids(hits []Hit) []string {
+	var out []string
+	for _, h := range hits {
+		out = append(out, h.ID)
+	}
+	return out
+}
+
+func TestTokens(t *testing.T) {
+	got := Tokens("Bitcoin, NOSTR & bitcoin: a relay-operator's café 42")
+	want := []string{"bitcoin", "nostr", "relay", "operator", "café", "42"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Tokens = %q, want %q", got, want)
+	}
+}
+
+func TestText(t *testing.T) {
+	p := profile(1, alice, 1, `{"name":"alice","display_name":"Alice A","about":"relay operator","nip05":"alice@example.com"}`)
+	if got := Tokens(Text(p)); !reflect.DeepEqual(got, []string{"alice", "relay", "operator"}) {
+		t.Errorf("kind 0 tokens = %q", got)
+	}
+	article := nostr.Event{Kind: 30024, Content: "body text", Tags: [][]string{{"d", "x"}, {"title", "Draft Title"}}}
+	if got := Tokens(Text(article)); !reflect.DeepEqual(got, []string{"body", "text", "draft", "title"}) {
+		t.Errorf("kind 30024 tokens = %q", got)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	x := open(t, filepath.Join(t.TempDir(), "search_index.jsonl"), 0, 1, 30024)
+	if !reflect.DeepEqual(x.Kinds(), []int{0, 30024}) {
+		t.Fatalf("Kinds = %v, kind 1 is nostrdb's", x.Kinds())
+	}
+	x.Add(profile(1, alice, 100, `{"name":"alice","about":"nostr relay operator"}`))
+	x.Add(profile(2, bob, 200, `{"name":"bob","about":"relay hopper"}`))
+	x.Add(nostr.Event{ID: id(3), PubKey: bob, Kind: 1, CreatedAt: 300, Content: "relay"})
+	x.Add(nostr.Event{ID: id(4), PubKey: bob, Kind: 30024, CreatedAt: 50, Content: "relay notes", Tags: [][]string{{"d", "n"}}})
+
+	cases := []struct {
+		q    Query
+		want []string
+	}{
+		{Query{Terms: "Relay"}, []string{id(2), id(1), id(4)}},
+		{Query{Terms: "relay", OldestFirst: true}, []string{id(4), id(1), id(2)}},
+		{Query{Terms: "relay operator"}, []string{id(1)}},
+		{Query{Terms: "relay", Kinds: []int{0}}, []string{id(2), id(1)}},
+		{Query{Terms: "relay", Authors: []string{bob}}, []string{id(2), id(4)}},
+		{Query{Terms: "relay", Since: 60, Until: 150}, []string{id(1)}},
+		{Query{Terms: "rel"}, nil},
+		{Query{Terms: "a"}, nil},
+	}
+	for _, c := range cases {
+		if got := ids(x.Search(c.q)); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Search(%+v) = %v, want %v", c.q, got, c.want)
+		}
+	}
+}
+
+func TestReplaceableKeepsNewest(t *testing.T) {
+	x := open(t, filepath.Join(t.TempDir(), "search_index.jsonl"), 0)
+	x.Add(profile(1, alice, 100, `{"about":"cats"}`))
+	x.Add(profile(2, alice, 200, `{"about":"dogs"}`))
+	if added, _ := x.Add(profile(3, alice, 150, `{"about":"birds"}`)); added {
+		t.Error("an older profile replaced the newer one")
+	}
+	if got := ids(x.Search(Query{Terms: "cats"})); got != nil {
+		t.Errorf("replaced profile still found: %v", got)
+	}
+	if got := ids(x.Search(Query{Terms: "dogs"})); !reflect.DeepEqual(got, []string{id(2)}) {
+		t.Errorf("dogs = %v", got)
+	}
+	if x.Len() != 1 {
+		t.Errorf("Len = %d, want 1", x.Len())
+	}
+}
+
+func TestReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "search_index.jsonl")
+	x, err := Open(path, []int{0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !x.Fresh() || x.Missing() != nil {
+		t.Fatalf("new index: fresh %v, missing %v", x.Fresh(), x.Missing())
+	}
+	x.Add(profile(1, alice, 100, `{"about":"cats"}`))
+	x.Add(profile(2, bob, 100, `{"about":"cats"}`))
+	x.Remove(id(2))
+	x.Close()
+
+	// A torn last line is skipped and repaired.
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	f.WriteString(`{"id":"`)
+	f.Close()
+
+	x = open(t, path, 0, 30024)
+	if x.Fresh() {
+		t.Error("reopened index is fresh")
+	}
+	if got := ids(x.Search(Query{Terms: "cats"})); !reflect.DeepEqual(got, []string{id(1)}) {
+		t.Errorf("after reopen: %v", got)
+	}
+	if !reflect.DeepEqual(x.Missing(), []int{30024}) {
+		t.Errorf("Missing = %v, want [30024]", x.Missing())
+	}
+	x.Add(profile(3, bob, 300, `{"about":"dogs"}`))
+
+	if err := x.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if x.Len() != 0 || x.Missing() != nil {
+		t.Errorf("after Reset: len %d, missing %v", x.Len(), x.Missing())
+	}
+	x.Close()
+	x = open(t, path, 0, 30024)
+	if x.Len() != 0 || x.Missing() != nil {
+		t.Errorf("reopened after Reset: len %d, missing %v", x.Len(), x.Missing())
+	}
+}
+
+func TestHitSettled(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "search_index.jsonl")
+	x, err := Open(path, []int{0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	x.Add(profile(1, alice, 100, `{"about":"cats"}`))
+	if hits := x.Search(Query{Terms: "cats"}); len(hits) != 1 || hits[0].Settled {
+		t.Fatalf("just added: %+v, want one unsettled hit", hits)
+	}
+	x.Close()
+
+	// Anything in the file was added by an earlier run.
+	x = open(t, path, 0)
+	if hits := x.Search(Query{Terms: "cats"}); len(hits) != 1 || !hits[0].Settled {
+		t.Errorf("reloaded: %+v, want one settled hit", hits)
+	}
+}
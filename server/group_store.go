@@ -0,0 +1,44 @@
+package server
+
+import (
+	"context"
+
+	"github.com/0ceanslim/grain/server/db/nostrdb"
+	"github.com/0ceanslim/grain/server/fulltext"
+	"github.com/0ceanslim/grain/server/groups"
+	nostr "github.com/0ceanslim/grain/server/types"
+)
+
+// groupStore backs groups.Store with nostrdb and the broadcast path.
+type groupStore struct {
+	db          *nostrdb.NDB
+	relayPubkey string
+}
+
+// Publish stores a relay-signed group state event and pushes it to
+// subscribers, the same way HandleEvent does for client events.
+func (s groupStore) Publish(evt nostr.Event) error {
+	if err := s.db.StoreEvent(context.TODO(), evt); err != nil {
+		return err
+	}
+	fulltext.Add(evt)
+	BroadcastEvent(evt)
+	return nil
+}
+
+func (s groupStore) DeleteEvents(groupID string, ids []string) error {
+	_, err := s.db.DeleteTaggedByID(ids, "h", groupID)
+	return err
+}
+
+func (s groupStore) DeleteGroup(groupID string) error {
+	if _, err := s.db.DeleteMatching(nostr.Filter{Tags: map[string][]string{"h": {groupID}}}); err != nil {
+		return err
+	}
+	_, err := s.db.DeleteMatching(nostr.Filter{
+		Authors: []string{s.relayPubkey},
+		Kinds:   []int{groups.KindGroupMetadata, groups.KindGroupAdmins, groups.KindGroupMembers, groups.KindGroupRoles},
+		Tags:    map[string][]string{"d": {groupID}},
+	})
+	return err
+}
@@ -0,0 +1,255 @@
+package groups
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	nostr "github.com/0ceanslim/grain/server/types"
+	"github.com/0ceanslim/grain/server/utils/log"
+)
+
+// validGroupID is NIP-29's group id alphabet.
+var validGroupID = regexp.MustCompile(`^[a-z0-9_-]{1,64}$`)
+
+var validPubkey = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+const errNoPermission = "restricted: you don't have permission to do that in this group"
+
+// Admit decides whether evt may be stored. It returns "" to accept or
+// a NIP-01 OK reason to reject. Events without an h tag that aren't
+// group kinds pass untouched.
+func (m *Manager) Admit(evt nostr.Event) string {
+	if isStateKind(evt.Kind) {
+		return "blocked: group state events are published by the relay"
+	}
+	id := groupID(evt)
+	if id == "" {
+		if isGroupKind(evt.Kind) {
+			return "invalid: group events need an h tag"
+		}
+		return ""
+	}
+	for _, h := range tagValues(evt, "h") {
+		if h != id {
+			return "invalid: an event can only be posted to one group"
+		}
+	}
+
+	m.mu.RLock()
+	reason := m.check(evt, id)
+	known := reason != "" || m.knowsKind(id, evt.Kind)
+	m.mu.RUnlock()
+	if !known {
+		m.noteKind(id, evt.Kind)
+	}
+	return reason
+}
+
+// knowsKind reports whether group id's Kinds already cover kind.
+// Caller holds mu.
+func (m *Manager) knowsKind(id string, kind int) bool {
+	g := m.groups[id]
+	if g == nil || g.Kinds == nil {
+		return true
+	}
+	i := sort.SearchInts(g.Kinds, kind)
+	return i < len(g.Kinds) && g.Kinds[i] == kind
+}
+
+// noteKind adds kind to group id's Kinds. Admit calls it before the
+// event is stored, so a filter over that kind is checked by the time
+// the event can match it.
+func (m *Manager) noteKind(id string, kind int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.knowsKind(id, kind) {
+		return
+	}
+	g := m.groups[id]
+	i := sort.SearchInts(g.Kinds, kind)
+	g.Kinds = append(g.Kinds[:i], append([]int{kind}, g.Kinds[i:]...)...)
+	m.recount()
+	if err := m.save(); err != nil {
+		log.Groups().Error("Failed to save group state", "path", m.path, "error", err)
+	}
+}
+
+// check is Admit for an event tagged with group id. Caller holds mu.
+func (m *Manager) check(evt nostr.Event, id string) string {
+	g := m.groups[id]
+	if evt.Kind == KindCreateGroup {
+		switch {
+		case !validGroupID.MatchString(id):
+			return "invalid: group ids are 1-64 characters of a-z, 0-9, - and _"
+		case g != nil:
+			return "duplicate: group already exists"
+		case !m.canCreate(evt.PubKey):
+			return "restricted: you are not allowed to create groups on this relay"
+		}
+		return ""
+	}
+	if g == nil {
+		return fmt.Sprintf("invalid: group %q does not exist", id)
+	}
+
+	switch {
+	case evt.Kind == KindJoinRequest:
+		if g.isMember(evt.PubKey) {
+			return "duplicate: already a member"
+		}
+		if g.Closed && !g.Invites[tagValue(evt, "code")] {
+			return "restricted: this group is closed; ask an admin for an invite code"
+		}
+		return ""
+	case evt.Kind == KindLeaveRequest:
+		if !g.isMember(evt.PubKey) {
+			return "invalid: not a member of this group"
+		}
+		return ""
+	case isModerationKind(evt.Kind):
+		return m.checkModeration(g, evt)
+	}
+
+	if g.Restricted && !g.isMember(evt.PubKey) && !m.isSuperuser(evt.PubKey) {
+		return "restricted: only members can post to this group"
+	}
+	return ""
+}
+
+// checkModeration checks a 9000-9020 event against the author's
+// roles in g.
+func (m *Manager) checkModeration(g *Group, evt nostr.Event) string {
+	isAdmin := m.isSuperuser(evt.PubKey) || hasRole(g.Admins[evt.PubKey], RoleAdmin)
+	isMod := isAdmin || hasRole(g.Admins[evt.PubKey], RoleModerator)
+
+	switch evt.Kind {
+	case KindPutUser, KindRemoveUser:
+		targets := pTags(evt)
+		if len(targets) == 0 {
+			return "invalid: missing p tag"
+		}
+		if !isMod {
+			return errNoPermission
+		}
+		removedAdmins := 0
+		for _, tag := range targets {
+			pk, roles := tag[1], tag[2:]
+			if !validPubkey.MatchString(pk) {
+				return fmt.Sprintf("invalid: %q is not a hex pubkey", pk)
+			}
+			for _, r := range roles {
+				if !knownRole(r) {
+					return fmt.Sprintf("invalid: unknown role %q", r)
+				}
+			}
+			_, targetIsAdmin := g.Admins[pk]
+			if !isAdmin && (targetIsAdmin || len(roles) > 0) {
+				return "restricted: only group admins can assign roles or change an admin"
+			}
+			if targetIsAdmin && hasRole(g.Admins[pk], RoleAdmin) &&
+				(evt.Kind == KindRemoveUser || !hasRole(roles, RoleAdmin)) {
+				removedAdmins++
+			}
+		}
+		if removedAdmins > 0 && removedAdmins >= countAdmins(g) {
+			return "invalid: a group must keep at least one admin"
+		}
+	case KindEditMetadata, KindDeleteGroup:
+		if !isAdmin {
+			return errNoPermission
+		}
+	case KindDeleteEvent:
+		if !isMod {
+			return errNoPermission
+		}
+		if len(tagValues(evt, "e")) == 0 {
+			return "invalid: missing e tag"
+		}
+	case KindCreateInvite:
+		if !isMod {
+			return errNoPermission
+		}
+		if tagValue(evt, "code") == "" {
+			return "invalid: missing code tag"
+		}
+	default:
+		return fmt.Sprintf("invalid: unsupported group moderation kind %d", evt.Kind)
+	}
+	return ""
+}
+
+func (m *Manager) canCreate(pubkey string) bool {
+	if len(m.cfg.Creators) == 0 || m.isSuperuser(pubkey) {
+		return true
+	}
+	for _, c := range m.cfg.Creators {
+		if c == pubkey {
+			return true
+		}
+	}
+	return false
+}
+
+// isModerationKind covers the range NIP-29 reserves for moderation.
+func isModerationKind(kind int) bool { return kind >= 9000 && kind <= 9020 }
+
+// isGroupKind is every user-sent NIP-29 kind; all need an h tag.
+func isGroupKind(kind int) bool {
+	return isModerationKind(kind) || kind == KindJoinRequest || kind == KindLeaveRequest
+}
+
+func isStateKind(kind int) bool { return kind >= KindGroupMetadata && kind <= KindGroupRoles }
+
+func knownRole(r string) bool { return r == RoleAdmin || r == RoleModerator }
+
+func hasRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func countAdmins(g *Group) int {
+	n := 0
+	for _, roles := range g.Admins {
+		if hasRole(roles, RoleAdmin) {
+			n++
+		}
+	}
+	return n
+}
+
+// groupID is the event's first h tag.
+func groupID(evt nostr.Event) string { return tagValue(evt, "h") }
+
+func tagValue(evt nostr.Event, name string) string {
+	for _, tag := range evt.Tags {
+		if len(tag) >= 2 && tag[0] == name {
+			return tag[1]
+		}
+	}
+	return ""
+}
+
+func tagValues(evt nostr.Event, name string) []string {
+	var out []string
+	for _, tag := range evt.Tags {
+		if len(tag) >= 2 && tag[0] == name {
+			out = append(out, tag[1])
+		}
+	}
+	return out
+}
+
+func pTags(evt nostr.Event) [][]string {
+	var out [][]string
+	for _, tag := range evt.Tags {
+		if len(tag) >= 2 && tag[0] == "p" {
+			out = append(out, tag)
+		}
+	}
+	return out
+}
@@ -0,0 +1,230 @@
+package groups
+
+import (
+	"sort"
+	"time"
+
+	nostr "github.com/0ceanslim/grain/server/types"
+	"github.com/0ceanslim/grain/server/utils/log"
+)
+
+// Apply makes a stored group event take effect: updates the state,
+// saves groups.json and publishes the re-signed state events. Content
+// events (anything that isn't a NIP-29 kind) need nothing.
+//
+// The event is checked again first — another moderation event for the
+// same group may have been applied since Admit. If it no longer holds,
+// the event stays stored as a record but changes nothing.
+func (m *Manager) Apply(evt nostr.Event) {
+	if !isGroupKind(evt.Kind) {
+		return
+	}
+	id := groupID(evt)
+	if id == "" {
+		return
+	}
+
+	m.mu.Lock()
+	if reason := m.check(evt, id); reason != "" {
+		m.mu.Unlock()
+		log.Groups().Info("Group event no longer applies",
+			"event_id", evt.ID, "kind", evt.Kind, "group", id, "reason", reason)
+		return
+	}
+
+	g := m.groups[id]
+	var publish []int
+	var deleteIDs []string
+	deleteGroup := false
+
+	switch evt.Kind {
+	case KindCreateGroup:
+		g = &Group{
+			ID:        id,
+			Admins:    map[string][]string{evt.PubKey: {RoleAdmin}},
+			Members:   map[string]bool{evt.PubKey: true},
+			Kinds:     []int{KindCreateGroup},
+			CreatedAt: evt.CreatedAt,
+		}
+		editMetadata(g, evt)
+		m.groups[id] = g
+		publish = []int{KindGroupMetadata, KindGroupAdmins, KindGroupMembers, KindGroupRoles}
+	case KindPutUser:
+		for _, tag := range pTags(evt) {
+			pk, roles := tag[1], tag[2:]
+			if len(roles) > 0 {
+				g.Admins[pk] = append([]string(nil), roles...)
+			} else {
+				delete(g.Admins, pk)
+			}
+			g.Members[pk] = true
+		}
+		publish = []int{KindGroupAdmins, KindGroupMembers}
+	case KindRemoveUser:
+		for _, tag := range pTags(evt) {
+			delete(g.Admins, tag[1])
+			delete(g.Members, tag[1])
+		}
+		publish = []int{KindGroupAdmins, KindGroupMembers}
+	case KindEditMetadata:
+		editMetadata(g, evt)
+		publish = []int{KindGroupMetadata}
+	case KindDeleteEvent:
+		deleteIDs = tagValues(evt, "e")
+	case KindDeleteGroup:
+		delete(m.groups, id)
+		deleteGroup = true
+	case KindCreateInvite:
+		if g.Invites == nil {
+			g.Invites = make(map[string]bool)
+		}
+		g.Invites[tagValue(evt, "code")] = true
+	case KindJoinRequest:
+		g.Members[evt.PubKey] = true
+		publish = []int{KindGroupMembers}
+	case KindLeaveRequest:
+		delete(g.Admins, evt.PubKey)
+		delete(g.Members, evt.PubKey)
+		publish = []int{KindGroupAdmins, KindGroupMembers}
+	}
+	m.recount()
+
+	var events []nostr.Event
+	if len(publish) > 0 {
+		events = m.stateEvents(g, publish)
+	}
+	if evt.Kind != KindDeleteEvent {
+		if err := m.save(); err != nil {
+			log.Groups().Error("Failed to save group state", "path", m.path, "error", err)
+		}
+	}
+	m.mu.Unlock()
+
+	log.Groups().Info("Group event applied",
+		"event_id", evt.ID, "kind", evt.Kind, "group", id, "pubkey", evt.PubKey)
+
+	// Database work happens outside the lock: publishing goes through
+	// the broadcast path, which asks allowRead about every subscriber.
+	for _, e := range events {
+		if err := m.store.Publish(e); err != nil {
+			log.Groups().Error("Failed to publish group state event",
+				"group", id, "kind", e.Kind, "error", err)
+		}
+	}
+	if len(deleteIDs) > 0 {
+		if err := m.store.DeleteEvents(id, deleteIDs); err != nil {
+			log.Groups().Error("Failed to delete group events", "group", id, "error", err)
+		}
+	}
+	if deleteGroup {
+		if err := m.store.DeleteGroup(id); err != nil {
+			log.Groups().Error("Failed to delete group", "group", id, "error", err)
+		}
+	}
+}
+
+// editMetadata applies the metadata tags of a create-group or
+// edit-metadata event. Flags take either NIP-29 form: the current
+// bare tags (`private`, `closed`, ...) or their older opposites
+// (`public`, `open`, ...).
+func editMetadata(g *Group, evt nostr.Event) {
+	for _, tag := range evt.Tags {
+		if len(tag) == 0 {
+			continue
+		}
+		value := ""
+		if len(tag) >= 2 {
+			value = tag[1]
+		}
+		switch tag[0] {
+		case "name":
+			g.Name = value
+		case "picture":
+			g.Picture = value
+		case "about":
+			g.About = value
+		case "private":
+			g.Private = true
+		case "public":
+			g.Private = false
+		case "closed":
+			g.Closed = true
+		case "open":
+			g.Closed = false
+		case "restricted":
+			g.Restricted = true
+		case "unrestricted":
+			g.Restricted = false
+		case "hidden":
+			g.Hidden = true
+		case "visible":
+			g.Hidden = false
+		}
+	}
+}
+
+// stateEvents builds and signs the given state kinds for g. Caller
+// holds mu.
+func (m *Manager) stateEvents(g *Group, kinds []int) []nostr.Event {
+	createdAt := time.Now().Unix()
+	if createdAt <= g.Published {
+		createdAt = g.Published + 1
+	}
+	g.Published = createdAt
+
+	var out []nostr.Event
+	for _, kind := range kinds {
+		tags := [][]string{{"d", g.ID}}
+		switch kind {
+		case KindGroupMetadata:
+			for _, kv := range [][2]string{{"name", g.Name}, {"picture", g.Picture}, {"about", g.About}} {
+				if kv[1] != "" {
+					tags = append(tags, []string{kv[0], kv[1]})
+				}
+			}
+			tags = append(tags, flagTag(g.Private, "private", "public"), flagTag(g.Closed, "closed", "open"))
+			if g.Restricted {
+				tags = append(tags, []string{"restricted"})
+			}
+			if g.Hidden {
+				tags = append(tags, []string{"hidden"})
+			}
+		case KindGroupAdmins:
+			for _, pk := range sortedKeys(g.Admins) {
+				tags = append(tags, append([]string{"p", pk}, g.Admins[pk]...))
+			}
+		case KindGroupMembers:
+			for _, pk := range sortedKeys(g.Members) {
+				tags = append(tags, []string{"p", pk})
+			}
+		case KindGroupRoles:
+			for _, r := range roleDescriptions {
+				tags = append(tags, []string{"role", r[0], r[1]})
+			}
+		}
+
+		evt := nostr.Event{CreatedAt: createdAt, Kind: kind, Tags: tags, Content: ""}
+		if err := m.signer.SignEvent(&evt); err != nil {
+			log.Groups().Error("Failed to sign group state event", "group", g.ID, "kind", kind, "error", err)
+			continue
+		}
+		out = append(out, evt)
+	}
+	return out
+}
+
+func flagTag(on bool, yes, no string) []string {
+	if on {
+		return []string{yes}
+	}
+	return []string{no}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
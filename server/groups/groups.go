@@ -0,0 +1,335 @@
+// Package groups implements NIP-29 relay-based groups.
+//
+// A group is a set of members and admins the relay itself keeps track
+// of. Users post to a group by tagging events `["h", <group id>]`,
+// admins manage it with moderation events (kinds 9000-9020), and
+// anyone can ask to join or leave (9021 / 9022). After every change
+// the relay publishes the group's state as addressable events signed
+// with its own key (relay_key in the data directory):
+//
+//	39000 metadata  name, picture, about, private/closed/restricted/hidden
+//	39001 admins    ["p", <pubkey>, <role>...]
+//	39002 members   ["p", <pubkey>]
+//	39003 roles     ["role", <name>, <description>]
+//
+// The authoritative state is groups.json in the data directory, not
+// those events — they're a view, re-signed on every change, so a purge
+// that takes them out never loses a group.
+//
+// HandleEvent calls Admit before storing anything and Apply once the
+// event is stored. Reads go through ReadRule, which startup installs
+// in the read policy: h-tagged events of a private group only reach
+// members, and a hidden group's 39000-39003 only reach members.
+//
+// Roles are grain's own; NIP-29 leaves them to the relay:
+//
+//	admin      everything
+//	moderator  add and remove non-admin members, delete events, create invites
+//
+// A group's creator becomes its first admin. The relay owner
+// (relay_metadata.json) and the relay key are admins of every group.
+package groups
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/0ceanslim/grain/client/core"
+	cfgType "github.com/0ceanslim/grain/config/types"
+	"github.com/0ceanslim/grain/server/policy"
+	nostr "github.com/0ceanslim/grain/server/types"
+	"github.com/0ceanslim/grain/server/utils"
+	"github.com/0ceanslim/grain/server/utils/log"
+)
+
+// NIP-29 event kinds.
+const (
+	KindPutUser      = 9000
+	KindRemoveUser   = 9001
+	KindEditMetadata = 9002
+	KindDeleteEvent  = 9005
+	KindCreateGroup  = 9007
+	KindDeleteGroup  = 9008
+	KindCreateInvite = 9009
+	KindJoinRequest  = 9021
+	KindLeaveRequest = 9022
+
+	KindGroupMetadata = 39000
+	KindGroupAdmins   = 39001
+	KindGroupMembers  = 39002
+	KindGroupRoles    = 39003
+)
+
+// Roles a group admin can hand out with put-user.
+const (
+	RoleAdmin     = "admin"
+	RoleModerator = "moderator"
+)
+
+var roleDescriptions = [][2]string{
+	{RoleAdmin, "Full control of the group"},
+	{RoleModerator, "Can add and remove members, delete events and create invites"},
+}
+
+// relayOwner is the relay_metadata.json owner; a var so tests can set it.
+var relayOwner = utils.GetRelayOwnerPubkey
+
+// Group is one group's state as kept in groups.json.
+type Group struct {
+	ID         string              `json:"id"`
+	Name       string              `json:"name,omitempty"`
+	Picture    string              `json:"picture,omitempty"`
+	About      string              `json:"about,omitempty"`
+	Private    bool                `json:"private,omitempty"`    // only members can read
+	Closed     bool                `json:"closed,omitempty"`     // joining needs an invite code
+	Restricted bool                `json:"restricted,omitempty"` // only members can write
+	Hidden     bool                `json:"hidden,omitempty"`     // only members can see 39000-39003
+	Admins     map[string][]string `json:"admins"`               // pubkey → roles
+	Members    map[string]bool     `json:"members"`
+	Invites    map[string]bool     `json:"invites,omitempty"` // codes from create-invite; reusable
+	// Kinds are the event kinds posted to the group so far, sorted,
+	// so the read rule can tell which filters can reach a private
+	// group. Nil (a groups.json from before) means any kind.
+	Kinds     []int `json:"kinds"`
+	CreatedAt int64 `json:"created_at"`
+	// Published is the created_at of the last state events the relay
+	// signed. The next ones go strictly after it so they always win
+	// the addressable-event replacement.
+	Published int64 `json:"published"`
+}
+
+func (g *Group) isMember(pubkey string) bool {
+	_, admin := g.Admins[pubkey]
+	return admin || g.Members[pubkey]
+}
+
+// Store is the part of the event database the manager needs. The
+// server package implements it on nostrdb.
+type Store interface {
+	// Publish stores a relay-signed event and delivers it to
+	// subscribers.
+	Publish(evt nostr.Event) error
+	// DeleteEvents removes the given events, but only those tagged
+	// with groupID.
+	DeleteEvents(groupID string, ids []string) error
+	// DeleteGroup removes every event tagged with groupID and the
+	// relay's state events for it.
+	DeleteGroup(groupID string) error
+}
+
+// Manager owns the state of every group.
+type Manager struct {
+	mu     sync.RWMutex
+	cfg    cfgType.GroupsConfig
+	signer *core.EventSigner
+	store  Store
+	path   string
+	groups map[string]*Group
+	// private and hidden count the groups with each flag, and
+	// privateKinds collects the Kinds of the private ones (all of
+	// them when privateAnyKind), so the read rule can tell without a
+	// scan whether a filter needs checking. See recount.
+	private        int
+	hidden         int
+	privateKinds   map[int]bool
+	privateAnyKind bool
+}
+
+// Open loads the group state from path (groups.json; missing means
+// no groups yet). signer is the relay key state events are signed
+// with.
+func Open(cfg cfgType.GroupsConfig, signer *core.EventSigner, store Store, path string) (*Manager, error) {
+	m := &Manager{
+		cfg:    cfg,
+		signer: signer,
+		store:  store,
+		path:   path,
+		groups: make(map[string]*Group),
+	}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	log.Groups().Info("Groups loaded", "groups", len(m.groups), "relay_pubkey", signer.GetPublicKey())
+	return m, nil
+}
+
+// Group returns a copy of one group's state, or nil.
+func (m *Manager) Group(id string) *Group {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	g := m.groups[id]
+	if g == nil {
+		return nil
+	}
+	cp := *g
+	return &cp
+}
+
+// isSuperuser reports whether pubkey is an admin of every group: the
+// relay owner or the relay itself.
+func (m *Manager) isSuperuser(pubkey string) bool {
+	if pubkey == "" {
+		return false
+	}
+	return pubkey == m.signer.GetPublicKey() || pubkey == relayOwner()
+}
+
+// ReadRule is the read-policy rule for group privacy. It covers every
+// kind, since anything can carry an h tag, but only judges events of a
+// private or hidden group, and only filters that can match one.
+func (m *Manager) ReadRule() policy.ReadRule {
+	return policy.ReadRule{
+		Name:        "nip29_groups",
+		Gates:       m.gatesRead,
+		GatesFilter: m.gatesFilter,
+		Allow:       m.allowRead,
+	}
+}
+
+func (m *Manager) gatesRead(evt nostr.Event) bool {
+	if !isStateKind(evt.Kind) && groupID(evt) == "" {
+		return false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.restricted(evt) != nil
+}
+
+// gatesFilter reports whether f can match an event of a private group
+// or a hidden group's state.
+func (m *Manager) gatesFilter(f nostr.Filter) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.private > 0 && m.reachesPrivate(f) {
+		return true
+	}
+	return m.hidden > 0 && reachesState(f, m.signer.GetPublicKey())
+}
+
+// reachesPrivate reports whether f can match an event posted to a
+// private group: one its #h names or, without #h, one of a kind such a
+// group has been sent. Admit keeps events to one h tag, so #h naming
+// only other groups can't. Caller holds mu.
+func (m *Manager) reachesPrivate(f nostr.Filter) bool {
+	if ids := f.Tags["h"]; len(ids) > 0 {
+		for _, id := range ids {
+			if g := m.groups[id]; g != nil && g.Private {
+				return true
+			}
+		}
+		return false
+	}
+	if m.privateAnyKind || len(f.Kinds) == 0 {
+		return true
+	}
+	for _, k := range f.Kinds {
+		if m.privateKinds[k] {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Manager) allowRead(reader string, evt nostr.Event) bool {
+	if reader != "" && reader == evt.PubKey {
+		return true
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	g := m.restricted(evt)
+	return g == nil || (reader != "" && (g.isMember(reader) || m.isSuperuser(reader)))
+}
+
+// restricted returns the group whose members alone may read evt, or
+// nil if anyone may: evt is the relay's state for a hidden group or is
+// tagged with a private one. Caller holds mu.
+func (m *Manager) restricted(evt nostr.Event) *Group {
+	if isStateKind(evt.Kind) && evt.PubKey == m.signer.GetPublicKey() {
+		if g := m.groups[tagValue(evt, "d")]; g != nil && g.Hidden {
+			return g
+		}
+		return nil
+	}
+	if g := m.groups[groupID(evt)]; g != nil && g.Private {
+		return g
+	}
+	return nil
+}
+
+// recount refreshes the private and hidden counts and the private
+// groups' kinds. Caller holds mu.
+func (m *Manager) recount() {
+	m.private, m.hidden = 0, 0
+	m.privateKinds, m.privateAnyKind = make(map[int]bool), false
+	for _, g := range m.groups {
+		if g.Private {
+			m.private++
+			m.privateAnyKind = m.privateAnyKind || g.Kinds == nil
+			for _, k := range g.Kinds {
+				m.privateKinds[k] = true
+			}
+		}
+		if g.Hidden {
+			m.hidden++
+		}
+	}
+}
+
+// reachesState reports whether f can match a 39000-39003 event signed
+// by relay.
+func reachesState(f nostr.Filter, relay string) bool {
+	kinds := len(f.Kinds) == 0
+	for _, k := range f.Kinds {
+		kinds = kinds || isStateKind(k)
+	}
+	authors := len(f.Authors) == 0
+	for _, a := range f.Authors {
+		authors = authors || strings.HasPrefix(relay, a)
+	}
+	return kinds && authors
+}
+
+var (
+	active   *Manager
+	activeMu sync.RWMutex
+)
+
+// SetManager installs the instance-wide manager (nil to clear) and
+// returns the previous one.
+func SetManager(m *Manager) *Manager {
+	activeMu.Lock()
+	defer activeMu.Unlock()
+	prev := active
+	active = m
+	return prev
+}
+
+func current() *Manager {
+	activeMu.RLock()
+	defer activeMu.RUnlock()
+	return active
+}
+
+// Admit asks the active manager about evt; with groups off everything
+// is admitted.
+func Admit(evt nostr.Event) string {
+	if m := current(); m != nil {
+		return m.Admit(evt)
+	}
+	return ""
+}
+
+// Apply hands a stored event to the active manager, if any.
+func Apply(evt nostr.Event) {
+	if m := current(); m != nil {
+		m.Apply(evt)
+	}
+}
+
+// ReadRules returns the active manager's read rule, or none.
+func ReadRules() []policy.ReadRule {
+	if m := current(); m != nil {
+		return []policy.ReadRule{m.ReadRule()}
+	}
+	return nil
+}
@@ -0,0 +1,339 @@
+package groups
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/0ceanslim/grain/client/core"
+	cfgType "github.com/0ceanslim/grain/config/types"
+	nostr "github.com/0ceanslim/grain/server/types"
+)
+
+var (
+	alice = strings.Repeat("a", 64)
+	bob   = strings.Repeat("b", 64)
+	carol = strings.Repeat("c", 64)
+	dave  = strings.Repeat("d", 64)
+)
+
+type fakeStore struct {
+	mu        sync.Mutex
+	published []nostr.Event
+	deleted   map[string][]string
+	dropped   []string
+}
+
+func (s *fakeStore) Publish(evt nostr.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.published = append(s.published, evt)
+	return nil
+}
+
+func (s *fakeStore) DeleteEvents(groupID string, ids []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.deleted == nil {
+		s.deleted = make(map[string][]string)
+	}
+	s.deleted[groupID] = append(s.deleted[groupID], ids...)
+	return nil
+}
+
+func (s *fakeStore) DeleteGroup(groupID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dropped = append(s.dropped, groupID)
+	return nil
+}
+
+// latest returns the newest published state event of kind for group.
+func (s *fakeStore) latest(kind int, group string) *nostr.Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out *nostr.Event
+	for i := range s.published {
+		e := s.published[i]
+		if e.Kind == kind && tagValue(e, "d") == group && (out == nil || e.CreatedAt > out.CreatedAt) {
+			out = &e
+		}
+	}
+	return out
+}
+
+func newTestManager(t *testing.T, cfg cfgType.GroupsConfig) (*Manager, *fakeStore, string) {
+	t.Helper()
+	prev := relayOwner
+	relayOwner = func() string { return dave }
+	t.Cleanup(func() { relayOwner = prev })
+
+	signer, err := core.NewEventSignerFromRandom()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &fakeStore{}
+	path := filepath.Join(t.TempDir(), "groups.json")
+	m, err := Open(cfg, signer, store, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, store, path
+}
+
+func groupEvent(kind int, pubkey, group string, tags ...[]string) nostr.Event {
+	return nostr.Event{
+		ID:     strings.Repeat("0", 64),
+		PubKey: pubkey,
+		Kind:   kind,
+		Tags:   append([][]string{{"h", group}}, tags...),
+	}
+}
+
+// admitAndApply is what HandleEvent does around StoreEvent.
+func admitAndApply(t *testing.T, m *Manager, evt nostr.Event) string {
+	t.Helper()
+	if reason := m.Admit(evt); reason != "" {
+		return reason
+	}
+	m.Apply(evt)
+	return ""
+}
+
+func mustAccept(t *testing.T, m *Manager, evt nostr.Event) {
+	t.Helper()
+	if reason := admitAndApply(t, m, evt); reason != "" {
+		t.Fatalf("kind %d by %s… rejected: %s", evt.Kind, evt.PubKey[:4], reason)
+	}
+}
+
+func mustReject(t *testing.T, m *Manager, evt nostr.Event, prefix string) {
+	t.Helper()
+	reason := admitAndApply(t, m, evt)
+	if !strings.HasPrefix(reason, prefix) {
+		t.Fatalf("kind %d by %s…: got %q, want prefix %q", evt.Kind, evt.PubKey[:4], reason, prefix)
+	}
+}
+
+func TestGroups_CreatePublishesSignedStateAndPersists(t *testing.T) {
+	m, store, path := newTestManager(t, cfgType.GroupsConfig{Enabled: true})
+	mustAccept(t, m, groupEvent(KindCreateGroup, alice, "pizza", []string{"name", "Pizza"}, []string{"closed"}))
+
+	for _, kind := range []int{KindGroupMetadata, KindGroupAdmins, KindGroupMembers, KindGroupRoles} {
+		e := store.latest(kind, "pizza")
+		if e == nil {
+			t.Fatalf("no kind %d published", kind)
+		}
+		if e.PubKey != m.signer.GetPublicKey() || !core.VerifyEventSignature(e) {
+			t.Fatalf("kind %d not signed by the relay key", kind)
+		}
+	}
+	meta := store.latest(KindGroupMetadata, "pizza")
+	if tagValue(*meta, "name") != "Pizza" || !hasTag(*meta, "closed") || !hasTag(*meta, "public") {
+		t.Fatalf("metadata tags = %v", meta.Tags)
+	}
+	admins := store.latest(KindGroupAdmins, "pizza")
+	if len(admins.Tags) != 2 || admins.Tags[1][1] != alice || admins.Tags[1][2] != RoleAdmin {
+		t.Fatalf("admins tags = %v", admins.Tags)
+	}
+
+	// Everything survives a reload from groups.json.
+	reopened, err := Open(cfgType.GroupsConfig{Enabled: true}, m.signer, store, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := reopened.Group("pizza")
+	if g == nil || g.Name != "Pizza" || !g.Closed || !hasRole(g.Admins[alice], RoleAdmin) {
+		t.Fatalf("reloaded group = %+v", g)
+	}
+}
+
+func TestGroups_AdmitBasics(t *testing.T) {
+	m, _, _ := newTestManager(t, cfgType.GroupsConfig{Enabled: true, Creators: []string{alice}})
+
+	mustReject(t, m, groupEvent(KindCreateGroup, bob, "pizza"), "restricted:")
+	mustAccept(t, m, groupEvent(KindCreateGroup, dave, "owners")) // relay owner is always allowed
+	mustReject(t, m, groupEvent(KindCreateGroup, alice, "Bad Id!"), "invalid:")
+	mustAccept(t, m, groupEvent(KindCreateGroup, alice, "pizza"))
+	mustReject(t, m, groupEvent(KindCreateGroup, alice, "pizza"), "duplicate:")
+
+	mustReject(t, m, groupEvent(1, bob, "nope"), "invalid:")
+	mustReject(t, m, nostr.Event{PubKey: alice, Kind: KindPutUser}, "invalid:")
+	mustReject(t, m, nostr.Event{PubKey: alice, Kind: KindGroupMetadata, Tags: [][]string{{"d", "pizza"}}}, "blocked:")
+	mustReject(t, m, groupEvent(9010, alice, "pizza"), "invalid:")
+
+	if m.Admit(nostr.Event{PubKey: bob, Kind: 1}) != "" {
+		t.Fatal("event without an h tag was not admitted")
+	}
+}
+
+func TestGroups_RolesAndMembership(t *testing.T) {
+	m, store, _ := newTestManager(t, cfgType.GroupsConfig{Enabled: true})
+	mustAccept(t, m, groupEvent(KindCreateGroup, alice, "pizza"))
+
+	mustReject(t, m, groupEvent(KindPutUser, bob, "pizza", []string{"p", carol}), "restricted:")
+	mustAccept(t, m, groupEvent(KindPutUser, alice, "pizza", []string{"p", bob, RoleModerator}))
+
+	// A moderator can add plain members but not hand out roles or
+	// touch an admin.
+	mustAccept(t, m, groupEvent(KindPutUser, bob, "pizza", []string{"p", carol}))
+	mustReject(t, m, groupEvent(KindPutUser, bob, "pizza", []string{"p", carol, RoleAdmin}), "restricted:")
+	mustReject(t, m, groupEvent(KindRemoveUser, bob, "pizza", []string{"p", alice}), "restricted:")
+	mustReject(t, m, groupEvent(KindEditMetadata, bob, "pizza", []string{"name", "x"}), "restricted:")
+	mustReject(t, m, groupEvent(KindPutUser, alice, "pizza", []string{"p", carol, "owner"}), "invalid:")
+
+	// The last admin can't be removed or demoted.
+	mustReject(t, m, groupEvent(KindRemoveUser, alice, "pizza", []string{"p", alice}), "invalid:")
+	mustReject(t, m, groupEvent(KindPutUser, alice, "pizza", []string{"p", alice}), "invalid:")
+
+	members := store.latest(KindGroupMembers, "pizza")
+	if len(members.Tags) != 4 { // d + alice, bob, carol
+		t.Fatalf("members tags = %v", members.Tags)
+	}
+
+	mustAccept(t, m, groupEvent(KindRemoveUser, bob, "pizza", []string{"p", carol}))
+	mustAccept(t, m, groupEvent(KindLeaveRequest, bob, "pizza"))
+	g := m.Group("pizza")
+	if g.isMember(bob) || g.isMember(carol) || !g.isMember(alice) {
+		t.Fatalf("members after removals = %v admins = %v", g.Members, g.Admins)
+	}
+	mustReject(t, m, groupEvent(KindLeaveRequest, bob, "pizza"), "invalid:")
+}
+
+func TestGroups_ClosedAndRestricted(t *testing.T) {
+	m, _, _ := newTestManager(t, cfgType.GroupsConfig{Enabled: true})
+	mustAccept(t, m, groupEvent(KindCreateGroup, alice, "club", []string{"closed"}, []string{"restricted"}))
+
+	mustReject(t, m, groupEvent(1, bob, "club"), "restricted:")
+	mustReject(t, m, groupEvent(KindJoinRequest, bob, "club"), "restricted:")
+	mustAccept(t, m, groupEvent(KindCreateInvite, alice, "club", []string{"code", "s3cret"}))
+	mustReject(t, m, groupEvent(KindJoinRequest, bob, "club", []string{"code", "wrong"}), "restricted:")
+	mustAccept(t, m, groupEvent(KindJoinRequest, bob, "club", []string{"code", "s3cret"}))
+	mustReject(t, m, groupEvent(KindJoinRequest, bob, "club", []string{"code", "s3cret"}), "duplicate:")
+	mustAccept(t, m, groupEvent(1, bob, "club"))
+
+	mustAccept(t, m, groupEvent(KindEditMetadata, alice, "club", []string{"open"}, []string{"unrestricted"}))
+	mustAccept(t, m, groupEvent(1, carol, "club"))
+	mustAccept(t, m, groupEvent(KindJoinRequest, carol, "club"))
+}
+
+func TestGroups_DeleteEventAndGroup(t *testing.T) {
+	m, store, _ := newTestManager(t, cfgType.GroupsConfig{Enabled: true})
+	mustAccept(t, m, groupEvent(KindCreateGroup, alice, "pizza"))
+
+	target := strings.Repeat("e", 64)
+	mustReject(t, m, groupEvent(KindDeleteEvent, bob, "pizza", []string{"e", target}), "restricted:")
+	mustAccept(t, m, groupEvent(KindDeleteEvent, alice, "pizza", []string{"e", target}))
+	if got := store.deleted["pizza"]; len(got) != 1 || got[0] != target {
+		t.Fatalf("deleted = %v", store.deleted)
+	}
+
+	mustAccept(t, m, groupEvent(KindDeleteGroup, alice, "pizza"))
+	if m.Group("pizza") != nil || len(store.dropped) != 1 {
+		t.Fatalf("group still present or not dropped: %v", store.dropped)
+	}
+	mustReject(t, m, groupEvent(1, alice, "pizza"), "invalid:")
+}
+
+func TestGroups_ReadRule(t *testing.T) {
+	m, store, _ := newTestManager(t, cfgType.GroupsConfig{Enabled: true})
+	mustAccept(t, m, groupEvent(KindCreateGroup, alice, "secret", []string{"private"}, []string{"hidden"}))
+	mustAccept(t, m, groupEvent(KindCreateGroup, alice, "lobby"))
+	allow := m.ReadRule().Allow
+
+	post := groupEvent(1, alice, "secret")
+	if allow("", post) || allow(bob, post) {
+		t.Error("private group post visible to a non-member")
+	}
+	if !allow(alice, post) || !allow(dave, post) {
+		t.Error("private group post hidden from a member or the relay owner")
+	}
+	join := groupEvent(KindJoinRequest, bob, "secret")
+	if !allow(bob, join) {
+		t.Error("author can't read their own event")
+	}
+
+	meta := *store.latest(KindGroupMetadata, "secret")
+	if allow(bob, meta) || !allow(alice, meta) {
+		t.Error("hidden group metadata not limited to members")
+	}
+	if !allow("", *store.latest(KindGroupMetadata, "lobby")) || !allow("", groupEvent(1, alice, "lobby")) {
+		t.Error("public group hidden from an anonymous reader")
+	}
+}
+
+func TestGroups_ReadRuleGates(t *testing.T) {
+	m, store, _ := newTestManager(t, cfgType.GroupsConfig{Enabled: true})
+	rule := m.ReadRule()
+	relay := m.signer.GetPublicKey()
+	anything := nostr.Filter{Kinds: []int{1}}
+	state := nostr.Filter{Kinds: []int{KindGroupMetadata}, Authors: []string{relay[:8]}}
+
+	mustAccept(t, m, groupEvent(KindCreateGroup, alice, "lobby"))
+	if rule.GatesFilter(anything) || rule.GatesFilter(state) {
+		t.Error("filters gated with only public groups around")
+	}
+	if rule.Gates(groupEvent(1, alice, "lobby")) || rule.Gates(nostr.Event{Kind: 1}) {
+		t.Error("public or untagged event gated")
+	}
+
+	mustAccept(t, m, groupEvent(KindCreateGroup, alice, "quiet", []string{"hidden"}))
+	if rule.GatesFilter(anything) || rule.GatesFilter(nostr.Filter{Kinds: []int{KindGroupMetadata}, Authors: []string{alice}}) {
+		t.Error("filter that can't match relay state gated for a hidden group")
+	}
+	if !rule.GatesFilter(state) || !rule.GatesFilter(nostr.Filter{}) {
+		t.Error("filter over relay state not gated for a hidden group")
+	}
+	if !rule.Gates(*store.latest(KindGroupMetadata, "quiet")) || rule.Gates(*store.latest(KindGroupMetadata, "lobby")) {
+		t.Error("state events gated by the wrong group")
+	}
+
+	// A private group gates filters over the kinds posted to it and
+	// filters whose #h names it; nothing else.
+	mustAccept(t, m, groupEvent(KindEditMetadata, alice, "lobby", []string{"private"}))
+	if rule.GatesFilter(anything) || !rule.Gates(groupEvent(1, alice, "lobby")) {
+		t.Error("filter over a kind the private group hasn't had gated, or its event not gated")
+	}
+	mustAccept(t, m, groupEvent(1, alice, "lobby"))
+	cases := []struct {
+		f    nostr.Filter
+		want bool
+	}{
+		{anything, true},
+		{nostr.Filter{}, true},
+		{nostr.Filter{Kinds: []int{KindEditMetadata}}, true},
+		{nostr.Filter{Kinds: []int{30023}}, false},
+		{nostr.Filter{Kinds: []int{30023}, Tags: map[string][]string{"h": {"lobby"}}}, true},
+		{nostr.Filter{Kinds: []int{1}, Tags: map[string][]string{"h": {"quiet"}}}, false},
+	}
+	for _, c := range cases {
+		if got := rule.GatesFilter(c.f); got != c.want {
+			t.Errorf("GatesFilter(%+v) = %v, want %v", c.f, got, c.want)
+		}
+	}
+	mustReject(t, m, groupEvent(1, alice, "quiet", []string{"h", "lobby"}), "invalid:")
+
+	// Kinds from a groups.json that didn't track them: anything goes.
+	m.mu.Lock()
+	m.groups["lobby"].Kinds = nil
+	m.recount()
+	m.mu.Unlock()
+	if !rule.GatesFilter(nostr.Filter{Kinds: []int{30023}}) {
+		t.Error("private group with unknown kinds didn't gate every kind")
+	}
+
+	mustAccept(t, m, groupEvent(KindDeleteGroup, alice, "lobby"))
+	if rule.GatesFilter(anything) {
+		t.Error("filters still gated after the private group was deleted")
+	}
+}
+
+func hasTag(evt nostr.Event, name string) bool {
+	for _, tag := range evt.Tags {
+		if len(tag) >= 1 && tag[0] == name {
+			return true
+		}
+	}
+	return false
+}
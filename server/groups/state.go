@@ -0,0 +1,51 @@
+package groups
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/0ceanslim/grain/config"
+)
+
+// stateFile is the groups.json layout.
+type stateFile struct {
+	Groups map[string]*Group `json:"groups"`
+}
+
+// load reads groups.json; a missing file is an empty relay.
+func (m *Manager) load() error {
+	raw, err := os.ReadFile(m.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read group state: %w", err)
+	}
+	var st stateFile
+	if err := json.Unmarshal(raw, &st); err != nil {
+		return fmt.Errorf("parse group state %s: %w", m.path, err)
+	}
+	for id, g := range st.Groups {
+		if g.Admins == nil {
+			g.Admins = make(map[string][]string)
+		}
+		if g.Members == nil {
+			g.Members = make(map[string]bool)
+		}
+		g.ID = id
+		m.groups[id] = g
+	}
+	m.recount()
+	return nil
+}
+
+// save writes groups.json atomically. Caller holds mu.
+func (m *Manager) save() error {
+	out, err := json.MarshalIndent(stateFile{Groups: m.groups}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return config.AtomicWriteFile(m.path, out, 0644)
+}
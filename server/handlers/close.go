@@ -54,6 +54,7 @@ func HandleClose(client nostr.ClientInterface, message []interface{}) {
 
 	// Remove the subscription
 	client.DeleteSubscription(subID)
+	cancelQuery(client, subID)
 	log.Close().Info("Subscription closed by client request",
 		"subscription_id", subID,
 		"remaining_subscriptions", client.SubscriptionCount(),
@@ -1,9 +1,12 @@
 package handlers
 
 import (
+	"time"
+
 	"github.com/0ceanslim/grain/config"
 	"github.com/0ceanslim/grain/server/db/nostrdb"
 	"github.com/0ceanslim/grain/server/handlers/response"
+	"github.com/0ceanslim/grain/server/metrics"
 	nostr "github.com/0ceanslim/grain/server/types"
 	"github.com/0ceanslim/grain/server/utils"
 	"github.com/0ceanslim/grain/server/utils/log"
@@ -11,14 +14,18 @@ import (
 
 // HandleCount processes a NIP-45 "COUNT" message. The wire format
 // mirrors REQ — `["COUNT", <sub_id>, <filter1>, ...]` — and the
-// response is `["COUNT", <sub_id>, {"count": N}]` (with an optional
-// `approximate: true` field).
+// response is `["COUNT", <sub_id>, {"count": N}]`, with `approximate:
+// true` when the count may be off and a hex `hll` for filters NIP-45
+// defines a HyperLogLog for. Events matching several filters count
+// once.
 //
 // Filter parsing intentionally duplicates the REQ-side logic rather
 // than sharing it: REQ also creates a long-lived subscription; COUNT
 // is a one-shot read. The shared bits are small enough that a helper
 // would obscure more than it saves.
 func HandleCount(client nostr.ClientInterface, message []interface{}) {
+	defer metrics.CountDuration.ObserveSince(time.Now())
+
 	if len(message) < 3 {
 		log.Req().Error("Invalid COUNT message format")
 		response.SendClosed(client, "", "invalid: invalid COUNT message format")
@@ -69,11 +76,22 @@ func HandleCount(client nostr.ClientInterface, message []interface{}) {
 
 		f.Tags = make(map[string][]string)
 		for k, v := range filterData {
-			if len(k) >= 2 && k[0] == '#' {
-				tagName := k[1:]
-				if vals := utils.ToStringArray(v); len(vals) > 0 {
-					f.Tags[tagName] = vals
+			if len(k) < 2 {
+				continue
+			}
+			vals := utils.ToStringArray(v)
+			if len(vals) == 0 {
+				continue
+			}
+			switch k[0] {
+			case '#':
+				f.Tags[k[1:]] = vals
+			case '&':
+				// NIP-119: every value is required.
+				if f.AndTags == nil {
+					f.AndTags = make(map[string][]string)
 				}
+				f.AndTags[k[1:]] = vals
 			}
 		}
 		// Filter `limit` is intentionally ignored for COUNT — NIP-45
@@ -89,18 +107,20 @@ func HandleCount(client nostr.ClientInterface, message []interface{}) {
 		return
 	}
 
-	count, approximate, err := db.CountFiltered(filters)
+	// Count only what this connection could REQ.
+	res, err := db.CountFiltered(filters, readPolicyFilter(client, filters))
 	if err != nil {
 		log.Req().Error("COUNT query failed", "sub_id", subID, "error", err)
 		response.SendClosed(client, subID, "error: could not count events")
 		return
 	}
 
-	response.SendCount(client, subID, count, approximate)
+	response.SendCount(client, subID, res.Count, res.Approximate, res.HLL)
 
 	log.Req().Info("COUNT served",
 		"sub_id", subID,
 		"filter_count", len(filters),
-		"count", count,
-		"approximate", approximate)
+		"count", res.Count,
+		"approximate", res.Approximate,
+		"hll", res.HLL != nil)
 }
@@ -9,7 +9,16 @@ import (
 
 	"github.com/0ceanslim/grain/config"
 	"github.com/0ceanslim/grain/server/db/nostrdb"
+	"github.com/0ceanslim/grain/server/fulltext"
+	"github.com/0ceanslim/grain/server/groups"
 	"github.com/0ceanslim/grain/server/handlers/response"
+	"github.com/0ceanslim/grain/server/metrics"
+	"github.com/0ceanslim/grain/server/moderation"
+	"github.com/0ceanslim/grain/server/policy"
+	"github.com/0ceanslim/grain/server/quota"
+	"github.com/0ceanslim/grain/server/replication"
+	"github.com/0ceanslim/grain/server/storage"
+	"github.com/0ceanslim/grain/server/tombstone"
 	nostr "github.com/0ceanslim/grain/server/types"
 	"github.com/0ceanslim/grain/server/utils"
 	"github.com/0ceanslim/grain/server/utils/log"
@@ -57,7 +66,7 @@ func HandleEvent(client nostr.ClientInterface, message []interface{}) {
 	if cfg.Auth.Required {
 		if !IsAuthenticated(client) {
 			log.Event().Info("EVENT rejected: authentication required", "event_id", evt.ID)
-			response.SendOK(client, evt.ID, false, "auth-required: authentication is required to use this relay")
+			sendEventOK(client, evt.ID, false, "auth-required: authentication is required to use this relay")
 			return
 		}
 	}
@@ -70,27 +79,27 @@ func HandleEvent(client nostr.ClientInterface, message []interface{}) {
 		if authedPubkey == "" {
 			log.Event().Info("EVENT rejected: protected event requires authentication (NIP-70)",
 				"event_id", evt.ID, "pubkey", evt.PubKey)
-			response.SendOK(client, evt.ID, false, "auth-required: this event is protected and requires authentication")
+			sendEventOK(client, evt.ID, false, "auth-required: this event is protected and requires authentication")
 			return
 		}
 		if authedPubkey != evt.PubKey {
 			log.Event().Info("EVENT rejected: protected event author mismatch (NIP-70)",
 				"event_id", evt.ID, "event_pubkey", evt.PubKey, "authed_pubkey", authedPubkey)
-			response.SendOK(client, evt.ID, false, "restricted: protected events may only be published by their author")
+			sendEventOK(client, evt.ID, false, "restricted: protected events may only be published by their author")
 			return
 		}
 	}
 
 	if cfg == nil {
 		log.Event().Error("Failed to get server configuration")
-		response.SendOK(client, evt.ID, false, "error: internal server error")
+		sendEventOK(client, evt.ID, false, "error: internal server error")
 		return
 	}
 
 	// Validate event timestamps
 	if !validation.ValidateEventTimestamp(evt, cfg) {
 		log.Event().Warn("Invalid timestamp for event", "event_id", evt.ID)
-		response.SendOK(client, evt.ID, false, "invalid: event created_at timestamp is out of allowed range")
+		sendEventOK(client, evt.ID, false, "invalid: event created_at timestamp is out of allowed range")
 		return
 	}
 
@@ -99,14 +108,35 @@ func HandleEvent(client nostr.ClientInterface, message []interface{}) {
 	// expiration tracker (see server/db/nostrdb/expiration.go).
 	if validation.IsExpired(evt, time.Now().Unix()) {
 		log.Event().Info("EVENT rejected: expired (NIP-40)", "event_id", evt.ID)
-		response.SendOK(client, evt.ID, false, "invalid: event is expired")
+		sendEventOK(client, evt.ID, false, "invalid: event is expired")
+		return
+	}
+
+	// NIP-13 proof of work. Before the signature check: counting zero
+	// bits is cheap, and an id forged to look mined fails there anyway.
+	if result := validation.CheckPow(evt, cfg); !result.Valid {
+		sendEventOK(client, evt.ID, false, result.Message)
 		return
 	}
 
 	// Signature check
 	if !validation.CheckSignature(evt) {
 		log.Event().Error("Signature verification failed", "event_id", evt.ID)
-		response.SendOK(client, evt.ID, false, "invalid: signature verification failed")
+		sendEventOK(client, evt.ID, false, "invalid: signature verification failed")
+		return
+	}
+
+	// Banned by an admin with NIP-86 banevent.
+	if moderation.IsBanned(evt.ID) {
+		log.Event().Info("EVENT rejected: banned event", "event_id", evt.ID, "pubkey", evt.PubKey)
+		sendEventOK(client, evt.ID, false, "blocked: this event is banned from the relay")
+		return
+	}
+
+	// Deleted by its author with a NIP-09 deletion request.
+	if tombstone.Deleted(evt) {
+		log.Event().Info("EVENT rejected: deleted event", "event_id", evt.ID, "pubkey", evt.PubKey)
+		sendEventOK(client, evt.ID, false, tombstone.Reason)
 		return
 	}
 
@@ -119,7 +149,7 @@ func HandleEvent(client nostr.ClientInterface, message []interface{}) {
 			"event_id", evt.ID,
 			"pubkey", evt.PubKey,
 			"reason", result.Message)
-		response.SendOK(client, evt.ID, false, result.Message)
+		sendEventOK(client, evt.ID, false, result.Message)
 		return
 	}
 
@@ -131,7 +161,7 @@ func HandleEvent(client nostr.ClientInterface, message []interface{}) {
 			"kind", evt.Kind,
 			"size", eventSize,
 			"reason", result.Message)
-		response.SendOK(client, evt.ID, false, result.Message)
+		sendEventOK(client, evt.ID, false, result.Message)
 		return
 	}
 
@@ -139,7 +169,7 @@ func HandleEvent(client nostr.ClientInterface, message []interface{}) {
 	db := nostrdb.GetDB()
 	if db == nil {
 		log.Event().Error("Database not available", "event_id", evt.ID)
-		response.SendOK(client, evt.ID, false, "error: database not available")
+		sendEventOK(client, evt.ID, false, "error: database not available")
 		return
 	}
 
@@ -149,21 +179,117 @@ func HandleEvent(client nostr.ClientInterface, message []interface{}) {
 		log.Event().Error("Error checking for duplicate event",
 			"event_id", evt.ID,
 			"error", err)
-		response.SendOK(client, evt.ID, false, "error: internal server error during duplicate check")
+		sendEventOK(client, evt.ID, false, "error: internal server error during duplicate check")
 		return
 	}
 
 	if isDuplicate {
 		log.Event().Info("Duplicate event detected", "event_id", evt.ID)
-		response.SendOK(client, evt.ID, false, "duplicate: already have this event")
+		sendEventOK(client, evt.ID, false, "duplicate: already have this event")
 		response.SendNotice(client, evt.PubKey, fmt.Sprintf("event %s was rejected because the relay already stores it", evt.ID))
 		return
 	}
 
+	// NIP-29: h-tag enforcement and group moderation permissions. A
+	// no-op unless groups are enabled.
+	if reason := groups.Admit(evt); reason != "" {
+		log.Event().Info("Event rejected by group rules",
+			"event_id", evt.ID,
+			"kind", evt.Kind,
+			"pubkey", evt.PubKey,
+			"reason", reason)
+		sendEventOK(client, evt.ID, false, reason)
+		return
+	}
+
+	// No room: nostrdb would take the event and lose it on its writer
+	// thread, so say so now.
+	if storage.Full() {
+		log.Event().Warn("Event rejected: storage full", "event_id", evt.ID, "kind", evt.Kind)
+		sendEventOK(client, evt.ID, false, storage.FullReason)
+		return
+	}
+
+	// Per-pubkey storage quota. With evict_oldest, room is made once
+	// the event is stored (quota.Stored below), so an event that
+	// doesn't make it in costs its author nothing.
+	if reason := quota.Admit(evt); reason != "" {
+		log.Event().Info("Event rejected by storage quota",
+			"event_id", evt.ID,
+			"kind", evt.Kind,
+			"pubkey", evt.PubKey)
+		sendEventOK(client, evt.ID, false, reason)
+		return
+	}
+
+	// Write-policy plugin: the operator's custom acceptance rules, run
+	// after every built-in check so the plugin only sees events the
+	// relay would store. Only the moderation hold comes later, so the
+	// queue never gets an event the plugin turned down.
+	verdict := policy.CheckWrite(evt, clientIP(client), GetAuthedPubkey(client))
+	switch verdict.Action {
+	case policy.ActionReject:
+		reason := policy.OKReason(verdict.Msg)
+		log.Event().Info("Event rejected by write policy",
+			"event_id", evt.ID,
+			"kind", evt.Kind,
+			"pubkey", evt.PubKey,
+			"reason", reason)
+		sendEventOK(client, evt.ID, false, reason)
+		return
+	case policy.ActionShadowReject:
+		// Looks accepted to the sender; nothing is stored, broadcast
+		// or replicated.
+		log.Event().Info("Event shadow-rejected by write policy",
+			"event_id", evt.ID,
+			"kind", evt.Kind,
+			"pubkey", evt.PubKey,
+			"msg", verdict.Msg)
+		metrics.Events.With("rejected", "shadow").Inc()
+		response.SendOK(client, evt.ID, true, "")
+		return
+	}
+
+	// Ban-word matches (with moderation.hold_ban_words on) and events
+	// from quarantined authors wait for an admin (NIP-86 allowevent /
+	// banevent). Last, so only events that would otherwise be stored
+	// end up in the queue.
+	holdSource, holdReason := "", ""
+	if moderation.IsQuarantined(evt) {
+		holdSource, holdReason = moderation.SourceQuarantine, "quarantined: reported"
+	} else if cfg.Moderation.HoldBanWords {
+		if word := config.MatchBanWord(evt.Content); word != "" {
+			holdSource, holdReason = moderation.SourceBanWord, "ban word: "+word
+		}
+	}
+	if holdSource != "" {
+		if err := moderation.Hold(evt, holdSource, holdReason); err != nil {
+			log.Event().Warn("Failed to hold event for moderation",
+				"event_id", evt.ID,
+				"error", err)
+			sendEventOK(client, evt.ID, false, "restricted: event needs moderation and the queue can't take it")
+			return
+		}
+		log.Event().Info("Event held for moderation",
+			"event_id", evt.ID,
+			"kind", evt.Kind,
+			"pubkey", evt.PubKey,
+			"source", holdSource)
+		sendEventOK(client, evt.ID, false, "restricted: event is held for moderator review")
+		return
+	}
+
 	// Store event in nostrdb
 	var storeErr error
 	if evt.Kind == 5 {
 		storeErr = db.ProcessDeletion(context.TODO(), evt)
+		if storeErr == nil {
+			// Tombstones only once the deletion took. A re-publish
+			// that got past tombstone.Deleted meanwhile is swept out
+			// again here, or by its own recheck below.
+			tombstone.Record(evt)
+			db.DeleteTargets(evt)
+		}
 	} else {
 		storeErr = db.StoreEvent(context.TODO(), evt)
 	}
@@ -180,7 +306,7 @@ func HandleEvent(client nostr.ClientInterface, message []interface{}) {
 				"event_id", evt.ID,
 				"kind", evt.Kind,
 				"reason", msg)
-			response.SendOK(client, evt.ID, false, msg)
+			sendEventOK(client, evt.ID, false, msg)
 			response.SendNotice(client, evt.PubKey, fmt.Sprintf("event %s was rejected: %s", evt.ID, msg))
 			return
 		}
@@ -188,11 +314,36 @@ func HandleEvent(client nostr.ClientInterface, message []interface{}) {
 			"event_id", evt.ID,
 			"kind", evt.Kind,
 			"error", storeErr)
-		response.SendOK(client, evt.ID, false, fmt.Sprintf("error: %v", storeErr))
+		// A backed-up ingest queue is what a writer stuck on a full
+		// map looks like from here.
+		if storage.Recheck() {
+			sendEventOK(client, evt.ID, false, storage.FullReason)
+			return
+		}
+		sendEventOK(client, evt.ID, false, fmt.Sprintf("error: %v", storeErr))
 		return
 	}
 
-	response.SendOK(client, evt.ID, true, "")
+	// Deleted while it was being stored: take it back out.
+	if tombstone.Deleted(evt) {
+		if err := db.DeleteByID(evt.ID); err != nil {
+			log.Event().Error("Failed to remove event deleted during store",
+				"event_id", evt.ID,
+				"error", err)
+		}
+		log.Event().Info("EVENT rejected: deleted event", "event_id", evt.ID, "pubkey", evt.PubKey)
+		sendEventOK(client, evt.ID, false, tombstone.Reason)
+		return
+	}
+
+	// Evict the author's oldest events if this took them over quota.
+	quota.Stored(evt)
+
+	// Make it searchable if nostrdb's own index doesn't cover its kind.
+	// Before the OK, so a search right after finds it.
+	fulltext.Add(evt)
+
+	sendEventOK(client, evt.ID, true, "")
 	log.Event().Info("Event stored successfully",
 		"event_id", evt.ID,
 		"kind", evt.Kind,
@@ -203,27 +354,20 @@ func HandleEvent(client nostr.ClientInterface, message []interface{}) {
 		OnEventStored(evt)
 	}
 
-	// Send to backup relay(s). Fan out in parallel goroutines —
-	// one slow upstream shouldn't block the others, and the
-	// ingestion path has already completed (this is purely
-	// best-effort forwarding).
-	if cfg.BackupRelay.Enabled {
-		for _, url := range cfg.BackupRelay.URLs {
-			url := url // capture per-iteration for the goroutine
-			go func() {
-				if err := utils.SendToBackupRelay(url, evt); err != nil {
-					log.Event().Error("Failed to send event to backup relay",
-						"event_id", evt.ID,
-						"relay_url", url,
-						"error", err)
-				} else {
-					log.Event().Info("Event sent to backup relay",
-						"event_id", evt.ID,
-						"relay_url", url)
-				}
-			}()
-		}
-	}
+	// Let a group moderation / join / leave event take effect. After
+	// the broadcast so subscribers see the request before the relay's
+	// re-signed group state.
+	groups.Apply(evt)
+
+	// Index a NIP-56 report; it may put the event it names in the
+	// moderation queue or quarantine its target.
+	moderation.Apply(evt)
+
+	// Queue for the backup relay(s). This is a local outbox append —
+	// delivery, OK tracking and retries happen in server/replication's
+	// per-target senders, so a slow or down upstream never touches
+	// the ingestion path.
+	replication.Enqueue(evt)
 
 	log.Event().Info("Event processing completed",
 		"event_id", evt.ID,
@@ -231,6 +375,22 @@ func HandleEvent(client nostr.ClientInterface, message []interface{}) {
 		"pubkey", evt.PubKey)
 }
 
+// sendEventOK answers an EVENT and counts the outcome for /metrics.
+func sendEventOK(client nostr.ClientInterface, eventID string, accepted bool, message string) {
+	metrics.RecordEventOK(accepted, message)
+	response.SendOK(client, eventID, accepted, message)
+}
+
+// clientIP is the connection's source address for the write policy,
+// or "" for clients without an HTTP request behind them (tests).
+func clientIP(client nostr.ClientInterface) string {
+	ws := client.GetWS()
+	if ws == nil || ws.Request() == nil {
+		return ""
+	}
+	return utils.GetClientIP(ws.Request())
+}
+
 // isClientFacingReject reports whether a storage error message starts with a
 // NIP-01 OK-machine-readable prefix that the client is expected to handle
 // (`blocked:`, `duplicate:`, `invalid:`). These are normal client interactions
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	nostr "github.com/0ceanslim/grain/server/types"
+)
+
+// reqQuery is a REQ still sending stored events. CLOSE, a new REQ
+// with the same subscription ID, or the connection going away cancels
+// it; it stops at its next page or event and sends no EOSE.
+type reqQuery struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	finished chan struct{}
+	// prev is the query of the same subscription ID this one replaced.
+	// Its events must all be out before this one's start.
+	prev *reqQuery
+}
+
+var (
+	queryMu  sync.Mutex
+	inflight = make(map[nostr.ClientInterface]map[string]*reqQuery)
+
+	// queriesRunning counts the queries holding a relay-wide slot;
+	// queryFreed is closed, and replaced, whenever one is given back.
+	queriesRunning int
+	queryFreed     = make(chan struct{})
+)
+
+// beginQuery registers a query for subID on client, cancelling the one
+// subID still has running. It returns false when client already has
+// perConn queries running, counting cancelled ones not yet finished.
+func beginQuery(client nostr.ClientInterface, subID string, perConn int) (*reqQuery, bool) {
+	queryMu.Lock()
+	defer queryMu.Unlock()
+	queries := inflight[client]
+	prev := queries[subID]
+	if prev == nil && len(queries) >= perConn {
+		return nil, false
+	}
+	if prev != nil {
+		prev.cancel()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &reqQuery{ctx: ctx, cancel: cancel, finished: make(chan struct{}), prev: prev}
+	if queries == nil {
+		queries = make(map[string]*reqQuery)
+		inflight[client] = queries
+	}
+	queries[subID] = q
+	return q, true
+}
+
+// end unregisters q once it has sent its last message.
+func (q *reqQuery) end(client nostr.ClientInterface, subID string) {
+	q.cancel()
+	queryMu.Lock()
+	if queries := inflight[client]; queries[subID] == q {
+		delete(queries, subID)
+		if len(queries) == 0 {
+			delete(inflight, client)
+		}
+	}
+	queryMu.Unlock()
+	close(q.finished)
+}
+
+// acquire waits for one of limit relay-wide slots, giving up when q is
+// cancelled or deadline passes.
+func (q *reqQuery) acquire(limit int, deadline time.Time) bool {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	for {
+		queryMu.Lock()
+		if queriesRunning < limit {
+			queriesRunning++
+			queryMu.Unlock()
+			return true
+		}
+		freed := queryFreed
+		queryMu.Unlock()
+
+		select {
+		case <-freed:
+		case <-q.ctx.Done():
+			return false
+		case <-timer.C:
+			return false
+		}
+	}
+}
+
+// release gives back the slot acquire took.
+func (q *reqQuery) release() {
+	queryMu.Lock()
+	queriesRunning--
+	close(queryFreed)
+	queryFreed = make(chan struct{})
+	queryMu.Unlock()
+}
+
+// cancelQuery stops subID's query on client, if it has one running.
+func cancelQuery(client nostr.ClientInterface, subID string) {
+	queryMu.Lock()
+	defer queryMu.Unlock()
+	if q := inflight[client][subID]; q != nil {
+		q.cancel()
+	}
+}
+
+// queryRunning reports whether subID has a query running on client.
+func queryRunning(client nostr.ClientInterface, subID string) bool {
+	queryMu.Lock()
+	defer queryMu.Unlock()
+	return inflight[client][subID] != nil
+}
+
+// CancelQueries stops every query a connection has running. Called
+// when the connection goes away.
+func CancelQueries(client nostr.ClientInterface) {
+	queryMu.Lock()
+	defer queryMu.Unlock()
+	for _, q := range inflight[client] {
+		q.cancel()
+	}
+	delete(inflight, client)
+}
@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"encoding/hex"
+	"errors"
+	"sync"
+
+	"github.com/0ceanslim/grain/config"
+	"github.com/0ceanslim/grain/server/db/nostrdb"
+	"github.com/0ceanslim/grain/server/handlers/response"
+	"github.com/0ceanslim/grain/server/negentropy"
+	nostr "github.com/0ceanslim/grain/server/types"
+	"github.com/0ceanslim/grain/server/utils"
+	"github.com/0ceanslim/grain/server/utils/log"
+)
+
+// NIP-77 negentropy limits. A session pins its whole (created_at, id)
+// set in memory for as long as the peer keeps it open — 40 bytes per
+// event — so both the set size and the number of concurrent sessions
+// per connection are bounded.
+const (
+	// negentropyMaxItems caps the events a single NEG-OPEN filter may
+	// match. Peers syncing more than this should split by time range.
+	negentropyMaxItems = 1_000_000
+
+	// negentropyMaxSessions caps open NEG sessions per connection.
+	negentropyMaxSessions = 8
+
+	// negentropyFrameSizeLimit bounds each outgoing message (before hex
+	// encoding, so frames on the wire are at most twice this).
+	negentropyFrameSizeLimit = 256 * 1024
+)
+
+var negMu sync.Mutex
+
+// negSessions holds the open negentropy state per connection, keyed by
+// the NEG-OPEN subscription id. NEG sessions live in their own
+// namespace: a NEG-OPEN never replaces a REQ of the same id.
+var negSessions = make(map[nostr.ClientInterface]map[string]*negentropy.Negentropy)
+
+// HandleNegOpen processes a NIP-77 "NEG-OPEN" message:
+// `["NEG-OPEN", <sub_id>, <filter>, <initial_message_hex>]`.
+//
+// The relay snapshots the (created_at, id) pairs of every event that
+// matches the filter, answers the initiator's first message, and keeps
+// the session around for subsequent NEG-MSG rounds. Re-opening an
+// existing sub_id discards the old session and starts fresh.
+func HandleNegOpen(client nostr.ClientInterface, message []interface{}) {
+	if len(message) != 4 {
+		log.Req().Error("Invalid NEG-OPEN message format")
+		response.SendNotice(client, "", "invalid: invalid NEG-OPEN message format")
+		return
+	}
+
+	subID, ok := message[1].(string)
+	if !ok || len(subID) == 0 || len(subID) > 64 {
+		log.Req().Error("Invalid NEG-OPEN subscription ID format or length",
+			"sub_id", subID, "length", len(subID))
+		response.SendNotice(client, "", "invalid: subscription ID must be between 1 and 64 characters long")
+		return
+	}
+
+	cfg := config.GetConfig()
+	if cfg.Auth.Required {
+		if !IsAuthenticated(client) {
+			log.Req().Info("NEG-OPEN rejected: authentication required", "sub_id", subID)
+			response.SendNegErr(client, subID, "auth-required: authentication is required to use this relay")
+			return
+		}
+	}
+
+	// Building the storage walks every matching event, which is at
+	// least as expensive as a REQ — share its rate limiter.
+	if allowed, msg := client.AllowReq(); !allowed {
+		log.Req().Warn("NEG-OPEN rate limit exceeded", "sub_id", subID, "reason", msg)
+		response.SendNegErr(client, subID, "rate-limited: "+msg)
+		return
+	}
+
+	filterData, ok := message[2].(map[string]interface{})
+	if !ok {
+		log.Req().Error("Invalid NEG-OPEN filter format", "sub_id", subID)
+		response.SendNegErr(client, subID, "invalid: invalid filter format")
+		return
+	}
+
+	var f nostr.Filter
+	f.IDs = utils.ToStringArray(filterData["ids"])
+	f.Authors = utils.ToStringArray(filterData["authors"])
+	f.Kinds = utils.ToIntArray(filterData["kinds"])
+	f.Since = utils.ToTime(filterData["since"])
+	f.Until = utils.ToTime(filterData["until"])
+
+	f.Tags = make(map[string][]string)
+	for k, v := range filterData {
+		if len(k) < 2 {
+			continue
+		}
+		vals := utils.ToStringArray(v)
+		if len(vals) == 0 {
+			continue
+		}
+		switch k[0] {
+		case '#':
+			f.Tags[k[1:]] = vals
+		case '&':
+			// NIP-119: every value is required.
+			if f.AndTags == nil {
+				f.AndTags = make(map[string][]string)
+			}
+			f.AndTags[k[1:]] = vals
+		}
+	}
+	// Filter `limit` is ignored, as with COUNT: reconciliation covers
+	// the full matching set.
+
+	initial, ok := decodeNegMessage(message[3])
+	if !ok {
+		log.Req().Error("Invalid NEG-OPEN initial message", "sub_id", subID)
+		response.SendNegErr(client, subID, "invalid: negentropy message must be hex")
+		return
+	}
+
+	// Drop any previous session under this id before counting against
+	// the per-connection cap, so a re-open never trips it.
+	removeNegSession(client, subID)
+	if negSessionCount(client) >= negentropyMaxSessions {
+		log.Req().Warn("NEG-OPEN rejected: too many sessions", "sub_id", subID)
+		response.SendNegErr(client, subID, "blocked: too many concurrent negentropy sessions")
+		return
+	}
+
+	db := nostrdb.GetDB()
+	if db == nil {
+		log.Req().Error("Database not available for NEG-OPEN", "sub_id", subID)
+		response.SendNegErr(client, subID, "error: database not available")
+		return
+	}
+
+	// Leave out events the read policy hides from this connection, so
+	// reconciliation can't be used to learn their ids.
+	storage, err := db.NegentropyStorage(f, negentropyMaxItems, readPolicyFilter(client, []nostr.Filter{f}))
+	if err != nil {
+		if errors.Is(err, nostrdb.ErrNegentropyTooBig) {
+			log.Req().Info("NEG-OPEN rejected: filter too broad",
+				"sub_id", subID, "max_items", negentropyMaxItems)
+			response.SendNegErr(client, subID, "blocked: this query is too big")
+			return
+		}
+		log.Req().Error("NEG-OPEN storage query failed", "sub_id", subID, "error", err)
+		response.SendNegErr(client, subID, "error: could not query events")
+		return
+	}
+
+	ne, err := negentropy.New(storage, negentropyFrameSizeLimit)
+	if err != nil {
+		log.Req().Error("Failed to create negentropy session", "sub_id", subID, "error", err)
+		response.SendNegErr(client, subID, "error: could not start negentropy session")
+		return
+	}
+
+	reply, err := ne.Reconcile(initial)
+	if err != nil {
+		log.Req().Info("NEG-OPEN reconcile failed", "sub_id", subID, "error", err)
+		response.SendNegErr(client, subID, "closed: "+err.Error())
+		return
+	}
+
+	negMu.Lock()
+	if negSessions[client] == nil {
+		negSessions[client] = make(map[string]*negentropy.Negentropy)
+	}
+	negSessions[client][subID] = ne
+	negMu.Unlock()
+
+	response.SendNegMsg(client, subID, reply)
+
+	log.Req().Info("NEG-OPEN served",
+		"sub_id", subID,
+		"items", storage.Size(),
+		"reply_bytes", len(reply))
+}
+
+// HandleNegMsg processes a follow-up "NEG-MSG" from the initiator:
+// `["NEG-MSG", <sub_id>, <message_hex>]`.
+func HandleNegMsg(client nostr.ClientInterface, message []interface{}) {
+	if len(message) != 3 {
+		log.Req().Error("Invalid NEG-MSG message format")
+		response.SendNotice(client, "", "invalid: invalid NEG-MSG message format")
+		return
+	}
+
+	subID, ok := message[1].(string)
+	if !ok || len(subID) == 0 || len(subID) > 64 {
+		response.SendNotice(client, "", "invalid: subscription ID must be between 1 and 64 characters long")
+		return
+	}
+
+	negMu.Lock()
+	ne := negSessions[client][subID]
+	negMu.Unlock()
+
+	if ne == nil {
+		log.Req().Debug("NEG-MSG for unknown session", "sub_id", subID)
+		response.SendNegErr(client, subID, "closed: unknown negentropy session")
+		return
+	}
+
+	msg, ok := decodeNegMessage(message[2])
+	if !ok {
+		removeNegSession(client, subID)
+		response.SendNegErr(client, subID, "invalid: negentropy message must be hex")
+		return
+	}
+
+	reply, err := ne.Reconcile(msg)
+	if err != nil {
+		log.Req().Info("NEG-MSG reconcile failed", "sub_id", subID, "error", err)
+		removeNegSession(client, subID)
+		response.SendNegErr(client, subID, "closed: "+err.Error())
+		return
+	}
+
+	response.SendNegMsg(client, subID, reply)
+}
+
+// HandleNegClose processes "NEG-CLOSE": `["NEG-CLOSE", <sub_id>]`. The
+// spec defines no response.
+func HandleNegClose(client nostr.ClientInterface, message []interface{}) {
+	if len(message) != 2 {
+		log.Req().Debug("Invalid NEG-CLOSE message format", "message_length", len(message))
+		return
+	}
+	subID, ok := message[1].(string)
+	if !ok {
+		return
+	}
+	removeNegSession(client, subID)
+	log.Req().Debug("Negentropy session closed", "sub_id", subID)
+}
+
+// ReleaseNegentropySessions frees every negentropy session held by a
+// connection. Called when the connection goes away — the sessions can
+// pin a lot of memory, so they must not outlive it.
+func ReleaseNegentropySessions(client nostr.ClientInterface) {
+	negMu.Lock()
+	delete(negSessions, client)
+	negMu.Unlock()
+}
+
+func removeNegSession(client nostr.ClientInterface, subID string) {
+	negMu.Lock()
+	defer negMu.Unlock()
+	if sessions := negSessions[client]; sessions != nil {
+		delete(sessions, subID)
+		if len(sessions) == 0 {
+			delete(negSessions, client)
+		}
+	}
+}
+
+func negSessionCount(client nostr.ClientInterface) int {
+	negMu.Lock()
+	defer negMu.Unlock()
+	return len(negSessions[client])
+}
+
+func decodeNegMessage(v interface{}) ([]byte, bool) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, false
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, false
+	}
+	return b, true
+}
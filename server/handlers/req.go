@@ -7,8 +7,11 @@ import (
 	"time"
 
 	"github.com/0ceanslim/grain/config"
+	cfgType "github.com/0ceanslim/grain/config/types"
 	"github.com/0ceanslim/grain/server/db/nostrdb"
 	"github.com/0ceanslim/grain/server/handlers/response"
+	"github.com/0ceanslim/grain/server/metrics"
+	"github.com/0ceanslim/grain/server/policy"
 	nostr "github.com/0ceanslim/grain/server/types"
 	"github.com/0ceanslim/grain/server/utils"
 	"github.com/0ceanslim/grain/server/utils/log"
@@ -17,6 +20,8 @@ import (
 
 // HandleReq processes a new subscription request with proper subscription management
 func HandleReq(client nostr.ClientInterface, message []interface{}) {
+	start := time.Now()
+
 	if len(message) < 3 {
 		log.Req().Error("Invalid REQ message format")
 		response.SendClosed(client, "", "invalid: invalid REQ message format")
@@ -76,14 +81,26 @@ func HandleReq(client nostr.ClientInterface, message []interface{}) {
 			f.Search = s
 		}
 
-		// NIP-01: tag filters are top-level keys like "#e", "#p", "#a".
+		// NIP-01: tag filters are top-level keys like "#e", "#p", "#a";
+		// NIP-119 adds "&t" and the like.
 		f.Tags = make(map[string][]string)
 		for k, v := range filterData {
-			if len(k) >= 2 && k[0] == '#' {
-				tagName := k[1:]
-				if vals := utils.ToStringArray(v); len(vals) > 0 {
-					f.Tags[tagName] = vals
+			if len(k) < 2 {
+				continue
+			}
+			vals := utils.ToStringArray(v)
+			if len(vals) == 0 {
+				continue
+			}
+			switch k[0] {
+			case '#':
+				f.Tags[k[1:]] = vals
+			case '&':
+				// NIP-119: every value is required.
+				if f.AndTags == nil {
+					f.AndTags = make(map[string][]string)
 				}
+				f.AndTags[k[1:]] = vals
 			}
 		}
 
@@ -97,11 +114,15 @@ func HandleReq(client nostr.ClientInterface, message []interface{}) {
 			log.Req().Debug("Duplicate subscription detected, ignoring",
 				"sub_id", subID,
 				"filter_count", len(filters))
-			// Still send EOSE for duplicate subscriptions to satisfy client expectations.
+			// Still send EOSE for duplicate subscriptions to satisfy client expectations,
+			// unless the first REQ is still sending its stored events: its own EOSE
+			// follows them, and one sent now would arrive before they're done.
 			// Use the blocking variant for symmetry with the historical-fulfillment
 			// path below — there's only one frame here, so backpressure is moot, but
 			// the error return lets us bail cleanly if the client has already gone.
-			_ = client.SendMessageBlocking([]interface{}{"EOSE", subID})
+			if !queryRunning(client, subID) {
+				_ = client.SendMessageBlocking([]interface{}{"EOSE", subID})
+			}
 			return
 		} else {
 			log.Req().Info("Subscription updated with new filters",
@@ -111,12 +132,35 @@ func HandleReq(client nostr.ClientInterface, message []interface{}) {
 		}
 	}
 
+	// Query database for historical events
+	db := nostrdb.GetDB()
+	if db == nil {
+		log.Req().Error("Database not available", "sub_id", subID)
+		response.SendClosed(client, subID, "error: database not available")
+		return
+	}
+
+	// Stored events are sent from a goroutine of their own, so a big
+	// REQ doesn't hold up the connection's other messages — including
+	// the CLOSE that would end it.
+	limits := queryLimits(cfg.Query)
+	q, ok := beginQuery(client, subID, limits.MaxConcurrentPerConnection)
+	if !ok {
+		log.Req().Warn("REQ rejected: too many queries in flight",
+			"sub_id", subID,
+			"max_concurrent_per_connection", limits.MaxConcurrentPerConnection)
+		metrics.ReqLimited.With("concurrency").Inc()
+		response.SendClosed(client, subID, "rate-limited: too many queries in flight")
+		return
+	}
+
 	// Remove oldest subscription if needed
 	subCount := client.SubscriptionCount()
 	if subCount >= config.GetConfig().Server.MaxSubscriptionsPerClient {
 		for id := range subscriptions {
 			if id != subID {
 				client.DeleteSubscription(id)
+				cancelQuery(client, id)
 				log.Req().Info("Dropped oldest subscription",
 					"old_sub_id", id,
 					"current_count", subCount-1)
@@ -132,13 +176,45 @@ func HandleReq(client nostr.ClientInterface, message []interface{}) {
 		"filter_count", len(filters),
 		"total_subscriptions", client.SubscriptionCount())
 
-	// Query database for historical events
-	db := nostrdb.GetDB()
-	if db == nil {
-		log.Req().Error("Database not available", "sub_id", subID)
-		response.SendClosed(client, subID, "error: database not available")
+	// Send stored events
+	go func() {
+		defer q.end(client, subID)
+		fulfilReq(q, client, subID, filters, db, limits, start)
+	}()
+
+	// NOTE: Subscription remains ACTIVE after EOSE
+	// It will be closed only when:
+	// 1. Client sends CLOSE message
+	// 2. Client disconnects
+	// 3. New REQ with same subID (replaces this one)
+	// 4. Subscription limit reached (oldest removed)
+	// 5. Its query budget runs out and query.on_exceeded is "closed"
+}
+
+// fulfilReq sends a REQ's stored events and its EOSE, within the
+// query budget. The subscription itself is already live.
+func fulfilReq(q *reqQuery, client nostr.ClientInterface, subID string, filters []nostr.Filter, db *nostrdb.NDB, limits cfgType.QueryConfig, start time.Time) {
+	defer metrics.ReqDuration.ObserveSince(start)
+
+	// Wait out the query this one replaced, so none of its events
+	// arrive after this one's.
+	if q.prev != nil {
+		<-q.prev.finished
+	}
+	deadline := start.Add(time.Duration(limits.MaxMillis) * time.Millisecond)
+	if !q.acquire(limits.MaxConcurrent, deadline) {
+		if q.ctx.Err() != nil {
+			return
+		}
+		log.Req().Warn("REQ rejected: relay busy",
+			"sub_id", subID,
+			"max_concurrent", limits.MaxConcurrent)
+		metrics.ReqLimited.With("busy").Inc()
+		client.DeleteSubscription(subID)
+		response.SendClosed(client, subID, "rate-limited: relay is busy, try again later")
 		return
 	}
+	defer q.release()
 
 	// Determine effective limit from config
 	effectiveLimit := 1000
@@ -147,9 +223,8 @@ func HandleReq(client nostr.ClientInterface, message []interface{}) {
 	}
 
 	// Split filters into search vs. non-search. NIP-50 search filters
-	// hit nostrdb's fulltext index via TextSearch; the rest go through
-	// the standard Query path. Concatenate results — NIP-01 multi-
-	// filter REQs are already a union with no dedupe contract.
+	// hit nostrdb's fulltext index via TextSearch after the rest are
+	// streamed.
 	var nonSearch []nostr.Filter
 	var searchFilters []nostr.Filter
 	for _, f := range filters {
@@ -160,30 +235,6 @@ func HandleReq(client nostr.ClientInterface, message []interface{}) {
 		}
 	}
 
-	var queriedEvents []nostr.Event
-	if len(nonSearch) > 0 {
-		evts, err := db.Query(nonSearch, effectiveLimit)
-		if err != nil {
-			log.Req().Error("Error querying events",
-				"sub_id", subID,
-				"error", err)
-			response.SendClosed(client, subID, "error: could not query events")
-			return
-		}
-		queriedEvents = append(queriedEvents, evts...)
-	}
-	for _, sf := range searchFilters {
-		evts, err := pagedTextSearch(db, sf, effectiveLimit)
-		if err != nil {
-			log.Req().Error("Error executing search",
-				"sub_id", subID,
-				"error", err)
-			response.SendClosed(client, subID, "error: could not run search")
-			return
-		}
-		queriedEvents = append(queriedEvents, evts...)
-	}
-
 	// Send historical events to client. Use SendMessageBlocking so the
 	// producer (this loop) stays in step with the writeLoop consumer —
 	// without backpressure, a 500-event REQ would shove all 500 events
@@ -194,14 +245,25 @@ func HandleReq(client nostr.ClientInterface, message []interface{}) {
 	// NIP-40: drop events whose expiration has passed. Defense in depth
 	// alongside the background sweeper — guarantees expired events are
 	// never served even if a sweep hasn't yet reached them.
+	//
+	// The read policy drops events this connection may not see (e.g.
+	// other people's DMs).
+	allow := readPolicyFilter(client, filters)
 	nowUnix := time.Now().Unix()
 	delivered := 0
 	skippedExpired := 0
-	aborted := false
-	for _, evt := range queriedEvents {
+	skippedPolicy := 0
+	send := func(evt nostr.Event) bool {
+		if q.ctx.Err() != nil {
+			return false
+		}
 		if validation.IsExpired(evt, nowUnix) {
 			skippedExpired++
-			continue
+			return true
+		}
+		if allow != nil && !allow(evt) {
+			skippedPolicy++
+			return true
 		}
 		if err := client.SendMessageBlocking([]interface{}{"EVENT", subID, evt}); err != nil {
 			// Client gone; skip the rest and the EOSE. The
@@ -209,16 +271,72 @@ func HandleReq(client nostr.ClientInterface, message []interface{}) {
 			// fire so we have a record of how many made it.
 			log.Req().Debug("REQ fulfillment aborted: client disconnected",
 				"sub_id", subID,
-				"delivered", delivered,
-				"total_queried", len(queriedEvents))
-			aborted = true
-			break
+				"delivered", delivered)
+			return false
 		}
 		delivered++
+		return true
+	}
+
+	budget := newQueryBudget(limits.MaxScanned, deadline)
+	aborted := false
+	if len(nonSearch) > 0 {
+		stream := newHistoryStream(nonSearch, effectiveLimit, budget, db.QueryPageRead)
+		for !aborted {
+			evt, ok, err := stream.next()
+			if err != nil {
+				log.Req().Error("Error querying events",
+					"sub_id", subID,
+					"error", err)
+				response.SendClosed(client, subID, "error: could not query events")
+				return
+			}
+			if !ok {
+				break
+			}
+			aborted = !send(evt)
+		}
 	}
-	if !aborted {
-		// Only send EOSE if we delivered the whole historical batch
-		// (expired-skipped events count as delivered for EOSE purposes).
+	for _, sf := range searchFilters {
+		if aborted || budget.exceeded != "" {
+			break
+		}
+		evts, err := pagedSearch(q.ctx, db, sf, effectiveLimit, budget)
+		if err != nil {
+			log.Req().Error("Error executing search",
+				"sub_id", subID,
+				"error", err)
+			response.SendClosed(client, subID, "error: could not run search")
+			return
+		}
+		for _, evt := range evts {
+			if aborted = !send(evt); aborted {
+				break
+			}
+		}
+	}
+	if aborted || q.ctx.Err() != nil {
+		// Closed, replaced or disconnected: no EOSE.
+		return
+	}
+
+	status := "active"
+	if budget.exceeded != "" {
+		metrics.ReqLimited.With(budget.exceeded).Inc()
+		log.Req().Info("REQ query budget exceeded",
+			"sub_id", subID,
+			"reason", budget.exceeded,
+			"scanned", budget.scanned,
+			"elapsed_ms", time.Since(start).Milliseconds())
+		if limits.OnExceeded == "closed" {
+			client.DeleteSubscription(subID)
+			response.SendClosed(client, subID, "rate-limited: query budget exceeded")
+			status = "closed"
+		}
+	}
+	if status == "active" {
+		// EOSE ends the stored events however many were sent: all of
+		// them, or as many as the budget allowed.
 		_ = client.SendMessageBlocking([]interface{}{"EOSE", subID})
 	}
 
@@ -226,14 +344,9 @@ func HandleReq(client nostr.ClientInterface, message []interface{}) {
 		"sub_id", subID,
 		"historical_events_sent", delivered,
 		"skipped_expired", skippedExpired,
-		"status", "active")
-
-	// NOTE: Subscription remains ACTIVE after EOSE
-	// It will be closed only when:
-	// 1. Client sends CLOSE message
-	// 2. Client disconnects
-	// 3. New REQ with same subID (replaces this one)
-	// 4. Subscription limit reached (oldest removed)
+		"skipped_policy", skippedPolicy,
+		"scanned", budget.scanned,
+		"status", status)
 }
 
 // areFiltersIdentical compares two filter slices to detect duplicates
@@ -249,58 +362,16 @@ func areFiltersIdentical(filters1, filters2 []nostr.Filter) bool {
 	return hash1 == hash2
 }
 
-// pagedTextSearch runs the NIP-50 search on `f` and pages through the
-// nostrdb 128-result-per-call cap until either the effective REQ limit
-// is filled, the search is exhausted, or the filter's Since bound is
-// crossed. Same Until-cursor pattern as CountFiltered and the
-// expiration bootstrap; same same-second-tie undercount caveat.
-func pagedTextSearch(db *nostrdb.NDB, f nostr.Filter, effectiveLimit int) ([]nostr.Event, error) {
-	const pageSize = 128
-	remaining := effectiveLimit
-	if f.Limit != nil && *f.Limit > 0 && *f.Limit < remaining {
-		remaining = *f.Limit
-	}
-
-	var acc []nostr.Event
-	cursor := f.Until
-	for remaining > 0 {
-		page := f
-		page.Until = cursor
-
-		want := pageSize
-		if remaining < want {
-			want = remaining
-		}
-		events, err := db.TextSearch(f.Search, page, want)
-		if err != nil {
-			return nil, err
-		}
-		if len(events) == 0 {
-			break
-		}
-		acc = append(acc, events...)
-		remaining -= len(events)
-
-		if len(events) < pageSize {
-			break
-		}
-
-		// Advance cursor strictly older than the oldest result on this
-		// page. nostrdb returns newest-first per the descending order
-		// we set in TextSearch.
-		oldestTs := events[0].CreatedAt
-		for _, e := range events {
-			if e.CreatedAt < oldestTs {
-				oldestTs = e.CreatedAt
-			}
-		}
-		next := time.Unix(oldestTs-1, 0)
-		if f.Since != nil && !next.After(*f.Since) {
-			break
-		}
-		cursor = &next
+// readPolicyFilter returns the read-policy check for client over
+// events matching filters, or nil when no filter can reach a gated
+// event — nil lets the storage layer skip per-event checks entirely.
+func readPolicyFilter(client nostr.ClientInterface, filters []nostr.Filter) func(nostr.Event) bool {
+	readPolicy := policy.ActiveReadPolicy()
+	if !readPolicy.GatesFilters(filters) {
+		return nil
 	}
-	return acc, nil
+	reader := GetAuthedPubkey(client)
+	return func(evt nostr.Event) bool { return readPolicy.Allow(reader, evt) }
 }
 
 // hashFilters creates a deterministic hash of filter contents
@@ -1,17 +1,22 @@
 package response
 
 import (
+	"encoding/hex"
+
 	nostr "github.com/0ceanslim/grain/server/types"
 )
 
 // SendCount sends a NIP-45 COUNT response to the client. When
 // approximate is true, the relay signals that the count may not be
-// exact (e.g. capped at countHardCap or the result of a multi-filter
-// request).
-func SendCount(client nostr.ClientInterface, subID string, count int, approximate bool) {
+// exact (e.g. capped at countHardCap). hll, when non-nil, is sent
+// hex-encoded so the client can merge it with other relays' counts.
+func SendCount(client nostr.ClientInterface, subID string, count int, approximate bool, hll []byte) {
 	payload := map[string]interface{}{"count": count}
 	if approximate {
 		payload["approximate"] = true
 	}
+	if hll != nil {
+		payload["hll"] = hex.EncodeToString(hll)
+	}
 	client.SendMessage([]interface{}{"COUNT", subID, payload})
 }
@@ -0,0 +1,19 @@
+package response
+
+import (
+	"encoding/hex"
+
+	nostr "github.com/0ceanslim/grain/server/types"
+)
+
+// SendNegMsg sends a NIP-77 "NEG-MSG" frame carrying a hex-encoded
+// negentropy message.
+func SendNegMsg(client nostr.ClientInterface, subID string, msg []byte) {
+	client.SendMessage([]interface{}{"NEG-MSG", subID, hex.EncodeToString(msg)})
+}
+
+// SendNegErr sends a NIP-77 "NEG-ERR" frame. The reason uses the same
+// machine-readable prefixes as CLOSED ("blocked:", "closed:", ...).
+func SendNegErr(client nostr.ClientInterface, subID string, reason string) {
+	client.SendMessage([]interface{}{"NEG-ERR", subID, reason})
+}
@@ -0,0 +1,371 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/0ceanslim/grain/server/db/nostrdb"
+	"github.com/0ceanslim/grain/server/fulltext"
+	nostr "github.com/0ceanslim/grain/server/types"
+	"github.com/0ceanslim/grain/server/utils/log"
+)
+
+// searchPageSize mirrors nostrdb's 128-result cap per text search.
+const searchPageSize = 128
+
+// pagedSearch runs the NIP-50 search of f, up to limit events (or f's
+// own limit if lower), in nostrdb's index and, for the kinds in
+// search.indexed_kinds, grain's own. Of the search's extensions,
+// language: needs a NIP-32 ISO-639-1 label on the event, domain: the
+// NIP-05 domain its author's stored profile gives (unverified), and
+// sort:old turns the order round; include:spam is accepted as is,
+// since nothing is filtered out as spam. Others are ignored. A search
+// of extensions alone matches every event they allow.
+//
+// Every event read counts against budget, kept or not, as does each
+// profile domain: looks up. The search stops short, with what it has,
+// once budget is spent or ctx is done.
+func pagedSearch(ctx context.Context, db *nostrdb.NDB, f nostr.Filter, limit int, budget *queryBudget) ([]nostr.Event, error) {
+	if f.Limit != nil && *f.Limit > 0 && *f.Limit < limit {
+		limit = *f.Limit
+	}
+	q := nostr.ParseSearch(f.Search)
+	if len(q.Ignored) > 0 {
+		log.Req().Debug("Ignoring unsupported search extensions", "extensions", q.Ignored)
+	}
+
+	base := f
+	base.Search = ""
+	if q.Language != "" {
+		// The label is a tag, so the index narrows by it too.
+		base.AndTags = withTagValue(base.AndTags, "l", q.Language)
+	}
+	domains := authorDomains{db: db, domain: q.Domain, budget: budget}
+	keep := func(e nostr.Event) bool {
+		return base.MatchesAndTags(e) &&
+			(q.Language == "" || nostr.HasLanguage(e, q.Language)) &&
+			domains.match(e.PubKey)
+	}
+
+	if q.Terms == "" {
+		return pagedFilter(ctx, db, base, limit, budget, keep)
+	}
+
+	// Kinds in search.indexed_kinds are searched in grain's own index,
+	// the rest in nostrdb's; what both find is merged.
+	nativeKinds, searchNative, own := splitSearchKinds(base.Kinds, fulltext.Kinds())
+	var events []nostr.Event
+	if searchNative {
+		native := base
+		native.Kinds = nativeKinds
+		var err error
+		if events, err = pagedTextSearch(ctx, db, q, native, limit, budget, keep); err != nil {
+			return nil, err
+		}
+	}
+	if len(own) == 0 {
+		return events, nil
+	}
+	indexed, err := pagedIndexSearch(ctx, db, q, base, own, limit, budget, keep)
+	if err != nil {
+		return nil, err
+	}
+	return mergeSearch(events, indexed, q.Oldest, limit), nil
+}
+
+// readable is how many of n more events a search may read: 0 once
+// ctx is done or budget is spent.
+func readable(ctx context.Context, budget *queryBudget, n int) int {
+	if ctx.Err() != nil {
+		return 0
+	}
+	return budget.take(n)
+}
+
+// splitSearchKinds divides a search's kinds between nostrdb's index
+// and grain's own, which has indexed. No kinds means all of both; a
+// search of indexed kinds alone skips nostrdb (searchNative false).
+func splitSearchKinds(kinds, indexed []int) (native []int, searchNative bool, own []int) {
+	if len(kinds) == 0 {
+		return nil, true, indexed
+	}
+	for _, k := range kinds {
+		if slices.Contains(indexed, k) {
+			own = append(own, k)
+		} else {
+			native = append(native, k)
+		}
+	}
+	return native, len(native) > 0, own
+}
+
+// pagedTextSearch pages through nostrdb's text search for q's terms
+// until limit events pass keep, the search is exhausted, or base's
+// Since (Until, oldest first) is crossed.
+func pagedTextSearch(ctx context.Context, db *nostrdb.NDB, q nostr.SearchQuery, base nostr.Filter, limit int, budget *queryBudget, keep func(nostr.Event) bool) ([]nostr.Event, error) {
+	search := db.TextSearch
+	if q.Oldest {
+		search = db.TextSearchOldest
+	}
+	return searchPages(ctx, search, q, base, limit, budget, keep)
+}
+
+// textSearcher is nostrdb.NDB.TextSearch or TextSearchOldest.
+type textSearcher func(query string, base nostr.Filter, limit int) ([]nostr.Event, error)
+
+// searchPages is pagedTextSearch over search. nostrdb's search can't
+// resume part way through a second, so a full page's last second is
+// searched again on its own before the cursor steps past it; only a
+// second with more matches than one search returns is cut short.
+func searchPages(ctx context.Context, search textSearcher, q nostr.SearchQuery, base nostr.Filter, limit int, budget *queryBudget, keep func(nostr.Event) bool) ([]nostr.Event, error) {
+	var acc []nostr.Event
+	// add keeps what of events passes keep and wasn't already had,
+	// reporting whether limit is reached.
+	seen := make(map[string]bool)
+	add := func(events []nostr.Event) bool {
+		for _, e := range events {
+			if seen[e.ID] {
+				continue
+			}
+			seen[e.ID] = true
+			if keep(e) {
+				acc = append(acc, e)
+				if len(acc) == limit {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	page := base
+	for len(acc) < limit {
+		n := readable(ctx, budget, searchPageSize)
+		if n == 0 {
+			break
+		}
+		events, err := search(q.Terms, page, n)
+		if err != nil {
+			return nil, err
+		}
+		budget.spend(len(events))
+		if add(events) || len(events) < n {
+			break
+		}
+
+		// The page may have stopped inside its last second: read that
+		// second whole, then step strictly past it.
+		last := events[len(events)-1].CreatedAt
+		if n = readable(ctx, budget, searchPageSize); n == 0 {
+			break
+		}
+		at := time.Unix(last, 0)
+		second := base
+		second.Since, second.Until = &at, &at
+		tied, err := search(q.Terms, second, n)
+		if err != nil {
+			return nil, err
+		}
+		budget.spend(len(tied))
+		if add(tied) {
+			break
+		}
+		if len(tied) == searchPageSize {
+			log.Req().Debug("Search second has more matches than one search returns; skipping the rest",
+				"created_at", last)
+		}
+		if q.Oldest {
+			next := time.Unix(last+1, 0)
+			if base.Until != nil && next.After(*base.Until) {
+				break
+			}
+			page.Since = &next
+		} else {
+			next := time.Unix(last-1, 0)
+			if base.Since != nil && next.Before(*base.Since) {
+				break
+			}
+			page.Until = &next
+		}
+	}
+	return acc, nil
+}
+
+// pagedFilter is pagedTextSearch for a search with no terms: f's
+// stored events, newest first, until limit pass keep.
+func pagedFilter(ctx context.Context, db *nostrdb.NDB, f nostr.Filter, limit int, budget *queryBudget, keep func(nostr.Event) bool) ([]nostr.Event, error) {
+	var acc []nostr.Event
+	var cursor *nostrdb.Cursor
+	for len(acc) < limit {
+		n := readable(ctx, budget, streamPageSize)
+		if n == 0 {
+			break
+		}
+		events, next, read, err := db.QueryPageRead(f, cursor, n)
+		if err != nil {
+			return nil, err
+		}
+		budget.spend(read)
+		for _, e := range events {
+			if keep(e) {
+				acc = append(acc, e)
+				if len(acc) == limit {
+					break
+				}
+			}
+		}
+		if next == nil {
+			break
+		}
+		cursor = next
+	}
+	return acc, nil
+}
+
+// pagedIndexSearch is pagedTextSearch over grain's own index of kinds,
+// reading each hit from db a page at a time. A settled hit that's no
+// longer stored (deleted, purged, or replaced) is dropped from the
+// index on the way.
+func pagedIndexSearch(ctx context.Context, db *nostrdb.NDB, q nostr.SearchQuery, base nostr.Filter, kinds []int, limit int, budget *queryBudget, keep func(nostr.Event) bool) ([]nostr.Event, error) {
+	fq := fulltext.Query{Terms: q.Terms, Kinds: kinds, Authors: base.Authors, OldestFirst: q.Oldest}
+	if base.Since != nil {
+		fq.Since = base.Since.Unix()
+	}
+	if base.Until != nil {
+		fq.Until = base.Until.Unix()
+	}
+	hits := fulltext.Search(fq)
+
+	var acc []nostr.Event
+	for len(hits) > 0 && len(acc) < limit {
+		n := readable(ctx, budget, min(len(hits), searchPageSize))
+		if n == 0 {
+			break
+		}
+		events, gone, err := readHits(db, hits[:n])
+		if err != nil {
+			return nil, err
+		}
+		budget.spend(n)
+		for _, id := range gone {
+			fulltext.Remove(id)
+		}
+		for _, e := range events {
+			if base.MatchesEvent(e) && keep(e) {
+				acc = append(acc, e)
+				if len(acc) == limit {
+					break
+				}
+			}
+		}
+		hits = hits[n:]
+	}
+	return acc, nil
+}
+
+// readHits reads hits' events in one transaction, in order, and lists
+// the ids of the settled ones db no longer has.
+func readHits(db *nostrdb.NDB, hits []fulltext.Hit) (events []nostr.Event, gone []string, err error) {
+	txn, err := db.BeginQuery()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer txn.EndQuery()
+	for _, h := range hits {
+		evt, err := txn.GetNoteByID(h.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if evt == nil {
+			if h.Settled {
+				gone = append(gone, h.ID)
+			}
+			continue
+		}
+		events = append(events, *evt)
+	}
+	return events, gone, nil
+}
+
+// mergeSearch merges two searches' results, each in the order asked
+// for, and keeps the first limit.
+func mergeSearch(a, b []nostr.Event, oldest bool, limit int) []nostr.Event {
+	if len(b) == 0 {
+		return a
+	}
+	out := append(append(make([]nostr.Event, 0, len(a)+len(b)), a...), b...)
+	sort.SliceStable(out, func(i, j int) bool {
+		if oldest {
+			return eventBefore(out[j], out[i])
+		}
+		return eventBefore(out[i], out[j])
+	})
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+// withTagValue is tags, copied, with value required of tag name too.
+func withTagValue(tags map[string][]string, name, value string) map[string][]string {
+	out := make(map[string][]string, len(tags)+1)
+	for k, v := range tags {
+		out[k] = v
+	}
+	out[name] = append(append([]string(nil), out[name]...), value)
+	return out
+}
+
+// authorDomains answers domain: for one search, looking each author's
+// profile up once, each lookup a read against budget.
+type authorDomains struct {
+	db     *nostrdb.NDB
+	domain string
+	budget *queryBudget
+	seen   map[string]bool
+}
+
+// match reports whether pubkey's stored kind 0 gives a NIP-05
+// identifier at the domain; true when the search has no domain:.
+func (d *authorDomains) match(pubkey string) bool {
+	if d.domain == "" {
+		return true
+	}
+	if ok, done := d.seen[pubkey]; done {
+		return ok
+	}
+	if d.budget.take(1) == 0 {
+		return false
+	}
+	d.budget.spend(1)
+	ok := false
+	profiles, err := d.db.Query([]nostr.Filter{{Authors: []string{pubkey}, Kinds: []int{0}}}, 1)
+	if err == nil && len(profiles) > 0 {
+		ok = nip05Domain(profiles[0].Content) == d.domain
+	}
+	if d.seen == nil {
+		d.seen = make(map[string]bool)
+	}
+	d.seen[pubkey] = ok
+	return ok
+}
+
+// nip05Domain is the domain of the NIP-05 identifier in kind 0
+// content, lower case, or "" when it has none.
+func nip05Domain(content string) string {
+	var profile struct {
+		Nip05 string `json:"nip05"`
+	}
+	if json.Unmarshal([]byte(content), &profile) != nil || profile.Nip05 == "" {
+		return ""
+	}
+	domain := profile.Nip05
+	if _, d, found := strings.Cut(domain, "@"); found {
+		domain = d
+	}
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+}
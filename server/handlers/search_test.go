@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	nostr "github.com/0ceanslim/grain/server/types"
+)
+
+func TestSplitSearchKinds(t *testing.T) {
+	indexed := []int{0, 30024}
+	cases := []struct {
+		kinds        []int
+		native       []int
+		searchNative bool
+		own          []int
+	}{
+		{nil, nil, true, []int{0, 30024}},
+		{[]int{1, 0}, []int{1}, true, []int{0}},
+		{[]int{0}, nil, false, []int{0}},
+		{[]int{7}, []int{7}, true, nil},
+	}
+	for _, c := range cases {
+		native, searchNative, own := splitSearchKinds(c.kinds, indexed)
+		if !reflect.DeepEqual(native, c.native) || searchNative != c.searchNative || !reflect.DeepEqual(own, c.own) {
+			t.Errorf("splitSearchKinds(%v) = %v, %v, %v; want %v, %v, %v",
+				c.kinds, native, searchNative, own, c.native, c.searchNative, c.own)
+		}
+	}
+}
+
+func TestMergeSearch(t *testing.T) {
+	ev := func(id string, at int64) nostr.Event { return nostr.Event{ID: id, CreatedAt: at} }
+	ids := func(events []nostr.Event) (out []string) {
+		for _, e := range events {
+			out = append(out, e.ID)
+		}
+		return out
+	}
+	notes := []nostr.Event{ev("n3", 30), ev("n1", 10)}
+	profiles := []nostr.Event{ev("p4", 40), ev("p2", 20)}
+	if got := ids(mergeSearch(notes, profiles, false, 3)); !reflect.DeepEqual(got, []string{"p4", "n3", "p2"}) {
+		t.Errorf("newest first: %v", got)
+	}
+	notes = []nostr.Event{ev("n1", 10), ev("n3", 30)}
+	profiles = []nostr.Event{ev("p2", 20), ev("p4", 40)}
+	if got := ids(mergeSearch(notes, profiles, true, 10)); !reflect.DeepEqual(got, []string{"n1", "p2", "n3", "p4"}) {
+		t.Errorf("oldest first: %v", got)
+	}
+}
+
+func TestReadable(t *testing.T) {
+	budget := newQueryBudget(300, time.Now().Add(time.Minute))
+	if n := readable(context.Background(), budget, searchPageSize); n != searchPageSize {
+		t.Fatalf("fresh budget: %d", n)
+	}
+	budget.spend(250)
+	if n := readable(context.Background(), budget, searchPageSize); n != 50 {
+		t.Fatalf("50 left: %d", n)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if n := readable(ctx, budget, searchPageSize); n != 0 || budget.exceeded != "" {
+		t.Fatalf("cancelled: %d, exceeded %q", n, budget.exceeded)
+	}
+	budget.spend(50)
+	if n := readable(context.Background(), budget, searchPageSize); n != 0 || budget.exceeded != "scanned" {
+		t.Fatalf("spent: %d, exceeded %q", n, budget.exceeded)
+	}
+}
+
+// TestSearchPages_SecondOnBoundary checks that a page ending inside a
+// second doesn't lose the rest of that second's matches.
+func TestSearchPages_SecondOnBoundary(t *testing.T) {
+	var events []nostr.Event // newest first
+	for i := 0; i < 100; i++ {
+		events = append(events, nostr.Event{ID: fmt.Sprintf("a%03d", i), CreatedAt: int64(1000 - i)})
+	}
+	for i := 0; i < 50; i++ {
+		events = append(events, nostr.Event{ID: fmt.Sprintf("b%03d", i), CreatedAt: 800})
+	}
+	for i := 0; i < 100; i++ {
+		events = append(events, nostr.Event{ID: fmt.Sprintf("c%03d", i), CreatedAt: int64(700 - i)})
+	}
+	search := func(_ string, f nostr.Filter, n int) ([]nostr.Event, error) {
+		var out []nostr.Event
+		for _, e := range events {
+			if len(out) == min(n, searchPageSize) {
+				break
+			}
+			if (f.Since == nil || e.CreatedAt >= f.Since.Unix()) && (f.Until == nil || e.CreatedAt <= f.Until.Unix()) {
+				out = append(out, e)
+			}
+		}
+		return out, nil
+	}
+
+	budget := newQueryBudget(1_000_000, time.Now().Add(time.Minute))
+	keep := func(nostr.Event) bool { return true }
+	got, err := searchPages(context.Background(), search, nostr.SearchQuery{Terms: "x"}, nostr.Filter{}, 1000, budget, keep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, e := range got {
+		if seen[e.ID] {
+			t.Fatalf("event %s repeated", e.ID)
+		}
+		seen[e.ID] = true
+	}
+	if len(got) != len(events) {
+		t.Fatalf("got %d events, want %d", len(got), len(events))
+	}
+}
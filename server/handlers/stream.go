@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"time"
+
+	cfgType "github.com/0ceanslim/grain/config/types"
+	"github.com/0ceanslim/grain/server/db/nostrdb"
+	nostr "github.com/0ceanslim/grain/server/types"
+)
+
+// streamPageSize is how many of one filter's events a REQ reads per
+// read transaction. Pages are sent before the next is read, so no
+// transaction stays open while the client drains them.
+const streamPageSize = 256
+
+// Defaults for config.Query.
+const (
+	defaultMaxScanned                 = 100_000
+	defaultMaxMillis                  = 10_000
+	defaultMaxConcurrentPerConnection = 4
+	defaultMaxConcurrent              = 64
+)
+
+// queryLimits is config.Query with the defaults filled in.
+func queryLimits(q cfgType.QueryConfig) cfgType.QueryConfig {
+	if q.MaxScanned <= 0 {
+		q.MaxScanned = defaultMaxScanned
+	}
+	if q.MaxMillis <= 0 {
+		q.MaxMillis = defaultMaxMillis
+	}
+	if q.OnExceeded == "" {
+		q.OnExceeded = "eose"
+	}
+	if q.MaxConcurrentPerConnection <= 0 {
+		q.MaxConcurrentPerConnection = defaultMaxConcurrentPerConnection
+	}
+	if q.MaxConcurrent <= 0 {
+		q.MaxConcurrent = defaultMaxConcurrent
+	}
+	return q
+}
+
+// queryBudget is what a REQ may still spend reading stored events:
+// events read, whether they're sent or not, and wall time.
+type queryBudget struct {
+	scanned, maxScanned int
+	deadline            time.Time
+	// exceeded is why the REQ stopped short, "scanned" or "time".
+	exceeded string
+}
+
+func newQueryBudget(maxScanned int, deadline time.Time) *queryBudget {
+	return &queryBudget{maxScanned: maxScanned, deadline: deadline}
+}
+
+// take is how many of n more events may be read, 0 once the budget is
+// spent.
+func (b *queryBudget) take(n int) int {
+	if !time.Now().Before(b.deadline) {
+		b.exceeded = "time"
+		return 0
+	}
+	if left := b.maxScanned - b.scanned; n > left {
+		n = left
+	}
+	if n <= 0 {
+		b.exceeded = "scanned"
+		return 0
+	}
+	return n
+}
+
+// spend counts n events read.
+func (b *queryBudget) spend(n int) {
+	b.scanned += n
+}
+
+// pageReader reads a page of a filter's stored events, and how many
+// notes it read to fill it, as nostrdb.NDB.QueryPageRead does.
+type pageReader func(filter nostr.Filter, after *nostrdb.Cursor, n int) ([]nostr.Event, *nostrdb.Cursor, int, error)
+
+// filterStream is one filter's place in a historyStream.
+type filterStream struct {
+	filter nostr.Filter
+	left   int // events it may still give, its limit
+	buf    []nostr.Event
+	after  *nostrdb.Cursor
+	done   bool
+}
+
+// historyStream is what a REQ's non-search filters have stored: each
+// filter's newest events up to its limit, merged newest first with an
+// event matching several filters given once, limit at most in all.
+// Events of the same second come lowest id first, as NIP-01 has it,
+// and a limit falling inside a crowded second cuts it in that order.
+//
+// Filters are read a page at a time as the merge needs them, so the
+// first events go out before the last are read.
+type historyStream struct {
+	read    pageReader
+	filters []*filterStream
+	left    int
+	budget  *queryBudget
+}
+
+func newHistoryStream(filters []nostr.Filter, limit int, budget *queryBudget, read pageReader) *historyStream {
+	s := &historyStream{read: read, left: limit, budget: budget}
+	for _, f := range filters {
+		n := limit
+		if f.Limit != nil && *f.Limit > 0 && *f.Limit < n {
+			n = *f.Limit
+		}
+		s.filters = append(s.filters, &filterStream{filter: f, left: n, done: n <= 0})
+	}
+	return s
+}
+
+// next returns the next event, or false at the end. It also ends when
+// the budget runs out; budget.exceeded says so.
+func (s *historyStream) next() (nostr.Event, bool, error) {
+	if s.left <= 0 {
+		return nostr.Event{}, false, nil
+	}
+	var first *filterStream
+	for _, fs := range s.filters {
+		if len(fs.buf) == 0 && !fs.done {
+			if err := s.fill(fs); err != nil {
+				return nostr.Event{}, false, err
+			}
+			if s.budget.exceeded != "" {
+				return nostr.Event{}, false, nil
+			}
+		}
+		if len(fs.buf) > 0 && (first == nil || eventBefore(fs.buf[0], first.buf[0])) {
+			first = fs
+		}
+	}
+	if first == nil {
+		return nostr.Event{}, false, nil
+	}
+
+	// Every filter walks the same order, so a filter that also has
+	// this event has it first too.
+	evt := first.buf[0]
+	for _, fs := range s.filters {
+		if len(fs.buf) > 0 && fs.buf[0].ID == evt.ID {
+			fs.buf = fs.buf[1:]
+			if fs.left--; fs.left == 0 {
+				fs.done = true
+			}
+		}
+	}
+	s.left--
+	return evt, true, nil
+}
+
+// fill reads fs's next page.
+func (s *historyStream) fill(fs *filterStream) error {
+	n := streamPageSize
+	if fs.left < n {
+		n = fs.left
+	}
+	if s.left < n {
+		n = s.left
+	}
+	if n = s.budget.take(n); n == 0 {
+		return nil
+	}
+	page, after, read, err := s.read(fs.filter, fs.after, n)
+	if err != nil {
+		return err
+	}
+	s.budget.spend(read)
+	fs.buf, fs.after = page, after
+	if after == nil || len(page) == 0 {
+		fs.done = true
+	}
+	return nil
+}
+
+// eventBefore reports whether a comes before b in REQ order: newer,
+// or the same second and a lower id.
+func eventBefore(a, b nostr.Event) bool {
+	if a.CreatedAt != b.CreatedAt {
+		return a.CreatedAt > b.CreatedAt
+	}
+	return a.ID < b.ID
+}
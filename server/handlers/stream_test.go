@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/0ceanslim/grain/server/db/nostrdb"
+	nostr "github.com/0ceanslim/grain/server/types"
+)
+
+// fakePages pages through events the way NDB.QueryPageRead does,
+// matching filters on kind alone, and counts the notes it reads: the
+// events it hands out, and skip more per page, like the ones a page
+// reads past for "&" tags.
+type fakePages struct {
+	events []nostr.Event
+	skip   int
+	read   int
+}
+
+func newFakePages(events []nostr.Event) *fakePages {
+	sorted := append([]nostr.Event(nil), events...)
+	sort.Slice(sorted, func(i, j int) bool { return eventBefore(sorted[i], sorted[j]) })
+	return &fakePages{events: sorted}
+}
+
+func (p *fakePages) QueryPageRead(f nostr.Filter, after *nostrdb.Cursor, n int) ([]nostr.Event, *nostrdb.Cursor, int, error) {
+	var page []nostr.Event
+	read := p.skip
+	p.read += p.skip
+	for _, e := range p.events {
+		if after != nil && !eventBefore(nostr.Event{CreatedAt: after.CreatedAt, ID: after.ID}, e) {
+			continue
+		}
+		if len(f.Kinds) > 0 && !containsKind(f.Kinds, e.Kind) {
+			continue
+		}
+		if len(page) == n {
+			last := page[len(page)-1]
+			return page, &nostrdb.Cursor{CreatedAt: last.CreatedAt, ID: last.ID}, read, nil
+		}
+		page = append(page, e)
+		read++
+		p.read++
+	}
+	return page, nil, read, nil
+}
+
+func containsKind(kinds []int, kind int) bool {
+	for _, k := range kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// streamEvents makes n events of each kind, several to a second.
+func streamEvents(n int, kinds ...int) []nostr.Event {
+	var events []nostr.Event
+	for _, kind := range kinds {
+		for i := 0; i < n; i++ {
+			events = append(events, nostr.Event{
+				ID:        fmt.Sprintf("%064x", kind*1_000_000+i),
+				CreatedAt: int64(1_700_000_000 + i/7),
+				Kind:      kind,
+			})
+		}
+	}
+	return events
+}
+
+func drain(t *testing.T, s *historyStream) []nostr.Event {
+	t.Helper()
+	var out []nostr.Event
+	for {
+		evt, ok, err := s.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			return out
+		}
+		out = append(out, evt)
+	}
+}
+
+func limitOf(n int) *int { return &n }
+
+func TestHistoryStream_Merge(t *testing.T) {
+	events := streamEvents(600, 1, 7)
+	pages := newFakePages(events)
+	filters := []nostr.Filter{
+		{Kinds: []int{1}, Limit: limitOf(400)},
+		{Kinds: []int{1, 7}},
+	}
+	budget := newQueryBudget(1_000_000, time.Now().Add(time.Minute))
+	got := drain(t, newHistoryStream(filters, 1000, budget, pages.QueryPageRead))
+
+	// The second filter's newest 1000 of the 1200, the first's 400
+	// among them, each once.
+	want := append([]nostr.Event(nil), pages.events[:1000]...)
+	if len(got) != len(want) {
+		t.Fatalf("got %d events, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].ID != want[i].ID {
+			t.Fatalf("event %d: got %s, want %s", i, got[i].ID, want[i].ID)
+		}
+	}
+	if budget.exceeded != "" {
+		t.Errorf("exceeded = %q, want none", budget.exceeded)
+	}
+	if budget.scanned != pages.read {
+		t.Errorf("scanned = %d, pages read %d", budget.scanned, pages.read)
+	}
+}
+
+func TestHistoryStream_ScanBudget(t *testing.T) {
+	pages := newFakePages(streamEvents(1000, 1))
+
+	budget := newQueryBudget(300, time.Now().Add(time.Minute))
+	got := drain(t, newHistoryStream([]nostr.Filter{{}}, 1000, budget, pages.QueryPageRead))
+	if len(got) != 300 || budget.exceeded != "scanned" {
+		t.Errorf("got %d events, exceeded %q; want 300, scanned", len(got), budget.exceeded)
+	}
+
+	// A budget the REQ fits in exactly isn't exceeded.
+	budget = newQueryBudget(300, time.Now().Add(time.Minute))
+	got = drain(t, newHistoryStream([]nostr.Filter{{Limit: limitOf(300)}}, 1000, budget, pages.QueryPageRead))
+	if len(got) != 300 || budget.exceeded != "" {
+		t.Errorf("got %d events, exceeded %q; want 300, none", len(got), budget.exceeded)
+	}
+
+	// Notes read past count too.
+	pages.skip = 200
+	budget = newQueryBudget(300, time.Now().Add(time.Minute))
+	got = drain(t, newHistoryStream([]nostr.Filter{{}}, 1000, budget, pages.QueryPageRead))
+	if len(got) != streamPageSize || budget.exceeded != "scanned" {
+		t.Errorf("got %d events, exceeded %q; want one page, scanned", len(got), budget.exceeded)
+	}
+}
+
+func TestHistoryStream_TimeBudget(t *testing.T) {
+	pages := newFakePages(streamEvents(1000, 1))
+	budget := newQueryBudget(1_000_000, time.Now().Add(-time.Millisecond))
+	got := drain(t, newHistoryStream([]nostr.Filter{{}}, 1000, budget, pages.QueryPageRead))
+	if len(got) != 0 || budget.exceeded != "time" {
+		t.Errorf("got %d events, exceeded %q; want 0, time", len(got), budget.exceeded)
+	}
+}
+
+// queryClient is a connection for the inflight tests.
+type queryClient struct {
+	nostr.ClientInterface
+	id int
+}
+
+func TestBeginQuery_PerConnection(t *testing.T) {
+	client := &queryClient{id: 1}
+	defer CancelQueries(client)
+
+	a, ok := beginQuery(client, "a", 2)
+	if !ok {
+		t.Fatal("first query refused")
+	}
+	if _, ok := beginQuery(client, "b", 2); !ok {
+		t.Fatal("second query refused")
+	}
+	if _, ok := beginQuery(client, "c", 2); ok {
+		t.Fatal("third query accepted past the limit")
+	}
+
+	// Replacing a subscription's query takes its place and cancels it.
+	a2, ok := beginQuery(client, "a", 2)
+	if !ok {
+		t.Fatal("replacement refused")
+	}
+	if a.ctx.Err() == nil || a2.prev != a {
+		t.Fatal("replaced query not cancelled and waited on")
+	}
+	a.end(client, "a")
+	if !queryRunning(client, "a") {
+		t.Fatal("replacement not running after the replaced query ended")
+	}
+	a2.end(client, "a")
+	if queryRunning(client, "a") {
+		t.Fatal("query still running after it ended")
+	}
+	if _, ok := beginQuery(client, "c", 2); !ok {
+		t.Fatal("query refused after one ended")
+	}
+}
+
+func TestReqQuery_Acquire(t *testing.T) {
+	client := &queryClient{id: 2}
+	defer CancelQueries(client)
+
+	a, _ := beginQuery(client, "a", 4)
+	b, _ := beginQuery(client, "b", 4)
+	if !a.acquire(1, time.Now().Add(time.Minute)) {
+		t.Fatal("free slot not acquired")
+	}
+	if b.acquire(1, time.Now().Add(20*time.Millisecond)) {
+		t.Fatal("slot acquired past the limit")
+	}
+
+	acquired := make(chan bool)
+	go func() { acquired <- b.acquire(1, time.Now().Add(time.Minute)) }()
+	a.release()
+	if !<-acquired {
+		t.Fatal("released slot not handed on")
+	}
+	b.release()
+
+	// A cancelled query stops waiting.
+	a.acquire(1, time.Now().Add(time.Minute))
+	go func() { acquired <- b.acquire(1, time.Now().Add(time.Minute)) }()
+	cancelQuery(client, "b")
+	if <-acquired {
+		t.Fatal("cancelled query acquired a slot")
+	}
+	a.release()
+}
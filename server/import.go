@@ -13,12 +13,16 @@ import (
 	"time"
 
 	"github.com/0ceanslim/grain/config"
+	cfgType "github.com/0ceanslim/grain/config/types"
 	"github.com/0ceanslim/grain/server/db/nostrdb"
+	"github.com/0ceanslim/grain/server/fulltext"
+	"github.com/0ceanslim/grain/server/tombstone"
 	nostr "github.com/0ceanslim/grain/server/types"
 )
 
 // ImportEvents reads a JSONL file of Nostr events and stores them in nostrdb.
 // It processes the entire file in a single run with an in-place progress bar.
+// .gz and .zst files (as written by --export) are decompressed on the fly.
 func ImportEvents(filename string) error {
 	if err := ensureConfigFiles(); err != nil {
 		return fmt.Errorf("failed to ensure config files: %w", err)
@@ -29,39 +33,32 @@ func ImportEvents(filename string) error {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
 
-	dbPath := cfg.Database.Path
-	if dbPath == "" {
-		dbPath = "data"
-	}
-	if !filepath.IsAbs(dbPath) {
-		dbPath = filepath.Join(config.GetDataDir(), dbPath)
-	}
-	mapSizeMB := cfg.Database.MapSizeMB
-	if mapSizeMB <= 0 {
-		mapSizeMB = 4096
-	}
+	dbPath, mapSizeMB := resolveDatabaseSettings(cfg)
 
 	if err := os.MkdirAll(dbPath, 0755); err != nil {
 		return fmt.Errorf("failed to create database directory: %w", err)
 	}
 
-	file, err := os.Open(filename)
+	// First pass: count lines for accurate progress. A compressed
+	// stream can't seek, so the file is simply opened twice.
+	fmt.Printf("Counting events in %s...", filename)
+	file, err := openEventFile(filename)
 	if err != nil {
 		return fmt.Errorf("failed to open import file: %w", err)
 	}
-	defer file.Close()
-
-	// First pass: count lines for accurate progress.
-	fmt.Printf("Counting events in %s...", filename)
 	totalLines, err := countLines(file)
+	file.Close()
 	if err != nil {
 		return fmt.Errorf("failed to count lines: %w", err)
 	}
-	if _, err := file.Seek(0, io.SeekStart); err != nil {
-		return fmt.Errorf("failed to seek to start: %w", err)
-	}
 	fmt.Printf(" %d lines\n", totalLines)
 
+	file, err = openEventFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to open import file: %w", err)
+	}
+	defer file.Close()
+
 	fmt.Printf("Importing into %s\n\n", dbPath)
 
 	db, err := nostrdb.OpenWithFlags(dbPath, mapSizeMB, 1, nostrdb.FlagSkipNoteVerify)
@@ -75,21 +72,60 @@ func ImportEvents(filename string) error {
 		db.Close()
 	}()
 
-	scanner := bufio.NewScanner(file)
+	if _, err := openTombstones(db); err != nil {
+		return fmt.Errorf("failed to open tombstones: %w", err)
+	}
+	defer closeTombstones()
+
+	if _, err := openSearchIndex(cfg, db); err != nil {
+		return fmt.Errorf("failed to open search index: %w", err)
+	}
+	defer closeSearchIndex()
+
+	startTime := time.Now()
+	stats, err := importFrom(context.Background(), db, file, totalLines, true)
+	if err != nil {
+		return err
+	}
+	fmt.Println() // newline after the \r progress bar
+
+	elapsed := time.Since(startTime)
+	rate := float64(0)
+	if elapsed.Seconds() > 0 {
+		rate = float64(stats.imported) / elapsed.Seconds()
+	}
+
+	fmt.Printf("\nImport complete in %s\n", elapsed.Round(time.Millisecond))
+	fmt.Printf("  Total lines:  %d\n", stats.lines)
+	fmt.Printf("  Imported:     %d\n", stats.imported)
+	fmt.Printf("  Skipped:      %d (parse errors / missing fields)\n", stats.skipped)
+	fmt.Printf("  Store errors: %d (duplicates / rejected replacements / deleted)\n", stats.errors)
+	fmt.Printf("  Throughput:   %.0f events/sec\n", rate)
+
+	return nil
+}
+
+// importStats counts what importFrom did with each line.
+type importStats struct {
+	lines, imported, skipped, errors int
+}
+
+// importFrom does the work of ImportEvents against an open database:
+// one JSON event per line from r, stored through storeWithRetry.
+// totalLines only feeds the progress bar.
+func importFrom(ctx context.Context, db *nostrdb.NDB, r io.Reader, totalLines int, progress bool) (importStats, error) {
+	var stats importStats
+
+	scanner := bufio.NewScanner(r)
 	scanner.Buffer(make([]byte, 0, 4*1024), 10*1024*1024) // up to 10MB per line
 
-	ctx := context.Background()
-	var linesRead, imported, skipped, errors int
 	startTime := time.Now()
 	lastRender := time.Now()
 	backoff := time.Millisecond
 
-	const maxBackoff = 100 * time.Millisecond
-	const maxRetries = 500
-
 	for scanner.Scan() {
 		line := scanner.Bytes()
-		linesRead++
+		stats.lines++
 
 		if len(line) == 0 {
 			continue
@@ -97,77 +133,100 @@ func ImportEvents(filename string) error {
 
 		var evt nostr.Event
 		if err := json.Unmarshal(line, &evt); err != nil {
-			skipped++
+			stats.skipped++
 			continue
 		}
 
 		if evt.ID == "" || evt.PubKey == "" || evt.Sig == "" {
-			skipped++
+			stats.skipped++
 			continue
 		}
 
-		// Retry with exponential backoff on transient ingest failures
-		// (queue full). Permanent rejections (duplicates, replaceable
-		// conflicts) are not retried.
-		stored := false
-		for attempt := 0; attempt < maxRetries; attempt++ {
-			err := db.StoreEvent(ctx, evt)
-			if err == nil {
-				stored = true
-				backoff = time.Millisecond // reset on success
-				break
-			}
-			// "blocked:" prefix = permanent rejection (duplicate,
-			// replaceable conflict). Don't retry.
-			if strings.HasPrefix(err.Error(), "blocked:") {
-				errors++
-				break
-			}
-			// Transient failure — back off and retry.
-			if attempt < maxRetries-1 {
-				time.Sleep(backoff)
-				backoff *= 2
-				if backoff > maxBackoff {
-					backoff = maxBackoff
-				}
-				continue
-			}
-			errors++
-		}
-
-		if stored {
-			imported++
+		if storeWithRetry(ctx, db, evt, &backoff) {
+			stats.imported++
+		} else {
+			stats.errors++
 		}
 
 		// Update progress bar at most every 100ms to avoid terminal overhead.
-		if time.Since(lastRender) >= 100*time.Millisecond {
-			renderProgress(linesRead, totalLines, imported, skipped, errors, startTime)
+		if progress && time.Since(lastRender) >= 100*time.Millisecond {
+			renderProgress(stats.lines, totalLines, stats.imported, stats.skipped, stats.errors, startTime)
 			lastRender = time.Now()
 		}
 	}
 
 	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("error reading file: %w", err)
+		return stats, fmt.Errorf("error reading file: %w", err)
 	}
 
 	// Final progress render.
-	renderProgress(linesRead, totalLines, imported, skipped, errors, startTime)
-	fmt.Println() // newline after the \r progress bar
+	if progress {
+		renderProgress(stats.lines, totalLines, stats.imported, stats.skipped, stats.errors, startTime)
+	}
+	return stats, nil
+}
 
-	elapsed := time.Since(startTime)
-	rate := float64(0)
-	if elapsed.Seconds() > 0 {
-		rate = float64(imported) / elapsed.Seconds()
+// resolveDatabaseSettings returns the nostrdb directory and map size
+// for the offline CLI modes, applying the same defaults as startup.
+func resolveDatabaseSettings(cfg *cfgType.ServerConfig) (string, int) {
+	dbPath := cfg.Database.Path
+	if dbPath == "" {
+		dbPath = "data"
 	}
+	if !filepath.IsAbs(dbPath) {
+		dbPath = filepath.Join(config.GetDataDir(), dbPath)
+	}
+	mapSizeMB := cfg.Database.MapSizeMB
+	if mapSizeMB <= 0 {
+		mapSizeMB = 4096
+	}
+	return dbPath, mapSizeMB
+}
 
-	fmt.Printf("\nImport complete in %s\n", elapsed.Round(time.Millisecond))
-	fmt.Printf("  Total lines:  %d\n", linesRead)
-	fmt.Printf("  Imported:     %d\n", imported)
-	fmt.Printf("  Skipped:      %d (parse errors / missing fields)\n", skipped)
-	fmt.Printf("  Store errors: %d (duplicates / rejected replacements)\n", errors)
-	fmt.Printf("  Throughput:   %.0f events/sec\n", rate)
+// storeWithRetry stores one event, retrying with exponential backoff on
+// transient ingest failures (queue full). Permanent rejections
+// (duplicates, replaceable conflicts) are not retried. backoff carries
+// the current delay across calls so a saturated writer keeps being
+// given room; it resets on success.
+//
+// Deletions are honoured the way HandleEvent does: an event a NIP-09
+// deletion request removed stays out, and a deletion request is
+// carried out as well as stored.
+func storeWithRetry(ctx context.Context, db *nostrdb.NDB, evt nostr.Event, backoff *time.Duration) bool {
+	const maxBackoff = 100 * time.Millisecond
+	const maxRetries = 500
 
-	return nil
+	if tombstone.Deleted(evt) {
+		return false
+	}
+	store := db.StoreEvent
+	if evt.Kind == tombstone.KindDeletion {
+		store = db.ProcessDeletion
+	}
+
+	for attempt := 0; attempt < maxRetries; attempt++ {
+		err := store(ctx, evt)
+		if err == nil {
+			*backoff = time.Millisecond
+			tombstone.Record(evt)
+			fulltext.Add(evt)
+			return true
+		}
+		// "blocked:" prefix = permanent rejection (duplicate,
+		// replaceable conflict). Don't retry.
+		if strings.HasPrefix(err.Error(), "blocked:") {
+			return false
+		}
+		// Transient failure — back off and retry.
+		if attempt < maxRetries-1 {
+			time.Sleep(*backoff)
+			*backoff *= 2
+			if *backoff > maxBackoff {
+				*backoff = maxBackoff
+			}
+		}
+	}
+	return false
 }
 
 // countLines counts the number of newline-delimited lines in r.
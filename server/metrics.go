@@ -0,0 +1,68 @@
+package server
+
+import (
+	"github.com/0ceanslim/grain/config"
+	"github.com/0ceanslim/grain/server/db/nostrdb"
+	"github.com/0ceanslim/grain/server/metrics"
+	"github.com/0ceanslim/grain/server/storage"
+)
+
+// registerGauges installs the /metrics gauges whose values live in
+// this package or in config. They're read at scrape time, so nothing
+// on the hot path has to keep a copy up to date. Called once from Run;
+// every closure looks up the current instance's state, so restarts
+// don't need to re-register.
+func registerGauges() {
+	metrics.SetGauge("grain_connections",
+		"Open WebSocket connections.",
+		func() float64 { return float64(currentConnections.Load()) })
+
+	metrics.SetGauge("grain_subscriptions",
+		"Open REQ subscriptions across all connections.",
+		func() float64 { return float64(subIndex.len()) })
+
+	metrics.SetGauge("grain_client_write_queue_messages",
+		"Messages waiting in outgoing queues, summed over all connections.",
+		func() float64 {
+			clientsMu.Lock()
+			defer clientsMu.Unlock()
+			total := 0
+			for _, c := range clients {
+				total += len(c.outgoing)
+			}
+			return float64(total)
+		})
+
+	metrics.SetGaugeVec("grain_ip_bans",
+		"Blocked IPs by type: permanent CIDRs, and IPs serving a temp ban.", "type",
+		func() map[string]float64 {
+			permanent, temp := config.IPBanCounts()
+			return map[string]float64{"permanent": float64(permanent), "temp": float64(temp)}
+		})
+
+	metrics.SetGauge("grain_expiration_tracked_events",
+		"Events with a future NIP-40 expiration waiting for the sweeper.",
+		func() float64 {
+			if db := nostrdb.GetDB(); db != nil {
+				return float64(db.PendingExpirations())
+			}
+			return 0
+		})
+
+	metrics.SetGauge("grain_db_map_size_bytes",
+		"LMDB map size: the most the database can hold without growing.",
+		func() float64 { return float64(storage.Last().MapSizeBytes) })
+
+	metrics.SetGauge("grain_db_map_used_bytes",
+		"LMDB map in use, as of the last storage check.",
+		func() float64 { return float64(storage.Last().UsedBytes) })
+
+	metrics.SetGauge("grain_storage_full",
+		"1 while new events are refused for lack of space.",
+		func() float64 {
+			if storage.Full() {
+				return 1
+			}
+			return 0
+		})
+}
@@ -0,0 +1,287 @@
+// Package metrics serves grain's counters on /metrics in the
+// Prometheus text exposition format (version 0.0.4).
+//
+// It's deliberately small instead of pulling in client_golang: three
+// metric types (counter, histogram, gauge read at scrape time) and
+// at most one or two labels. Everything grain exports is declared in
+// relay.go, so the full list lives in one place; instrumented code
+// only calls Inc / Observe.
+//
+// Values that already live elsewhere (subscription count, IP bans,
+// ...) aren't copied into a metric on every change. The owning
+// package installs a gauge function with SetGauge and it's read
+// when Prometheus scrapes.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// collector is anything that can write its samples.
+type collector interface {
+	write(w io.Writer)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]collector)
+)
+
+func register(name string, c collector) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[name] = c
+}
+
+// Counter is a monotonically increasing count.
+type Counter struct {
+	v atomic.Uint64
+}
+
+// Inc adds one.
+func (c *Counter) Inc() { c.v.Add(1) }
+
+// Add adds n.
+func (c *Counter) Add(n uint64) { c.v.Add(n) }
+
+// Value returns the current count.
+func (c *Counter) Value() uint64 { return c.v.Load() }
+
+type counterMetric struct {
+	name, help string
+	c          *Counter
+}
+
+func (m *counterMetric) write(w io.Writer) {
+	writeHeader(w, m.name, m.help, "counter")
+	fmt.Fprintf(w, "%s %d\n", m.name, m.c.Value())
+}
+
+// NewCounter registers an unlabelled counter.
+func NewCounter(name, help string) *Counter {
+	c := &Counter{}
+	register(name, &counterMetric{name: name, help: help, c: c})
+	return c
+}
+
+// CounterVec is a family of counters told apart by label values.
+type CounterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.RWMutex
+	values map[string]*Counter // key: label values joined by \xff
+}
+
+// NewCounterVec registers a counter family with the given label names.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{name: name, help: help, labels: labels, values: make(map[string]*Counter)}
+	register(name, v)
+	return v
+}
+
+// With returns the counter for one set of label values, in the order
+// the labels were declared. Keep the set of values small and fixed:
+// every combination is a separate time series.
+func (v *CounterVec) With(values ...string) *Counter {
+	key := strings.Join(values, "\xff")
+	v.mu.RLock()
+	c := v.values[key]
+	v.mu.RUnlock()
+	if c != nil {
+		return c
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if c = v.values[key]; c == nil {
+		c = &Counter{}
+		v.values[key] = c
+	}
+	return c
+}
+
+func (v *CounterVec) write(w io.Writer) {
+	writeHeader(w, v.name, v.help, "counter")
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	for _, key := range sortedKeys(v.values) {
+		fmt.Fprintf(w, "%s%s %d\n", v.name, labelString(v.labels, strings.Split(key, "\xff")), v.values[key].Value())
+	}
+}
+
+// Histogram counts observations into cumulative buckets.
+type Histogram struct {
+	name, help string
+	bounds     []float64
+	counts     []atomic.Uint64 // one per bound, plus +Inf
+	sumBits    atomic.Uint64   // float64 bits, updated with CAS
+	count      atomic.Uint64
+}
+
+// NewHistogram registers a histogram with the given upper bounds,
+// which must be sorted ascending.
+func NewHistogram(name, help string, bounds []float64) *Histogram {
+	h := &Histogram{name: name, help: help, bounds: bounds, counts: make([]atomic.Uint64, len(bounds)+1)}
+	register(name, h)
+	return h
+}
+
+// Observe records one value.
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v) // first bound >= v
+	h.counts[i].Add(1)
+	h.count.Add(1)
+	for {
+		old := h.sumBits.Load()
+		sum := math.Float64frombits(old) + v
+		if h.sumBits.CompareAndSwap(old, math.Float64bits(sum)) {
+			return
+		}
+	}
+}
+
+// ObserveSince records the seconds elapsed since start. Meant for
+// `defer h.ObserveSince(time.Now())`.
+func (h *Histogram) ObserveSince(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+// Count returns the number of observations.
+func (h *Histogram) Count() uint64 { return h.count.Load() }
+
+func (h *Histogram) write(w io.Writer) {
+	writeHeader(w, h.name, h.help, "histogram")
+	var cumulative uint64
+	for i, b := range h.bounds {
+		cumulative += h.counts[i].Load()
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", h.name, formatFloat(b), cumulative)
+	}
+	cumulative += h.counts[len(h.bounds)].Load()
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, cumulative)
+	fmt.Fprintf(w, "%s_sum %s\n", h.name, formatFloat(math.Float64frombits(h.sumBits.Load())))
+	fmt.Fprintf(w, "%s_count %d\n", h.name, cumulative)
+}
+
+// gaugeMetric is read from fn on every scrape. A labelled gauge
+// returns one value per label value.
+type gaugeMetric struct {
+	name, help string
+	label      string
+	fn         func() map[string]float64
+}
+
+func (g *gaugeMetric) write(w io.Writer) {
+	values := g.fn()
+	writeHeader(w, g.name, g.help, "gauge")
+	if g.label == "" {
+		fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(values[""]))
+		return
+	}
+	for _, k := range sortedKeys(values) {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, labelString([]string{g.label}, []string{k}), formatFloat(values[k]))
+	}
+}
+
+// SetGauge installs (or replaces) a gauge whose value is fn(), read
+// at scrape time. fn must be cheap and safe to call concurrently.
+func SetGauge(name, help string, fn func() float64) {
+	register(name, &gaugeMetric{name: name, help: help, fn: func() map[string]float64 {
+		return map[string]float64{"": fn()}
+	}})
+}
+
+// SetGaugeVec is SetGauge for a gauge with one label; fn returns the
+// value for each label value.
+func SetGaugeVec(name, help, label string, fn func() map[string]float64) {
+	register(name, &gaugeMetric{name: name, help: help, label: label, fn: fn})
+}
+
+// WriteTo writes every registered metric, sorted by name.
+func WriteTo(w io.Writer) {
+	registryMu.RLock()
+	names := sortedKeys(registry)
+	collectors := make([]collector, len(names))
+	for i, name := range names {
+		collectors[i] = registry[name]
+	}
+	registryMu.RUnlock()
+
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+// Handler serves the metrics to a Prometheus scraper.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteTo(w)
+	})
+}
+
+func writeHeader(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, typ)
+}
+
+func labelString(names, values []string) string {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(value))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
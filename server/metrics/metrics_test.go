@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("content type = %q", ct)
+	}
+	return rec.Body.String()
+}
+
+func expectLines(t *testing.T, body string, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, body)
+		}
+	}
+}
+
+func TestCounterVec_Exposition(t *testing.T) {
+	v := NewCounterVec("test_requests_total", "Requests by \"code\".\nSecond line.", "code")
+	v.With("200").Add(3)
+	v.With("5\"00").Inc()
+
+	expectLines(t, scrape(t),
+		`# HELP test_requests_total Requests by "code".\nSecond line.`,
+		`# TYPE test_requests_total counter`,
+		`test_requests_total{code="200"} 3`,
+		`test_requests_total{code="5\"00"} 1`,
+	)
+}
+
+func TestHistogram_CumulativeBuckets(t *testing.T) {
+	h := NewHistogram("test_latency_seconds", "Latency.", []float64{0.1, 1})
+	for _, v := range []float64{0.05, 0.1, 0.5, 3} {
+		h.Observe(v)
+	}
+
+	expectLines(t, scrape(t),
+		`# TYPE test_latency_seconds histogram`,
+		`test_latency_seconds_bucket{le="0.1"} 2`,
+		`test_latency_seconds_bucket{le="1"} 3`,
+		`test_latency_seconds_bucket{le="+Inf"} 4`,
+		`test_latency_seconds_sum 3.65`,
+		`test_latency_seconds_count 4`,
+	)
+}
+
+func TestSetGauge_ReplacesAndReadsAtScrape(t *testing.T) {
+	n := 1.0
+	SetGauge("test_things", "Old help.", func() float64 { return 0 })
+	SetGauge("test_things", "Things.", func() float64 { return n })
+	SetGaugeVec("test_bans", "Bans.", "type", func() map[string]float64 {
+		return map[string]float64{"temp": 2, "permanent": 1}
+	})
+	n = 7
+
+	body := scrape(t)
+	expectLines(t, body,
+		`# HELP test_things Things.`,
+		`test_things 7`,
+		`test_bans{type="permanent"} 1`,
+		`test_bans{type="temp"} 2`,
+	)
+	if strings.Contains(body, "Old help.") {
+		t.Error("replaced gauge still exported")
+	}
+}
+
+func TestRecordEventOK_ReasonPrefix(t *testing.T) {
+	before := func(result, reason string) uint64 { return Events.With(result, reason).Value() }
+	acc, inv, other := before("accepted", ""), before("rejected", "invalid"), before("rejected", "other")
+
+	RecordEventOK(true, "")
+	RecordEventOK(false, "invalid: bad signature")
+	RecordEventOK(false, "made-up: whatever")
+	RecordEventOK(false, "no prefix at all")
+
+	if got := before("accepted", "") - acc; got != 1 {
+		t.Errorf("accepted += %d, want 1", got)
+	}
+	if got := before("rejected", "invalid") - inv; got != 1 {
+		t.Errorf("rejected/invalid += %d, want 1", got)
+	}
+	if got := before("rejected", "other") - other; got != 2 {
+		t.Errorf("rejected/other += %d, want 2", got)
+	}
+}
@@ -0,0 +1,60 @@
+package metrics
+
+import "strings"
+
+// latencyBuckets covers a fast index hit (sub-millisecond) up to a
+// REQ that streams thousands of events to a slow client.
+var latencyBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Everything grain exports besides the gauges installed with
+// SetGauge at startup.
+var (
+	Events = NewCounterVec("grain_events_total",
+		"EVENT messages answered, by result (accepted/rejected) and the NIP-01 prefix of the OK message (shadow = shadow-rejected by the write policy).",
+		"result", "reason")
+
+	ReqDuration = NewHistogram("grain_req_duration_seconds",
+		"Time to handle a REQ, up to and including its EOSE.", latencyBuckets)
+	ReqLimited = NewCounterVec("grain_req_limited_total",
+		"REQs refused or cut short, by reason (concurrency = per-connection limit, busy = relay-wide limit, scanned/time = query budget).",
+		"reason")
+	CountDuration = NewHistogram("grain_count_duration_seconds",
+		"Time to answer a NIP-45 COUNT.", latencyBuckets)
+	DBQueryDuration = NewHistogram("grain_db_query_duration_seconds",
+		"Time spent in a single nostrdb query.", latencyBuckets)
+
+	BroadcastFanout = NewHistogram("grain_broadcast_fanout",
+		"Subscriptions a newly stored event was delivered to.",
+		[]float64{0, 1, 2, 5, 10, 25, 50, 100, 250, 500, 1000})
+
+	WriteQueueDepth = NewHistogram("grain_client_write_queue_depth",
+		"Messages already waiting in a connection's outgoing queue when another is enqueued.",
+		[]float64{0, 1, 4, 16, 64, 128, 256, 512, 1024})
+	SlowConsumerDisconnects = NewCounter("grain_client_slow_consumer_disconnects_total",
+		"Connections closed because their outgoing queue was full.")
+
+	ConnectionsRejected = NewCounterVec("grain_connections_rejected_total",
+		"WebSocket connections refused before the upgrade, by reason (max_conn, rate_limit, blocked).",
+		"reason")
+)
+
+// okPrefixes are the NIP-01 machine-readable OK prefixes. Anything
+// else is counted as "other" so a free-form message can't mint new
+// time series.
+var okPrefixes = map[string]bool{
+	"duplicate": true, "pow": true, "blocked": true, "rate-limited": true, "invalid": true,
+	"restricted": true, "mute": true, "error": true, "auth-required": true,
+}
+
+// RecordEventOK counts one OK sent in reply to an EVENT.
+func RecordEventOK(accepted bool, msg string) {
+	if accepted {
+		Events.With("accepted", "").Inc()
+		return
+	}
+	reason := "other"
+	if prefix, _, found := strings.Cut(msg, ":"); found && okPrefixes[prefix] {
+		reason = prefix
+	}
+	Events.With("rejected", reason).Inc()
+}
@@ -0,0 +1,465 @@
+// Package moderation keeps the relay's per-event moderation state:
+// event ids an admin has banned, and the queue of events waiting for
+// an admin to look at them. Both back NIP-86's banevent / allowevent /
+// listbannedevents / listeventsneedingmoderation.
+//
+// Events get into the queue two ways:
+//
+//	ban_word    held: the event matched a blacklist.yml ban word while
+//	            moderation.hold_ban_words is on. It isn't stored until
+//	            an admin allows it.
+//	report      flagged: a NIP-56 report (kind 1984) named it while
+//	            moderation.queue_reports is on. It stays stored and
+//	            visible until an admin bans it.
+//	quarantine  held: trusted reporters pushed it, or its author, past
+//	            moderation.quarantine_threshold (see reports.go).
+//
+// banevent deletes the event and remembers its id, so a re-publish is
+// rejected with "blocked:"; allowevent stores a held event, clears a
+// flag, or lifts a ban. State lives in moderation.json in the data
+// directory.
+package moderation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/0ceanslim/grain/config"
+	cfgType "github.com/0ceanslim/grain/config/types"
+	nostr "github.com/0ceanslim/grain/server/types"
+	"github.com/0ceanslim/grain/server/utils/log"
+)
+
+// Why an event is in the queue.
+const (
+	SourceBanWord    = "ban_word"
+	SourceReport     = "report"
+	SourceQuarantine = "quarantine"
+)
+
+// KindReport is the NIP-56 report kind.
+const KindReport = 1984
+
+const defaultMaxQueue = 1000
+
+// BannedEvent is one entry of listbannedevents.
+type BannedEvent struct {
+	ID       string `json:"id"`
+	Reason   string `json:"reason,omitempty"`
+	BannedAt int64  `json:"banned_at"`
+}
+
+// QueuedEvent is one entry of listeventsneedingmoderation.
+type QueuedEvent struct {
+	ID        string `json:"id"`
+	Reason    string `json:"reason"`
+	Source    string `json:"source"` // SourceBanWord, SourceReport or SourceQuarantine
+	FlaggedAt int64  `json:"flagged_at"`
+	// Event is the held event itself. Nil for flagged events, which
+	// are in the database.
+	Event *nostr.Event `json:"event,omitempty"`
+}
+
+// Store is the part of the event database the manager needs. The
+// server package implements it on nostrdb.
+type Store interface {
+	// Get returns a stored event, or nil.
+	Get(id string) *nostr.Event
+	// Delete removes a stored event; a missing one is not an error.
+	Delete(id string) error
+	// Publish stores an event and delivers it the way HandleEvent
+	// does for an accepted one.
+	Publish(evt nostr.Event) error
+}
+
+// reportSaveDelay is how long a change that came from a report waits
+// before moderation.json is written, so a burst of reports costs one
+// write. A var so tests can shorten it.
+var reportSaveDelay = 5 * time.Second
+
+// Manager owns the banned ids, the queue and the report index.
+type Manager struct {
+	mu      sync.RWMutex
+	cfg     cfgType.ModerationConfig
+	store   Store
+	path    string
+	trusted func(pubkey string) bool
+	banned  map[string]BannedEvent
+	queue   map[string]*QueuedEvent
+	reports map[string]*reportTarget
+
+	// saveMu orders writes of moderation.json; timerMu guards
+	// saveTimer, the pending saveSoon. Neither is taken under mu.
+	saveMu    sync.Mutex
+	timerMu   sync.Mutex
+	saveTimer *time.Timer
+}
+
+// stateFile is the moderation.json layout.
+type stateFile struct {
+	Banned  map[string]BannedEvent   `json:"banned"`
+	Queue   map[string]*QueuedEvent  `json:"queue"`
+	Reports map[string]*reportTarget `json:"reports"`
+}
+
+// Open loads the state from path (moderation.json; missing means
+// nothing banned or queued yet). trusted says whose reports count
+// towards quarantine_threshold; nil trusts nobody.
+func Open(cfg cfgType.ModerationConfig, store Store, path string, trusted func(pubkey string) bool) (*Manager, error) {
+	if cfg.MaxQueue <= 0 {
+		cfg.MaxQueue = defaultMaxQueue
+	}
+	m := &Manager{
+		cfg:     cfg,
+		store:   store,
+		path:    path,
+		trusted: trusted,
+		banned:  make(map[string]BannedEvent),
+		queue:   make(map[string]*QueuedEvent),
+		reports: make(map[string]*reportTarget),
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("read moderation state: %w", err)
+	}
+	if err == nil {
+		var st stateFile
+		if err := json.Unmarshal(raw, &st); err != nil {
+			return nil, fmt.Errorf("parse moderation state %s: %w", path, err)
+		}
+		for id, b := range st.Banned {
+			b.ID = id
+			m.banned[id] = b
+		}
+		for id, q := range st.Queue {
+			q.ID = id
+			m.queue[id] = q
+		}
+		for target, t := range st.Reports {
+			t.Target = target
+			m.reports[target] = t
+		}
+	}
+	log.Moderation().Info("Moderation state loaded", "banned", len(m.banned), "queued", len(m.queue), "reported", len(m.reports))
+	return m, nil
+}
+
+// save writes moderation.json atomically. The state is encoded under
+// a read lock and written after it's released, so IsBanned and Hold
+// never wait on the disk. Caller must not hold mu.
+func (m *Manager) save() error {
+	m.saveMu.Lock()
+	defer m.saveMu.Unlock()
+	m.mu.RLock()
+	out, err := json.MarshalIndent(stateFile{Banned: m.banned, Queue: m.queue, Reports: m.reports}, "", "  ")
+	m.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	return config.AtomicWriteFile(m.path, out, 0644)
+}
+
+// saveSoon saves within reportSaveDelay, once however many changes
+// come in meanwhile. Caller must not hold mu.
+func (m *Manager) saveSoon() {
+	m.timerMu.Lock()
+	defer m.timerMu.Unlock()
+	if m.saveTimer != nil {
+		return
+	}
+	m.saveTimer = time.AfterFunc(reportSaveDelay, func() {
+		m.timerMu.Lock()
+		m.saveTimer = nil
+		m.timerMu.Unlock()
+		if err := m.save(); err != nil {
+			log.Moderation().Error("Failed to save moderation state", "error", err)
+		}
+	})
+}
+
+// Close writes out a pending saveSoon.
+func (m *Manager) Close() error {
+	m.timerMu.Lock()
+	t := m.saveTimer
+	m.saveTimer = nil
+	m.timerMu.Unlock()
+	if t != nil && t.Stop() {
+		return m.save()
+	}
+	return nil
+}
+
+// IsBanned reports whether id was banned with banevent.
+func (m *Manager) IsBanned(id string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.banned[id]
+	return ok
+}
+
+// Hold queues evt for review instead of storing it. source is
+// SourceBanWord or SourceQuarantine.
+func (m *Manager) Hold(evt nostr.Event, source, reason string) error {
+	m.mu.Lock()
+	if _, ok := m.queue[evt.ID]; ok {
+		m.mu.Unlock()
+		return nil
+	}
+	if len(m.queue) >= m.cfg.MaxQueue {
+		m.mu.Unlock()
+		return fmt.Errorf("moderation queue is full")
+	}
+	held := evt
+	m.queue[evt.ID] = &QueuedEvent{
+		ID:        evt.ID,
+		Reason:    reason,
+		Source:    source,
+		FlaggedAt: time.Now().Unix(),
+		Event:     &held,
+	}
+	m.mu.Unlock()
+	log.Moderation().Info("Event held for moderation", "event_id", evt.ID, "pubkey", evt.PubKey, "source", source, "reason", reason)
+	return m.save()
+}
+
+// Apply looks at a stored event. A NIP-56 report is indexed, and with
+// queue_reports on flags the events it names, if they're on this
+// relay.
+func (m *Manager) Apply(evt nostr.Event) {
+	if evt.Kind != KindReport {
+		return
+	}
+	m.ingestReport(evt)
+	if !m.cfg.QueueReports {
+		return
+	}
+	for _, tag := range evt.Tags {
+		if len(tag) < 2 || tag[0] != "e" {
+			continue
+		}
+		id := tag[1]
+		reason := "reported"
+		if len(tag) >= 3 && tag[2] != "" {
+			reason = "reported: " + tag[2]
+		}
+		if err := m.flag(id, reason); err != nil {
+			log.Moderation().Warn("Failed to queue reported event", "event_id", id, "report_id", evt.ID, "error", err)
+		}
+	}
+}
+
+func (m *Manager) flag(id, reason string) error {
+	if m.store.Get(id) == nil {
+		return nil
+	}
+	m.mu.Lock()
+	_, queued := m.queue[id]
+	_, banned := m.banned[id]
+	full := len(m.queue) >= m.cfg.MaxQueue
+	if !queued && !banned && !full {
+		m.queue[id] = &QueuedEvent{ID: id, Reason: reason, Source: SourceReport, FlaggedAt: time.Now().Unix()}
+	}
+	m.mu.Unlock()
+	if queued || banned {
+		return nil
+	}
+	if full {
+		return fmt.Errorf("moderation queue is full")
+	}
+	log.Moderation().Info("Event flagged for moderation", "event_id", id, "reason", reason)
+	m.saveSoon()
+	return nil
+}
+
+// Ban rejects id from now on, drops it from the queue and deletes the
+// event if stored. The ban is in place before the delete, so a
+// re-publish can't get in between; the delete itself runs outside mu
+// so IsBanned doesn't wait on the database.
+func (m *Manager) Ban(id, reason string) error {
+	m.mu.Lock()
+	delete(m.queue, id)
+	delete(m.reports, id)
+	m.banned[id] = BannedEvent{ID: id, Reason: reason, BannedAt: time.Now().Unix()}
+	m.mu.Unlock()
+	err := m.save()
+	log.Moderation().Info("Event banned", "event_id", id, "reason", reason)
+
+	if derr := m.store.Delete(id); derr != nil {
+		return fmt.Errorf("event banned, but deleting it failed: %w", derr)
+	}
+	return err
+}
+
+// Allow lifts a ban on id, or settles its place in the queue: a held
+// event is stored and delivered, a flagged one just stops being
+// flagged. Either way its reports are dismissed, so the next one
+// doesn't quarantine it straight back.
+func (m *Manager) Allow(id string) error {
+	m.mu.RLock()
+	_, banned := m.banned[id]
+	q, queued := m.queue[id]
+	m.mu.RUnlock()
+	if !banned && !queued {
+		return fmt.Errorf("event is not banned or awaiting moderation")
+	}
+	// Stored before it leaves the queue, so a failure leaves it there.
+	if queued && q.Event != nil {
+		if err := m.store.Publish(*q.Event); err != nil {
+			return fmt.Errorf("store held event: %w", err)
+		}
+	}
+
+	m.mu.Lock()
+	delete(m.banned, id)
+	delete(m.queue, id)
+	delete(m.reports, id)
+	m.mu.Unlock()
+	log.Moderation().Info("Event allowed", "event_id", id, "was_banned", banned, "was_queued", queued)
+	return m.save()
+}
+
+// Banned lists the banned events, newest first.
+func (m *Manager) Banned() []BannedEvent {
+	m.mu.RLock()
+	out := make([]BannedEvent, 0, len(m.banned))
+	for _, b := range m.banned {
+		out = append(out, b)
+	}
+	m.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].BannedAt != out[j].BannedAt {
+			return out[i].BannedAt > out[j].BannedAt
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+// Queue lists the events awaiting review, oldest first.
+func (m *Manager) Queue() []QueuedEvent {
+	m.mu.RLock()
+	out := make([]QueuedEvent, 0, len(m.queue))
+	for _, q := range m.queue {
+		out = append(out, *q)
+	}
+	m.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].FlaggedAt != out[j].FlaggedAt {
+			return out[i].FlaggedAt < out[j].FlaggedAt
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+var (
+	active   *Manager
+	activeMu sync.RWMutex
+)
+
+// errUnavailable is what the NIP-86 methods get before startup has
+// installed a manager (or after it failed to).
+var errUnavailable = errors.New("moderation is not available")
+
+var errNotReported = errors.New("nothing has been reported about that target")
+
+// SetManager installs the instance-wide manager (nil to clear) and
+// returns the previous one.
+func SetManager(m *Manager) *Manager {
+	activeMu.Lock()
+	defer activeMu.Unlock()
+	prev := active
+	active = m
+	return prev
+}
+
+func current() *Manager {
+	activeMu.RLock()
+	defer activeMu.RUnlock()
+	return active
+}
+
+// IsBanned asks the active manager; with none, nothing is banned.
+func IsBanned(id string) bool {
+	if m := current(); m != nil {
+		return m.IsBanned(id)
+	}
+	return false
+}
+
+// IsQuarantined asks the active manager; with none, nothing is.
+func IsQuarantined(evt nostr.Event) bool {
+	if m := current(); m != nil {
+		return m.IsQuarantined(evt)
+	}
+	return false
+}
+
+// Hold queues evt on the active manager.
+func Hold(evt nostr.Event, source, reason string) error {
+	if m := current(); m != nil {
+		return m.Hold(evt, source, reason)
+	}
+	return errUnavailable
+}
+
+// Apply hands a stored event to the active manager, if any.
+func Apply(evt nostr.Event) {
+	if m := current(); m != nil {
+		m.Apply(evt)
+	}
+}
+
+// Ban bans id on the active manager.
+func Ban(id, reason string) error {
+	if m := current(); m != nil {
+		return m.Ban(id, reason)
+	}
+	return errUnavailable
+}
+
+// Allow allows id on the active manager.
+func Allow(id string) error {
+	if m := current(); m != nil {
+		return m.Allow(id)
+	}
+	return errUnavailable
+}
+
+// ListBanned returns the active manager's banned events, or none.
+func ListBanned() []BannedEvent {
+	if m := current(); m != nil {
+		return m.Banned()
+	}
+	return []BannedEvent{}
+}
+
+// ListQueue returns the active manager's queue, or none.
+func ListQueue() []QueuedEvent {
+	if m := current(); m != nil {
+		return m.Queue()
+	}
+	return []QueuedEvent{}
+}
+
+// ListReports returns the active manager's reported targets, or none.
+func ListReports(q ReportQuery) []ReportSummary {
+	if m := current(); m != nil {
+		return m.Reports(q)
+	}
+	return []ReportSummary{}
+}
+
+// DismissReports dismisses target's reports on the active manager.
+func DismissReports(target string) error {
+	if m := current(); m != nil {
+		return m.DismissReports(target)
+	}
+	return errUnavailable
+}
@@ -0,0 +1,307 @@
+package moderation
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	cfgType "github.com/0ceanslim/grain/config/types"
+	nostr "github.com/0ceanslim/grain/server/types"
+)
+
+// memStore is a Store over a map.
+type memStore struct {
+	events    map[string]nostr.Event
+	published []string
+	// during, if set, runs inside every Delete and Publish.
+	during func()
+}
+
+func newMemStore() *memStore { return &memStore{events: map[string]nostr.Event{}} }
+
+func (s *memStore) Get(id string) *nostr.Event {
+	if evt, ok := s.events[id]; ok {
+		return &evt
+	}
+	return nil
+}
+
+func (s *memStore) Delete(id string) error {
+	if s.during != nil {
+		s.during()
+	}
+	delete(s.events, id)
+	return nil
+}
+
+func (s *memStore) Publish(evt nostr.Event) error {
+	if s.during != nil {
+		s.during()
+	}
+	s.events[evt.ID] = evt
+	s.published = append(s.published, evt.ID)
+	return nil
+}
+
+func id(c string) string { return strings.Repeat(c, 64) }
+
+func TestManager_HoldAllowBan(t *testing.T) {
+	store := newMemStore()
+	path := filepath.Join(t.TempDir(), "moderation.json")
+	m, err := Open(cfgType.ModerationConfig{HoldBanWords: true}, store, path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	held := nostr.Event{ID: id("a"), PubKey: id("1"), Kind: 1, Content: "bad word"}
+	if err := m.Hold(held, SourceBanWord, "ban word: bad"); err != nil {
+		t.Fatal(err)
+	}
+	if q := m.Queue(); len(q) != 1 || q[0].Source != SourceBanWord || q[0].Event == nil {
+		t.Fatalf("queue = %+v", q)
+	}
+	if store.Get(held.ID) != nil {
+		t.Fatal("held event was stored")
+	}
+
+	// allowevent publishes a held event and clears it.
+	if err := m.Allow(held.ID); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.published) != 1 || len(m.Queue()) != 0 {
+		t.Fatalf("published %v, queue %+v", store.published, m.Queue())
+	}
+
+	// banevent deletes and remembers.
+	if err := m.Ban(held.ID, "spam"); err != nil {
+		t.Fatal(err)
+	}
+	if store.Get(held.ID) != nil || !m.IsBanned(held.ID) {
+		t.Fatal("banned event still stored, or not remembered")
+	}
+
+	// State survives a reopen.
+	m2, err := Open(cfgType.ModerationConfig{}, store, path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := m2.Banned(); len(b) != 1 || b[0].ID != held.ID || b[0].Reason != "spam" {
+		t.Fatalf("banned after reopen = %+v", b)
+	}
+
+	// allowevent on a banned id lifts the ban; on anything else it's
+	// an error.
+	if err := m2.Allow(held.ID); err != nil || m2.IsBanned(held.ID) {
+		t.Fatalf("unban: %v, still banned %v", err, m2.IsBanned(held.ID))
+	}
+	if err := m2.Allow(id("f")); err == nil {
+		t.Fatal("allowevent on an unknown id should fail")
+	}
+}
+
+func TestManager_ReportsFlagStoredEvents(t *testing.T) {
+	store := newMemStore()
+	target := nostr.Event{ID: id("b"), Kind: 1}
+	store.events[target.ID] = target
+	m, err := Open(cfgType.ModerationConfig{QueueReports: true}, store, filepath.Join(t.TempDir(), "moderation.json"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.Apply(nostr.Event{ID: id("c"), Kind: KindReport, Tags: [][]string{
+		{"e", target.ID, "spam"},
+		{"e", id("d"), "illegal"}, // not on this relay: ignored
+		{"p", id("1")},
+	}})
+	q := m.Queue()
+	if len(q) != 1 || q[0].ID != target.ID || q[0].Reason != "reported: spam" || q[0].Source != SourceReport || q[0].Event != nil {
+		t.Fatalf("queue = %+v", q)
+	}
+
+	// Allowing a flagged event only clears the flag.
+	if err := m.Allow(target.ID); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.published) != 0 || len(m.Queue()) != 0 || store.Get(target.ID) == nil {
+		t.Fatalf("published %v, queue %+v", store.published, m.Queue())
+	}
+
+	// With queue_reports off, reports are just stored.
+	off, _ := Open(cfgType.ModerationConfig{}, store, filepath.Join(t.TempDir(), "moderation.json"), nil)
+	off.Apply(nostr.Event{ID: id("e"), Kind: KindReport, Tags: [][]string{{"e", target.ID}}})
+	if len(off.Queue()) != 0 {
+		t.Fatal("report queued with queue_reports off")
+	}
+}
+
+func TestManager_QueueCap(t *testing.T) {
+	m, err := Open(cfgType.ModerationConfig{MaxQueue: 1}, newMemStore(), filepath.Join(t.TempDir(), "moderation.json"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Hold(nostr.Event{ID: id("a")}, SourceBanWord, "x"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Hold(nostr.Event{ID: id("b")}, SourceBanWord, "x"); err == nil {
+		t.Fatal("expected a full queue to refuse another held event")
+	}
+}
+
+func TestManager_ReportIndexAndQuarantine(t *testing.T) {
+	store := newMemStore()
+	target := nostr.Event{ID: id("b"), PubKey: id("9"), Kind: 1}
+	store.events[target.ID] = target
+	trusted := map[string]bool{id("1"): true, id("2"): true}
+	path := filepath.Join(t.TempDir(), "moderation.json")
+	m, err := Open(cfgType.ModerationConfig{QuarantineThreshold: 2}, store, path, func(pk string) bool { return trusted[pk] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := func(reportID, reporter string, tags ...[]string) {
+		m.Apply(nostr.Event{ID: reportID, PubKey: reporter, Kind: KindReport, Tags: tags})
+	}
+
+	// Untrusted reports, and a trusted reporter reporting twice, don't
+	// reach the threshold.
+	report(id("c"), id("7"), []string{"e", target.ID, "spam"}, []string{"p", target.PubKey})
+	report(id("d"), id("8"), []string{"e", target.ID, "spam"})
+	report(id("e"), id("1"), []string{"e", target.ID, "spam"})
+	report(id("f"), id("1"), []string{"e", target.ID, "illegal"})
+	s := m.Reports(ReportQuery{})
+	if len(s) != 1 || s[0].TargetType != TargetEvent || s[0].Count != 3 || s[0].TrustedReporters != 1 || s[0].Quarantined {
+		t.Fatalf("reports = %+v", s)
+	}
+	if s[0].Types["spam"] != 2 || s[0].Types["illegal"] != 1 {
+		t.Fatalf("types = %v", s[0].Types)
+	}
+
+	// The second trusted reporter quarantines it: out of the store,
+	// held in the queue.
+	report(id("0"), id("2"), []string{"e", target.ID, "spam"})
+	if !m.IsQuarantined(target) || store.Get(target.ID) != nil {
+		t.Fatal("event not quarantined")
+	}
+	if q := m.Queue(); len(q) != 1 || q[0].Source != SourceQuarantine || q[0].Event == nil {
+		t.Fatalf("queue = %+v", q)
+	}
+
+	// A report without e tags is about the p-tagged pubkey.
+	author := nostr.Event{ID: id("a"), PubKey: id("5")}
+	report(id("3"), id("1"), []string{"p", author.PubKey, "impersonation"})
+	report(id("4"), id("2"), []string{"p", author.PubKey})
+	if !m.IsQuarantined(author) {
+		t.Fatal("pubkey not quarantined")
+	}
+	if s := m.Reports(ReportQuery{TargetType: TargetPubkey}); len(s) != 1 || s[0].Types["other"] != 1 {
+		t.Fatalf("pubkey reports = %+v", s)
+	}
+	if s := m.Reports(ReportQuery{Type: "illegal"}); len(s) != 1 || s[0].Target != target.ID {
+		t.Fatalf("type filter = %+v", s)
+	}
+
+	// The index survives a reopen once Close has written the pending
+	// save; dismissing puts the event back.
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	m2, err := Open(cfgType.ModerationConfig{QuarantineThreshold: 2}, store, path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := m2.Reports(ReportQuery{Quarantined: true}); len(s) != 2 {
+		t.Fatalf("after reopen = %+v", s)
+	}
+	if err := m2.DismissReports(target.ID); err != nil {
+		t.Fatal(err)
+	}
+	if store.Get(target.ID) == nil || m2.IsQuarantined(target) || len(m2.Queue()) != 0 {
+		t.Fatal("dismissed event not restored")
+	}
+	if err := m2.DismissReports(author.PubKey); err != nil || m2.IsQuarantined(author) {
+		t.Fatalf("dismiss pubkey: %v", err)
+	}
+	if err := m2.DismissReports(id("6")); err == nil {
+		t.Fatal("dismissing an unreported target should fail")
+	}
+}
+
+func TestManager_StoreWorkOutsideLock(t *testing.T) {
+	store := newMemStore()
+	path := filepath.Join(t.TempDir(), "moderation.json")
+	trusted := func(string) bool { return true }
+	m, err := Open(cfgType.ModerationConfig{QuarantineThreshold: 1}, store, path, trusted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	store.during = func() {
+		calls++
+		if !m.mu.TryLock() {
+			t.Error("store called with mu held")
+			return
+		}
+		m.mu.Unlock()
+	}
+
+	held := nostr.Event{ID: id("a"), PubKey: id("1"), Kind: 1}
+	if err := m.Hold(held, SourceBanWord, "ban word: x"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Allow(held.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Ban(held.ID, "spam"); err != nil {
+		t.Fatal(err)
+	}
+	reported := nostr.Event{ID: id("b"), PubKey: id("2"), Kind: 1}
+	store.events[reported.ID] = reported
+	m.Apply(nostr.Event{ID: id("c"), PubKey: id("3"), Kind: KindReport, Tags: [][]string{{"e", reported.ID}}})
+	if err := m.DismissReports(reported.ID); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 4 {
+		t.Fatalf("store called %d times, want 4 (publish, delete, quarantine, restore)", calls)
+	}
+}
+
+func TestManager_ReportSaves(t *testing.T) {
+	old := reportSaveDelay
+	t.Cleanup(func() { reportSaveDelay = old })
+	reportSaveDelay = time.Hour
+
+	path := filepath.Join(t.TempDir(), "moderation.json")
+	m, err := Open(cfgType.ModerationConfig{}, newMemStore(), path, func(pk string) bool { return pk == id("1") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := func(reportID, reporter string) {
+		m.Apply(nostr.Event{ID: reportID, PubKey: reporter, Kind: KindReport, Tags: [][]string{{"p", id("9")}}})
+	}
+
+	// Untrusted reports stay in memory.
+	report(id("a"), id("2"))
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("untrusted report was written to disk: %v", err)
+	}
+
+	// Trusted ones are saved, but not on the EVENT path.
+	report(id("b"), id("1"))
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("trusted report saved synchronously: %v", err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	m2, err := Open(cfgType.ModerationConfig{}, newMemStore(), path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := m2.Reports(ReportQuery{}); len(s) != 1 || s[0].Count != 2 {
+		t.Fatalf("after reopen = %+v", s)
+	}
+}
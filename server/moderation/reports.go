@@ -0,0 +1,337 @@
+package moderation
+
+import (
+	"sort"
+	"time"
+
+	nostr "github.com/0ceanslim/grain/server/types"
+	"github.com/0ceanslim/grain/server/utils/log"
+)
+
+// What a report is about.
+const (
+	TargetEvent  = "event"
+	TargetPubkey = "pubkey"
+)
+
+// Index limits. A target past maxReportsPerTarget drops its oldest
+// untrusted report; past maxReportTargets, the least recently
+// reported target that isn't quarantined goes.
+const (
+	maxReportsPerTarget = 200
+	maxReportTargets    = 10000
+)
+
+// Report is one NIP-56 report of a target. A reporter reporting the
+// same target again replaces their earlier report.
+type Report struct {
+	ID        string `json:"id"` // the kind 1984 event
+	Reporter  string `json:"reporter"`
+	Type      string `json:"type"` // nudity, malware, spam, ... ("other" when unset)
+	CreatedAt int64  `json:"created_at"`
+	// Trusted is whether the reporter was a relay admin or
+	// whitelisted when the report came in. Only trusted reports count
+	// towards quarantine_threshold.
+	Trusted bool `json:"trusted"`
+}
+
+// reportTarget is everything reported about one event or pubkey.
+type reportTarget struct {
+	Target        string   `json:"target"`
+	TargetType    string   `json:"target_type"`
+	Reports       []Report `json:"reports"`
+	LastReportAt  int64    `json:"last_report_at"`
+	Quarantined   bool     `json:"quarantined,omitempty"`
+	QuarantinedAt int64    `json:"quarantined_at,omitempty"`
+}
+
+// ReportSummary is one entry of grain_listreports.
+type ReportSummary struct {
+	Target           string         `json:"target"`
+	TargetType       string         `json:"target_type"`
+	Count            int            `json:"count"`
+	TrustedReporters int            `json:"trusted_reporters"`
+	Types            map[string]int `json:"types"`
+	LastReportAt     int64          `json:"last_report_at"`
+	Quarantined      bool           `json:"quarantined"`
+	QuarantinedAt    int64          `json:"quarantined_at,omitempty"`
+	Reports          []Report       `json:"reports"`
+}
+
+// ReportQuery filters grain_listreports. Zero values match everything.
+type ReportQuery struct {
+	TargetType  string `json:"target_type"`
+	Type        string `json:"type"`
+	Quarantined bool   `json:"quarantined"` // only quarantined targets
+	Limit       int    `json:"limit"`
+}
+
+// reportTargets reads a kind 1984 event's targets. Per NIP-56 a report
+// with e tags is about those events (its p tag is just the author);
+// without, it's about the p-tagged pubkeys. The report type is the
+// tag's third element.
+func reportTargets(evt nostr.Event) (targetType string, targets map[string]string) {
+	collect := func(name string) map[string]string {
+		out := make(map[string]string)
+		for _, tag := range evt.Tags {
+			if len(tag) < 2 || tag[0] != name || !isHex64(tag[1]) {
+				continue
+			}
+			typ := "other"
+			if len(tag) >= 3 && tag[2] != "" {
+				typ = tag[2]
+			}
+			out[tag[1]] = typ
+		}
+		return out
+	}
+	if events := collect("e"); len(events) > 0 {
+		return TargetEvent, events
+	}
+	return TargetPubkey, collect("p")
+}
+
+func isHex64(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// ingestReport indexes a stored report and quarantines any target it
+// pushes over quarantine_threshold.
+func (m *Manager) ingestReport(evt nostr.Event) {
+	targetType, targets := reportTargets(evt)
+	if len(targets) == 0 {
+		return
+	}
+	trusted := m.trusted != nil && m.trusted(evt.PubKey)
+
+	// Quarantining an event reads it from the store, which must
+	// happen outside mu; look the targets up first.
+	stored := make(map[string]*nostr.Event)
+	if targetType == TargetEvent && m.cfg.QuarantineThreshold > 0 && trusted {
+		for id := range targets {
+			stored[id] = m.store.Get(id)
+		}
+	}
+
+	m.mu.Lock()
+	var quarantined []string
+	for target, typ := range targets {
+		t := m.reports[target]
+		if t == nil {
+			m.evictReportTarget()
+			t = &reportTarget{Target: target, TargetType: targetType}
+			m.reports[target] = t
+		}
+		t.add(Report{ID: evt.ID, Reporter: evt.PubKey, Type: typ, CreatedAt: evt.CreatedAt, Trusted: trusted})
+		t.LastReportAt = time.Now().Unix()
+
+		if t.Quarantined || m.cfg.QuarantineThreshold <= 0 || t.trustedReporters() < m.cfg.QuarantineThreshold {
+			continue
+		}
+		t.Quarantined = true
+		t.QuarantinedAt = time.Now().Unix()
+		log.Moderation().Warn("Report target quarantined",
+			"target", target,
+			"target_type", targetType,
+			"trusted_reporters", t.trustedReporters())
+		// Not stored (yet) means HandleEvent holds it if it shows up.
+		if targetType == TargetEvent && stored[target] != nil {
+			quarantined = append(quarantined, target)
+		}
+	}
+	m.mu.Unlock()
+
+	// Untrusted reports are only indexed in memory: they can't
+	// quarantine anything, and a flood of them mustn't turn into a
+	// flood of writes. They go to disk with the next save.
+	if trusted {
+		m.saveSoon()
+	}
+	for _, id := range quarantined {
+		m.quarantineEvent(id, *stored[id])
+	}
+}
+
+// quarantineEvent takes a stored event out of the database and holds
+// it in the queue, so allowevent can put it back. The database work
+// happens outside mu; IsQuarantined already keeps a re-publish out.
+func (m *Manager) quarantineEvent(id string, evt nostr.Event) {
+	if err := m.store.Delete(id); err != nil {
+		log.Moderation().Error("Failed to remove quarantined event", "event_id", id, "error", err)
+		return
+	}
+
+	m.mu.Lock()
+	t := m.reports[id]
+	lifted := t == nil || !t.Quarantined
+	if !lifted {
+		m.queue[id] = &QueuedEvent{
+			ID:        id,
+			Reason:    "quarantined: reported",
+			Source:    SourceQuarantine,
+			FlaggedAt: time.Now().Unix(),
+			Event:     &evt,
+		}
+	}
+	m.mu.Unlock()
+	// Straight away: the queue is the event's only copy now.
+	if err := m.save(); err != nil {
+		log.Moderation().Error("Failed to save moderation state", "error", err)
+	}
+
+	// Dismissed while it was being deleted: put it back.
+	if lifted {
+		if err := m.store.Publish(evt); err != nil {
+			log.Moderation().Error("Failed to restore event after its quarantine was lifted", "event_id", id, "error", err)
+		}
+	}
+}
+
+// add records r, replacing the reporter's earlier report and keeping
+// the list under maxReportsPerTarget.
+func (t *reportTarget) add(r Report) {
+	for i, old := range t.Reports {
+		if old.Reporter == r.Reporter {
+			t.Reports = append(t.Reports[:i], t.Reports[i+1:]...)
+			break
+		}
+	}
+	t.Reports = append(t.Reports, r)
+	if len(t.Reports) <= maxReportsPerTarget {
+		return
+	}
+	drop := 0
+	for i, old := range t.Reports {
+		if !old.Trusted {
+			drop = i
+			break
+		}
+	}
+	t.Reports = append(t.Reports[:drop], t.Reports[drop+1:]...)
+}
+
+func (t *reportTarget) trustedReporters() int {
+	n := 0
+	for _, r := range t.Reports {
+		if r.Trusted {
+			n++
+		}
+	}
+	return n
+}
+
+func (t *reportTarget) summary() ReportSummary {
+	s := ReportSummary{
+		Target:           t.Target,
+		TargetType:       t.TargetType,
+		Count:            len(t.Reports),
+		TrustedReporters: t.trustedReporters(),
+		Types:            make(map[string]int),
+		LastReportAt:     t.LastReportAt,
+		Quarantined:      t.Quarantined,
+		QuarantinedAt:    t.QuarantinedAt,
+		Reports:          append([]Report(nil), t.Reports...),
+	}
+	for _, r := range t.Reports {
+		s.Types[r.Type]++
+	}
+	return s
+}
+
+// evictReportTarget makes room for one more target. Caller holds mu.
+func (m *Manager) evictReportTarget() {
+	if len(m.reports) < maxReportTargets {
+		return
+	}
+	var oldest *reportTarget
+	for _, t := range m.reports {
+		if !t.Quarantined && (oldest == nil || t.LastReportAt < oldest.LastReportAt) {
+			oldest = t
+		}
+	}
+	if oldest != nil {
+		delete(m.reports, oldest.Target)
+	}
+}
+
+// IsQuarantined reports whether evt, or its author, is quarantined.
+func (m *Manager) IsQuarantined(evt nostr.Event) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, key := range []string{evt.ID, evt.PubKey} {
+		if t, ok := m.reports[key]; ok && t.Quarantined {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports lists the reported targets matching q: most trusted
+// reporters first, then most recently reported.
+func (m *Manager) Reports(q ReportQuery) []ReportSummary {
+	m.mu.RLock()
+	out := make([]ReportSummary, 0, len(m.reports))
+	for _, t := range m.reports {
+		if q.TargetType != "" && t.TargetType != q.TargetType {
+			continue
+		}
+		if q.Quarantined && !t.Quarantined {
+			continue
+		}
+		s := t.summary()
+		if q.Type != "" && s.Types[q.Type] == 0 {
+			continue
+		}
+		out = append(out, s)
+	}
+	m.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].TrustedReporters != out[j].TrustedReporters {
+			return out[i].TrustedReporters > out[j].TrustedReporters
+		}
+		if out[i].LastReportAt != out[j].LastReportAt {
+			return out[i].LastReportAt > out[j].LastReportAt
+		}
+		return out[i].Target < out[j].Target
+	})
+	if q.Limit > 0 && len(out) > q.Limit {
+		out = out[:q.Limit]
+	}
+	return out
+}
+
+// DismissReports forgets the reports about target and lifts its
+// quarantine. A quarantined event goes back into the database.
+func (m *Manager) DismissReports(target string) error {
+	m.mu.RLock()
+	_, reported := m.reports[target]
+	q, queued := m.queue[target]
+	m.mu.RUnlock()
+	if !reported {
+		return errNotReported
+	}
+	// Stored before it leaves the queue, so a failure leaves it there.
+	quarantined := queued && q.Source == SourceQuarantine && q.Event != nil
+	if quarantined {
+		if err := m.store.Publish(*q.Event); err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	if quarantined {
+		delete(m.queue, target)
+	}
+	delete(m.reports, target)
+	m.mu.Unlock()
+	log.Moderation().Info("Reports dismissed", "target", target)
+	return m.save()
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"context"
+
+	"github.com/0ceanslim/grain/server/db/nostrdb"
+	"github.com/0ceanslim/grain/server/fulltext"
+	"github.com/0ceanslim/grain/server/groups"
+	"github.com/0ceanslim/grain/server/replication"
+	nostr "github.com/0ceanslim/grain/server/types"
+)
+
+// moderationStore backs moderation.Store with nostrdb and the same
+// post-store steps HandleEvent runs.
+type moderationStore struct {
+	db *nostrdb.NDB
+}
+
+func (s moderationStore) Get(id string) *nostr.Event {
+	txn, err := s.db.BeginQuery()
+	if err != nil {
+		return nil
+	}
+	defer txn.EndQuery()
+	evt, err := txn.GetNoteByID(id)
+	if err != nil {
+		return nil
+	}
+	return evt
+}
+
+func (s moderationStore) Delete(id string) error {
+	idBytes, err := hexToBytes32(id)
+	if err != nil {
+		return err
+	}
+	var id32 [32]byte
+	copy(id32[:], idBytes)
+	return s.db.DeleteNoteByID(id32)
+}
+
+// Publish stores an event an admin let out of the moderation queue.
+// It already passed every HandleEvent check when it was held.
+func (s moderationStore) Publish(evt nostr.Event) error {
+	if err := s.db.StoreEvent(context.TODO(), evt); err != nil {
+		return err
+	}
+	fulltext.Add(evt)
+	BroadcastEvent(evt)
+	groups.Apply(evt)
+	replication.Enqueue(evt)
+	return nil
+}
@@ -0,0 +1,389 @@
+// Package negentropy implements the range-based set reconciliation
+// protocol used by NIP-77 (protocol version 1, byte 0x61). Both sides
+// hold a sorted set of (created_at, id) items; they exchange
+// fingerprints over sub-ranges of that set and recurse only into the
+// ranges whose fingerprints disagree, so two relays that mostly agree
+// learn which IDs differ without transferring the events themselves.
+//
+// The encoding follows the reference implementation at
+// https://github.com/hoytech/negentropy — messages produced here
+// interoperate with strfry, nostr-tools and go-nostr peers. The relay
+// is always the responder (Reconcile); the initiator side
+// (Initiate/ReconcileWithIDs) exists for the pull-sync CLI and tests.
+package negentropy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+)
+
+const (
+	// ProtocolVersion is the first byte of every negentropy message.
+	// Versions 0x60..0x6f are reserved for the protocol; a responder
+	// that receives an unknown version replies with its own version
+	// byte alone so the initiator can downgrade or give up.
+	ProtocolVersion byte = 0x61
+
+	// IDSize is the byte length of an item ID (a nostr event id).
+	IDSize = 32
+
+	// FingerprintSize is the truncated SHA-256 length carried on the
+	// wire for fingerprint ranges.
+	FingerprintSize = 16
+
+	// MaxTimestamp is the "infinity" bound that closes the last range.
+	MaxTimestamp uint64 = math.MaxUint64
+
+	// MinFrameSizeLimit is the smallest non-zero frame size limit the
+	// reference implementation accepts; anything lower can't fit a
+	// useful batch of IDs plus the trailing fingerprint.
+	MinFrameSizeLimit = 4096
+
+	modeSkip        = 0
+	modeFingerprint = 1
+	modeIDList      = 2
+
+	// buckets is the fan-out used when splitting a range whose
+	// fingerprints disagreed. Ranges smaller than 2*buckets are sent
+	// as a plain ID list instead.
+	buckets = 16
+)
+
+// Negentropy is one side of a reconciliation session. It is not safe
+// for concurrent use; the relay keeps one per NEG-OPEN subscription.
+type Negentropy struct {
+	storage        *Vector
+	frameSizeLimit int
+	isInitiator    bool
+
+	lastTimestampIn  uint64
+	lastTimestampOut uint64
+}
+
+// New wraps a sealed storage vector. frameSizeLimit bounds the size of
+// each outgoing message in bytes (before hex encoding); 0 disables the
+// limit.
+func New(storage *Vector, frameSizeLimit int) (*Negentropy, error) {
+	if storage == nil || !storage.sealed {
+		return nil, errors.New("negentropy storage must be sealed")
+	}
+	if frameSizeLimit != 0 && frameSizeLimit < MinFrameSizeLimit {
+		return nil, fmt.Errorf("frame size limit must be 0 or at least %d", MinFrameSizeLimit)
+	}
+	return &Negentropy{storage: storage, frameSizeLimit: frameSizeLimit}, nil
+}
+
+// Initiate builds the first message of a session. Only the initiator
+// calls this; the relay never does.
+func (n *Negentropy) Initiate() ([]byte, error) {
+	if n.isInitiator {
+		return nil, errors.New("already initiated")
+	}
+	n.isInitiator = true
+
+	n.lastTimestampOut = 0
+	out := []byte{ProtocolVersion}
+	out = append(out, n.splitRange(0, n.storage.Size(), Bound{Item: Item{Timestamp: MaxTimestamp}})...)
+	return out, nil
+}
+
+// Reconcile processes a message from the initiator and returns the
+// response to send back. This is the responder (relay) side.
+func (n *Negentropy) Reconcile(query []byte) ([]byte, error) {
+	if n.isInitiator {
+		return nil, errors.New("initiator must use ReconcileWithIDs")
+	}
+	out, _, _, err := n.reconcileAux(query)
+	return out, err
+}
+
+// ReconcileWithIDs processes a responder message on the initiator side.
+// have holds IDs the initiator has and the responder lacks; need holds
+// IDs the responder has and the initiator lacks. A nil output with a
+// nil error means reconciliation is complete.
+func (n *Negentropy) ReconcileWithIDs(query []byte) (out []byte, have, need [][IDSize]byte, err error) {
+	if !n.isInitiator {
+		return nil, nil, nil, errors.New("non-initiator asking for have/need IDs")
+	}
+	out, have, need, err = n.reconcileAux(query)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(out) == 1 {
+		// Only the version byte: nothing left to ask about.
+		out = nil
+	}
+	return out, have, need, nil
+}
+
+func (n *Negentropy) reconcileAux(query []byte) (full []byte, have, need [][IDSize]byte, err error) {
+	n.lastTimestampIn = 0
+	n.lastTimestampOut = 0
+
+	r := &reader{buf: query}
+	full = []byte{ProtocolVersion}
+
+	version, err := r.byte()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if version < 0x60 || version > 0x6f {
+		return nil, nil, nil, errors.New("invalid negentropy protocol version byte")
+	}
+	if version != ProtocolVersion {
+		if n.isInitiator {
+			return nil, nil, nil, fmt.Errorf("unsupported negentropy protocol version requested: 0x%x", version)
+		}
+		return full, nil, nil, nil
+	}
+
+	storageSize := n.storage.Size()
+	var prevBound Bound
+	prevIndex := 0
+	skip := false
+
+	for r.len() > 0 {
+		var o []byte
+
+		doSkip := func() {
+			if skip {
+				skip = false
+				o = append(o, n.encodeBound(prevBound)...)
+				o = append(o, encodeVarInt(modeSkip)...)
+			}
+		}
+
+		currBound, err := n.decodeBound(r)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		mode, err := r.varint()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		lower := prevIndex
+		upper := n.storage.findLowerBound(prevIndex, storageSize, currBound)
+
+		switch mode {
+		case modeSkip:
+			skip = true
+
+		case modeFingerprint:
+			theirs, err := r.bytes(FingerprintSize)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			ours := n.storage.Fingerprint(lower, upper)
+			if !bytes.Equal(theirs, ours[:]) {
+				doSkip()
+				o = append(o, n.splitRange(lower, upper, currBound)...)
+			} else {
+				skip = true
+			}
+
+		case modeIDList:
+			numIDs, err := r.varint()
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			if numIDs > uint64(r.len()/IDSize) {
+				return nil, nil, nil, errors.New("id list longer than message")
+			}
+
+			theirs := make(map[[IDSize]byte]struct{}, numIDs)
+			for i := uint64(0); i < numIDs; i++ {
+				raw, err := r.bytes(IDSize)
+				if err != nil {
+					return nil, nil, nil, err
+				}
+				if n.isInitiator {
+					var id [IDSize]byte
+					copy(id[:], raw)
+					theirs[id] = struct{}{}
+				}
+			}
+
+			if n.isInitiator {
+				skip = true
+				for _, item := range n.storage.items[lower:upper] {
+					if _, ok := theirs[item.ID]; ok {
+						delete(theirs, item.ID)
+					} else {
+						have = append(have, item.ID)
+					}
+				}
+				for id := range theirs {
+					need = append(need, id)
+				}
+			} else {
+				doSkip()
+
+				var responseIDs []byte
+				numResponseIDs := 0
+				endBound := currBound
+
+				for i := lower; i < upper; i++ {
+					if n.exceededFrameSizeLimit(len(full) + len(responseIDs)) {
+						endBound = Bound{Item: n.storage.items[i], IDLen: IDSize}
+						upper = i
+						break
+					}
+					responseIDs = append(responseIDs, n.storage.items[i].ID[:]...)
+					numResponseIDs++
+				}
+
+				o = append(o, n.encodeBound(endBound)...)
+				o = append(o, encodeVarInt(modeIDList)...)
+				o = append(o, encodeVarInt(uint64(numResponseIDs))...)
+				o = append(o, responseIDs...)
+
+				full = append(full, o...)
+				o = nil
+			}
+
+		default:
+			return nil, nil, nil, fmt.Errorf("unexpected mode %d", mode)
+		}
+
+		if n.exceededFrameSizeLimit(len(full) + len(o)) {
+			// Out of room: stop here and hand the initiator a single
+			// fingerprint over everything we didn't get to. It will
+			// recurse into that range on the next round trip.
+			remaining := n.storage.Fingerprint(upper, storageSize)
+			full = append(full, n.encodeBound(Bound{Item: Item{Timestamp: MaxTimestamp}})...)
+			full = append(full, encodeVarInt(modeFingerprint)...)
+			full = append(full, remaining[:]...)
+			break
+		}
+		full = append(full, o...)
+
+		prevIndex = upper
+		prevBound = currBound
+	}
+
+	return full, have, need, nil
+}
+
+// splitRange encodes [lower, upper) either as an ID list (small
+// ranges) or as `buckets` fingerprint sub-ranges.
+func (n *Negentropy) splitRange(lower, upper int, upperBound Bound) []byte {
+	var o []byte
+	numElems := upper - lower
+
+	if numElems < buckets*2 {
+		o = append(o, n.encodeBound(upperBound)...)
+		o = append(o, encodeVarInt(modeIDList)...)
+		o = append(o, encodeVarInt(uint64(numElems))...)
+		for _, item := range n.storage.items[lower:upper] {
+			o = append(o, item.ID[:]...)
+		}
+		return o
+	}
+
+	itemsPerBucket := numElems / buckets
+	bucketsWithExtra := numElems % buckets
+	curr := lower
+
+	for i := 0; i < buckets; i++ {
+		bucketSize := itemsPerBucket
+		if i < bucketsWithExtra {
+			bucketSize++
+		}
+		fp := n.storage.Fingerprint(curr, curr+bucketSize)
+		curr += bucketSize
+
+		var nextBound Bound
+		if curr == upper {
+			nextBound = upperBound
+		} else {
+			nextBound = minimalBound(n.storage.items[curr-1], n.storage.items[curr])
+		}
+
+		o = append(o, n.encodeBound(nextBound)...)
+		o = append(o, encodeVarInt(modeFingerprint)...)
+		o = append(o, fp[:]...)
+	}
+
+	return o
+}
+
+func (n *Negentropy) exceededFrameSizeLimit(size int) bool {
+	return n.frameSizeLimit != 0 && size > n.frameSizeLimit-200
+}
+
+// Timestamps are delta-encoded against the previous bound in the same
+// message, offset by one so that 0 can mean infinity.
+
+func (n *Negentropy) encodeTimestampOut(ts uint64) []byte {
+	if ts == MaxTimestamp {
+		n.lastTimestampOut = MaxTimestamp
+		return encodeVarInt(0)
+	}
+	delta := ts - n.lastTimestampOut
+	n.lastTimestampOut = ts
+	return encodeVarInt(delta + 1)
+}
+
+func (n *Negentropy) decodeTimestampIn(r *reader) (uint64, error) {
+	ts, err := r.varint()
+	if err != nil {
+		return 0, err
+	}
+	if ts == 0 {
+		ts = MaxTimestamp
+	} else {
+		ts--
+	}
+	if n.lastTimestampIn == MaxTimestamp || ts == MaxTimestamp {
+		n.lastTimestampIn = MaxTimestamp
+		return MaxTimestamp, nil
+	}
+	ts += n.lastTimestampIn
+	n.lastTimestampIn = ts
+	return ts, nil
+}
+
+func (n *Negentropy) encodeBound(b Bound) []byte {
+	o := n.encodeTimestampOut(b.Item.Timestamp)
+	o = append(o, encodeVarInt(uint64(b.IDLen))...)
+	return append(o, b.Item.ID[:b.IDLen]...)
+}
+
+func (n *Negentropy) decodeBound(r *reader) (Bound, error) {
+	ts, err := n.decodeTimestampIn(r)
+	if err != nil {
+		return Bound{}, err
+	}
+	idLen, err := r.varint()
+	if err != nil {
+		return Bound{}, err
+	}
+	if idLen > IDSize {
+		return Bound{}, errors.New("bound key too long")
+	}
+	raw, err := r.bytes(int(idLen))
+	if err != nil {
+		return Bound{}, err
+	}
+	b := Bound{Item: Item{Timestamp: ts}, IDLen: int(idLen)}
+	copy(b.Item.ID[:], raw)
+	return b, nil
+}
+
+// minimalBound returns the shortest bound that sorts after prev and at
+// or before curr, so split points cost as few bytes as possible.
+func minimalBound(prev, curr Item) Bound {
+	if curr.Timestamp != prev.Timestamp {
+		return Bound{Item: Item{Timestamp: curr.Timestamp}}
+	}
+	shared := 0
+	for shared < IDSize && curr.ID[shared] == prev.ID[shared] {
+		shared++
+	}
+	b := Bound{Item: Item{Timestamp: curr.Timestamp}, IDLen: shared + 1}
+	copy(b.Item.ID[:b.IDLen], curr.ID[:b.IDLen])
+	return b
+}
@@ -0,0 +1,203 @@
+package negentropy
+
+import (
+	"bytes"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestVarIntRoundTrip(t *testing.T) {
+	for _, n := range []uint64{0, 1, 127, 128, 255, 16383, 16384, 1 << 32, MaxTimestamp} {
+		r := &reader{buf: encodeVarInt(n)}
+		got, err := r.varint()
+		if err != nil {
+			t.Fatalf("varint(%d): %v", n, err)
+		}
+		if got != n || r.len() != 0 {
+			t.Errorf("varint(%d) round-tripped to %d with %d bytes left", n, got, r.len())
+		}
+	}
+}
+
+func TestSealRejectsDuplicates(t *testing.T) {
+	v := NewVector(2)
+	var id [IDSize]byte
+	v.Insert(1, id)
+	v.Insert(1, id)
+	if err := v.Seal(); err == nil {
+		t.Fatal("expected duplicate error")
+	}
+}
+
+func TestFingerprintOrderIndependent(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	items := randomItems(rng, 50)
+
+	a, b := NewVector(len(items)), NewVector(len(items))
+	for _, it := range items {
+		a.Insert(it.Timestamp, it.ID)
+	}
+	for i := len(items) - 1; i >= 0; i-- {
+		b.Insert(items[i].Timestamp, items[i].ID)
+	}
+	mustSeal(t, a)
+	mustSeal(t, b)
+
+	if a.Fingerprint(0, a.Size()) != b.Fingerprint(0, b.Size()) {
+		t.Fatal("fingerprint depends on insertion order")
+	}
+	if a.Fingerprint(0, 10) == a.Fingerprint(0, 11) {
+		t.Fatal("fingerprint ignored an extra item")
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	cases := []struct {
+		name                          string
+		shared, clientOnly, relayOnly int
+		frameSizeLimit                int
+	}{
+		{"both empty", 0, 0, 0, 0},
+		{"identical", 5000, 0, 0, 0},
+		{"client empty", 0, 0, 300, 0},
+		{"relay empty", 0, 300, 0, 0},
+		{"small diff", 20, 3, 4, 0},
+		{"large diff", 20000, 150, 200, 0},
+		{"frame limited", 20000, 2000, 3000, MinFrameSizeLimit},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rng := rand.New(rand.NewSource(42))
+			shared := randomItems(rng, tc.shared)
+			clientOnly := randomItems(rng, tc.clientOnly)
+			relayOnly := randomItems(rng, tc.relayOnly)
+
+			clientVec := NewVector(0)
+			relayVec := NewVector(0)
+			for _, it := range shared {
+				clientVec.Insert(it.Timestamp, it.ID)
+				relayVec.Insert(it.Timestamp, it.ID)
+			}
+			for _, it := range clientOnly {
+				clientVec.Insert(it.Timestamp, it.ID)
+			}
+			for _, it := range relayOnly {
+				relayVec.Insert(it.Timestamp, it.ID)
+			}
+			mustSeal(t, clientVec)
+			mustSeal(t, relayVec)
+
+			client, err := New(clientVec, tc.frameSizeLimit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			relay, err := New(relayVec, tc.frameSizeLimit)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			msg, err := client.Initiate()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var have, need [][IDSize]byte
+			for rounds := 0; msg != nil; rounds++ {
+				if rounds > 1000 {
+					t.Fatal("reconciliation did not converge")
+				}
+				if tc.frameSizeLimit != 0 && len(msg) > tc.frameSizeLimit {
+					t.Fatalf("client message %d bytes exceeds limit", len(msg))
+				}
+				resp, err := relay.Reconcile(msg)
+				if err != nil {
+					t.Fatalf("relay reconcile: %v", err)
+				}
+				if tc.frameSizeLimit != 0 && len(resp) > tc.frameSizeLimit {
+					t.Fatalf("relay message %d bytes exceeds limit", len(resp))
+				}
+				var h, n [][IDSize]byte
+				msg, h, n, err = client.ReconcileWithIDs(resp)
+				if err != nil {
+					t.Fatalf("client reconcile: %v", err)
+				}
+				have = append(have, h...)
+				need = append(need, n...)
+			}
+
+			assertSameIDs(t, "have", have, clientOnly)
+			assertSameIDs(t, "need", need, relayOnly)
+		})
+	}
+}
+
+func TestUnsupportedVersion(t *testing.T) {
+	v := NewVector(0)
+	mustSeal(t, v)
+	relay, _ := New(v, 0)
+
+	resp, err := relay.Reconcile([]byte{0x62})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(resp, []byte{ProtocolVersion}) {
+		t.Errorf("expected bare version byte, got %x", resp)
+	}
+
+	if _, err := relay.Reconcile([]byte{0x01}); err == nil {
+		t.Error("expected error for non-negentropy version byte")
+	}
+}
+
+func TestTruncatedMessage(t *testing.T) {
+	v := NewVector(0)
+	mustSeal(t, v)
+	relay, _ := New(v, 0)
+
+	// Version, bound (infinity, no id), fingerprint mode, then only 3
+	// of the 16 fingerprint bytes.
+	if _, err := relay.Reconcile([]byte{ProtocolVersion, 0, 0, modeFingerprint, 1, 2, 3}); err == nil {
+		t.Error("expected error for truncated fingerprint")
+	}
+}
+
+func randomItems(rng *rand.Rand, n int) []Item {
+	items := make([]Item, n)
+	for i := range items {
+		// Narrow timestamp range so plenty of items share a second and
+		// bounds have to fall back to id prefixes.
+		items[i].Timestamp = uint64(1700000000 + rng.Intn(n/4+1))
+		rng.Read(items[i].ID[:])
+	}
+	return items
+}
+
+func mustSeal(t *testing.T, v *Vector) {
+	t.Helper()
+	if err := v.Seal(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertSameIDs(t *testing.T, label string, got [][IDSize]byte, want []Item) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s: got %d ids, want %d", label, len(got), len(want))
+	}
+	sorted := func(ids [][IDSize]byte) [][IDSize]byte {
+		sort.Slice(ids, func(i, j int) bool { return bytes.Compare(ids[i][:], ids[j][:]) < 0 })
+		return ids
+	}
+	wantIDs := make([][IDSize]byte, len(want))
+	for i, it := range want {
+		wantIDs[i] = it.ID
+	}
+	got, wantIDs = sorted(got), sorted(wantIDs)
+	for i := range got {
+		if got[i] != wantIDs[i] {
+			t.Fatalf("%s: id mismatch at %d", label, i)
+		}
+	}
+}
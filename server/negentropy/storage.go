@@ -0,0 +1,142 @@
+package negentropy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/bits"
+	"sort"
+)
+
+// Item is one element of the reconciled set: an event's created_at and
+// its 32-byte id. Items sort by timestamp, then by id bytes.
+type Item struct {
+	Timestamp uint64
+	ID        [IDSize]byte
+}
+
+// Less reports whether a sorts before b.
+func (a Item) Less(b Item) bool {
+	if a.Timestamp != b.Timestamp {
+		return a.Timestamp < b.Timestamp
+	}
+	return bytes.Compare(a.ID[:], b.ID[:]) < 0
+}
+
+// Bound is a range boundary: a timestamp plus an id prefix of IDLen
+// bytes (the rest of Item.ID is zero).
+type Bound struct {
+	Item  Item
+	IDLen int
+}
+
+// Vector is an in-memory, sorted item store. Insert everything, Seal
+// once, then hand it to New. The relay builds one per NEG-OPEN from
+// the events matching the subscription filter.
+type Vector struct {
+	items  []Item
+	sealed bool
+}
+
+// NewVector returns an empty vector with room for sizeHint items.
+func NewVector(sizeHint int) *Vector {
+	return &Vector{items: make([]Item, 0, sizeHint)}
+}
+
+// Insert adds an item. It fails once the vector is sealed.
+func (v *Vector) Insert(timestamp uint64, id [IDSize]byte) error {
+	if v.sealed {
+		return errors.New("vector already sealed")
+	}
+	v.items = append(v.items, Item{Timestamp: timestamp, ID: id})
+	return nil
+}
+
+// InsertHex is Insert for a nostr event's created_at and hex id.
+func (v *Vector) InsertHex(createdAt int64, hexID string) error {
+	id, err := ParseID(hexID)
+	if err != nil {
+		return err
+	}
+	if createdAt < 0 {
+		return fmt.Errorf("negative created_at %d", createdAt)
+	}
+	return v.Insert(uint64(createdAt), id)
+}
+
+// Seal sorts the items and rejects duplicates. The vector is read-only
+// afterwards.
+func (v *Vector) Seal() error {
+	if v.sealed {
+		return errors.New("vector already sealed")
+	}
+	sort.Slice(v.items, func(i, j int) bool { return v.items[i].Less(v.items[j]) })
+	for i := 1; i < len(v.items); i++ {
+		if v.items[i] == v.items[i-1] {
+			return errors.New("duplicate item inserted")
+		}
+	}
+	v.sealed = true
+	return nil
+}
+
+// Size returns the number of items.
+func (v *Vector) Size() int {
+	return len(v.items)
+}
+
+// Fingerprint hashes items [begin, end): the 256-bit little-endian sum
+// of their ids followed by the varint item count, SHA-256'd and
+// truncated to FingerprintSize bytes.
+func (v *Vector) Fingerprint(begin, end int) [FingerprintSize]byte {
+	var acc accumulator
+	for _, item := range v.items[begin:end] {
+		acc.add(item.ID)
+	}
+	return acc.fingerprint(end - begin)
+}
+
+// findLowerBound returns the first index in [begin, end) whose item is
+// not below bound, or end.
+func (v *Vector) findLowerBound(begin, end int, bound Bound) int {
+	return begin + sort.Search(end-begin, func(i int) bool {
+		return !v.items[begin+i].Less(bound.Item)
+	})
+}
+
+// ParseID decodes a 64-char hex event id.
+func ParseID(hexID string) ([IDSize]byte, error) {
+	var id [IDSize]byte
+	if len(hexID) != IDSize*2 {
+		return id, fmt.Errorf("invalid id length %d", len(hexID))
+	}
+	if _, err := hex.Decode(id[:], []byte(hexID)); err != nil {
+		return id, fmt.Errorf("invalid id: %w", err)
+	}
+	return id, nil
+}
+
+// accumulator is a 256-bit little-endian integer; adding ids to it
+// modulo 2^256 makes the fingerprint independent of insertion order.
+type accumulator [IDSize]byte
+
+func (a *accumulator) add(id [IDSize]byte) {
+	var carry uint64
+	for i := 0; i < IDSize; i += 8 {
+		sum, c := bits.Add64(binary.LittleEndian.Uint64(a[i:]), binary.LittleEndian.Uint64(id[i:]), carry)
+		binary.LittleEndian.PutUint64(a[i:], sum)
+		carry = c
+	}
+}
+
+func (a *accumulator) fingerprint(n int) [FingerprintSize]byte {
+	h := sha256.New()
+	h.Write(a[:])
+	h.Write(encodeVarInt(uint64(n)))
+	var fp [FingerprintSize]byte
+	copy(fp[:], h.Sum(nil))
+	return fp
+}
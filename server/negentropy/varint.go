@@ -0,0 +1,67 @@
+package negentropy
+
+import "errors"
+
+var errParseEnded = errors.New("parse ends prematurely")
+
+// encodeVarInt writes n as a big-endian base-128 varint: the high bit
+// is set on every byte except the last.
+func encodeVarInt(n uint64) []byte {
+	if n == 0 {
+		return []byte{0}
+	}
+	var o []byte
+	for n != 0 {
+		o = append(o, byte(n&0x7f))
+		n >>= 7
+	}
+	for i, j := 0, len(o)-1; i < j; i, j = i+1, j-1 {
+		o[i], o[j] = o[j], o[i]
+	}
+	for i := 0; i < len(o)-1; i++ {
+		o[i] |= 0x80
+	}
+	return o
+}
+
+// reader is a cursor over an incoming message.
+type reader struct {
+	buf []byte
+}
+
+func (r *reader) len() int { return len(r.buf) }
+
+func (r *reader) byte() (byte, error) {
+	if len(r.buf) < 1 {
+		return 0, errParseEnded
+	}
+	b := r.buf[0]
+	r.buf = r.buf[1:]
+	return b, nil
+}
+
+func (r *reader) bytes(n int) ([]byte, error) {
+	if len(r.buf) < n {
+		return nil, errParseEnded
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b, nil
+}
+
+func (r *reader) varint() (uint64, error) {
+	var res uint64
+	for i := 0; ; i++ {
+		if i >= 10 {
+			return 0, errors.New("varint too long")
+		}
+		b, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		res = (res << 7) | uint64(b&0x7f)
+		if b&0x80 == 0 {
+			return res, nil
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package policy
+
+import (
+	"sync"
+
+	cfgType "github.com/0ceanslim/grain/config/types"
+	nostr "github.com/0ceanslim/grain/server/types"
+)
+
+// The read policy decides, per (reader, event), whether a stored event
+// may go out on a connection. The reader is the pubkey the connection
+// AUTHed as (NIP-42), or "" if it hasn't. It's consulted everywhere
+// an event or its existence can reach a client: REQ results, live
+// broadcasts, COUNT totals and NEG-OPEN storage. Refused events are
+// left out silently — telling the client something was withheld
+// would leak that it exists.
+//
+// Rules declare the kinds they cover, and optionally cheaper
+// predicates narrowing them further, so the hot paths stay cheap:
+// BroadcastEvent only looks up a subscriber's AUTH state for a gated
+// event, and COUNT / NEG-OPEN only fall back to per-event checks when
+// one of their filters can reach one.
+
+// defaultDMKinds are NIP-04 encrypted DMs and NIP-59 gift wraps.
+var defaultDMKinds = []int{4, 1059}
+
+// ReadRule is one read check. Allow is only called for events whose
+// kind is in Kinds (every kind when Kinds is nil) and that Gates, if
+// set, reports true for. GatesFilter, if set, reports whether a filter
+// can match such an event at all. All three must be safe for
+// concurrent use, and the two predicates cheap.
+type ReadRule struct {
+	Name        string
+	Kinds       []int
+	Gates       func(evt nostr.Event) bool
+	GatesFilter func(f nostr.Filter) bool
+	Allow       func(reader string, evt nostr.Event) bool
+}
+
+// ReadPolicy is a set of rules; an event is delivered only if every
+// rule covering its kind allows it. A nil *ReadPolicy allows
+// everything, so callers never need to check for one.
+type ReadPolicy struct {
+	rules    []ReadRule
+	kinds    map[int]struct{}
+	allKinds bool
+}
+
+// NewReadPolicy builds the policy for cfg's built-ins plus any extra
+// rules. It returns nil when no rule is configured.
+func NewReadPolicy(cfg cfgType.ReadPolicyConfig, extra ...ReadRule) *ReadPolicy {
+	var rules []ReadRule
+	if cfg.DMPrivacy {
+		rules = append(rules, DMPrivacyRule(cfg.DMKinds))
+	}
+	if len(cfg.AuthRequiredKinds) > 0 {
+		rules = append(rules, AuthRequiredRule(cfg.AuthRequiredKinds))
+	}
+	rules = append(rules, extra...)
+	if len(rules) == 0 {
+		return nil
+	}
+
+	p := &ReadPolicy{rules: rules, kinds: make(map[int]struct{})}
+	for _, r := range rules {
+		if r.Kinds == nil {
+			p.allKinds = true
+		}
+		for _, k := range r.Kinds {
+			p.kinds[k] = struct{}{}
+		}
+	}
+	return p
+}
+
+// DMPrivacyRule serves events of the given kinds (empty = 4 and 1059)
+// only to an AUTHed reader who wrote them or is p-tagged in them. For
+// gift wraps the author is a throwaway key, so in practice that's the
+// recipient only.
+func DMPrivacyRule(kinds []int) ReadRule {
+	if len(kinds) == 0 {
+		kinds = defaultDMKinds
+	}
+	return ReadRule{
+		Name:  "dm_privacy",
+		Kinds: kinds,
+		Allow: func(reader string, evt nostr.Event) bool {
+			if reader == "" {
+				return false
+			}
+			if evt.PubKey == reader {
+				return true
+			}
+			for _, tag := range evt.Tags {
+				if len(tag) >= 2 && tag[0] == "p" && tag[1] == reader {
+					return true
+				}
+			}
+			return false
+		},
+	}
+}
+
+// AuthRequiredRule serves events of the given kinds to any AUTHed
+// reader and to no one else.
+func AuthRequiredRule(kinds []int) ReadRule {
+	return ReadRule{
+		Name:  "auth_required_kinds",
+		Kinds: kinds,
+		Allow: func(reader string, _ nostr.Event) bool { return reader != "" },
+	}
+}
+
+// Gates reports whether any rule covers kind. When it doesn't, Allow
+// is true for every reader and callers can skip the AUTH lookup.
+func (p *ReadPolicy) Gates(kind int) bool {
+	if p == nil {
+		return false
+	}
+	if p.allKinds {
+		return true
+	}
+	_, ok := p.kinds[kind]
+	return ok
+}
+
+// GatesEvent reports whether any rule judges evt. When none does,
+// Allow is true for every reader and callers can skip the AUTH lookup.
+func (p *ReadPolicy) GatesEvent(evt nostr.Event) bool {
+	if !p.Gates(evt.Kind) {
+		return false
+	}
+	for _, r := range p.rules {
+		if ruleGates(r, evt) {
+			return true
+		}
+	}
+	return false
+}
+
+// GatesFilters reports whether any of filters can match a gated
+// event. A filter without kinds can match any kind.
+func (p *ReadPolicy) GatesFilters(filters []nostr.Filter) bool {
+	if p == nil {
+		return false
+	}
+	for _, f := range filters {
+		for _, r := range p.rules {
+			if ruleReaches(r, f) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Allow reports whether evt may be delivered to reader.
+func (p *ReadPolicy) Allow(reader string, evt nostr.Event) bool {
+	if !p.Gates(evt.Kind) {
+		return true
+	}
+	for _, r := range p.rules {
+		if !ruleGates(r, evt) {
+			continue
+		}
+		if !r.Allow(reader, evt) {
+			return false
+		}
+	}
+	return true
+}
+
+func ruleGates(r ReadRule, evt nostr.Event) bool {
+	return ruleCovers(r, evt.Kind) && (r.Gates == nil || r.Gates(evt))
+}
+
+// ruleReaches reports whether f can match an event r judges.
+func ruleReaches(r ReadRule, f nostr.Filter) bool {
+	if r.GatesFilter != nil && !r.GatesFilter(f) {
+		return false
+	}
+	if r.Kinds == nil || len(f.Kinds) == 0 {
+		return true
+	}
+	for _, k := range f.Kinds {
+		if ruleCovers(r, k) {
+			return true
+		}
+	}
+	return false
+}
+
+func ruleCovers(r ReadRule, kind int) bool {
+	if r.Kinds == nil {
+		return true
+	}
+	for _, k := range r.Kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	readPolicy   *ReadPolicy
+	readPolicyMu sync.RWMutex
+)
+
+// SetReadPolicy installs the instance-wide read policy (nil to clear)
+// and returns the previous one.
+func SetReadPolicy(p *ReadPolicy) *ReadPolicy {
+	readPolicyMu.Lock()
+	defer readPolicyMu.Unlock()
+	prev := readPolicy
+	readPolicy = p
+	return prev
+}
+
+// ActiveReadPolicy returns the installed read policy, nil (allow
+// everything) when none is. Handlers grab it once per request so one
+// REQ is judged by one policy even across a config reload.
+func ActiveReadPolicy() *ReadPolicy {
+	readPolicyMu.RLock()
+	defer readPolicyMu.RUnlock()
+	return readPolicy
+}
@@ -0,0 +1,118 @@
+package policy
+
+import (
+	"strings"
+	"testing"
+
+	cfgType "github.com/0ceanslim/grain/config/types"
+	nostr "github.com/0ceanslim/grain/server/types"
+)
+
+var (
+	alice = strings.Repeat("a", 64)
+	bob   = strings.Repeat("b", 64)
+	carol = strings.Repeat("c", 64)
+)
+
+func dm(kind int, from, to string) nostr.Event {
+	return nostr.Event{Kind: kind, PubKey: from, Tags: [][]string{{"p", to}}}
+}
+
+func TestReadPolicy_DMPrivacy(t *testing.T) {
+	p := NewReadPolicy(cfgType.ReadPolicyConfig{DMPrivacy: true})
+
+	cases := []struct {
+		name   string
+		reader string
+		evt    nostr.Event
+		want   bool
+	}{
+		{"author", alice, dm(4, alice, bob), true},
+		{"recipient", bob, dm(4, alice, bob), true},
+		{"third party", carol, dm(4, alice, bob), false},
+		{"unauthenticated", "", dm(4, alice, bob), false},
+		{"gift wrap recipient", bob, dm(1059, carol, bob), true},
+		{"gift wrap other", alice, dm(1059, carol, bob), false},
+		{"ungated kind", "", dm(1, alice, bob), true},
+	}
+	for _, c := range cases {
+		if got := p.Allow(c.reader, c.evt); got != c.want {
+			t.Errorf("%s: Allow = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestReadPolicy_CombinedRules(t *testing.T) {
+	p := NewReadPolicy(cfgType.ReadPolicyConfig{
+		DMPrivacy:         true,
+		DMKinds:           []int{14},
+		AuthRequiredKinds: []int{30023},
+	}, ReadRule{
+		Name:  "no-carol",
+		Kinds: nil,
+		Allow: func(reader string, _ nostr.Event) bool { return reader != carol },
+	})
+
+	if p.Allow("", dm(30023, alice, "")) {
+		t.Error("auth_required kind served to an unauthenticated reader")
+	}
+	if !p.Allow(bob, dm(30023, alice, "")) {
+		t.Error("auth_required kind refused to an authed reader")
+	}
+	if !p.Allow("", dm(4, alice, bob)) {
+		t.Error("kind 4 gated although dm_kinds overrides the default")
+	}
+	if p.Allow(alice, dm(14, bob, carol)) {
+		t.Error("custom dm kind served to a third party")
+	}
+	if p.Allow(carol, dm(1, alice, "")) {
+		t.Error("extra rule without kinds was not applied")
+	}
+}
+
+func TestReadPolicy_Gates(t *testing.T) {
+	if NewReadPolicy(cfgType.ReadPolicyConfig{}) != nil {
+		t.Fatal("policy with no rules should be nil")
+	}
+	var none *ReadPolicy
+	if none.Gates(4) || none.GatesFilters([]nostr.Filter{{}}) || !none.Allow("", dm(4, alice, bob)) {
+		t.Fatal("nil policy must allow everything")
+	}
+
+	p := NewReadPolicy(cfgType.ReadPolicyConfig{DMPrivacy: true})
+	if !p.Gates(4) || !p.Gates(1059) || p.Gates(1) {
+		t.Fatal("Gates doesn't match the default DM kinds")
+	}
+	if p.GatesFilters([]nostr.Filter{{Kinds: []int{0, 1}}}) {
+		t.Error("filter over ungated kinds reported as gated")
+	}
+	if !p.GatesFilters([]nostr.Filter{{Kinds: []int{1}}, {Kinds: []int{4}}}) {
+		t.Error("filter including kind 4 not reported as gated")
+	}
+	if !p.GatesFilters([]nostr.Filter{{Authors: []string{alice}}}) {
+		t.Error("filter without kinds can match DMs and must be gated")
+	}
+}
+
+func TestReadPolicy_RulePredicates(t *testing.T) {
+	p := NewReadPolicy(cfgType.ReadPolicyConfig{}, ReadRule{
+		Name:        "tagged",
+		Gates:       func(evt nostr.Event) bool { return len(evt.Tags) > 0 },
+		GatesFilter: func(f nostr.Filter) bool { return len(f.Authors) == 0 },
+		Allow:       func(reader string, _ nostr.Event) bool { return reader != "" },
+	})
+
+	untagged := nostr.Event{Kind: 1, PubKey: alice}
+	if p.GatesEvent(untagged) || !p.Allow("", untagged) {
+		t.Error("event the rule's Gates rejects was gated")
+	}
+	if !p.GatesEvent(dm(1, alice, bob)) || p.Allow("", dm(1, alice, bob)) {
+		t.Error("tagged event not gated")
+	}
+	if p.GatesFilters([]nostr.Filter{{Authors: []string{alice}}}) {
+		t.Error("filter the rule's GatesFilter rejects reported as gated")
+	}
+	if !p.GatesFilters([]nostr.Filter{{Authors: []string{alice}}, {Kinds: []int{1}}}) {
+		t.Error("filter the rule can reach not reported as gated")
+	}
+}
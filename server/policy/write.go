@@ -0,0 +1,442 @@
+// Package policy holds grain's pluggable acceptance hooks: the
+// write-policy plugin here, and the read policy in read.go.
+//
+// The write-policy plugin lets operators express rules
+// that whitelist.yml / blacklist.yml can't — per-kind content checks,
+// external reputation lookups, spam classifiers — without forking
+// grain. It speaks strfry's plugin protocol, so a plugin written for
+// strfry runs here unchanged:
+//
+//	grain → plugin (one JSON object per line on stdin)
+//	  {"type":"new","event":{...},"receivedAt":1700000000,
+//	   "sourceType":"IP4","sourceInfo":"203.0.113.7","authed":"<hex>"}
+//
+//	plugin → grain (one JSON object per line on stdout)
+//	  {"id":"<event id>","action":"accept|reject|shadowReject","msg":"..."}
+//
+// `authed` is the NIP-42 pubkey of the connection, empty when it
+// hasn't authenticated. Requests are sent one at a time, so a plugin
+// can be a plain read-line/write-line loop. stderr is forwarded to
+// the relay log.
+//
+// HandleEvent asks after every built-in check (signature, lists,
+// rate limits, duplicates, groups, storage room and quota) and before
+// the moderation hold and StoreEvent. A reject becomes
+// OK false with msg as the reason (prefixed `blocked:` if the plugin
+// didn't use a NIP-01 prefix); shadowReject answers OK true but
+// doesn't store — the sender can't tell it was dropped.
+package policy
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	cfgType "github.com/0ceanslim/grain/config/types"
+	nostr "github.com/0ceanslim/grain/server/types"
+	"github.com/0ceanslim/grain/server/utils/log"
+)
+
+// Action is a plugin verdict.
+type Action string
+
+const (
+	ActionAccept       Action = "accept"
+	ActionReject       Action = "reject"
+	ActionShadowReject Action = "shadowReject"
+)
+
+// Verdict is what HandleEvent acts on.
+type Verdict struct {
+	Action Action
+	Msg    string
+}
+
+// Tunables, vars so tests can shrink them.
+var (
+	defaultTimeout = 2 * time.Second
+
+	// maxConsecutiveTimeouts is how many verdicts in a row may time
+	// out before the plugin is assumed wedged and killed (the
+	// supervisor then restarts it).
+	maxConsecutiveTimeouts = 3
+
+	restartMinBackoff = time.Second
+	restartMaxBackoff = 30 * time.Second
+
+	// stableRuntime is how long a plugin has to stay up for its next
+	// crash to restart with the minimum backoff again.
+	stableRuntime = time.Minute
+)
+
+// maxLineBytes caps one stdout line. Verdicts are tiny; anything
+// bigger is a broken plugin.
+const maxLineBytes = 1 << 20
+
+// writeInput is one request line.
+type writeInput struct {
+	Type       string      `json:"type"`
+	Event      nostr.Event `json:"event"`
+	ReceivedAt int64       `json:"receivedAt"`
+	SourceType string      `json:"sourceType"`
+	SourceInfo string      `json:"sourceInfo"`
+	Authed     string      `json:"authed"`
+}
+
+// writeOutput is one response line.
+type writeOutput struct {
+	ID     string `json:"id"`
+	Action Action `json:"action"`
+	Msg    string `json:"msg"`
+}
+
+// WritePlugin runs and supervises one plugin process.
+type WritePlugin struct {
+	command  string
+	args     []string
+	dir      string
+	timeout  time.Duration
+	failOpen bool
+
+	// reqMu serialises Check calls: one request in flight at a time.
+	reqMu    sync.Mutex
+	timeouts int // consecutive; guarded by reqMu
+
+	procMu sync.Mutex
+	proc   *pluginProc // nil while the plugin is down
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// pluginProc is one running instance of the plugin.
+type pluginProc struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	lines chan []byte   // stdout, line by line; closed at EOF
+	done  chan struct{} // closed once the process has been reaped
+	err   error         // exit status; valid after done
+}
+
+// StartWritePlugin launches the plugin described by cfg and keeps it
+// running until Close. command is cfg.Command already resolved to a
+// path; dir is the working directory (the data dir). If the program
+// can't be started the supervisor keeps retrying, and Check applies
+// the fail_open policy in the meantime.
+func StartWritePlugin(cfg cfgType.WritePolicyConfig, command, dir string) *WritePlugin {
+	timeout := time.Duration(cfg.TimeoutMs) * time.Millisecond
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &WritePlugin{
+		command:  command,
+		args:     cfg.Args,
+		dir:      dir,
+		timeout:  timeout,
+		failOpen: cfg.FailOpen,
+		cancel:   cancel,
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.supervise(ctx)
+	}()
+	return p
+}
+
+// Close stops the plugin and its supervisor. The plugin sees EOF on
+// stdin first and is killed if it hasn't exited a second later.
+func (p *WritePlugin) Close() {
+	p.cancel()
+	p.wg.Wait()
+}
+
+func (p *WritePlugin) current() *pluginProc {
+	p.procMu.Lock()
+	defer p.procMu.Unlock()
+	return p.proc
+}
+
+func (p *WritePlugin) setCurrent(proc *pluginProc) {
+	p.procMu.Lock()
+	p.proc = proc
+	p.procMu.Unlock()
+}
+
+// supervise starts the plugin and restarts it whenever it exits, with
+// exponential backoff so a plugin that dies on startup doesn't spin.
+func (p *WritePlugin) supervise(ctx context.Context) {
+	backoff := restartMinBackoff
+	for {
+		started := time.Now()
+		proc, err := p.spawn()
+		if err == nil {
+			p.setCurrent(proc)
+			log.Policy().Info("Write-policy plugin started",
+				"command", p.command,
+				"pid", proc.cmd.Process.Pid)
+
+			select {
+			case <-proc.done:
+				err = proc.err
+				if err == nil {
+					err = errors.New("exited")
+				}
+			case <-ctx.Done():
+				p.setCurrent(nil)
+				proc.stop()
+				return
+			}
+			p.setCurrent(nil)
+			if time.Since(started) >= stableRuntime {
+				backoff = restartMinBackoff
+			}
+		}
+
+		log.Policy().Error("Write-policy plugin not running, restarting",
+			"command", p.command,
+			"error", err,
+			"restart_in", backoff.String())
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > restartMaxBackoff {
+			backoff = restartMaxBackoff
+		}
+	}
+}
+
+func (p *WritePlugin) spawn() (*pluginProc, error) {
+	cmd := exec.Command(p.command, p.args...)
+	cmd.Dir = p.dir
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	proc := &pluginProc{
+		cmd:   cmd,
+		stdin: stdin,
+		lines: make(chan []byte, 16),
+		done:  make(chan struct{}),
+	}
+
+	// Both pipes must be drained before Wait, per os/exec.
+	var readers sync.WaitGroup
+	readers.Add(2)
+	go func() {
+		defer readers.Done()
+		defer close(proc.lines)
+		sc := bufio.NewScanner(stdout)
+		sc.Buffer(make([]byte, 64*1024), maxLineBytes)
+		for sc.Scan() {
+			proc.lines <- append([]byte(nil), sc.Bytes()...)
+		}
+		// A line over maxLineBytes stops the scanner; the plugin is
+		// broken, so stop reading and let it be restarted.
+		if sc.Err() != nil {
+			log.Policy().Error("Write-policy plugin stdout unreadable, killing", "error", sc.Err())
+			_ = cmd.Process.Kill()
+			_, _ = io.Copy(io.Discard, stdout)
+		}
+	}()
+	go func() {
+		defer readers.Done()
+		sc := bufio.NewScanner(stderr)
+		for sc.Scan() {
+			log.Policy().Info("Write-policy plugin stderr", "line", sc.Text())
+		}
+	}()
+	go func() {
+		readers.Wait()
+		proc.err = cmd.Wait()
+		close(proc.done)
+	}()
+	return proc, nil
+}
+
+// stop closes stdin (the polite EOF shutdown strfry plugins expect)
+// and kills the process if it hasn't exited within a second.
+func (proc *pluginProc) stop() {
+	_ = proc.stdin.Close()
+	select {
+	case <-proc.done:
+		return
+	case <-time.After(time.Second):
+	}
+	_ = proc.cmd.Process.Kill()
+	// Consume leftover verdicts so the stdout reader can reach EOF.
+	go func() {
+		for range proc.lines {
+		}
+	}()
+	<-proc.done
+}
+
+// Check asks the plugin about evt. sourceIP is the client's address
+// and authed its NIP-42 pubkey ("" when unauthenticated).
+func (p *WritePlugin) Check(evt nostr.Event, sourceIP, authed string) Verdict {
+	p.reqMu.Lock()
+	defer p.reqMu.Unlock()
+
+	proc := p.current()
+	if proc == nil {
+		return p.unavailable(evt, "not running")
+	}
+
+	line, err := json.Marshal(writeInput{
+		Type:       "new",
+		Event:      evt,
+		ReceivedAt: time.Now().Unix(),
+		SourceType: sourceType(sourceIP),
+		SourceInfo: sourceIP,
+		Authed:     authed,
+	})
+	if err != nil {
+		return p.unavailable(evt, err.Error())
+	}
+	line = append(line, '\n')
+
+	// The write can block if the plugin stops reading and the pipe
+	// fills up, so it runs under the same timeout as the reply.
+	writeErr := make(chan error, 1)
+	go func() {
+		_, err := proc.stdin.Write(line)
+		writeErr <- err
+	}()
+
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case err := <-writeErr:
+			if err != nil {
+				return p.unavailable(evt, "write: "+err.Error())
+			}
+		case raw, ok := <-proc.lines:
+			if !ok {
+				return p.unavailable(evt, "plugin exited")
+			}
+			var out writeOutput
+			if err := json.Unmarshal(raw, &out); err != nil {
+				log.Policy().Warn("Write-policy plugin sent unparseable line",
+					"line", string(raw),
+					"error", err)
+				continue
+			}
+			if out.ID != evt.ID {
+				// A late answer to an earlier request that timed out.
+				continue
+			}
+			p.timeouts = 0
+			switch out.Action {
+			case ActionAccept, ActionReject, ActionShadowReject:
+				return Verdict{Action: out.Action, Msg: out.Msg}
+			default:
+				return p.unavailable(evt, fmt.Sprintf("unknown action %q", out.Action))
+			}
+		case <-timer.C:
+			p.timeouts++
+			if p.timeouts >= maxConsecutiveTimeouts {
+				log.Policy().Error("Write-policy plugin unresponsive, killing",
+					"consecutive_timeouts", p.timeouts)
+				_ = proc.cmd.Process.Kill()
+				p.timeouts = 0
+			}
+			return p.unavailable(evt, "timeout after "+p.timeout.String())
+		}
+	}
+}
+
+// unavailable applies the fail_open policy when no usable verdict
+// could be had.
+func (p *WritePlugin) unavailable(evt nostr.Event, why string) Verdict {
+	log.Policy().Warn("Write-policy verdict unavailable",
+		"event_id", evt.ID,
+		"reason", why,
+		"fail_open", p.failOpen)
+	if p.failOpen {
+		return Verdict{Action: ActionAccept}
+	}
+	return Verdict{Action: ActionReject, Msg: "error: write policy unavailable"}
+}
+
+// sourceType is strfry's IP4 / IP6 tag for the source address.
+func sourceType(ip string) string {
+	if parsed := net.ParseIP(ip); parsed != nil && parsed.To4() == nil {
+		return "IP6"
+	}
+	return "IP4"
+}
+
+// nip01Prefixes are the machine-readable OK prefixes a plugin's msg
+// may already start with.
+var nip01Prefixes = []string{
+	"duplicate:", "pow:", "blocked:", "rate-limited:", "invalid:",
+	"restricted:", "mute:", "error:", "auth-required:",
+}
+
+// OKReason turns a reject msg into an OK reason: passed through if it
+// already carries a NIP-01 prefix, otherwise prefixed `blocked:`.
+func OKReason(msg string) string {
+	msg = strings.TrimSpace(msg)
+	for _, p := range nip01Prefixes {
+		if strings.HasPrefix(msg, p) {
+			return msg
+		}
+	}
+	if msg == "" {
+		return "blocked: rejected by write policy"
+	}
+	return "blocked: " + msg
+}
+
+var (
+	active   *WritePlugin
+	activeMu sync.RWMutex
+)
+
+// SetWritePlugin installs the instance-wide plugin (nil to clear) and
+// returns the previous one so the caller can Close it.
+func SetWritePlugin(p *WritePlugin) *WritePlugin {
+	activeMu.Lock()
+	defer activeMu.Unlock()
+	prev := active
+	active = p
+	return prev
+}
+
+// CheckWrite asks the active plugin about evt. Without a plugin
+// everything is accepted.
+func CheckWrite(evt nostr.Event, sourceIP, authed string) Verdict {
+	activeMu.RLock()
+	p := active
+	activeMu.RUnlock()
+	if p == nil {
+		return Verdict{Action: ActionAccept}
+	}
+	return p.Check(evt, sourceIP, authed)
+}
@@ -0,0 +1,159 @@
+package policy
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	cfgType "github.com/0ceanslim/grain/config/types"
+	nostr "github.com/0ceanslim/grain/server/types"
+)
+
+// The test binary doubles as the plugin: when GRAIN_TEST_PLUGIN is
+// set, TestMain runs one of the modes below instead of the tests.
+func TestMain(m *testing.M) {
+	if mode := os.Getenv("GRAIN_TEST_PLUGIN"); mode != "" {
+		runTestPlugin(mode)
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runTestPlugin modes:
+//
+//	rules  - reject content "spam", shadowReject "shadow", else accept;
+//	         msg echoes sourceType/sourceInfo/authed
+//	crash  - answer the first request, then exit 1
+//	hang   - read requests, never answer
+func runTestPlugin(mode string) {
+	sc := bufio.NewScanner(os.Stdin)
+	out := json.NewEncoder(os.Stdout)
+	for sc.Scan() {
+		var in writeInput
+		if err := json.Unmarshal(sc.Bytes(), &in); err != nil {
+			fmt.Fprintln(os.Stderr, "bad input:", err)
+			continue
+		}
+		if mode == "hang" {
+			continue
+		}
+		res := writeOutput{ID: in.Event.ID, Action: ActionAccept,
+			Msg: in.SourceType + " " + in.SourceInfo + " " + in.Authed}
+		switch in.Event.Content {
+		case "spam":
+			res.Action, res.Msg = ActionReject, "no spam please"
+		case "shadow":
+			res.Action = ActionShadowReject
+		}
+		_ = out.Encode(res)
+		if mode == "crash" {
+			os.Exit(1)
+		}
+	}
+}
+
+func startTestPlugin(t *testing.T, mode string, failOpen bool) *WritePlugin {
+	t.Helper()
+	t.Setenv("GRAIN_TEST_PLUGIN", mode)
+	p := StartWritePlugin(cfgType.WritePolicyConfig{Enabled: true, TimeoutMs: 300, FailOpen: failOpen},
+		os.Args[0], t.TempDir())
+	t.Cleanup(p.Close)
+	waitRunning(t, p)
+	return p
+}
+
+func waitRunning(t *testing.T, p *WritePlugin) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for p.current() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("plugin never started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func policyEvent(id, content string) nostr.Event {
+	return nostr.Event{ID: id, Kind: 1, Content: content}
+}
+
+func TestWritePlugin_Verdicts(t *testing.T) {
+	p := startTestPlugin(t, "rules", false)
+
+	v := p.Check(policyEvent("e1", "hello"), "2001:db8::1", "abc")
+	if v.Action != ActionAccept || v.Msg != "IP6 2001:db8::1 abc" {
+		t.Fatalf("accept verdict = %+v", v)
+	}
+	if v := p.Check(policyEvent("e2", "spam"), "203.0.113.7", ""); v.Action != ActionReject || v.Msg != "no spam please" {
+		t.Fatalf("reject verdict = %+v", v)
+	}
+	if v := p.Check(policyEvent("e3", "shadow"), "203.0.113.7", ""); v.Action != ActionShadowReject {
+		t.Fatalf("shadow verdict = %+v", v)
+	}
+}
+
+func TestWritePlugin_RestartsAfterCrash(t *testing.T) {
+	old := restartMinBackoff
+	restartMinBackoff = 10 * time.Millisecond
+	t.Cleanup(func() { restartMinBackoff = old })
+
+	p := startTestPlugin(t, "crash", false)
+	first := p.current()
+	if v := p.Check(policyEvent("e1", "hi"), "", ""); v.Action != ActionAccept {
+		t.Fatalf("first verdict = %+v", v)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if cur := p.current(); cur != nil && cur != first {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("plugin was not restarted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if v := p.Check(policyEvent("e2", "hi"), "", ""); v.Action != ActionAccept {
+		t.Fatalf("verdict after restart = %+v", v)
+	}
+}
+
+func TestWritePlugin_TimeoutFailsClosedOrOpen(t *testing.T) {
+	closed := startTestPlugin(t, "hang", false)
+	v := closed.Check(policyEvent("e1", "hi"), "", "")
+	if v.Action != ActionReject || !strings.HasPrefix(v.Msg, "error:") {
+		t.Fatalf("fail-closed verdict = %+v", v)
+	}
+
+	open := startTestPlugin(t, "hang", true)
+	if v := open.Check(policyEvent("e1", "hi"), "", ""); v.Action != ActionAccept {
+		t.Fatalf("fail-open verdict = %+v", v)
+	}
+}
+
+func TestWritePlugin_UnstartableFailsClosed(t *testing.T) {
+	p := StartWritePlugin(cfgType.WritePolicyConfig{Enabled: true}, "/nonexistent/grain-plugin", t.TempDir())
+	defer p.Close()
+	if v := p.Check(policyEvent("e1", "hi"), "", ""); v.Action != ActionReject {
+		t.Fatalf("verdict with no plugin running = %+v", v)
+	}
+}
+
+func TestOKReason(t *testing.T) {
+	cases := map[string]string{
+		"":                      "blocked: rejected by write policy",
+		"no spam":               "blocked: no spam",
+		"pow: need 20 bits":     "pow: need 20 bits",
+		"restricted: members":   "restricted: members",
+		"  invalid: bad tags  ": "invalid: bad tags",
+	}
+	for in, want := range cases {
+		if got := OKReason(in); got != want {
+			t.Errorf("OKReason(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+// Package quota enforces per-pubkey storage quotas: how many events,
+// and how many bytes of them, one author may keep on the relay.
+// Whitelisted pubkeys get their own tier. The usage comes from the
+// per-author counts nostrdb keeps for grain_stats_database, updated
+// on every store and delete, so checking costs a map lookup.
+//
+// Over quota, an event is rejected with "blocked: storage quota
+// exceeded", or with quotas.evict_oldest the author's oldest events
+// are deleted to make room. Eviction waits until the new event is
+// stored (Stored), so an event turned away later on, or failing to
+// store, costs its author nothing. It deletes a little more than it
+// has to (evictHeadroom), since finding the oldest events means
+// walking all of the author's, and doing that for every new event of
+// an author sitting at the limit would be wasteful.
+//
+// Replaceable events (kinds 0, 3, 10000-19999) and deletions are
+// never rejected or evicted, though they count towards the usage: a
+// profile update replaces the old one, and a deletion is how an
+// author frees space.
+package quota
+
+import (
+	"encoding/json"
+	"sync"
+
+	cfgType "github.com/0ceanslim/grain/config/types"
+	nostr "github.com/0ceanslim/grain/server/types"
+	"github.com/0ceanslim/grain/server/utils/log"
+)
+
+// Reason is the NIP-01 OK message for an event over its author's
+// quota.
+const Reason = "blocked: storage quota exceeded"
+
+// evictHeadroom is the share of a limit eviction frees on top of
+// what the new event needs: 1/20th, so an author at the limit walks
+// their events once per 5% of quota rather than once per event.
+const evictHeadroom = 20
+
+// Usage is what one author has stored.
+type Usage struct {
+	Events int64
+	Bytes  int64
+}
+
+// Store is the database the quotas are counted in.
+type Store interface {
+	// AuthorUsage reports what pubkey has stored; ok is false while
+	// the counts aren't available yet.
+	AuthorUsage(pubkey string) (u Usage, ok bool)
+	// EvictOldest deletes pubkey's oldest events, other than spare
+	// (an event id) and those Exempt, until at least events and bytes
+	// are freed, and reports what it freed.
+	EvictOldest(pubkey, spare string, events int64, bytes int64) (Usage, error)
+}
+
+// Exempt reports whether events of kind are never rejected or evicted
+// for quota.
+func Exempt(kind int) bool {
+	return kind == 0 || kind == 3 || kind == 5 || (kind >= 10000 && kind < 30000)
+}
+
+// Size is an event's size as the quotas count it: its JSON.
+func Size(evt nostr.Event) int64 {
+	raw, err := json.Marshal(evt)
+	if err != nil {
+		return 0
+	}
+	return int64(len(raw))
+}
+
+// Enforcer checks events against the configured quotas.
+type Enforcer struct {
+	cfg         cfgType.QuotaConfig
+	store       Store
+	whitelisted func(pubkey string) bool
+
+	evictMu sync.Mutex // one eviction at a time, so two don't both free the same room
+}
+
+// NewEnforcer enforces cfg against store. whitelisted picks the tier.
+func NewEnforcer(cfg cfgType.QuotaConfig, store Store, whitelisted func(string) bool) *Enforcer {
+	return &Enforcer{cfg: cfg, store: store, whitelisted: whitelisted}
+}
+
+func (e *Enforcer) tier(pubkey string) cfgType.QuotaTier {
+	if e.whitelisted != nil && e.whitelisted(pubkey) {
+		return e.cfg.Whitelisted
+	}
+	return e.cfg.Default
+}
+
+// over is how many events and bytes u has to lose for more (events
+// and bytes still to be stored) to fit in t. Zero or less on both
+// means it fits.
+func over(t cfgType.QuotaTier, u, more Usage) (events, bytes int64) {
+	if t.MaxEvents > 0 {
+		events = u.Events + more.Events - int64(t.MaxEvents)
+	}
+	if t.MaxBytes > 0 {
+		bytes = u.Bytes + more.Bytes - t.MaxBytes
+	}
+	return events, bytes
+}
+
+// Admit checks evt against its author's quota. It returns "" to store
+// the event, or the OK reason to reject it with. With evict_oldest,
+// an event room can be made for is let through; Stored makes the
+// room once it's stored. Admit itself deletes nothing.
+func (e *Enforcer) Admit(evt nostr.Event) string {
+	if Exempt(evt.Kind) {
+		return ""
+	}
+	t := e.tier(evt.PubKey)
+	if t.MaxEvents == 0 && t.MaxBytes == 0 {
+		return ""
+	}
+	u, ok := e.store.AuthorUsage(evt.PubKey)
+	if !ok {
+		return "" // still counting after startup
+	}
+	size := Size(evt)
+	needEvents, needBytes := over(t, u, Usage{Events: 1, Bytes: size})
+	if needEvents <= 0 && needBytes <= 0 {
+		return ""
+	}
+	if !e.cfg.EvictOldest || (t.MaxBytes > 0 && size > t.MaxBytes) {
+		log.Quota().Info("Event over storage quota",
+			"event_id", evt.ID,
+			"pubkey", evt.PubKey,
+			"events", u.Events,
+			"bytes", u.Bytes,
+			"max_events", t.MaxEvents,
+			"max_bytes", t.MaxBytes)
+		return Reason
+	}
+	return ""
+}
+
+// Stored evicts the oldest of evt's author's events, other than evt,
+// if with evt stored (and counted) they're over quota and
+// evict_oldest is on. A failure is logged; evt stays either way.
+func (e *Enforcer) Stored(evt nostr.Event) {
+	if !e.cfg.EvictOldest || Exempt(evt.Kind) {
+		return
+	}
+	t := e.tier(evt.PubKey)
+	if t.MaxEvents == 0 && t.MaxBytes == 0 {
+		return
+	}
+
+	e.evictMu.Lock()
+	defer e.evictMu.Unlock()
+
+	// Another event of the same author may have made room already.
+	u, ok := e.store.AuthorUsage(evt.PubKey)
+	if !ok {
+		return
+	}
+	needEvents, needBytes := over(t, u, Usage{})
+	if needEvents <= 0 && needBytes <= 0 {
+		return
+	}
+	if needEvents > 0 {
+		needEvents += max(int64(t.MaxEvents)/evictHeadroom, 1)
+	}
+	if needBytes > 0 {
+		needBytes += t.MaxBytes / evictHeadroom
+	}
+
+	freed, err := e.store.EvictOldest(evt.PubKey, evt.ID, max(needEvents, 0), max(needBytes, 0))
+	if err != nil {
+		log.Quota().Error("Failed to evict for storage quota",
+			"pubkey", evt.PubKey,
+			"error", err)
+		return
+	}
+	log.Quota().Info("Evicted oldest events for storage quota",
+		"pubkey", evt.PubKey,
+		"event_id", evt.ID,
+		"evicted_events", freed.Events,
+		"evicted_bytes", freed.Bytes)
+
+	if ev, by := over(t, Usage{Events: u.Events - freed.Events, Bytes: u.Bytes - freed.Bytes}, Usage{}); ev > 0 || by > 0 {
+		log.Quota().Warn("Author still over storage quota: nothing left that may be evicted",
+			"pubkey", evt.PubKey,
+			"event_id", evt.ID)
+	}
+}
+
+var (
+	active   *Enforcer
+	activeMu sync.RWMutex
+)
+
+// SetEnforcer installs the instance-wide enforcer (nil to turn quotas
+// off) and returns the previous one.
+func SetEnforcer(e *Enforcer) *Enforcer {
+	activeMu.Lock()
+	defer activeMu.Unlock()
+	prev := active
+	active = e
+	return prev
+}
+
+// Admit runs the active enforcer; "" without one.
+func Admit(evt nostr.Event) string {
+	activeMu.RLock()
+	e := active
+	activeMu.RUnlock()
+	if e == nil {
+		return ""
+	}
+	return e.Admit(evt)
+}
+
+// Stored runs the active enforcer's eviction for a stored event.
+func Stored(evt nostr.Event) {
+	activeMu.RLock()
+	e := active
+	activeMu.RUnlock()
+	if e != nil {
+		e.Stored(evt)
+	}
+}
@@ -0,0 +1,171 @@
+package quota
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	cfgType "github.com/0ceanslim/grain/config/types"
+	nostr "github.com/0ceanslim/grain/server/types"
+)
+
+// fakeStore holds each author's events as sizes, oldest first.
+type fakeStore struct {
+	events   map[string][]int64
+	counting bool
+	evicts   int
+}
+
+func (f *fakeStore) AuthorUsage(pubkey string) (Usage, bool) {
+	if f.counting {
+		return Usage{}, false
+	}
+	var u Usage
+	for _, size := range f.events[pubkey] {
+		u.Events++
+		u.Bytes += size
+	}
+	return u, true
+}
+
+// EvictOldest ignores spare: the tests store the new event last, and
+// evict from the front.
+func (f *fakeStore) EvictOldest(pubkey, spare string, events int64, bytes int64) (Usage, error) {
+	f.evicts++
+	var freed Usage
+	for len(f.events[pubkey]) > 0 && (freed.Events < events || freed.Bytes < bytes) {
+		freed.Events++
+		freed.Bytes += f.events[pubkey][0]
+		f.events[pubkey] = f.events[pubkey][1:]
+	}
+	return freed, nil
+}
+
+func note(pubkey string, kind int, content string) nostr.Event {
+	return nostr.Event{ID: strings.Repeat("a", 64), PubKey: pubkey, Kind: kind, Content: content}
+}
+
+func fill(n int, size int64) []int64 {
+	out := make([]int64, n)
+	for i := range out {
+		out[i] = size
+	}
+	return out
+}
+
+func TestAdmitRejectsOverQuota(t *testing.T) {
+	store := &fakeStore{events: map[string][]int64{"alice": fill(10, 100), "wl": fill(10, 100)}}
+	e := NewEnforcer(cfgType.QuotaConfig{
+		Default:     cfgType.QuotaTier{MaxEvents: 10},
+		Whitelisted: cfgType.QuotaTier{MaxEvents: 20},
+	}, store, func(pk string) bool { return pk == "wl" })
+
+	if got := e.Admit(note("alice", 1, "hi")); got != Reason {
+		t.Fatalf("11th event: %q, want %q", got, Reason)
+	}
+	if got := e.Admit(note("wl", 1, "hi")); got != "" {
+		t.Fatalf("whitelisted tier: %q", got)
+	}
+	if got := e.Admit(note("bob", 1, "hi")); got != "" {
+		t.Fatalf("fresh author: %q", got)
+	}
+	// Profiles and deletions always go through.
+	for _, kind := range []int{0, 3, 5, 10002} {
+		if got := e.Admit(note("alice", kind, "")); got != "" {
+			t.Fatalf("kind %d: %q", kind, got)
+		}
+	}
+	// Nothing is enforced until the counts exist.
+	store.counting = true
+	if got := e.Admit(note("alice", 1, "hi")); got != "" {
+		t.Fatalf("while counting: %q", got)
+	}
+	if store.evicts != 0 {
+		t.Fatal("evicted without evict_oldest")
+	}
+}
+
+func TestAdmitBytes(t *testing.T) {
+	evt := note("alice", 1, strings.Repeat("x", 200))
+	size := Size(evt)
+	store := &fakeStore{events: map[string][]int64{"alice": {500}}}
+	e := NewEnforcer(cfgType.QuotaConfig{Default: cfgType.QuotaTier{MaxBytes: 500 + size}}, store, nil)
+	if got := e.Admit(evt); got != "" {
+		t.Fatalf("exactly at the byte limit: %q", got)
+	}
+	store.events["alice"] = append(store.events["alice"], 1)
+	if got := e.Admit(evt); got != Reason {
+		t.Fatalf("one byte over: %q", got)
+	}
+}
+
+func TestAdmitEvictsOldest(t *testing.T) {
+	store := &fakeStore{events: map[string][]int64{"alice": fill(40, 10)}}
+	e := NewEnforcer(cfgType.QuotaConfig{
+		Default:     cfgType.QuotaTier{MaxEvents: 40},
+		EvictOldest: true,
+	}, store, nil)
+
+	evt := note("alice", 1, "hi")
+	if got := e.Admit(evt); got != "" || store.evicts != 0 {
+		t.Fatalf("evict_oldest: Admit = %q with %d evictions, want the event let through and nothing evicted yet", got, store.evicts)
+	}
+	// Once it's stored, one for the new event plus 1/20th of the quota go.
+	store.events["alice"] = append(store.events["alice"], 10)
+	e.Stored(evt)
+	if n := len(store.events["alice"]); n != 38 || store.evicts != 1 {
+		t.Fatalf("after eviction: %d events, %d evictions", n, store.evicts)
+	}
+	// The headroom means the next event needs no walk.
+	evt = note("alice", 1, "again")
+	if got := e.Admit(evt); got != "" {
+		t.Fatalf("second event: %q", got)
+	}
+	store.events["alice"] = append(store.events["alice"], 10)
+	e.Stored(evt)
+	if store.evicts != 1 {
+		t.Fatalf("second event: %d evictions", store.evicts)
+	}
+
+	// An event bigger than the whole byte quota can't be made room for.
+	e = NewEnforcer(cfgType.QuotaConfig{Default: cfgType.QuotaTier{MaxBytes: 10}, EvictOldest: true}, store, nil)
+	if got := e.Admit(note("alice", 1, "far too big for ten bytes")); got != Reason {
+		t.Fatalf("oversized event: %q", got)
+	}
+}
+
+type failingStore struct{ fakeStore }
+
+func (f *failingStore) EvictOldest(string, string, int64, int64) (Usage, error) {
+	f.evicts++
+	return Usage{}, errors.New("writer queue full")
+}
+
+func TestStoredEvictionFailureKeepsEvent(t *testing.T) {
+	store := &failingStore{fakeStore{events: map[string][]int64{"alice": fill(5, 1)}}}
+	e := NewEnforcer(cfgType.QuotaConfig{Default: cfgType.QuotaTier{MaxEvents: 5}, EvictOldest: true}, store, nil)
+	evt := note("alice", 1, "hi")
+	if got := e.Admit(evt); got != "" {
+		t.Fatalf("evict_oldest: %q", got)
+	}
+	store.events["alice"] = append(store.events["alice"], 1)
+	e.Stored(evt)
+	if n := len(store.events["alice"]); n != 6 || store.evicts != 1 {
+		t.Fatalf("after failed eviction: %d events, %d evictions", n, store.evicts)
+	}
+}
+
+func TestPackageAdmit(t *testing.T) {
+	store := &fakeStore{events: map[string][]int64{"alice": fill(5, 1)}}
+	e := NewEnforcer(cfgType.QuotaConfig{Default: cfgType.QuotaTier{MaxEvents: 5}}, store, nil)
+
+	SetEnforcer(e)
+	t.Cleanup(func() { SetEnforcer(nil) })
+	if Admit(note("alice", 1, "hi")) != Reason {
+		t.Fatal("package Admit didn't use the installed enforcer")
+	}
+	SetEnforcer(nil)
+	if Admit(note("alice", 1, "hi")) != "" {
+		t.Fatal("Admit without an enforcer should allow")
+	}
+}
@@ -0,0 +1,24 @@
+package server
+
+import (
+	"github.com/0ceanslim/grain/server/db/nostrdb"
+	"github.com/0ceanslim/grain/server/quota"
+	nostr "github.com/0ceanslim/grain/server/types"
+)
+
+// quotaStore backs quota.Store with nostrdb's per-author counts.
+type quotaStore struct {
+	db *nostrdb.NDB
+}
+
+func (s quotaStore) AuthorUsage(pubkey string) (quota.Usage, bool) {
+	c, ok := s.db.AuthorUsage(pubkey)
+	return quota.Usage{Events: c.Events, Bytes: c.Bytes}, ok
+}
+
+func (s quotaStore) EvictOldest(pubkey, spare string, events int64, bytes int64) (quota.Usage, error) {
+	n, b, err := s.db.DeleteOldestByAuthor(pubkey, int(events), bytes, func(evt nostr.Event) bool {
+		return evt.ID == spare || quota.Exempt(evt.Kind)
+	})
+	return quota.Usage{Events: int64(n), Bytes: b}, err
+}
@@ -0,0 +1,397 @@
+package replication
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Outbox layout on disk, one directory per target:
+//
+//	queue.log  append-only JSONL, one entry per line:
+//	           {"t":<enqueued unix ms>,"id":"<event id>","e":<event>}
+//	cursor     byte offset of the first entry not yet resolved
+//	url        the target URL, for operators poking around the dir
+//
+// Entries are resolved (acked, or rejected for good) by offset. The
+// cursor only moves over a contiguous run of resolved entries, so a
+// crash replays everything from the cursor on — delivery is
+// at-least-once, and the remote answers `duplicate:` for anything it
+// already had. Appends and cursor updates aren't fsynced: a process
+// crash loses nothing (the bytes are in the page cache), an OS crash
+// can lose the tail, which is the same durability nostrdb's own
+// writer gives the events themselves.
+//
+// Once everything is resolved the log is truncated; under sustained
+// load it's instead rewritten without its resolved prefix once that
+// prefix passes compactThreshold. Both only happen between sender
+// rounds (see compact), so offsets held by the sender never go stale.
+// A crash in the middle of either replays the resolved prefix at worst.
+
+const (
+	queueFile  = "queue.log"
+	cursorFile = "cursor"
+	urlFile    = "url"
+)
+
+// compactThreshold is how much resolved prefix the log carries
+// before compact bothers rewriting it. A var so tests can lower it.
+var compactThreshold int64 = 64 << 20
+
+// errQueueFull is returned by append when the outbox is at its cap.
+var errQueueFull = errors.New("outbox full")
+
+// entry is one queued event as read back from the log.
+type entry struct {
+	start, end int64
+	enqueuedAt int64 // unix ms
+	id         string
+	event      json.RawMessage
+}
+
+// record is the on-disk line shape.
+type record struct {
+	T  int64           `json:"t"`
+	ID string          `json:"id"`
+	E  json.RawMessage `json:"e"`
+}
+
+type outbox struct {
+	mu sync.Mutex
+
+	dir    string
+	f      *os.File
+	cursor *os.File
+
+	size     int64 // end of queue.log
+	ackOff   int64 // persisted cursor
+	readOff  int64 // next entry next() hands out
+	depth    int   // unresolved entries at or after ackOff
+	maxBytes int64
+
+	// resolved holds entries past ackOff that were resolved out of
+	// order (start → end). next() skips them; resolveLocked folds them
+	// into ackOff once the gap before them closes.
+	resolved map[int64]int64
+
+	// notify is poked (non-blocking) on every append so an idle
+	// sender wakes up.
+	notify chan struct{}
+}
+
+// openOutbox opens or creates the outbox in dir. A torn final line
+// (crash mid-append) is truncated away; everything from the cursor
+// on is counted so depth is right from the first status call.
+func openOutbox(dir, url string, maxBytes int64) (*outbox, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create outbox dir: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, urlFile), []byte(url+"\n"), 0644); err != nil {
+		return nil, fmt.Errorf("write outbox url: %w", err)
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, queueFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open outbox queue: %w", err)
+	}
+	cur, err := os.OpenFile(filepath.Join(dir, cursorFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("open outbox cursor: %w", err)
+	}
+
+	ob := &outbox{
+		dir:      dir,
+		f:        f,
+		cursor:   cur,
+		maxBytes: maxBytes,
+		resolved: make(map[int64]int64),
+		notify:   make(chan struct{}, 1),
+	}
+	if err := ob.recover(); err != nil {
+		ob.close()
+		return nil, err
+	}
+	return ob, nil
+}
+
+// recover reads the cursor, trims a torn tail and counts depth.
+func (ob *outbox) recover() error {
+	raw, err := io.ReadAll(io.NewSectionReader(ob.cursor, 0, 64))
+	if err != nil {
+		return fmt.Errorf("read outbox cursor: %w", err)
+	}
+	if s := strings.TrimSpace(string(raw)); s != "" {
+		if ob.ackOff, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return fmt.Errorf("corrupt outbox cursor %q: %w", s, err)
+		}
+	}
+
+	info, err := ob.f.Stat()
+	if err != nil {
+		return fmt.Errorf("stat outbox queue: %w", err)
+	}
+	ob.size = info.Size()
+	if ob.ackOff > ob.size {
+		ob.ackOff = ob.size
+	}
+
+	r := bufio.NewReader(io.NewSectionReader(ob.f, ob.ackOff, ob.size-ob.ackOff))
+	off := ob.ackOff
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				if err := ob.f.Truncate(off); err != nil {
+					return fmt.Errorf("truncate torn outbox entry: %w", err)
+				}
+				ob.size = off
+			}
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("scan outbox queue: %w", err)
+		}
+		off += int64(len(line))
+		ob.depth++
+	}
+
+	ob.readOff = ob.ackOff
+	return nil
+}
+
+// append queues one event. id and evt are the event's id and its
+// marshalled JSON.
+func (ob *outbox) append(id string, evt []byte, enqueuedAt int64) error {
+	line, err := json.Marshal(record{T: enqueuedAt, ID: id, E: evt})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	if ob.maxBytes > 0 && ob.size-ob.ackOff+int64(len(line)) > ob.maxBytes {
+		return errQueueFull
+	}
+	if _, err := ob.f.WriteAt(line, ob.size); err != nil {
+		return err
+	}
+	ob.size += int64(len(line))
+	ob.depth++
+
+	select {
+	case ob.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// next returns up to n unresolved entries after the last one handed
+// out, skipping anything already resolved.
+func (ob *outbox) next(n int) ([]entry, error) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	var out []entry
+	r := bufio.NewReader(io.NewSectionReader(ob.f, ob.readOff, ob.size-ob.readOff))
+	for len(out) < n && ob.readOff < ob.size {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			// size only covers complete lines, so a short read here
+			// is a real I/O problem.
+			return out, fmt.Errorf("read outbox queue: %w", err)
+		}
+		start := ob.readOff
+		ob.readOff += int64(len(line))
+		if _, done := ob.resolved[start]; done {
+			continue
+		}
+
+		var rec record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			// Unparseable line: nothing useful can be sent for it, so
+			// resolve it in place rather than wedging the queue.
+			ob.resolveLocked(start, ob.readOff)
+			continue
+		}
+		out = append(out, entry{
+			start:      start,
+			end:        ob.readOff,
+			enqueuedAt: rec.T,
+			id:         rec.ID,
+			event:      rec.E,
+		})
+	}
+	return out, nil
+}
+
+// resolve marks an entry as done with (acked or permanently
+// rejected) and advances the cursor if it was at the head.
+func (ob *outbox) resolve(e entry) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	ob.resolveLocked(e.start, e.end)
+}
+
+func (ob *outbox) resolveLocked(start, end int64) {
+	if start < ob.ackOff {
+		return
+	}
+	if _, dup := ob.resolved[start]; dup {
+		return
+	}
+	ob.resolved[start] = end
+	ob.depth--
+
+	moved := false
+	for {
+		end, ok := ob.resolved[ob.ackOff]
+		if !ok {
+			break
+		}
+		delete(ob.resolved, ob.ackOff)
+		ob.ackOff = end
+		moved = true
+	}
+	if moved {
+		ob.writeCursorLocked()
+	}
+}
+
+// rewind makes next() start again from the cursor, so every
+// unresolved entry is offered again. Called after a reconnect or a
+// round that left entries unresolved.
+func (ob *outbox) rewind() {
+	ob.mu.Lock()
+	ob.readOff = ob.ackOff
+	ob.mu.Unlock()
+}
+
+// compact drops the resolved prefix of the log: by truncating when
+// nothing is left, or by rewriting the live tail once the prefix is
+// past compactThreshold. Must only be called while the caller holds
+// no entries from next().
+func (ob *outbox) compact() error {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	if ob.ackOff == 0 {
+		return nil
+	}
+	if ob.ackOff == ob.size {
+		if err := ob.f.Truncate(0); err != nil {
+			return err
+		}
+		ob.size, ob.ackOff, ob.readOff = 0, 0, 0
+		ob.resolved = make(map[int64]int64)
+		ob.writeCursorLocked()
+		return nil
+	}
+	if ob.ackOff < compactThreshold {
+		return nil
+	}
+
+	tmpPath := filepath.Join(ob.dir, queueFile+".tmp")
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmp, io.NewSectionReader(ob.f, ob.ackOff, ob.size-ob.ackOff)); err == nil {
+		err = tmp.Sync()
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	// The old cursor means nothing in the new file, so zero it before
+	// the rename: dying in between then replays the resolved prefix
+	// (the remote answers duplicate:) rather than skipping entries.
+	if err := ob.syncCursor(0); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, filepath.Join(ob.dir, queueFile)); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		ob.writeCursorLocked()
+		return err
+	}
+
+	shift := ob.ackOff
+	ob.f.Close()
+	ob.f = tmp
+	ob.size -= shift
+	ob.readOff -= shift
+	ob.ackOff = 0
+	shifted := make(map[int64]int64, len(ob.resolved))
+	for s, e := range ob.resolved {
+		shifted[s-shift] = e - shift
+	}
+	ob.resolved = shifted
+	ob.writeCursorLocked()
+	return nil
+}
+
+// writeCursorLocked persists ackOff. Fixed-width so the write is a
+// single in-place pwrite with no truncate.
+func (ob *outbox) writeCursorLocked() {
+	_, _ = ob.cursor.WriteAt(cursorLine(ob.ackOff), 0)
+}
+
+// syncCursor writes off as the cursor and fsyncs it, for compact,
+// which has to know it landed before it goes on.
+func (ob *outbox) syncCursor(off int64) error {
+	if _, err := ob.cursor.WriteAt(cursorLine(off), 0); err != nil {
+		return fmt.Errorf("write outbox cursor: %w", err)
+	}
+	return ob.cursor.Sync()
+}
+
+func cursorLine(off int64) []byte {
+	return []byte(fmt.Sprintf("%020d\n", off))
+}
+
+// oldest returns the enqueue time (unix ms) of the entry at the
+// cursor, or 0 when nothing is pending.
+func (ob *outbox) oldest() int64 {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	if ob.depth == 0 || ob.ackOff >= ob.size {
+		return 0
+	}
+	line, err := bufio.NewReader(io.NewSectionReader(ob.f, ob.ackOff, ob.size-ob.ackOff)).ReadBytes('\n')
+	if err != nil {
+		return 0
+	}
+	var rec struct {
+		T int64 `json:"t"`
+	}
+	if json.Unmarshal(line, &rec) != nil {
+		return 0
+	}
+	return rec.T
+}
+
+// stats returns the pending entry count and the bytes they occupy.
+func (ob *outbox) stats() (depth int, bytes int64) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	return ob.depth, ob.size - ob.ackOff
+}
+
+func (ob *outbox) close() {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	ob.f.Close()
+	ob.cursor.Close()
+}
@@ -0,0 +1,196 @@
+package replication
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func appendN(t *testing.T, ob *outbox, from, n int) {
+	t.Helper()
+	for i := from; i < from+n; i++ {
+		id := fmt.Sprintf("%064d", i)
+		if err := ob.append(id, []byte(fmt.Sprintf(`{"id":"%s"}`, id)), int64(1000+i)); err != nil {
+			t.Fatalf("append %d: %v", i, err)
+		}
+	}
+}
+
+func TestOutbox_OutOfOrderResolveAndReopen(t *testing.T) {
+	dir := t.TempDir()
+	ob, err := openOutbox(dir, "wss://x", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendN(t, ob, 0, 5)
+
+	got, err := ob.next(10)
+	if err != nil || len(got) != 5 {
+		t.Fatalf("next: %d entries, %v", len(got), err)
+	}
+	// Resolve 0, 2, 3 — the cursor can only move past 0.
+	ob.resolve(got[0])
+	ob.resolve(got[2])
+	ob.resolve(got[3])
+	if depth, _ := ob.stats(); depth != 2 {
+		t.Fatalf("depth = %d, want 2", depth)
+	}
+	if ob.oldest() != 1001 {
+		t.Fatalf("oldest = %d, want entry 1's timestamp", ob.oldest())
+	}
+
+	// A rewind re-offers only what's unresolved.
+	ob.rewind()
+	again, _ := ob.next(10)
+	if len(again) != 2 || again[0].id != got[1].id || again[1].id != got[4].id {
+		t.Fatalf("after rewind got %v", again)
+	}
+	ob.close()
+
+	// Reopening replays from the persisted cursor. Out-of-order
+	// resolutions aren't persisted, so 2 and 3 come back too —
+	// at-least-once.
+	ob, err = openOutbox(dir, "wss://x", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ob.close()
+	if depth, _ := ob.stats(); depth != 4 {
+		t.Fatalf("depth after reopen = %d, want 4", depth)
+	}
+	replay, _ := ob.next(10)
+	if len(replay) != 4 || replay[0].id != got[1].id {
+		t.Fatalf("replay = %v", replay)
+	}
+}
+
+func TestOutbox_TornTailIsTrimmed(t *testing.T) {
+	dir := t.TempDir()
+	ob, err := openOutbox(dir, "wss://x", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendN(t, ob, 0, 2)
+	ob.close()
+
+	f, _ := os.OpenFile(filepath.Join(dir, queueFile), os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString(`{"t":1,"id":"half`)
+	f.Close()
+
+	ob, err = openOutbox(dir, "wss://x", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ob.close()
+	if depth, _ := ob.stats(); depth != 2 {
+		t.Fatalf("depth = %d, want 2", depth)
+	}
+	appendN(t, ob, 2, 1)
+	all, err := ob.next(10)
+	if err != nil || len(all) != 3 {
+		t.Fatalf("next after trim: %d entries, %v", len(all), err)
+	}
+}
+
+func TestOutbox_CompactAndCap(t *testing.T) {
+	ob, err := openOutbox(t.TempDir(), "wss://x", 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ob.close()
+
+	// ~100 bytes per entry: the tenth or so hits the 1 KiB cap.
+	var full bool
+	for i := 0; i < 20 && !full; i++ {
+		id := fmt.Sprintf("%064d", i)
+		if err := ob.append(id, []byte(`{}`), 1); err == errQueueFull {
+			full = true
+		}
+	}
+	if !full {
+		t.Fatal("expected errQueueFull")
+	}
+
+	// Draining everything lets compact truncate, freeing the space.
+	entries, _ := ob.next(100)
+	for _, e := range entries {
+		ob.resolve(e)
+	}
+	if err := ob.compact(); err != nil {
+		t.Fatal(err)
+	}
+	if ob.size != 0 || ob.ackOff != 0 {
+		t.Fatalf("size=%d ackOff=%d after compact, want 0/0", ob.size, ob.ackOff)
+	}
+	appendN(t, ob, 100, 1)
+	if next, _ := ob.next(10); len(next) != 1 || next[0].start != 0 {
+		t.Fatalf("after truncate next = %+v", next)
+	}
+}
+
+func TestOutbox_CompactRewritesLiveTail(t *testing.T) {
+	old := compactThreshold
+	t.Cleanup(func() { compactThreshold = old })
+	compactThreshold = 1
+
+	dir := t.TempDir()
+	ob, err := openOutbox(dir, "wss://x", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendN(t, ob, 0, 4)
+	entries, _ := ob.next(10)
+	ob.resolve(entries[0])
+	ob.resolve(entries[1])
+	ob.resolve(entries[3]) // out of order: must survive the offset shift
+	if err := ob.compact(); err != nil {
+		t.Fatal(err)
+	}
+	if ob.ackOff != 0 || ob.size != entries[3].end-entries[2].start {
+		t.Fatalf("ackOff=%d size=%d after compact", ob.ackOff, ob.size)
+	}
+	ob.rewind()
+	left, _ := ob.next(10)
+	if len(left) != 1 || left[0].id != entries[2].id {
+		t.Fatalf("left = %+v", left)
+	}
+	ob.close()
+
+	ob, err = openOutbox(dir, "wss://x", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ob.close()
+	if replay, _ := ob.next(10); len(replay) != 2 || replay[0].id != entries[2].id {
+		t.Fatalf("replay after compact = %+v", replay)
+	}
+}
+
+// TestOutbox_CompactCrashBeforeRename is the state compact leaves if
+// the process dies between zeroing the cursor and the rename: the old
+// log with cursor 0. Everything is offered again; nothing is skipped.
+func TestOutbox_CompactCrashBeforeRename(t *testing.T) {
+	dir := t.TempDir()
+	ob, err := openOutbox(dir, "wss://x", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendN(t, ob, 0, 4)
+	entries, _ := ob.next(10)
+	ob.resolve(entries[0])
+	ob.resolve(entries[1])
+	if err := ob.syncCursor(0); err != nil {
+		t.Fatal(err)
+	}
+	ob.close()
+
+	ob, err = openOutbox(dir, "wss://x", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ob.close()
+	if replay, _ := ob.next(10); len(replay) != 4 || replay[0].id != entries[0].id {
+		t.Fatalf("replay = %+v, want all 4 entries", replay)
+	}
+}
@@ -0,0 +1,251 @@
+// Package replication mirrors accepted events to the backup relays
+// configured under backup_relay in config.yml.
+//
+// It replaces the old utils.SendToBackupRelay, which dialed a fresh
+// websocket per event, slept 500ms, never read the OK and dropped the
+// event on any failure — so a backup relay that was down for a minute
+// silently missed a minute of events. Here each target gets:
+//
+//   - a disk-backed outbox (outbox.go) that HandleEvent appends to
+//     synchronously, so an accepted event is queued before the client
+//     sees its OK and survives restarts;
+//   - one long-lived connection that pushes the outbox in rounds and
+//     only resolves an entry once the remote's OK comes back
+//     (target.go), with exponential backoff on dial failures,
+//     dropped connections, missing OKs and transient rejections;
+//   - an optional kind/author filter (BackupRelayTarget).
+//
+// Status() feeds the NIP-86 grain_replicationstatus method.
+package replication
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	cfgType "github.com/0ceanslim/grain/config/types"
+	nostr "github.com/0ceanslim/grain/server/types"
+	"github.com/0ceanslim/grain/server/utils/log"
+)
+
+// defaultMaxQueueMB applies when backup_relay.max_queue_mb is 0.
+const defaultMaxQueueMB = 1024
+
+// Manager owns every target's outbox and sender for one server
+// instance. A config reload closes it and starts a fresh one against
+// the same directories, so nothing queued is lost.
+type Manager struct {
+	targets []*target
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// Start opens an outbox under dir for every configured destination
+// and starts its sender. Plain URLs become unfiltered targets. A
+// target whose outbox can't be opened is logged and skipped rather
+// than failing the others.
+func Start(cfg cfgType.BackupRelayConfig, dir string) *Manager {
+	maxMB := cfg.MaxQueueMB
+	if maxMB <= 0 {
+		maxMB = defaultMaxQueueMB
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &Manager{cancel: cancel}
+
+	for _, tc := range Targets(cfg) {
+		box, err := openOutbox(filepath.Join(dir, outboxDirName(tc.URL)), tc.URL, int64(maxMB)<<20)
+		if err != nil {
+			log.Replication().Error("Failed to open replication outbox",
+				"relay_url", tc.URL,
+				"error", err)
+			continue
+		}
+		t := newTarget(tc, box)
+		m.targets = append(m.targets, t)
+
+		depth, _ := box.stats()
+		log.Replication().Info("Replication target started",
+			"relay_url", tc.URL,
+			"kinds", tc.Kinds,
+			"authors", len(tc.Authors),
+			"queued", depth)
+
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			t.run(ctx)
+		}()
+	}
+	return m
+}
+
+// Targets flattens the config into one list: every plain URL as an
+// unfiltered target, followed by the filtered targets.
+func Targets(cfg cfgType.BackupRelayConfig) []cfgType.BackupRelayTarget {
+	out := make([]cfgType.BackupRelayTarget, 0, len(cfg.URLs)+len(cfg.Targets))
+	for _, u := range cfg.URLs {
+		out = append(out, cfgType.BackupRelayTarget{URL: u})
+	}
+	return append(out, cfg.Targets...)
+}
+
+// outboxDirName derives a stable, filesystem-safe directory name for
+// a target: the host for readability plus a hash of the full URL so
+// two paths on one host don't collide.
+func outboxDirName(rawURL string) string {
+	sum := sha256.Sum256([]byte(rawURL))
+	host := "relay"
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		host = unsafeDirChars.ReplaceAllString(u.Host, "_")
+	}
+	return host + "-" + hex.EncodeToString(sum[:4])
+}
+
+var unsafeDirChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// Enqueue appends evt to the outbox of every target whose filter it
+// passes. Called on the HandleEvent path after the event is stored;
+// it does a file append per target and never blocks on the network.
+func (m *Manager) Enqueue(evt nostr.Event) {
+	var raw []byte
+	now := time.Now().UnixMilli()
+	for _, t := range m.targets {
+		if !t.wants(evt) {
+			continue
+		}
+		if raw == nil {
+			var err error
+			if raw, err = json.Marshal(evt); err != nil {
+				log.Replication().Error("Failed to marshal event for replication",
+					"event_id", evt.ID,
+					"error", err)
+				return
+			}
+		}
+		if err := t.box.append(evt.ID, raw, now); err != nil {
+			t.dropped.Add(1)
+			log.Replication().Error("Failed to queue event for replication",
+				"relay_url", t.url,
+				"event_id", evt.ID,
+				"error", err)
+		}
+	}
+}
+
+// Close stops every sender and closes the outboxes. Queued entries
+// stay on disk for the next Start.
+func (m *Manager) Close() {
+	m.cancel()
+	m.wg.Wait()
+	for _, t := range m.targets {
+		t.box.close()
+	}
+}
+
+// TargetStatus is one row of grain_replicationstatus.
+type TargetStatus struct {
+	URL             string   `json:"url"`
+	Kinds           []int    `json:"kinds,omitempty"`
+	Authors         []string `json:"authors,omitempty"`
+	Connected       bool     `json:"connected"`
+	QueueDepth      int      `json:"queue_depth"`
+	QueueBytes      int64    `json:"queue_bytes"`
+	LagSeconds      float64  `json:"lag_seconds"` // age of the oldest unacknowledged event
+	OldestPendingAt int64    `json:"oldest_pending_at,omitempty"`
+	LastAckAt       int64    `json:"last_ack_at,omitempty"`
+	Acked           uint64   `json:"acked"`
+	Rejected        uint64   `json:"rejected"`
+	Dropped         uint64   `json:"dropped"` // outbox full or unwritable
+	Retries         uint64   `json:"retries"`
+	LastError       string   `json:"last_error,omitempty"`
+	NextRetryAt     int64    `json:"next_retry_at,omitempty"`
+}
+
+// Status reports every target. Counters are since this Manager
+// started; depth and lag come from the outbox and so include events
+// queued before a restart.
+func (m *Manager) Status() []TargetStatus {
+	out := make([]TargetStatus, 0, len(m.targets))
+	now := time.Now()
+	for _, t := range m.targets {
+		st := TargetStatus{
+			URL:       t.url,
+			Connected: t.connected.Load(),
+			Acked:     t.acked.Load(),
+			Rejected:  t.rejected.Load(),
+			Dropped:   t.dropped.Load(),
+			Retries:   t.retries.Load(),
+		}
+		for k := range t.kinds {
+			st.Kinds = append(st.Kinds, k)
+		}
+		for a := range t.authors {
+			st.Authors = append(st.Authors, a)
+		}
+		sort.Ints(st.Kinds)
+		sort.Strings(st.Authors)
+		st.QueueDepth, st.QueueBytes = t.box.stats()
+		if oldest := t.box.oldest(); oldest > 0 {
+			st.OldestPendingAt = oldest / 1000
+			st.LagSeconds = now.Sub(time.UnixMilli(oldest)).Seconds()
+		}
+		if ms := t.lastAckAt.Load(); ms > 0 {
+			st.LastAckAt = ms / 1000
+		}
+
+		t.mu.Lock()
+		st.LastError = t.lastError
+		if !t.nextRetryAt.IsZero() {
+			st.NextRetryAt = t.nextRetryAt.Unix()
+		}
+		t.mu.Unlock()
+
+		out = append(out, st)
+	}
+	return out
+}
+
+var (
+	active   *Manager
+	activeMu sync.RWMutex
+)
+
+// SetManager installs the instance-wide manager (nil to clear) and
+// returns the previous one so the caller can Close it.
+func SetManager(m *Manager) *Manager {
+	activeMu.Lock()
+	defer activeMu.Unlock()
+	prev := active
+	active = m
+	return prev
+}
+
+// Enqueue hands evt to the active manager, if replication is on.
+func Enqueue(evt nostr.Event) {
+	activeMu.RLock()
+	m := active
+	activeMu.RUnlock()
+	if m != nil {
+		m.Enqueue(evt)
+	}
+}
+
+// Status returns the active manager's per-target status, or an empty
+// list when replication is off.
+func Status() []TargetStatus {
+	activeMu.RLock()
+	m := active
+	activeMu.RUnlock()
+	if m == nil {
+		return []TargetStatus{}
+	}
+	return m.Status()
+}
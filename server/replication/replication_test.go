@@ -0,0 +1,240 @@
+package replication
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	cfgType "github.com/0ceanslim/grain/config/types"
+	nostr "github.com/0ceanslim/grain/server/types"
+	"golang.org/x/net/websocket"
+)
+
+// fakeRelay is a minimal EVENT/OK responder. reply decides the OK
+// for each event; it's called with the number of times that id has
+// been seen so tests can reject first and accept later.
+type fakeRelay struct {
+	srv *httptest.Server
+
+	mu    sync.Mutex
+	seen  map[string]int
+	reply func(id string, attempt int) (bool, string)
+}
+
+func newFakeRelay(t *testing.T, reply func(id string, attempt int) (bool, string)) *fakeRelay {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newFakeRelayOn(t, l, reply)
+}
+
+// newFakeRelayOn serves on l, so a test can bring a relay up on an
+// address that was dead earlier.
+func newFakeRelayOn(t *testing.T, l net.Listener, reply func(id string, attempt int) (bool, string)) *fakeRelay {
+	t.Helper()
+	fr := &fakeRelay{seen: make(map[string]int), reply: reply}
+	fr.srv = httptest.NewUnstartedServer(websocket.Handler(func(ws *websocket.Conn) {
+		for {
+			var msg string
+			if err := websocket.Message.Receive(ws, &msg); err != nil {
+				return
+			}
+			var frame []json.RawMessage
+			if json.Unmarshal([]byte(msg), &frame) != nil || len(frame) != 2 {
+				continue
+			}
+			var evt nostr.Event
+			_ = json.Unmarshal(frame[1], &evt)
+
+			fr.mu.Lock()
+			fr.seen[evt.ID]++
+			attempt := fr.seen[evt.ID]
+			fr.mu.Unlock()
+
+			ok, reason := fr.reply(evt.ID, attempt)
+			out, _ := json.Marshal([]interface{}{"OK", evt.ID, ok, reason})
+			if websocket.Message.Send(ws, string(out)) != nil {
+				return
+			}
+		}
+	}))
+	fr.srv.Listener.Close()
+	fr.srv.Listener = l
+	fr.srv.Start()
+	t.Cleanup(fr.srv.Close)
+	return fr
+}
+
+func (fr *fakeRelay) url() string { return "ws" + strings.TrimPrefix(fr.srv.URL, "http") }
+
+func (fr *fakeRelay) count(id string) int {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	return fr.seen[id]
+}
+
+func fastRetries(t *testing.T) {
+	t.Helper()
+	prevMin, prevMax, prevAck := minBackoff, maxBackoff, ackTimeout
+	minBackoff, maxBackoff, ackTimeout = 10*time.Millisecond, 50*time.Millisecond, time.Second
+	t.Cleanup(func() { minBackoff, maxBackoff, ackTimeout = prevMin, prevMax, prevAck })
+}
+
+func testEvent(i, kind int, pubkey string) nostr.Event {
+	return nostr.Event{ID: fmt.Sprintf("%064x", i), PubKey: pubkey, Kind: kind, CreatedAt: int64(1700000000 + i)}
+}
+
+// waitFor polls cond for up to 5s.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestManager_DeliversAndFilters(t *testing.T) {
+	fastRetries(t)
+	all := newFakeRelay(t, func(string, int) (bool, string) { return true, "" })
+	notes := newFakeRelay(t, func(string, int) (bool, string) { return true, "" })
+
+	alice := strings.Repeat("a", 64)
+	m := Start(cfgType.BackupRelayConfig{
+		Enabled: true,
+		URLs:    []string{all.url()},
+		Targets: []cfgType.BackupRelayTarget{{URL: notes.url(), Kinds: []int{1}, Authors: []string{alice}}},
+	}, t.TempDir())
+	defer m.Close()
+
+	m.Enqueue(testEvent(1, 1, alice))
+	m.Enqueue(testEvent(2, 7, alice))                   // wrong kind for notes
+	m.Enqueue(testEvent(3, 1, strings.Repeat("b", 64))) // wrong author for notes
+
+	waitFor(t, "all three on the unfiltered target", func() bool {
+		return m.Status()[0].Acked == 3
+	})
+	waitFor(t, "one on the filtered target", func() bool {
+		return m.Status()[1].Acked == 1
+	})
+	if notes.count(testEvent(2, 7, alice).ID) != 0 || notes.count(testEvent(3, 1, "").ID) != 0 {
+		t.Fatal("filtered target received events outside its filter")
+	}
+
+	st := m.Status()[0]
+	if !st.Connected || st.QueueDepth != 0 || st.LagSeconds != 0 || st.LastAckAt == 0 {
+		t.Fatalf("unexpected status after drain: %+v", st)
+	}
+	if got := m.Status()[1].Kinds; len(got) != 1 || got[0] != 1 {
+		t.Fatalf("filtered target kinds = %v", got)
+	}
+}
+
+func TestManager_QueuesWhileDownAndSurvivesRestart(t *testing.T) {
+	fastRetries(t)
+	dir := t.TempDir()
+
+	// Reserve an address, then leave nothing listening on it: events
+	// pile up in the outbox.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	cfg := cfgType.BackupRelayConfig{Enabled: true, URLs: []string{"ws://" + addr}}
+	m := Start(cfg, dir)
+	for i := 0; i < 5; i++ {
+		m.Enqueue(testEvent(i, 1, ""))
+	}
+	waitFor(t, "a failed dial", func() bool { return m.Status()[0].Retries > 0 })
+	st := m.Status()[0]
+	if st.Connected || st.QueueDepth != 5 || st.LastError == "" || st.OldestPendingAt == 0 {
+		t.Fatalf("unexpected status while down: %+v", st)
+	}
+	m.Close() // a reload: the backlog stays on disk
+
+	l, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("address %s was reused before the relay came back: %v", addr, err)
+	}
+	relay := newFakeRelayOn(t, l, func(string, int) (bool, string) { return true, "" })
+
+	m = Start(cfg, dir)
+	defer m.Close()
+	waitFor(t, "the backlog to drain", func() bool { return m.Status()[0].Acked == 5 })
+	if st := m.Status()[0]; st.QueueDepth != 0 {
+		t.Fatalf("queue depth after drain = %d", st.QueueDepth)
+	}
+	for i := 0; i < 5; i++ {
+		if relay.count(testEvent(i, 1, "").ID) != 1 {
+			t.Fatalf("event %d delivered %d times", i, relay.count(testEvent(i, 1, "").ID))
+		}
+	}
+}
+
+func TestManager_TransientAndPermanentRejections(t *testing.T) {
+	fastRetries(t)
+	retryID := testEvent(1, 1, "").ID
+	blockedID := testEvent(2, 1, "").ID
+	relay := newFakeRelay(t, func(id string, attempt int) (bool, string) {
+		switch {
+		case id == retryID && attempt < 3:
+			return false, "rate-limited: slow down"
+		case id == blockedID:
+			return false, "blocked: not on my relay"
+		case attempt == 2:
+			return false, "duplicate: already have it"
+		}
+		return true, ""
+	})
+
+	m := Start(cfgType.BackupRelayConfig{Enabled: true, URLs: []string{relay.url()}}, t.TempDir())
+	defer m.Close()
+	m.Enqueue(testEvent(1, 1, ""))
+	m.Enqueue(testEvent(2, 1, ""))
+	m.Enqueue(testEvent(3, 1, ""))
+
+	waitFor(t, "everything resolved", func() bool {
+		st := m.Status()[0]
+		return st.QueueDepth == 0
+	})
+	st := m.Status()[0]
+	if st.Rejected != 1 || st.Acked != 2 || st.Retries < 2 {
+		t.Fatalf("acked=%d rejected=%d retries=%d, want 2/1/>=2", st.Acked, st.Rejected, st.Retries)
+	}
+	if relay.count(retryID) != 3 || relay.count(blockedID) != 1 {
+		t.Fatalf("retried event sent %d times, blocked one %d", relay.count(retryID), relay.count(blockedID))
+	}
+}
+
+func TestClassifyOK(t *testing.T) {
+	cases := []struct {
+		ok   okMsg
+		want okClass
+	}{
+		{okMsg{accepted: true}, okDelivered},
+		{okMsg{reason: "duplicate: have it"}, okDelivered},
+		{okMsg{reason: "rate-limited: slow"}, okRetry},
+		{okMsg{reason: "error: db down"}, okRetry},
+		{okMsg{reason: "auth-required: who are you"}, okRetry},
+		{okMsg{reason: "blocked: nope"}, okRejected},
+		{okMsg{reason: "invalid: bad sig"}, okRejected},
+		{okMsg{reason: "something else"}, okRejected},
+	}
+	for _, c := range cases {
+		if got := classifyOK(c.ok); got != c.want {
+			t.Errorf("classifyOK(%+v) = %d, want %d", c.ok, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,354 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	cfgType "github.com/0ceanslim/grain/config/types"
+	nostr "github.com/0ceanslim/grain/server/types"
+	"github.com/0ceanslim/grain/server/utils/log"
+	"golang.org/x/net/websocket"
+)
+
+// Tunables. Package vars rather than consts so tests can shrink them.
+var (
+	// roundSize is how many events go out before the sender waits for
+	// their OKs. Big enough to keep a high-latency link busy, small
+	// enough that a reconnect doesn't resend much.
+	roundSize = 100
+
+	// ackTimeout bounds the wait for a round's OKs. A relay that never
+	// sends OK (some blasters don't) gets reconnected and retried
+	// rather than silently treated as delivered.
+	ackTimeout = 30 * time.Second
+
+	dialTimeout = 10 * time.Second
+
+	// minBackoff / maxBackoff bound the exponential retry delay after
+	// a failed dial, a dropped connection, a timed-out round or a
+	// transient OK rejection.
+	minBackoff = time.Second
+	maxBackoff = 5 * time.Minute
+
+	// maxAttempts is how many transient rejections (rate-limited:,
+	// error:, auth-required:) one event gets before it's given up on,
+	// so a single poisoned event can't wedge the queue forever.
+	maxAttempts = 10
+)
+
+// target is one replication destination: its filter, its outbox and
+// the goroutine that drains it.
+type target struct {
+	url     string
+	kinds   map[int]bool
+	authors map[string]bool
+	box     *outbox
+
+	connected atomic.Bool
+	acked     atomic.Uint64
+	rejected  atomic.Uint64
+	dropped   atomic.Uint64
+	retries   atomic.Uint64
+	lastAckAt atomic.Int64 // unix ms
+
+	mu          sync.Mutex
+	lastError   string
+	nextRetryAt time.Time
+
+	// attempts counts transient rejections per event id. Only the
+	// sender goroutine touches it.
+	attempts map[string]int
+}
+
+func newTarget(t cfgType.BackupRelayTarget, box *outbox) *target {
+	tg := &target{url: t.URL, box: box, attempts: make(map[string]int)}
+	if len(t.Kinds) > 0 {
+		tg.kinds = make(map[int]bool, len(t.Kinds))
+		for _, k := range t.Kinds {
+			tg.kinds[k] = true
+		}
+	}
+	if len(t.Authors) > 0 {
+		tg.authors = make(map[string]bool, len(t.Authors))
+		for _, a := range t.Authors {
+			tg.authors[strings.ToLower(a)] = true
+		}
+	}
+	return tg
+}
+
+// wants reports whether evt passes this target's filter.
+func (t *target) wants(evt nostr.Event) bool {
+	if t.kinds != nil && !t.kinds[evt.Kind] {
+		return false
+	}
+	if t.authors != nil && !t.authors[evt.PubKey] {
+		return false
+	}
+	return true
+}
+
+func (t *target) setError(err error, retryAt time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err != nil {
+		t.lastError = err.Error()
+	}
+	t.nextRetryAt = retryAt
+}
+
+// okMsg is a parsed ["OK", id, accepted, reason].
+type okMsg struct {
+	id       string
+	accepted bool
+	reason   string
+}
+
+// run drains the outbox until ctx is cancelled: dial, push rounds,
+// and on any failure back off exponentially and start over from the
+// cursor.
+func (t *target) run(ctx context.Context) {
+	backoff := minBackoff
+	for ctx.Err() == nil {
+		progressed, err := t.session(ctx)
+		t.connected.Store(false)
+		if ctx.Err() != nil {
+			return
+		}
+		if progressed {
+			backoff = minBackoff
+		}
+
+		t.retries.Add(1)
+		retryAt := time.Now().Add(backoff)
+		t.setError(err, retryAt)
+		log.Replication().Warn("Replication target unavailable, retrying",
+			"relay_url", t.url,
+			"error", err,
+			"retry_in", backoff.String())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// session runs one connection's worth of rounds. It returns when the
+// connection fails or a round couldn't fully resolve; progressed
+// reports whether any entry was resolved along the way, which resets
+// the caller's backoff.
+func (t *target) session(ctx context.Context) (progressed bool, err error) {
+	t.box.rewind()
+
+	conn, err := t.dial(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	// Closing the conn is what unblocks the reader on shutdown.
+	sessCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-sessCtx.Done()
+		conn.Close()
+	}()
+
+	oks := make(chan okMsg, roundSize)
+	readErr := make(chan error, 1)
+	go t.readLoop(sessCtx, conn, oks, readErr)
+
+	t.connected.Store(true)
+	t.setError(nil, time.Time{})
+	log.Replication().Info("Connected to replication target", "relay_url", t.url)
+
+	for {
+		if err := t.box.compact(); err != nil {
+			log.Replication().Error("Outbox compaction failed", "relay_url", t.url, "error", err)
+		}
+
+		entries, err := t.box.next(roundSize)
+		if err != nil {
+			return progressed, err
+		}
+		if len(entries) == 0 {
+			select {
+			case <-ctx.Done():
+				return progressed, ctx.Err()
+			case err := <-readErr:
+				return progressed, err
+			case <-t.box.notify:
+			}
+			continue
+		}
+
+		resolved, err := t.round(ctx, conn, entries, oks, readErr)
+		if resolved > 0 {
+			progressed = true
+		}
+		if err != nil {
+			return progressed, err
+		}
+	}
+}
+
+func (t *target) dial(ctx context.Context) (*websocket.Conn, error) {
+	wsCfg, err := websocket.NewConfig(t.url, "http://localhost/")
+	if err != nil {
+		return nil, err
+	}
+	wsCfg.Dialer = &net.Dialer{Timeout: dialTimeout}
+	dialCtx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	return wsCfg.DialContext(dialCtx)
+}
+
+// round sends entries and waits for their OKs. It returns an error
+// (ending the session) if the connection drops, the OKs don't all
+// arrive within ackTimeout, or any entry was rejected transiently —
+// in every case the unresolved entries are offered again after the
+// backoff.
+func (t *target) round(ctx context.Context, conn *websocket.Conn, entries []entry, oks <-chan okMsg, readErr <-chan error) (int, error) {
+	pending := make(map[string][]entry, len(entries))
+	for _, e := range entries {
+		frame := make([]byte, 0, len(e.event)+12)
+		frame = append(frame, `["EVENT",`...)
+		frame = append(frame, e.event...)
+		frame = append(frame, ']')
+		if err := websocket.Message.Send(conn, string(frame)); err != nil {
+			return 0, fmt.Errorf("send: %w", err)
+		}
+		pending[e.id] = append(pending[e.id], e)
+	}
+
+	timer := time.NewTimer(ackTimeout)
+	defer timer.Stop()
+
+	resolved := 0
+	var transient error
+	for len(pending) > 0 {
+		select {
+		case <-ctx.Done():
+			return resolved, ctx.Err()
+		case err := <-readErr:
+			return resolved, err
+		case <-timer.C:
+			return resolved, fmt.Errorf("no OK for %d of %d events within %s", len(pending), len(entries), ackTimeout)
+		case ok := <-oks:
+			es, mine := pending[ok.id]
+			if !mine {
+				continue
+			}
+			delete(pending, ok.id)
+
+			switch classifyOK(ok) {
+			case okDelivered:
+				delete(t.attempts, ok.id)
+				t.acked.Add(uint64(len(es)))
+				t.lastAckAt.Store(time.Now().UnixMilli())
+			case okRejected:
+				delete(t.attempts, ok.id)
+				t.rejected.Add(uint64(len(es)))
+				log.Replication().Warn("Replication target rejected event",
+					"relay_url", t.url,
+					"event_id", ok.id,
+					"reason", ok.reason)
+			case okRetry:
+				t.attempts[ok.id]++
+				if t.attempts[ok.id] < maxAttempts {
+					transient = fmt.Errorf("event %s: %s", ok.id, ok.reason)
+					continue
+				}
+				delete(t.attempts, ok.id)
+				t.rejected.Add(uint64(len(es)))
+				log.Replication().Warn("Giving up on event after repeated transient rejections",
+					"relay_url", t.url,
+					"event_id", ok.id,
+					"attempts", maxAttempts,
+					"reason", ok.reason)
+			}
+			for _, e := range es {
+				t.box.resolve(e)
+				resolved++
+			}
+		}
+	}
+	return resolved, transient
+}
+
+// readLoop parses frames from the target and forwards OKs. Anything
+// else (NOTICE, AUTH challenges, stray EVENTs) is logged and dropped.
+func (t *target) readLoop(ctx context.Context, conn *websocket.Conn, oks chan<- okMsg, readErr chan<- error) {
+	for {
+		var msg string
+		if err := websocket.Message.Receive(conn, &msg); err != nil {
+			readErr <- fmt.Errorf("read: %w", err) // buffered; sent at most once
+			return
+		}
+		var frame []json.RawMessage
+		if json.Unmarshal([]byte(msg), &frame) != nil || len(frame) == 0 {
+			continue
+		}
+		var typ string
+		_ = json.Unmarshal(frame[0], &typ)
+		switch typ {
+		case "OK":
+			if len(frame) < 3 {
+				continue
+			}
+			var ok okMsg
+			_ = json.Unmarshal(frame[1], &ok.id)
+			_ = json.Unmarshal(frame[2], &ok.accepted)
+			if len(frame) > 3 {
+				_ = json.Unmarshal(frame[3], &ok.reason)
+			}
+			select {
+			case oks <- ok:
+			case <-ctx.Done():
+				return
+			}
+		case "NOTICE":
+			var notice string
+			if len(frame) > 1 {
+				_ = json.Unmarshal(frame[1], &notice)
+			}
+			log.Replication().Info("NOTICE from replication target", "relay_url", t.url, "notice", notice)
+		}
+	}
+}
+
+type okClass int
+
+const (
+	okDelivered okClass = iota
+	okRejected
+	okRetry
+)
+
+// classifyOK maps an OK to what the outbox should do with the event.
+// `duplicate:` counts as delivered — the remote has it. The NIP-01
+// prefixes that can clear up on their own are retried; everything
+// else (blocked:, invalid:, pow:, restricted:, unknown) is final.
+func classifyOK(ok okMsg) okClass {
+	if ok.accepted || strings.HasPrefix(ok.reason, "duplicate:") {
+		return okDelivered
+	}
+	for _, p := range []string{"rate-limited:", "error:", "auth-required:"} {
+		if strings.HasPrefix(ok.reason, p) {
+			return okRetry
+		}
+	}
+	return okRejected
+}
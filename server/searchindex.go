@@ -0,0 +1,119 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/0ceanslim/grain/config"
+	cfgType "github.com/0ceanslim/grain/config/types"
+	"github.com/0ceanslim/grain/server/db/nostrdb"
+	"github.com/0ceanslim/grain/server/fulltext"
+	nostr "github.com/0ceanslim/grain/server/types"
+	"github.com/0ceanslim/grain/server/utils/log"
+)
+
+// openSearchIndex opens <data-dir>/search_index.jsonl for the kinds in
+// search.indexed_kinds that nostrdb doesn't index itself, and installs
+// it; with none, or no database to search, there's nothing to open. The first time, it's filled
+// from the events of those kinds already in db. The relay, --import
+// and --sync all go through here.
+func openSearchIndex(cfg *cfgType.ServerConfig, db *nostrdb.NDB) (*fulltext.Index, error) {
+	if db == nil || len(fulltext.Own(cfg.Search.IndexedKinds)) == 0 {
+		return nil, nil
+	}
+	x, err := fulltext.Open(config.ConfigPath("search_index.jsonl"), cfg.Search.IndexedKinds)
+	if err != nil {
+		return nil, err
+	}
+	if x.Fresh() {
+		if err := fillSearchIndex(x, db); err != nil {
+			x.Close()
+			return nil, err
+		}
+	}
+	if missing := x.Missing(); len(missing) > 0 {
+		log.Search().Warn("Search index predates some indexed kinds; events of them stored before are unsearchable until `grain --reindex-search`",
+			"kinds", missing)
+	}
+	fulltext.SetIndex(x)
+	return x, nil
+}
+
+// fillSearchIndex adds every stored event of x's kinds to x.
+func fillSearchIndex(x *fulltext.Index, db *nostrdb.NDB) error {
+	if len(x.Kinds()) == 0 {
+		return nil // an empty filter would be every event
+	}
+	start := time.Now()
+	events, err := db.Export(nostr.Filter{Kinds: x.Kinds()}, func(evt nostr.Event) error {
+		_, err := x.Add(evt)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	log.Search().Info("Search index built from stored events",
+		"kinds", x.Kinds(),
+		"events", events,
+		"indexed", x.Len(),
+		"took", time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// closeSearchIndex uninstalls and closes the index.
+func closeSearchIndex() {
+	if x := fulltext.SetIndex(nil); x != nil {
+		if err := x.Close(); err != nil {
+			log.Search().Error("Failed to close search index", "error", err)
+		}
+	}
+}
+
+// ReindexSearch is the `grain --reindex-search` entry point: it
+// rebuilds search_index.jsonl from the stored events of the kinds
+// search.indexed_kinds lists now. The relay must be stopped, since it
+// keeps the file open.
+func ReindexSearch() error {
+	if err := ensureConfigFiles(); err != nil {
+		return fmt.Errorf("failed to ensure config files: %w", err)
+	}
+
+	cfg, err := config.LoadConfig(config.ConfigPath("config.yml"))
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	dbPath, mapSizeMB := resolveDatabaseSettings(cfg)
+
+	if databaseInUse(dbPath) {
+		return fmt.Errorf("the database at %s is open in another process; stop the relay before reindexing", dbPath)
+	}
+
+	x, err := fulltext.Open(config.ConfigPath("search_index.jsonl"), cfg.Search.IndexedKinds)
+	if err != nil {
+		return fmt.Errorf("failed to open search index: %w", err)
+	}
+	defer x.Close()
+	if len(x.Kinds()) == 0 {
+		fmt.Println("search.indexed_kinds lists no kinds beyond the ones nostrdb indexes (1 and 30023); emptying the search index.")
+	}
+
+	fmt.Printf("Opening database at %s...\n", dbPath)
+	db, err := nostrdb.Open(dbPath, mapSizeMB, 1)
+	if err != nil {
+		return fmt.Errorf("failed to open nostrdb: %w", err)
+	}
+	defer db.Close()
+
+	start := time.Now()
+	if err := x.Reset(); err != nil {
+		return fmt.Errorf("failed to reset search index: %w", err)
+	}
+	if err := fillSearchIndex(x, db); err != nil {
+		return fmt.Errorf("failed to reindex: %w", err)
+	}
+
+	fmt.Printf("\nReindex complete in %s\n", time.Since(start).Round(time.Millisecond))
+	fmt.Printf("  Kinds:   %v\n", x.Kinds())
+	fmt.Printf("  Indexed: %d events\n", x.Len())
+	return nil
+}
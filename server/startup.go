@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io/fs"
 	"net/http"
@@ -17,8 +18,17 @@ import (
 	"github.com/0ceanslim/grain/config"
 	cfgType "github.com/0ceanslim/grain/config/types"
 	relay "github.com/0ceanslim/grain/server/api"
+	"github.com/0ceanslim/grain/server/audit"
+	"github.com/0ceanslim/grain/server/backup"
 	"github.com/0ceanslim/grain/server/db/nostrdb"
+	"github.com/0ceanslim/grain/server/groups"
 	"github.com/0ceanslim/grain/server/handlers"
+	"github.com/0ceanslim/grain/server/metrics"
+	"github.com/0ceanslim/grain/server/moderation"
+	"github.com/0ceanslim/grain/server/policy"
+	"github.com/0ceanslim/grain/server/quota"
+	"github.com/0ceanslim/grain/server/replication"
+	"github.com/0ceanslim/grain/server/storage"
 	"github.com/0ceanslim/grain/server/utils"
 	"github.com/0ceanslim/grain/server/utils/log"
 
@@ -45,6 +55,12 @@ func Run() error {
 		}
 		return false
 	}
+	utils.MinPowProvider = func() int {
+		if c := config.GetConfig(); c != nil {
+			return c.Pow.MinDifficulty
+		}
+		return 0
+	}
 
 	// Setup configuration file watchers and signal handlers
 	restartChan := make(chan struct{}, 1) // Buffered channel to prevent blocking
@@ -78,18 +94,28 @@ func Run() error {
 		}
 	})
 
+	registerGauges()
+
 	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
 
 	log.Startup().Info("GRAIN relay server starting")
 
+	// Every admin action from here on goes to the audit log.
+	audit.SetLog(audit.New(config.ConfigPath("audit.jsonl")))
+
+	// The running config as of the last reload, so the next instance
+	// can audit what the reload changed. Nil on first start.
+	var reloadedFrom map[string]any
+
 	// Main server lifecycle loop
 	for {
 		// Create shutdown channel for this instance
 		shutdownChan := make(chan struct{})
 
 		// Start server instance in goroutine
+		prevConfig := reloadedFrom
 		go func() {
-			runServerInstance(shutdownChan, restartChan, signalChan)
+			runServerInstance(shutdownChan, restartChan, signalChan, prevConfig)
 		}()
 
 		// Wait for restart or shutdown signal
@@ -100,6 +126,7 @@ func Run() error {
 			time.Sleep(3 * time.Second) // Brief pause before restart
 
 			// Reset configurations to allow fresh loading
+			reloadedFrom = configSnapshot()
 			resetConfigurations()
 			continue
 		case <-signalChan:
@@ -130,8 +157,10 @@ func startConfigWatchers(restartChan chan<- struct{}) {
 	}
 }
 
-// runServerInstance runs a single server instance until shutdown signal
-func runServerInstance(shutdownChan <-chan struct{}, restartChan <-chan struct{}, signalChan <-chan os.Signal) {
+// runServerInstance runs a single server instance until shutdown signal.
+// reloadedFrom is the previous instance's configSnapshot when this one
+// is a reload, nil on first start.
+func runServerInstance(shutdownChan <-chan struct{}, restartChan <-chan struct{}, signalChan <-chan os.Signal, reloadedFrom map[string]any) {
 	// Load all configuration files
 	cfg, err := loadAllConfigs()
 	if err != nil {
@@ -177,6 +206,59 @@ func runServerInstance(shutdownChan <-chan struct{}, restartChan <-chan struct{}
 		return
 	}
 
+	if reloadedFrom != nil {
+		audit.Record(audit.Entry{
+			Source:  audit.SourceReload,
+			Method:  "reload",
+			Changes: audit.Diff(reloadedFrom, configSnapshot()),
+		})
+	}
+
+	// Backup-relay replication. Started before (and so stopped after)
+	// the HTTP server, so every event accepted by this instance makes
+	// it into an outbox before the outboxes are closed for a reload.
+	startReplication(cfg)
+	defer stopReplication()
+
+	// Write-policy plugin, same lifetime rule as replication.
+	startWritePolicy(cfg)
+	defer stopWritePolicy()
+
+	// NIP-29 groups. Before the read policy, which picks up the group
+	// privacy rule.
+	startGroups(cfg, db)
+	defer stopGroups()
+
+	// Event moderation: banned ids and the review queue.
+	startModeration(cfg, db)
+	defer stopModeration()
+
+	// NIP-09 tombstones: deleted events stay deleted.
+	startTombstones(db)
+	defer stopTombstones()
+
+	// NIP-50 search of the kinds nostrdb doesn't index itself.
+	startSearchIndex(cfg, db)
+	defer stopSearchIndex()
+
+	// Per-pubkey storage quotas.
+	startQuotas(cfg, db)
+	defer stopQuotas()
+
+	// Map usage: warnings, automatic growth, and "storage full".
+	// Stopped before the deferred db.Close, after any resize finishes.
+	startStorageMonitor(cfg, db, dbPath)
+	defer stopStorageMonitor()
+
+	// Database backups: grain_backup, and the schedule if enabled.
+	// Stopped before the deferred db.Close, after any copy finishes.
+	startBackups(cfg, db, dbPath)
+	defer stopBackups()
+
+	// Read policy. Nothing to shut down, but it's replaced on reload
+	// along with everything else.
+	policy.SetReadPolicy(policy.NewReadPolicy(cfg.ReadPolicy, groups.ReadRules()...))
+
 	// Setup HTTP server
 	httpServer := setupHTTPServer(cfg)
 	defer func() {
@@ -269,6 +351,15 @@ func initializeSubsystems(cfg *cfgType.ServerConfig) error {
 		log.Startup().Warn("Relay has no owner configured — visit /setup to claim ownership")
 	}
 
+	// The relay's own signing key, for events the relay authors
+	// itself (NIP-29 group state) and NIP-11 `self`. Created on first
+	// start next to relay_metadata.json.
+	if signer, err := utils.LoadRelayKey(config.ConfigPath("relay_key")); err != nil {
+		log.Startup().Error("Failed to load relay key", "error", err, "file", "relay_key")
+	} else {
+		utils.SetRelayKey(signer)
+	}
+
 	// Wire up real-time event broadcasting to active subscribers
 	handlers.OnEventStored = BroadcastEvent
 
@@ -355,6 +446,15 @@ func startBackgroundServices(cfg *cfgType.ServerConfig, dbAvailable bool) {
 				}
 			}()
 			go db.RunExpirationSweeper(context.Background())
+
+			// grain_stats_database: count events per kind and
+			// author once; ingest and delete keep the counts
+			// current from there.
+			go func() {
+				if err := db.BootstrapStats(); err != nil {
+					log.Startup().Error("Database stats count failed", "error", err)
+				}
+			}()
 		}
 
 		log.Startup().Info("All background services started")
@@ -365,6 +465,231 @@ func startBackgroundServices(cfg *cfgType.ServerConfig, dbAvailable bool) {
 	}
 }
 
+// startReplication starts the backup-relay senders when enabled.
+// Outboxes live under <data-dir>/replication, one directory per
+// target URL, so events queued while a target was down are still
+// delivered after a restart.
+func startReplication(cfg *cfgType.ServerConfig) {
+	if !cfg.BackupRelay.Enabled {
+		return
+	}
+	mgr := replication.Start(cfg.BackupRelay, filepath.Join(config.GetDataDir(), "replication"))
+	if prev := replication.SetManager(mgr); prev != nil {
+		prev.Close()
+	}
+}
+
+// stopReplication stops the senders and closes the outboxes.
+func stopReplication() {
+	if mgr := replication.SetManager(nil); mgr != nil {
+		mgr.Close()
+	}
+}
+
+// startWritePolicy launches the write-policy plugin when enabled. A
+// relative command resolves against the data directory, which is
+// also the plugin's working directory.
+func startWritePolicy(cfg *cfgType.ServerConfig) {
+	if !cfg.WritePolicy.Enabled {
+		return
+	}
+	command := cfg.WritePolicy.Command
+	if !filepath.IsAbs(command) && strings.ContainsRune(command, filepath.Separator) {
+		command = filepath.Join(config.GetDataDir(), command)
+	}
+	plugin := policy.StartWritePlugin(cfg.WritePolicy, command, config.GetDataDir())
+	if prev := policy.SetWritePlugin(plugin); prev != nil {
+		prev.Close()
+	}
+}
+
+// startGroups loads NIP-29 group state from <data-dir>/groups.json
+// when groups are enabled. Needs the database (to publish the relay's
+// group state events) and the relay key (to sign them).
+func startGroups(cfg *cfgType.ServerConfig, db *nostrdb.NDB) {
+	if !cfg.Groups.Enabled {
+		return
+	}
+	signer := utils.RelayKey()
+	if db == nil || signer == nil {
+		log.Startup().Error("NIP-29 groups disabled: needs the database and the relay key")
+		return
+	}
+	mgr, err := groups.Open(cfg.Groups, signer, groupStore{db: db, relayPubkey: signer.GetPublicKey()},
+		config.ConfigPath("groups.json"))
+	if err != nil {
+		log.Startup().Error("Failed to load NIP-29 groups", "error", err)
+		return
+	}
+	groups.SetManager(mgr)
+}
+
+// stopGroups detaches the group manager. Its state is saved on every
+// change, so there's nothing to flush.
+func stopGroups() {
+	groups.SetManager(nil)
+}
+
+// startModeration loads the banned event ids, the moderation queue
+// and the NIP-56 report index from <data-dir>/moderation.json. Always on: banevent has to keep
+// working whatever the queue settings are.
+func startModeration(cfg *cfgType.ServerConfig, db *nostrdb.NDB) {
+	if db == nil {
+		log.Startup().Error("Event moderation disabled: needs the database")
+		return
+	}
+	mgr, err := moderation.Open(cfg.Moderation, moderationStore{db: db}, config.ConfigPath("moderation.json"), trustedReporter)
+	if err != nil {
+		log.Startup().Error("Failed to load moderation state", "error", err)
+		return
+	}
+	moderation.SetManager(mgr)
+}
+
+// trustedReporter is whose NIP-56 reports count towards
+// moderation.quarantine_threshold: relay admins, and pubkeys on the
+// whitelist whether or not it's enforced.
+func trustedReporter(pubkey string) bool {
+	return relay.AdminRole(pubkey) != "" || config.IsPubKeyWhitelistedCached(pubkey, true)
+}
+
+// stopModeration detaches the moderation manager and writes out the
+// report changes it hasn't saved yet.
+func stopModeration() {
+	if mgr := moderation.SetManager(nil); mgr != nil {
+		if err := mgr.Close(); err != nil {
+			log.Startup().Error("Failed to save moderation state", "error", err)
+		}
+	}
+}
+
+// startTombstones opens <data-dir>/tombstones.jsonl. Without it
+// deletions still happen; they just don't stick.
+func startTombstones(db *nostrdb.NDB) {
+	if _, err := openTombstones(db); err != nil {
+		log.Startup().Error("Failed to load tombstones", "error", err)
+	}
+}
+
+// stopTombstones closes the tombstones file.
+func stopTombstones() {
+	closeTombstones()
+}
+
+// startSearchIndex opens <data-dir>/search_index.jsonl when
+// search.indexed_kinds asks for more than nostrdb indexes. Without it
+// searches just find nothing of those kinds.
+func startSearchIndex(cfg *cfgType.ServerConfig, db *nostrdb.NDB) {
+	if _, err := openSearchIndex(cfg, db); err != nil {
+		log.Startup().Error("Failed to load search index", "error", err)
+	}
+}
+
+// stopSearchIndex closes the search index file.
+func stopSearchIndex() {
+	closeSearchIndex()
+}
+
+// startBackups installs the backup manager for grain_backup and
+// starts the database.backup schedule if it's enabled.
+func startBackups(cfg *cfgType.ServerConfig, db *nostrdb.NDB, dbPath string) {
+	if db == nil {
+		return
+	}
+	m := backup.NewManager(cfg.Database.Backup, db, dbPath)
+	backup.SetManager(m)
+	m.Start()
+}
+
+// stopBackups ends the schedule, waiting out a copy in progress.
+func stopBackups() {
+	if m := backup.SetManager(nil); m != nil {
+		m.Stop()
+	}
+}
+
+// startQuotas installs the quota enforcer when quotas are enabled.
+// The whitelisted tier goes by the whitelist whether or not it's
+// enforced, like trustedReporter.
+func startQuotas(cfg *cfgType.ServerConfig, db *nostrdb.NDB) {
+	if !cfg.Quotas.Enabled {
+		return
+	}
+	if db == nil {
+		log.Startup().Error("Storage quotas disabled: needs the database")
+		return
+	}
+	quota.SetEnforcer(quota.NewEnforcer(cfg.Quotas, quotaStore{db: db}, func(pubkey string) bool {
+		return config.IsPubKeyWhitelistedCached(pubkey, true)
+	}))
+	log.Startup().Info("Storage quotas enabled",
+		"max_events", cfg.Quotas.Default.MaxEvents,
+		"max_bytes", cfg.Quotas.Default.MaxBytes,
+		"evict_oldest", cfg.Quotas.EvictOldest)
+}
+
+// stopQuotas removes the enforcer; a reload installs the new one.
+func stopQuotas() {
+	quota.SetEnforcer(nil)
+}
+
+// ndbStorage is the nostrdb side of storage.Source.
+type ndbStorage struct {
+	*nostrdb.NDB
+}
+
+func (s ndbStorage) StorageUsage() (storage.Usage, error) {
+	u, err := s.Usage()
+	if err != nil {
+		return storage.Usage{}, err
+	}
+	return storage.Usage{MapSizeBytes: u.MapSizeBytes, UsedBytes: u.UsedBytes}, nil
+}
+
+func (s ndbStorage) Resize(mapSizeMB int) error {
+	err := s.NDB.Resize(mapSizeMB)
+	if errors.Is(err, nostrdb.ErrResizeBusy) {
+		return storage.ErrBusy
+	}
+	return err
+}
+
+// startStorageMonitor watches how full the database is per
+// database.storage.
+func startStorageMonitor(cfg *cfgType.ServerConfig, db *nostrdb.NDB, dbPath string) {
+	if db == nil {
+		return
+	}
+	m := storage.NewMonitor(cfg.Database.Storage, ndbStorage{db}, dbPath)
+	storage.SetMonitor(m)
+	m.Start()
+}
+
+// stopStorageMonitor ends the checks.
+func stopStorageMonitor() {
+	if m := storage.SetMonitor(nil); m != nil {
+		m.Stop()
+	}
+}
+
+// configSnapshot captures the loaded config files for the audit
+// log's reload diff. Taken after initializeSubsystems on both sides,
+// so its in-place fix-ups (log file path) don't show up as changes.
+func configSnapshot() map[string]any {
+	return map[string]any{
+		"config":    config.GetConfig(),
+		"whitelist": config.GetWhitelistConfig(),
+		"blacklist": config.GetBlacklistConfig(),
+	}
+}
+
+// stopWritePolicy shuts the plugin down.
+func stopWritePolicy() {
+	if plugin := policy.SetWritePlugin(nil); plugin != nil {
+		plugin.Close()
+	}
+}
+
 // resetConfigurations resets all configuration state for restart
 func resetConfigurations() {
 	config.ResetConfig()
@@ -379,6 +704,9 @@ func initClient() http.Handler {
 	// Main route handles WebSocket upgrades, NIP-11 relay info, and web interface
 	mux.HandleFunc("/", initRoot)
 
+	// Prometheus scrape endpoint
+	mux.Handle("/metrics", metrics.Handler())
+
 	// Register API endpoints only (no view routes)
 	client.RegisterEndpoints(mux)
 
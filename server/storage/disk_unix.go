@@ -0,0 +1,15 @@
+//go:build !windows
+
+package storage
+
+import "syscall"
+
+// diskFree reports the bytes available to this process on the
+// filesystem holding path.
+func diskFree(path string) (uint64, bool) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, false
+	}
+	return st.Bavail * uint64(st.Bsize), true
+}
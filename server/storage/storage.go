@@ -0,0 +1,315 @@
+// Package storage watches how full the database is. nostrdb stores
+// into a fixed-size LMDB map (database.map_size_mb), and when that or
+// the disk under it runs out the failure happens on nostrdb's writer
+// thread: the event was queued, the OK went out, and the write is
+// silently lost. So the relay has to look before it leaps.
+//
+// A Monitor checks usage every database.storage.check_interval_sec:
+//
+//	warn_percent     log a warning as usage crosses each threshold
+//	grow_at_percent  reopen the database with a map twice the size, up
+//	                 to max_map_size_mb and what the disk can hold
+//	full_at_percent  or free disk under min_free_disk_mb: Full reports
+//	                 true and the EVENT handler answers "error: relay
+//	                 storage full" until space is found
+//
+// The database comes in as a Source (nostrdb.NDB behind an adapter in
+// package server), keeping this package free of cgo.
+package storage
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	cfgType "github.com/0ceanslim/grain/config/types"
+	"github.com/0ceanslim/grain/server/utils/log"
+)
+
+// FullReason is the NIP-01 OK message for an event turned away
+// because there's no room for it.
+const FullReason = "error: relay storage full"
+
+const (
+	defaultCheckIntervalSec = 30
+	defaultGrowAtPercent    = 85
+	defaultFullAtPercent    = 98
+	defaultMinFreeDiskMB    = 256
+
+	mb = 1024 * 1024
+)
+
+var defaultWarnPercent = []int{75, 90}
+
+// Usage is how much of the map is in use.
+type Usage struct {
+	MapSizeBytes uint64 `json:"map_size_bytes"`
+	UsedBytes    uint64 `json:"used_bytes"`
+}
+
+// Percent is UsedBytes as a percentage of MapSizeBytes.
+func (u Usage) Percent() float64 {
+	if u.MapSizeBytes == 0 {
+		return 0
+	}
+	return float64(u.UsedBytes) * 100 / float64(u.MapSizeBytes)
+}
+
+// ErrBusy is what a Source's Resize returns when it couldn't have the
+// database to itself without queueing behind long reads (a backup, a
+// big COUNT). Queued, it would hold up every other read and write
+// until they end; the monitor tries again at its next check instead.
+var ErrBusy = errors.New("database busy with long reads")
+
+// Source is the database being watched.
+type Source interface {
+	StorageUsage() (Usage, error)
+	// Resize reopens the database with a map of mapSizeMB, or returns
+	// ErrBusy rather than wait for long reads to end.
+	Resize(mapSizeMB int) error
+}
+
+// Status is the outcome of a check.
+type Status struct {
+	Usage
+	FreeDiskBytes uint64 `json:"free_disk_bytes"` // 0 where the platform can't tell
+	Full          bool   `json:"full"`
+	Reason        string `json:"reason,omitempty"` // why Full
+}
+
+// Monitor checks a Source against the database.storage settings.
+type Monitor struct {
+	cfg cfgType.DatabaseStorageConfig
+	src Source
+	dir string // the database directory, for the free-disk check
+
+	mu       sync.Mutex // one check at a time
+	warnedAt int        // highest warn_percent logged since usage was last below it
+	last     Status
+
+	full atomic.Bool
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewMonitor watches src, whose files are in dir, per cfg.
+func NewMonitor(cfg cfgType.DatabaseStorageConfig, src Source, dir string) *Monitor {
+	if cfg.CheckIntervalSec <= 0 {
+		cfg.CheckIntervalSec = defaultCheckIntervalSec
+	}
+	if len(cfg.WarnPercent) == 0 {
+		cfg.WarnPercent = defaultWarnPercent
+	}
+	cfg.WarnPercent = append([]int(nil), cfg.WarnPercent...)
+	sort.Ints(cfg.WarnPercent)
+	if cfg.GrowAtPercent <= 0 {
+		cfg.GrowAtPercent = defaultGrowAtPercent
+	}
+	if cfg.FullAtPercent <= 0 {
+		cfg.FullAtPercent = defaultFullAtPercent
+	}
+	if cfg.MinFreeDiskMB <= 0 {
+		cfg.MinFreeDiskMB = defaultMinFreeDiskMB
+	}
+	return &Monitor{cfg: cfg, src: src, dir: dir}
+}
+
+// Check looks at usage now: warns, grows the map and updates Full as
+// configured. An error reading usage leaves the previous status in
+// place.
+func (m *Monitor) Check() Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, err := m.src.StorageUsage()
+	if err != nil {
+		log.Storage().Warn("Failed to read database usage", "error", err)
+		return m.last
+	}
+	free, haveFree := diskFree(m.dir)
+
+	if m.shouldGrow(u) {
+		if grown, ok := m.grow(u, free, haveFree); ok {
+			u = grown
+		}
+	}
+	m.warn(u)
+
+	st := Status{Usage: u, FreeDiskBytes: free}
+	reserve := uint64(m.cfg.MinFreeDiskMB) * mb
+	switch {
+	case u.Percent() >= float64(m.cfg.FullAtPercent):
+		st.Full, st.Reason = true, "database map is full"
+	case haveFree && free < reserve:
+		st.Full, st.Reason = true, "disk is full"
+	}
+
+	if st.Full && !m.full.Load() {
+		log.Storage().Error("Relay storage full: rejecting new events",
+			"reason", st.Reason,
+			"used_bytes", u.UsedBytes,
+			"map_size_bytes", u.MapSizeBytes,
+			"free_disk_bytes", free)
+	} else if !st.Full && m.full.Load() {
+		log.Storage().Info("Relay storage has room again: accepting events",
+			"used_bytes", u.UsedBytes,
+			"map_size_bytes", u.MapSizeBytes)
+	}
+	m.full.Store(st.Full)
+	m.last = st
+	return st
+}
+
+func (m *Monitor) shouldGrow(u Usage) bool {
+	return m.cfg.MaxMapSizeMB > 0 && u.Percent() >= float64(m.cfg.GrowAtPercent)
+}
+
+// grow doubles the map, capped at max_map_size_mb and at what's on
+// disk now plus the free space above the min_free_disk_mb reserve.
+// A map bigger than the disk would only move the failure from LMDB to
+// the filesystem.
+func (m *Monitor) grow(u Usage, free uint64, haveFree bool) (Usage, bool) {
+	current := int(u.MapSizeBytes / mb)
+	target := min(current*2, m.cfg.MaxMapSizeMB)
+	if reserve := uint64(m.cfg.MinFreeDiskMB) * mb; haveFree && free > reserve {
+		target = min(target, int((u.UsedBytes+free-reserve)/mb))
+	} else if haveFree {
+		target = current
+	}
+	if target <= current {
+		return u, false
+	}
+
+	start := time.Now()
+	if err := m.src.Resize(target); errors.Is(err, ErrBusy) {
+		log.Storage().Warn("Database map growth put off: long reads open, retrying at the next check",
+			"from_mb", current,
+			"to_mb", target)
+		return u, false
+	} else if err != nil {
+		log.Storage().Error("Failed to grow the database map",
+			"from_mb", current,
+			"to_mb", target,
+			"error", err)
+		return u, false
+	}
+	log.Storage().Warn("Database map grown",
+		"from_mb", current,
+		"to_mb", target,
+		"max_map_size_mb", m.cfg.MaxMapSizeMB,
+		"took", time.Since(start).Round(time.Millisecond))
+
+	grown, err := m.src.StorageUsage()
+	if err != nil {
+		u.MapSizeBytes = uint64(target) * mb
+		return u, true
+	}
+	return grown, true
+}
+
+// warn logs once per threshold crossed on the way up. Dropping below
+// a threshold (growth, deletions) re-arms it.
+func (m *Monitor) warn(u Usage) {
+	pct := u.Percent()
+	crossed := 0
+	for _, t := range m.cfg.WarnPercent {
+		if pct >= float64(t) {
+			crossed = t
+		}
+	}
+	if crossed > m.warnedAt {
+		log.Storage().Warn("Database map filling up",
+			"percent_used", int(pct),
+			"threshold", crossed,
+			"used_bytes", u.UsedBytes,
+			"map_size_bytes", u.MapSizeBytes,
+			"max_map_size_mb", m.cfg.MaxMapSizeMB)
+	}
+	m.warnedAt = crossed
+}
+
+// Full reports whether the last check found no room for new events.
+func (m *Monitor) Full() bool { return m.full.Load() }
+
+// Last is the status from the last check.
+func (m *Monitor) Last() Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.last
+}
+
+// Start checks once and then every check_interval_sec until Stop.
+func (m *Monitor) Start() {
+	m.stop = make(chan struct{})
+	m.done = make(chan struct{})
+	m.Check()
+	go func() {
+		defer close(m.done)
+		ticker := time.NewTicker(time.Duration(m.cfg.CheckIntervalSec) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-m.stop:
+				return
+			case <-ticker.C:
+				m.Check()
+			}
+		}
+	}()
+}
+
+// Stop ends the checks, waiting out one in progress (and so any
+// resize).
+func (m *Monitor) Stop() {
+	if m.stop == nil {
+		return
+	}
+	close(m.stop)
+	<-m.done
+	m.stop = nil
+}
+
+var (
+	active   *Monitor
+	activeMu sync.RWMutex
+)
+
+// SetMonitor installs the instance-wide monitor (nil to clear) and
+// returns the previous one.
+func SetMonitor(m *Monitor) *Monitor {
+	activeMu.Lock()
+	defer activeMu.Unlock()
+	prev := active
+	active = m
+	return prev
+}
+
+func current() *Monitor {
+	activeMu.RLock()
+	defer activeMu.RUnlock()
+	return active
+}
+
+// Full reports whether the active monitor's last check found no room
+// for new events. False without a monitor.
+func Full() bool {
+	m := current()
+	return m != nil && m.Full()
+}
+
+// Last is the active monitor's last status; zero without a monitor.
+func Last() Status {
+	if m := current(); m != nil {
+		return m.Last()
+	}
+	return Status{}
+}
+
+// Recheck runs a check on the active monitor now and reports whether
+// storage is full; for a store that failed between scheduled checks.
+func Recheck() bool {
+	m := current()
+	return m != nil && m.Check().Full
+}
@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+
+	cfgType "github.com/0ceanslim/grain/config/types"
+)
+
+// fakeSource is a map of mapMB with usedMB in use.
+type fakeSource struct {
+	mapMB, usedMB int
+	failResize    bool
+	busy          int // Resizes to refuse with ErrBusy
+	resizes       []int
+}
+
+func (f *fakeSource) StorageUsage() (Usage, error) {
+	return Usage{MapSizeBytes: uint64(f.mapMB) * mb, UsedBytes: uint64(f.usedMB) * mb}, nil
+}
+
+func (f *fakeSource) Resize(mapSizeMB int) error {
+	f.resizes = append(f.resizes, mapSizeMB)
+	if f.busy > 0 {
+		f.busy--
+		return ErrBusy
+	}
+	if f.failResize {
+		return errors.New("ndb_init failed")
+	}
+	f.mapMB = mapSizeMB
+	return nil
+}
+
+func TestMonitorGrowsUpToMax(t *testing.T) {
+	src := &fakeSource{mapMB: 100, usedMB: 50}
+	m := NewMonitor(cfgType.DatabaseStorageConfig{MaxMapSizeMB: 300, MinFreeDiskMB: 1}, src, t.TempDir())
+
+	if st := m.Check(); st.Full || len(src.resizes) != 0 {
+		t.Fatalf("50%%: %+v, resizes %v", st, src.resizes)
+	}
+
+	src.usedMB = 90
+	if st := m.Check(); st.Full || src.mapMB != 200 {
+		t.Fatalf("90%% should double the map: %+v, map %d", st, src.mapMB)
+	}
+
+	// Doubling again would pass max_map_size_mb: capped.
+	src.usedMB = 190
+	if m.Check(); src.mapMB != 300 {
+		t.Fatalf("map = %d, want the 300 MB ceiling", src.mapMB)
+	}
+
+	// At the ceiling there's nowhere to go; past full_at_percent new
+	// events are refused until usage drops.
+	src.usedMB = 297
+	if st := m.Check(); !st.Full || st.Reason == "" || len(src.resizes) != 2 {
+		t.Fatalf("at the ceiling: %+v, resizes %v", st, src.resizes)
+	}
+	SetMonitor(m)
+	t.Cleanup(func() { SetMonitor(nil) })
+	if !Full() {
+		t.Fatal("Full() = false with a full monitor installed")
+	}
+
+	src.usedMB = 200
+	if Recheck() || Full() {
+		t.Fatal("still full after usage dropped")
+	}
+}
+
+func TestMonitorWithoutGrowth(t *testing.T) {
+	// max_map_size_mb 0 never resizes; a failed resize is survivable.
+	src := &fakeSource{mapMB: 100, usedMB: 99}
+	m := NewMonitor(cfgType.DatabaseStorageConfig{MinFreeDiskMB: 1}, src, t.TempDir())
+	if st := m.Check(); !st.Full || len(src.resizes) != 0 {
+		t.Fatalf("no growth configured: %+v, resizes %v", st, src.resizes)
+	}
+
+	src = &fakeSource{mapMB: 100, usedMB: 99, failResize: true}
+	m = NewMonitor(cfgType.DatabaseStorageConfig{MaxMapSizeMB: 1000, MinFreeDiskMB: 1}, src, t.TempDir())
+	if st := m.Check(); !st.Full || len(src.resizes) != 1 || src.mapMB != 100 {
+		t.Fatalf("failed resize: %+v, map %d", st, src.mapMB)
+	}
+}
+
+func TestMonitorRetriesBusyResize(t *testing.T) {
+	src := &fakeSource{mapMB: 100, usedMB: 90, busy: 1}
+	m := NewMonitor(cfgType.DatabaseStorageConfig{MaxMapSizeMB: 1000, MinFreeDiskMB: 1}, src, t.TempDir())
+	if m.Check(); src.mapMB != 100 || len(src.resizes) != 1 {
+		t.Fatalf("busy resize: map %d, resizes %v", src.mapMB, src.resizes)
+	}
+	if m.Check(); src.mapMB != 200 || len(src.resizes) != 2 {
+		t.Fatalf("retry: map %d, resizes %v", src.mapMB, src.resizes)
+	}
+}
+
+func TestMonitorWarnsOncePerThreshold(t *testing.T) {
+	src := &fakeSource{mapMB: 100}
+	m := NewMonitor(cfgType.DatabaseStorageConfig{WarnPercent: []int{90, 50}, MinFreeDiskMB: 1}, src, t.TempDir())
+
+	for _, step := range []struct{ used, warned int }{
+		{10, 0}, {60, 50}, {70, 50}, {95, 90}, {40, 0}, {55, 50},
+	} {
+		src.usedMB = step.used
+		m.Check()
+		if m.warnedAt != step.warned {
+			t.Fatalf("at %d%%: warnedAt = %d, want %d", step.used, m.warnedAt, step.warned)
+		}
+	}
+}
+
+func TestFullWithoutMonitor(t *testing.T) {
+	SetMonitor(nil)
+	if Full() || Recheck() {
+		t.Fatal("no monitor should never report full")
+	}
+}
@@ -0,0 +1,258 @@
+package server
+
+import (
+	"sort"
+	"sync"
+
+	nostr "github.com/0ceanslim/grain/server/types"
+)
+
+// Subscription inverted index.
+//
+// BroadcastEvent used to snapshot every client and run MatchesEvent on
+// every subscription for every stored event — O(clients × subs) per
+// event, which dominated CPU once a relay held a few thousand idle
+// connections with live subscriptions. The index files each filter
+// under exactly one key the filter *requires* an event to carry:
+//
+//   - a full 64-char event id, else
+//   - a full 64-char author pubkey, else
+//   - one NIP-119 "&" tag name/value pair, else
+//   - one tag name/value pair, else
+//   - a kind, else
+//   - the wildcard set (filters with nothing indexable: `{}`, prefix-only
+//     ids/authors, since/until/search only).
+//
+// Because a matching event must carry the key its filter was filed
+// under, the union of the buckets for an event's id, pubkey, tags and
+// kind (plus wildcards) is a complete candidate set. Candidates are then
+// confirmed with the full MatchesEvent check, so the index only ever
+// narrows work, never changes results.
+//
+// Entries are written by Client.SetSubscription/DeleteSubscription and
+// dropped wholesale when a connection closes. Lock order is
+// client.subMu → subscriptionIndex.mu; match never takes subMu.
+
+// subKey identifies one subscription on one connection.
+type subKey struct {
+	client *Client
+	subID  string
+}
+
+// indexedSub is what the index stores per subscription: its filters
+// (for the confirming MatchesEvent pass) and every bucket it was filed
+// in (so removal doesn't have to re-derive them).
+type indexedSub struct {
+	filters []nostr.Filter
+	keys    []indexKey
+}
+
+// indexKey names one bucket. kind is only meaningful for keyKind.
+type indexKey struct {
+	dim   indexDim
+	value string
+	kind  int
+}
+
+type indexDim uint8
+
+const (
+	dimWildcard indexDim = iota
+	dimID
+	dimAuthor
+	dimTag
+	dimKind
+)
+
+// fullHexLen is the length of a complete hex event id or pubkey; shorter
+// values are NIP-01 prefixes and can't be looked up by equality.
+const fullHexLen = 64
+
+type subscriptionIndex struct {
+	mu      sync.RWMutex
+	subs    map[subKey]*indexedSub
+	buckets map[indexKey]map[subKey]struct{}
+}
+
+func newSubscriptionIndex() *subscriptionIndex {
+	return &subscriptionIndex{
+		subs:    make(map[subKey]*indexedSub),
+		buckets: make(map[indexKey]map[subKey]struct{}),
+	}
+}
+
+// subIndex is the process-wide index consulted by BroadcastEvent.
+var subIndex = newSubscriptionIndex()
+
+// set files (or re-files) a subscription under its filters' keys.
+func (idx *subscriptionIndex) set(c *Client, subID string, filters []nostr.Filter) {
+	k := subKey{client: c, subID: subID}
+	entry := &indexedSub{filters: filters}
+	seen := make(map[indexKey]struct{})
+	for _, f := range filters {
+		for _, ik := range filterIndexKeys(f) {
+			if _, dup := seen[ik]; !dup {
+				seen[ik] = struct{}{}
+				entry.keys = append(entry.keys, ik)
+			}
+		}
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.removeLocked(k)
+	idx.subs[k] = entry
+	for _, ik := range entry.keys {
+		bucket := idx.buckets[ik]
+		if bucket == nil {
+			bucket = make(map[subKey]struct{})
+			idx.buckets[ik] = bucket
+		}
+		bucket[k] = struct{}{}
+	}
+}
+
+// remove drops one subscription. Missing entries are a no-op.
+func (idx *subscriptionIndex) remove(c *Client, subID string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.removeLocked(subKey{client: c, subID: subID})
+}
+
+func (idx *subscriptionIndex) removeLocked(k subKey) {
+	entry, ok := idx.subs[k]
+	if !ok {
+		return
+	}
+	delete(idx.subs, k)
+	for _, ik := range entry.keys {
+		if bucket := idx.buckets[ik]; bucket != nil {
+			delete(bucket, k)
+			if len(bucket) == 0 {
+				delete(idx.buckets, ik)
+			}
+		}
+	}
+}
+
+// len returns the number of indexed subscriptions.
+func (idx *subscriptionIndex) len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.subs)
+}
+
+// match returns every subscription with at least one filter matching
+// evt. Each subscription appears at most once.
+func (idx *subscriptionIndex) match(evt nostr.Event) []subKey {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	// A subscription filed in several buckets may be visited more than
+	// once. Only matches are deduplicated: re-running MatchesEvent on
+	// a rare multi-bucket miss is cheaper than tracking every candidate.
+	var matched []subKey
+	seen := make(map[subKey]struct{})
+	check := func(ik indexKey) {
+		for k := range idx.buckets[ik] {
+			if _, dup := seen[k]; dup {
+				continue
+			}
+			for _, f := range idx.subs[k].filters {
+				if f.MatchesEvent(evt) {
+					seen[k] = struct{}{}
+					matched = append(matched, k)
+					break
+				}
+			}
+		}
+	}
+
+	check(indexKey{dim: dimWildcard})
+	check(indexKey{dim: dimID, value: evt.ID})
+	check(indexKey{dim: dimAuthor, value: evt.PubKey})
+	check(indexKey{dim: dimKind, kind: evt.Kind})
+	for _, tag := range evt.Tags {
+		if len(tag) >= 2 {
+			check(indexKey{dim: dimTag, value: tag[0] + "\x00" + tag[1]})
+		}
+	}
+	return matched
+}
+
+// filterIndexKeys picks the most selective dimension the filter
+// constrains and returns one key per value in it. Every value becomes
+// its own key because the filter ORs within a field.
+func filterIndexKeys(f nostr.Filter) []indexKey {
+	if keys := exactHexKeys(dimID, f.IDs); keys != nil {
+		return keys
+	}
+	if keys := exactHexKeys(dimAuthor, f.Authors); keys != nil {
+		return keys
+	}
+
+	// Every "&" value is required, so any one of them will do.
+	if len(f.AndTags) > 0 {
+		names := make([]string, 0, len(f.AndTags))
+		for name, vals := range f.AndTags {
+			if len(vals) > 0 {
+				names = append(names, name)
+			}
+		}
+		if len(names) > 0 {
+			sort.Strings(names)
+			return []indexKey{{dim: dimTag, value: names[0] + "\x00" + f.AndTags[names[0]][0]}}
+		}
+	}
+
+	if len(f.Tags) > 0 {
+		// Pick the tag name with the fewest values for the smallest
+		// fan-out; sort first so the choice is deterministic.
+		names := make([]string, 0, len(f.Tags))
+		for name, vals := range f.Tags {
+			if len(vals) > 0 {
+				names = append(names, name)
+			}
+		}
+		if len(names) > 0 {
+			sort.Strings(names)
+			best := names[0]
+			for _, name := range names[1:] {
+				if len(f.Tags[name]) < len(f.Tags[best]) {
+					best = name
+				}
+			}
+			keys := make([]indexKey, 0, len(f.Tags[best]))
+			for _, v := range f.Tags[best] {
+				keys = append(keys, indexKey{dim: dimTag, value: best + "\x00" + v})
+			}
+			return keys
+		}
+	}
+
+	if len(f.Kinds) > 0 {
+		keys := make([]indexKey, 0, len(f.Kinds))
+		for _, kind := range f.Kinds {
+			keys = append(keys, indexKey{dim: dimKind, kind: kind})
+		}
+		return keys
+	}
+
+	return []indexKey{{dim: dimWildcard}}
+}
+
+// exactHexKeys returns a key per value when every value is a full-length
+// id/pubkey, or nil if the field is empty or holds any prefix.
+func exactHexKeys(dim indexDim, values []string) []indexKey {
+	if len(values) == 0 {
+		return nil
+	}
+	keys := make([]indexKey, 0, len(values))
+	for _, v := range values {
+		if len(v) != fullHexLen {
+			return nil
+		}
+		keys = append(keys, indexKey{dim: dim, value: v})
+	}
+	return keys
+}
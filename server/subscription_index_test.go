@@ -0,0 +1,231 @@
+package server
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+
+	nostr "github.com/0ceanslim/grain/server/types"
+)
+
+func hex64(rng *rand.Rand) string {
+	const digits = "0123456789abcdef"
+	b := make([]byte, fullHexLen)
+	for i := range b {
+		b[i] = digits[rng.Intn(16)]
+	}
+	return string(b)
+}
+
+// randomFilter mixes the shapes real clients send, weighted roughly
+// like production traffic: mostly follow-list author filters, kind+#p
+// notification filters and thread #e filters, with the occasional id
+// lookup, bare kind firehose and prefix-author filter (the last two
+// land in broad buckets and are the index's worst case), and NIP-119
+// "&t" topic filters.
+func randomFilter(rng *rand.Rand, pubkeys []string, eventIDs []string) nostr.Filter {
+	pick := func(pool []string, n int) []string {
+		out := make([]string, n)
+		for i := range out {
+			out[i] = pool[rng.Intn(len(pool))]
+		}
+		return out
+	}
+	switch r := rng.Intn(22); {
+	case r < 6:
+		return nostr.Filter{Authors: pick(pubkeys, 1+rng.Intn(20)), Kinds: []int{1, 6}}
+	case r < 11:
+		return nostr.Filter{Kinds: []int{1, 7, 9735}, Tags: map[string][]string{"p": pick(pubkeys, 1)}}
+	case r < 15:
+		return nostr.Filter{Tags: map[string][]string{"e": pick(eventIDs, 1+rng.Intn(3))}}
+	case r < 18:
+		return nostr.Filter{IDs: pick(eventIDs, 1)}
+	case r < 19:
+		since := time.Unix(1700000000, 0)
+		return nostr.Filter{Kinds: []int{rng.Intn(5)}, Since: &since}
+	case r < 20:
+		return nostr.Filter{Authors: []string{pubkeys[rng.Intn(len(pubkeys))][:8]}}
+	default:
+		return nostr.Filter{Kinds: []int{1}, AndTags: map[string][]string{"t": pick(testTopics, 1+rng.Intn(2))}}
+	}
+}
+
+var testTopics = []string{"nostr", "grain", "zaps"}
+
+func randomEvent(rng *rand.Rand, pubkeys []string, eventIDs []string) nostr.Event {
+	evt := nostr.Event{
+		ID:        eventIDs[rng.Intn(len(eventIDs))],
+		PubKey:    pubkeys[rng.Intn(len(pubkeys))],
+		CreatedAt: 1700000000 + int64(rng.Intn(1000)) - 500,
+		Kind:      []int{0, 1, 3, 6, 7, 9735}[rng.Intn(6)],
+	}
+	if rng.Intn(2) == 0 {
+		evt.Tags = append(evt.Tags, []string{"p", pubkeys[rng.Intn(len(pubkeys))]})
+	}
+	if rng.Intn(2) == 0 {
+		evt.Tags = append(evt.Tags, []string{"e", eventIDs[rng.Intn(len(eventIDs))]})
+	}
+	for _, topic := range testTopics {
+		if rng.Intn(2) == 0 {
+			evt.Tags = append(evt.Tags, []string{"t", topic})
+		}
+	}
+	return evt
+}
+
+// linearMatch is the pre-index BroadcastEvent algorithm, kept as the
+// oracle for the index and the baseline for the benchmark.
+func linearMatch(subs map[subKey][]nostr.Filter, evt nostr.Event) []subKey {
+	var out []subKey
+	for k, filters := range subs {
+		for _, f := range filters {
+			if f.MatchesEvent(evt) {
+				out = append(out, k)
+				break
+			}
+		}
+	}
+	return out
+}
+
+func sortKeys(keys []subKey) []string {
+	out := make([]string, len(keys))
+	for i, k := range keys {
+		out[i] = fmt.Sprintf("%p/%s", k.client, k.subID)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestSubscriptionIndex_AgreesWithLinearScan(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	pubkeys := make([]string, 50)
+	for i := range pubkeys {
+		pubkeys[i] = hex64(rng)
+	}
+	eventIDs := make([]string, 200)
+	for i := range eventIDs {
+		eventIDs[i] = hex64(rng)
+	}
+
+	idx := newSubscriptionIndex()
+	oracle := make(map[subKey][]nostr.Filter)
+	clients := make([]*Client, 100)
+	for i := range clients {
+		clients[i] = &Client{id: fmt.Sprintf("c%d", i)}
+		for s := 0; s < 3; s++ {
+			filters := []nostr.Filter{randomFilter(rng, pubkeys, eventIDs)}
+			if rng.Intn(3) == 0 {
+				filters = append(filters, randomFilter(rng, pubkeys, eventIDs))
+			}
+			subID := fmt.Sprintf("s%d", s)
+			idx.set(clients[i], subID, filters)
+			oracle[subKey{clients[i], subID}] = filters
+		}
+	}
+
+	// Replace and remove a few so stale bucket entries would show up.
+	for i := 0; i < 20; i++ {
+		c := clients[rng.Intn(len(clients))]
+		subID := fmt.Sprintf("s%d", rng.Intn(3))
+		if rng.Intn(2) == 0 {
+			filters := []nostr.Filter{randomFilter(rng, pubkeys, eventIDs)}
+			idx.set(c, subID, filters)
+			oracle[subKey{c, subID}] = filters
+		} else {
+			idx.remove(c, subID)
+			delete(oracle, subKey{c, subID})
+		}
+	}
+
+	if idx.len() != len(oracle) {
+		t.Fatalf("index holds %d subs, want %d", idx.len(), len(oracle))
+	}
+
+	for i := 0; i < 2000; i++ {
+		evt := randomEvent(rng, pubkeys, eventIDs)
+		got := sortKeys(idx.match(evt))
+		want := sortKeys(linearMatch(oracle, evt))
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("event %d: index matched %v, linear scan matched %v", i, got, want)
+		}
+	}
+}
+
+func TestSubscriptionIndex_RemoveClearsBuckets(t *testing.T) {
+	idx := newSubscriptionIndex()
+	c := &Client{}
+	pk := hex64(rand.New(rand.NewSource(1)))
+
+	idx.set(c, "a", []nostr.Filter{{Authors: []string{pk}}, {Kinds: []int{1}}})
+	idx.set(c, "a", []nostr.Filter{{Tags: map[string][]string{"t": {"nostr"}}}})
+	idx.remove(c, "a")
+
+	if idx.len() != 0 || len(idx.buckets) != 0 {
+		t.Fatalf("expected empty index, got %d subs / %d buckets", idx.len(), len(idx.buckets))
+	}
+}
+
+func TestFilterIndexKeys_PrefixFallsThrough(t *testing.T) {
+	full := hex64(rand.New(rand.NewSource(2)))
+
+	keys := filterIndexKeys(nostr.Filter{Authors: []string{full, full[:10]}, Kinds: []int{1}})
+	if len(keys) != 1 || keys[0].dim != dimKind {
+		t.Errorf("prefix author should fall through to kind, got %+v", keys)
+	}
+
+	keys = filterIndexKeys(nostr.Filter{IDs: []string{full[:4]}})
+	if len(keys) != 1 || keys[0].dim != dimWildcard {
+		t.Errorf("prefix-only id filter should be wildcard, got %+v", keys)
+	}
+}
+
+// BenchmarkBroadcastMatch measures the per-event cost of finding
+// recipients with 10k connections × 2 subscriptions, comparing the
+// index to the old scan-everything path. SendMessage is left out so
+// the numbers isolate matching.
+func BenchmarkBroadcastMatch(b *testing.B) {
+	const connections = 10_000
+
+	rng := rand.New(rand.NewSource(99))
+	pubkeys := make([]string, 5000)
+	for i := range pubkeys {
+		pubkeys[i] = hex64(rng)
+	}
+	eventIDs := make([]string, 20000)
+	for i := range eventIDs {
+		eventIDs[i] = hex64(rng)
+	}
+
+	idx := newSubscriptionIndex()
+	oracle := make(map[subKey][]nostr.Filter, connections*2)
+	for i := 0; i < connections; i++ {
+		c := &Client{}
+		for s := 0; s < 2; s++ {
+			filters := []nostr.Filter{randomFilter(rng, pubkeys, eventIDs)}
+			subID := fmt.Sprintf("s%d", s)
+			idx.set(c, subID, filters)
+			oracle[subKey{c, subID}] = filters
+		}
+	}
+
+	events := make([]nostr.Event, 1024)
+	for i := range events {
+		events[i] = randomEvent(rng, pubkeys, eventIDs)
+	}
+
+	b.Run("index", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = idx.match(events[i%len(events)])
+		}
+	})
+	b.Run("linear", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = linearMatch(oracle, events[i%len(events)])
+		}
+	})
+}
@@ -0,0 +1,304 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/0ceanslim/grain/client/core"
+	"github.com/0ceanslim/grain/config"
+	"github.com/0ceanslim/grain/server/db/nostrdb"
+	nostr "github.com/0ceanslim/grain/server/types"
+	"github.com/0ceanslim/grain/server/utils"
+	"github.com/0ceanslim/grain/server/validation"
+)
+
+const (
+	// syncMaxLocalItems caps the local (created_at, id) set held in
+	// memory for one reconciliation. Larger backfills should be split
+	// with since/until in the filter.
+	syncMaxLocalItems = 10_000_000
+
+	// syncFetchBatch is how many missing ids go into each REQ. Kept at
+	// or under the common NIP-11 max_limit so well-behaved relays
+	// answer a batch in one go; anything they trim gets re-requested.
+	syncFetchBatch = 500
+)
+
+// syncStats summarises one --sync run.
+type syncStats struct {
+	localItems  int
+	localOnly   int
+	remoteOnly  int
+	imported    int
+	invalid     int
+	errors      int
+	unavailable int
+}
+
+// SyncFromRelay is the `grain --sync <relay-url> --filter <json>` entry
+// point. It reconciles the local nostrdb against relayURL over NIP-77
+// negentropy, so only the (created_at, id) fingerprints of events both
+// sides already have cross the wire, then fetches just the missing
+// events by id and stores them through the same retrying path as
+// --import.
+//
+// Unlike --import the source is not trusted: nostrdb verifies every
+// signature on ingest, and events are also checked here before they are
+// queued so the summary reflects what actually got stored. Events we
+// have and the remote lacks are reported but never pushed — sync is
+// pull-only.
+func SyncFromRelay(relayURL, filterJSON string) error {
+	if err := ensureConfigFiles(); err != nil {
+		return fmt.Errorf("failed to ensure config files: %w", err)
+	}
+
+	cfg, err := config.LoadConfig(config.ConfigPath("config.yml"))
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	filter, err := parseSyncFilter(filterJSON)
+	if err != nil {
+		return err
+	}
+
+	dbPath, mapSizeMB := resolveDatabaseSettings(cfg)
+	if err := os.MkdirAll(dbPath, 0755); err != nil {
+		return fmt.Errorf("failed to create database directory: %w", err)
+	}
+
+	fmt.Printf("Opening database at %s...\n", dbPath)
+	db, err := nostrdb.Open(dbPath, mapSizeMB, 1)
+	if err != nil {
+		return fmt.Errorf("failed to open nostrdb: %w", err)
+	}
+	nostrdb.SetGlobalDB(db)
+	defer func() {
+		// Let the writer thread finish committing before exit.
+		time.Sleep(3 * time.Second)
+		db.Close()
+	}()
+
+	if _, err := openTombstones(db); err != nil {
+		return fmt.Errorf("failed to open tombstones: %w", err)
+	}
+	defer closeTombstones()
+
+	if _, err := openSearchIndex(cfg, db); err != nil {
+		return fmt.Errorf("failed to open search index: %w", err)
+	}
+	defer closeSearchIndex()
+
+	pool := core.NewRelayPool(core.ConfigFromServerConfig(cfg))
+	defer pool.Close()
+
+	fmt.Printf("Connecting to %s...\n", relayURL)
+	if err := pool.Connect(relayURL); err != nil {
+		return err
+	}
+
+	startTime := time.Now()
+	stats, err := syncWithRelay(context.Background(), db, pool, relayURL, filter, true)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\nSync complete in %s\n", time.Since(startTime).Round(time.Millisecond))
+	fmt.Printf("  Local events:   %d\n", stats.localItems)
+	fmt.Printf("  Missing here:   %d\n", stats.remoteOnly)
+	fmt.Printf("  Imported:       %d\n", stats.imported)
+	fmt.Printf("  Invalid:        %d (bad id / signature, or not requested)\n", stats.invalid)
+	fmt.Printf("  Store errors:   %d (duplicates / rejected replacements / deleted)\n", stats.errors)
+	fmt.Printf("  Unavailable:    %d (advertised but not served by the remote)\n", stats.unavailable)
+	fmt.Printf("  Only local:     %d (not pushed)\n", stats.localOnly)
+
+	return nil
+}
+
+// syncWithRelay does the work of SyncFromRelay against an already open
+// database and connected pool. A filter that leaves out deletion
+// requests gets a second pass for the ones covering it (see
+// deletionFilter), so what the remote deleted is deleted here too.
+func syncWithRelay(ctx context.Context, db *nostrdb.NDB, pool *core.RelayPool, relayURL string, filter nostr.Filter, progress bool) (syncStats, error) {
+	var stats syncStats
+
+	if err := syncFilter(ctx, db, pool, relayURL, filter, progress, &stats); err != nil {
+		return stats, err
+	}
+	if deletions, ok := deletionFilter(filter); ok {
+		if progress {
+			fmt.Println("Syncing deletion requests...")
+		}
+		if err := syncFilter(ctx, db, pool, relayURL, deletions, progress, &stats); err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+// syncFilter reconciles one filter and fetches what's missing, adding
+// to stats.
+func syncFilter(ctx context.Context, db *nostrdb.NDB, pool *core.RelayPool, relayURL string, filter nostr.Filter, progress bool, stats *syncStats) error {
+	storage, err := db.NegentropyStorage(filter, syncMaxLocalItems, nil)
+	if err != nil {
+		return fmt.Errorf("failed to read local events: %w", err)
+	}
+	stats.localItems += storage.Size()
+
+	have, need, err := pool.Reconcile(ctx, relayURL, filter, storage)
+	if err != nil {
+		return fmt.Errorf("reconciliation failed: %w", err)
+	}
+	stats.localOnly += len(have)
+	stats.remoteOnly += len(need)
+
+	if progress {
+		fmt.Printf("Reconciled %d local events: %d missing, %d only local\n\n",
+			stats.localItems, len(need), len(have))
+	}
+
+	backoff := time.Millisecond
+	startTime := time.Now()
+	lastRender := time.Now()
+	pending := need
+	batchNum := 0
+
+	for len(pending) > 0 {
+		n := syncFetchBatch
+		if n > len(pending) {
+			n = len(pending)
+		}
+		batch := pending[:n]
+		pending = pending[n:]
+
+		wanted := make(map[string]bool, len(batch))
+		for _, id := range batch {
+			wanted[id] = true
+		}
+
+		batchNum++
+		err := fetchByIDs(ctx, pool, relayURL, fmt.Sprintf("sync_%d", batchNum), batch, func(evt *nostr.Event) {
+			if !wanted[evt.ID] || !validation.CheckSignature(*evt) {
+				stats.invalid++
+				return
+			}
+			delete(wanted, evt.ID)
+			if storeWithRetry(ctx, db, *evt, &backoff) {
+				stats.imported++
+			} else {
+				stats.errors++
+			}
+		})
+		if err != nil {
+			return err
+		}
+
+		// Relays may trim a REQ below its limit. Re-queue what was left
+		// out as long as the batch made progress; a batch that returned
+		// nothing at all means the remote won't serve those ids.
+		if len(wanted) < len(batch) {
+			for id := range wanted {
+				pending = append(pending, id)
+			}
+		} else {
+			stats.unavailable += len(wanted)
+		}
+
+		if progress && time.Since(lastRender) >= 100*time.Millisecond {
+			done := len(need) - len(pending)
+			renderProgress(done, len(need), stats.imported, stats.invalid, stats.errors, startTime)
+			lastRender = time.Now()
+		}
+	}
+
+	if progress && len(need) > 0 {
+		renderProgress(len(need), len(need), stats.imported, stats.invalid, stats.errors, startTime)
+		fmt.Println()
+	}
+
+	return nil
+}
+
+// fetchByIDs issues one REQ for ids against relayURL and hands every
+// returned event to fn until EOSE.
+func fetchByIDs(ctx context.Context, pool *core.RelayPool, relayURL, subID string, ids []string, fn func(*nostr.Event)) error {
+	// Events are routed with a non-blocking send, so the channel must
+	// hold a whole batch or a slow store would drop events.
+	sub := &core.Subscription{
+		ID:     subID,
+		Events: make(chan *nostr.Event, len(ids)),
+		Errors: make(chan error, 1),
+		EOSE:   make(chan string, 1),
+	}
+	pool.RegisterSubscription(subID, sub)
+	defer func() {
+		pool.UnregisterSubscription(subID)
+		_ = pool.SendMessage(relayURL, []interface{}{"CLOSE", subID})
+	}()
+
+	limit := len(ids)
+	filter := nostr.Filter{IDs: ids, Limit: &limit}
+	if err := pool.SendMessage(relayURL, []interface{}{"REQ", subID, filter.ToSubscriptionFilter()}); err != nil {
+		return err
+	}
+
+	timeout := time.NewTimer(60 * time.Second)
+	defer timeout.Stop()
+
+	for {
+		select {
+		case evt := <-sub.Events:
+			fn(evt)
+		case <-sub.EOSE:
+			// Drain anything routed before EOSE but not yet consumed.
+			for {
+				select {
+				case evt := <-sub.Events:
+					fn(evt)
+				default:
+					return nil
+				}
+			}
+		case err := <-sub.Errors:
+			return err
+		case <-timeout.C:
+			return fmt.Errorf("timeout fetching events from %s", relayURL)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// parseSyncFilter turns the --filter JSON into a nostr.Filter using the
+// same field handling as REQ. An empty string means "everything".
+func parseSyncFilter(filterJSON string) (nostr.Filter, error) {
+	var f nostr.Filter
+	if strings.TrimSpace(filterJSON) == "" {
+		return f, nil
+	}
+
+	var filterData map[string]interface{}
+	if err := json.Unmarshal([]byte(filterJSON), &filterData); err != nil {
+		return f, fmt.Errorf("invalid --filter JSON: %w", err)
+	}
+
+	f.IDs = utils.ToStringArray(filterData["ids"])
+	f.Authors = utils.ToStringArray(filterData["authors"])
+	f.Kinds = utils.ToIntArray(filterData["kinds"])
+	f.Since = utils.ToTime(filterData["since"])
+	f.Until = utils.ToTime(filterData["until"])
+
+	f.Tags = make(map[string][]string)
+	for k, v := range filterData {
+		if len(k) >= 2 && k[0] == '#' {
+			if vals := utils.ToStringArray(v); len(vals) > 0 {
+				f.Tags[k[1:]] = vals
+			}
+		}
+	}
+	return f, nil
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0ceanslim/grain/client/core"
+	"github.com/0ceanslim/grain/config"
+	cfgType "github.com/0ceanslim/grain/config/types"
+	"github.com/0ceanslim/grain/server/db/nostrdb"
+	nostr "github.com/0ceanslim/grain/server/types"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"golang.org/x/net/websocket"
+)
+
+// syncTestEvent builds a signed kind-1 event.
+func syncTestEvent(t *testing.T, priv *btcec.PrivateKey, content string, ts int64) nostr.Event {
+	t.Helper()
+	return signedTestEvent(t, priv, 1, [][]string{}, content, ts)
+}
+
+// signedTestEvent builds a signed event of any kind.
+func signedTestEvent(t *testing.T, priv *btcec.PrivateKey, kind int, tags [][]string, content string, ts int64) nostr.Event {
+	t.Helper()
+	evt := nostr.Event{
+		PubKey:    hex.EncodeToString(schnorr.SerializePubKey(priv.PubKey())),
+		CreatedAt: ts,
+		Kind:      kind,
+		Tags:      tags,
+		Content:   content,
+	}
+	raw, _ := json.Marshal([]interface{}{0, evt.PubKey, evt.CreatedAt, evt.Kind, evt.Tags, evt.Content})
+	h := sha256.Sum256(raw)
+	evt.ID = hex.EncodeToString(h[:])
+	sig, err := schnorr.Sign(priv, h[:])
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	evt.Sig = hex.EncodeToString(sig.Serialize())
+	return evt
+}
+
+// waitForNotes polls until every id is queryable in db.
+func waitForNotes(t *testing.T, db *nostrdb.NDB, ids []string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for _, id := range ids {
+		for {
+			txn, err := db.BeginQuery()
+			if err != nil {
+				t.Fatalf("begin query: %v", err)
+			}
+			got, _ := txn.GetNoteByID(id)
+			txn.EndQuery()
+			if got != nil {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for event %s", id)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+}
+
+// TestSyncWithRelay_FetchesOnlyMissing runs a real GRAIN websocket
+// handler in-process as the remote, backed by its own nostrdb, and
+// syncs a second nostrdb against it.
+func TestSyncWithRelay_FetchesOnlyMissing(t *testing.T) {
+	prev := config.GetConfig()
+	config.SetConfigForTesting(&cfgType.ServerConfig{})
+	t.Cleanup(func() { config.SetConfigForTesting(prev) })
+
+	remote, err := nostrdb.Open(t.TempDir(), 32, 1)
+	if err != nil {
+		t.Fatalf("open remote: %v", err)
+	}
+	t.Cleanup(remote.Close)
+	nostrdb.SetGlobalDB(remote)
+	t.Cleanup(func() { nostrdb.SetGlobalDB(nil) })
+
+	local, err := nostrdb.Open(t.TempDir(), 32, 1)
+	if err != nil {
+		t.Fatalf("open local: %v", err)
+	}
+	t.Cleanup(local.Close)
+
+	priv, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	base := time.Now().Unix() - 3600
+
+	// 60 events on the remote, the first 25 also present locally, plus
+	// 5 only present locally. Pairs share a timestamp so range bounds
+	// have to split on id prefixes.
+	var remoteIDs, missing []string
+	for i := 0; i < 60; i++ {
+		evt := syncTestEvent(t, priv, fmt.Sprintf("remote %d", i), base+int64(i/2))
+		if err := remote.StoreEvent(ctx, evt); err != nil {
+			t.Fatalf("store remote %d: %v", i, err)
+		}
+		remoteIDs = append(remoteIDs, evt.ID)
+		if i < 25 {
+			if err := local.StoreEvent(ctx, evt); err != nil {
+				t.Fatalf("store local %d: %v", i, err)
+			}
+		} else {
+			missing = append(missing, evt.ID)
+		}
+	}
+	var localIDs []string
+	for i := 0; i < 5; i++ {
+		evt := syncTestEvent(t, priv, fmt.Sprintf("local %d", i), base+int64(i))
+		if err := local.StoreEvent(ctx, evt); err != nil {
+			t.Fatalf("store local-only %d: %v", i, err)
+		}
+		localIDs = append(localIDs, evt.ID)
+	}
+	waitForNotes(t, remote, remoteIDs)
+	waitForNotes(t, local, append(remoteIDs[:25:25], localIDs...))
+
+	srv := httptest.NewServer(websocket.Handler(ClientHandler))
+	defer srv.Close()
+	relayURL := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	pool := core.NewRelayPool(core.DefaultConfig())
+	defer pool.Close()
+	if err := pool.Connect(relayURL); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+
+	stats, err := syncWithRelay(ctx, local, pool, relayURL, nostr.Filter{}, false)
+	if err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	if stats.remoteOnly != len(missing) {
+		t.Errorf("remoteOnly = %d, want %d", stats.remoteOnly, len(missing))
+	}
+	if stats.localOnly != len(localIDs) {
+		t.Errorf("localOnly = %d, want %d", stats.localOnly, len(localIDs))
+	}
+	if stats.imported != len(missing) || stats.invalid != 0 || stats.unavailable != 0 {
+		t.Errorf("imported=%d invalid=%d unavailable=%d, want %d/0/0",
+			stats.imported, stats.invalid, stats.unavailable, len(missing))
+	}
+	waitForNotes(t, local, missing)
+
+	// A second pass has nothing left to fetch.
+	stats, err = syncWithRelay(ctx, local, pool, relayURL, nostr.Filter{}, false)
+	if err != nil {
+		t.Fatalf("second sync: %v", err)
+	}
+	if stats.remoteOnly != 0 || stats.imported != 0 {
+		t.Errorf("second sync fetched %d (imported %d), want 0", stats.remoteOnly, stats.imported)
+	}
+}
+
+func TestParseSyncFilter(t *testing.T) {
+	f, err := parseSyncFilter(`{"kinds":[0,1],"authors":["abc"],"#t":["nostr"],"since":1700000000}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Kinds) != 2 || f.Kinds[1] != 1 {
+		t.Errorf("kinds = %v", f.Kinds)
+	}
+	if len(f.Authors) != 1 || f.Tags["t"][0] != "nostr" {
+		t.Errorf("authors=%v tags=%v", f.Authors, f.Tags)
+	}
+	if f.Since == nil || f.Since.Unix() != 1700000000 {
+		t.Errorf("since = %v", f.Since)
+	}
+
+	if _, err := parseSyncFilter(`{"kinds":`); err == nil {
+		t.Error("expected error for malformed JSON")
+	}
+	if f, err := parseSyncFilter(""); err != nil || len(f.Kinds) != 0 {
+		t.Errorf("empty filter: %v %v", f, err)
+	}
+}
@@ -0,0 +1,375 @@
+// Package tombstone remembers what NIP-09 deletion requests (kind 5)
+// deleted, so a deleted event sent again — by another client, a
+// backup relay, --import or --sync — is turned away instead of coming
+// back. nostrdb.ProcessDeletion removes the events themselves; this is
+// the record that they're gone.
+//
+// A tombstone is either an event id (an "e" tag) or an address (an
+// "a" tag, "kind:pubkey:d"), with the deletion's author and
+// created_at. As NIP-09 has it, an id only stays deleted for its own
+// author, and an address only up to the deletion's created_at: a
+// newer version published afterwards is a new event.
+//
+// Tombstones are appended to tombstones.jsonl in the data directory,
+// one per line, and held in memory. Deletions only ever add to the
+// file, so it isn't rewritten on every change the way moderation.json
+// is; Open compacts it when superseded lines pile up.
+package tombstone
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/0ceanslim/grain/config"
+	nostr "github.com/0ceanslim/grain/server/types"
+	"github.com/0ceanslim/grain/server/utils/log"
+)
+
+// Reason is the NIP-01 OK message for a deleted event sent again.
+const Reason = "blocked: this event was deleted by its author"
+
+// KindDeletion is the NIP-09 deletion request kind.
+const KindDeletion = 5
+
+// compactMinLines is how long the file has to get before Open bothers
+// compacting it.
+const compactMinLines = 1024
+
+// Tombstone is one line of tombstones.jsonl.
+type Tombstone struct {
+	ID        string `json:"id,omitempty"`   // deleted event id
+	Addr      string `json:"addr,omitempty"` // deleted address, kind:pubkey:d
+	Pubkey    string `json:"pubkey"`         // who asked for the deletion
+	DeletedAt int64  `json:"deleted_at"`     // the deletion's created_at
+	By        string `json:"by"`             // the deletion's id
+}
+
+// idKey is an id tombstone's key. An id only stays deleted for the
+// author who deleted it, so anyone else's deletion of the same id is
+// kept apart rather than overwriting it.
+type idKey struct {
+	id     string
+	pubkey string
+}
+
+type idEntry struct {
+	deletedAt int64
+	by        string
+}
+
+type addrEntry struct {
+	deletedAt int64
+	by        string
+}
+
+// Store holds the tombstones.
+type Store struct {
+	mu    sync.RWMutex
+	path  string
+	file  *os.File
+	ids   map[idKey]idEntry
+	addrs map[string]addrEntry
+	fresh bool
+}
+
+// Open loads path (tombstones.jsonl) and keeps it open for appending.
+// A missing file is created; Fresh reports that, so the caller can
+// fill it from the deletions already stored.
+func Open(path string) (*Store, error) {
+	s := &Store{
+		path:  path,
+		ids:   make(map[idKey]idEntry),
+		addrs: make(map[string]addrEntry),
+	}
+
+	lines, bad, torn := 0, 0, false
+	f, err := os.Open(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		s.fresh = true
+	case err != nil:
+		return nil, fmt.Errorf("read tombstones: %w", err)
+	default:
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if len(scanner.Bytes()) == 0 {
+				continue
+			}
+			lines++
+			var t Tombstone
+			if err := json.Unmarshal(scanner.Bytes(), &t); err != nil {
+				bad++ // a line cut short by a crash
+				continue
+			}
+			s.apply(t)
+		}
+		err := scanner.Err()
+		if st, serr := f.Stat(); err == nil && serr == nil && st.Size() > 0 {
+			last := make([]byte, 1)
+			if _, rerr := f.ReadAt(last, st.Size()-1); rerr == nil && last[0] != '\n' {
+				torn = true
+			}
+		}
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("read tombstones %s: %w", path, err)
+		}
+	}
+
+	if live := s.Len(); lines > compactMinLines && lines > 2*live {
+		if err := s.compact(); err != nil {
+			return nil, err
+		}
+		log.Tombstone().Info("Tombstones compacted", "lines", lines, "kept", live)
+		torn = false
+	}
+
+	s.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open tombstones: %w", err)
+	}
+	if torn {
+		// End the cut-short line so the next one starts fresh.
+		if _, err := s.file.WriteString("\n"); err != nil {
+			s.file.Close()
+			return nil, fmt.Errorf("repair tombstones: %w", err)
+		}
+	}
+	log.Tombstone().Info("Tombstones loaded", "ids", len(s.ids), "addresses", len(s.addrs), "unreadable", bad)
+	return s, nil
+}
+
+// Fresh reports whether Open created the file.
+func (s *Store) Fresh() bool {
+	return s.fresh
+}
+
+// Len is the number of tombstones.
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.ids) + len(s.addrs)
+}
+
+// apply adds t to the maps and reports whether it changed anything: a
+// tombstone no newer than the one held already doesn't.
+func (s *Store) apply(t Tombstone) bool {
+	switch {
+	case t.ID != "":
+		key := idKey{id: t.ID, pubkey: t.Pubkey}
+		if cur, ok := s.ids[key]; ok && cur.deletedAt >= t.DeletedAt {
+			return false
+		}
+		s.ids[key] = idEntry{deletedAt: t.DeletedAt, by: t.By}
+		return true
+	case t.Addr != "":
+		if cur, ok := s.addrs[t.Addr]; ok && cur.deletedAt >= t.DeletedAt {
+			return false
+		}
+		s.addrs[t.Addr] = addrEntry{deletedAt: t.DeletedAt, by: t.By}
+		return true
+	}
+	return false
+}
+
+// compact rewrites the file with one line per tombstone.
+func (s *Store) compact() error {
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	for key, e := range s.ids {
+		if err := enc.Encode(Tombstone{ID: key.id, Pubkey: key.pubkey, DeletedAt: e.deletedAt, By: e.by}); err != nil {
+			return err
+		}
+	}
+	for addr, e := range s.addrs {
+		pubkey := strings.SplitN(addr, ":", 3)[1]
+		if err := enc.Encode(Tombstone{Addr: addr, Pubkey: pubkey, DeletedAt: e.deletedAt, By: e.by}); err != nil {
+			return err
+		}
+	}
+	return config.AtomicWriteFile(s.path, []byte(b.String()), 0644)
+}
+
+// Record keeps the tombstones a deletion request asks for: each "e"
+// tag, and each "a" tag naming one of the deleter's own addresses.
+// Call it once the deletion has been carried out, so a failed one
+// leaves nothing behind. It returns how many tombstones were new.
+func (s *Store) Record(evt nostr.Event) (int, error) {
+	if evt.Kind != KindDeletion {
+		return 0, nil
+	}
+	var add []Tombstone
+	for _, tag := range evt.Tags {
+		if len(tag) < 2 {
+			continue
+		}
+		switch tag[0] {
+		case "e":
+			if len(tag[1]) == 64 {
+				add = append(add, Tombstone{ID: tag[1], Pubkey: evt.PubKey, DeletedAt: evt.CreatedAt, By: evt.ID})
+			}
+		case "a":
+			// NIP-09: only the address's own author can delete it.
+			if addr, pubkey, ok := parseAddr(tag[1]); ok && pubkey == evt.PubKey {
+				add = append(add, Tombstone{Addr: addr, Pubkey: evt.PubKey, DeletedAt: evt.CreatedAt, By: evt.ID})
+			}
+		}
+	}
+	if len(add) == 0 {
+		return 0, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var buf []byte
+	n := 0
+	for _, t := range add {
+		if !s.apply(t) {
+			continue
+		}
+		line, err := json.Marshal(t)
+		if err != nil {
+			return n, err
+		}
+		buf = append(append(buf, line...), '\n')
+		n++
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	if s.file == nil {
+		return n, errors.New("tombstones are closed")
+	}
+	if _, err := s.file.Write(buf); err != nil {
+		return n, fmt.Errorf("write tombstones: %w", err)
+	}
+	log.Tombstone().Debug("Tombstones recorded", "deletion_id", evt.ID, "pubkey", evt.PubKey, "count", n)
+	return n, nil
+}
+
+// Deleted reports whether evt was deleted by a deletion request this
+// relay has seen. Deletion requests themselves can't be deleted.
+func (s *Store) Deleted(evt nostr.Event) bool {
+	if evt.Kind == KindDeletion {
+		return false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if e, ok := s.ids[idKey{id: evt.ID, pubkey: evt.PubKey}]; ok && evt.CreatedAt <= e.deletedAt {
+		return true
+	}
+	if addr := addrOf(evt); addr != "" {
+		if e, ok := s.addrs[addr]; ok && evt.CreatedAt <= e.deletedAt {
+			return true
+		}
+	}
+	return false
+}
+
+// Close syncs and closes the file.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Sync()
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	s.file = nil
+	return err
+}
+
+func isReplaceable(kind int) bool {
+	return kind == 0 || kind == 3 || (kind >= 10000 && kind < 20000)
+}
+
+func isAddressable(kind int) bool {
+	return kind >= 30000 && kind < 40000
+}
+
+// parseAddr normalizes an "a" tag value. A replaceable kind has no d
+// part; whatever follows its pubkey is dropped.
+func parseAddr(coord string) (addr, pubkey string, ok bool) {
+	parts := strings.SplitN(coord, ":", 3)
+	if len(parts) != 3 || len(parts[1]) != 64 {
+		return "", "", false
+	}
+	kind, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return "", "", false
+	}
+	switch {
+	case isAddressable(kind):
+		return parts[0] + ":" + parts[1] + ":" + parts[2], parts[1], true
+	case isReplaceable(kind):
+		return parts[0] + ":" + parts[1] + ":", parts[1], true
+	}
+	return "", "", false
+}
+
+// addrOf is evt's address, or "" for a kind that has none.
+func addrOf(evt nostr.Event) string {
+	switch {
+	case isReplaceable(evt.Kind):
+		return strconv.Itoa(evt.Kind) + ":" + evt.PubKey + ":"
+	case isAddressable(evt.Kind):
+		d := ""
+		for _, tag := range evt.Tags {
+			if len(tag) >= 2 && tag[0] == "d" {
+				d = tag[1]
+				break
+			}
+		}
+		return strconv.Itoa(evt.Kind) + ":" + evt.PubKey + ":" + d
+	}
+	return ""
+}
+
+var (
+	active   *Store
+	activeMu sync.RWMutex
+)
+
+// SetStore installs the instance-wide store (nil to clear) and
+// returns the previous one.
+func SetStore(s *Store) *Store {
+	activeMu.Lock()
+	defer activeMu.Unlock()
+	prev := active
+	active = s
+	return prev
+}
+
+func current() *Store {
+	activeMu.RLock()
+	defer activeMu.RUnlock()
+	return active
+}
+
+// Record records evt's tombstones on the active store, if any. A
+// failure to write is logged: the deletion goes ahead regardless.
+func Record(evt nostr.Event) {
+	s := current()
+	if s == nil {
+		return
+	}
+	if _, err := s.Record(evt); err != nil {
+		log.Tombstone().Error("Failed to record tombstones", "deletion_id", evt.ID, "error", err)
+	}
+}
+
+// Deleted asks the active store; with none, nothing is.
+func Deleted(evt nostr.Event) bool {
+	if s := current(); s != nil {
+		return s.Deleted(evt)
+	}
+	return false
+}
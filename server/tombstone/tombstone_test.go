@@ -0,0 +1,146 @@
+package tombstone
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	nostr "github.com/0ceanslim/grain/server/types"
+)
+
+var (
+	alice = strings.Repeat("a", 64)
+	bob   = strings.Repeat("b", 64)
+	noteA = strings.Repeat("1", 64)
+	noteB = strings.Repeat("2", 64)
+)
+
+func deletion(pubkey string, createdAt int64, tags ...[]string) nostr.Event {
+	return nostr.Event{ID: strings.Repeat("d", 64), PubKey: pubkey, Kind: KindDeletion, CreatedAt: createdAt, Tags: tags}
+}
+
+func open(t *testing.T, path string) *Store {
+	t.Helper()
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestDeletedByID(t *testing.T) {
+	s := open(t, filepath.Join(t.TempDir(), "tombstones.jsonl"))
+	if !s.Fresh() {
+		t.Fatal("a new file should be fresh")
+	}
+	if n, err := s.Record(deletion(alice, 100, []string{"e", noteA}, []string{"e", "short"})); n != 1 || err != nil {
+		t.Fatalf("Record = %d, %v", n, err)
+	}
+
+	cases := []struct {
+		name string
+		evt  nostr.Event
+		want bool
+	}{
+		{"deleted", nostr.Event{ID: noteA, PubKey: alice, Kind: 1, CreatedAt: 90}, true},
+		{"someone else's id", nostr.Event{ID: noteA, PubKey: bob, Kind: 1, CreatedAt: 90}, false},
+		{"newer than the deletion", nostr.Event{ID: noteA, PubKey: alice, Kind: 1, CreatedAt: 101}, false},
+		{"never deleted", nostr.Event{ID: noteB, PubKey: alice, Kind: 1, CreatedAt: 90}, false},
+		{"deletions stay", nostr.Event{ID: noteA, PubKey: alice, Kind: KindDeletion, CreatedAt: 90}, false},
+	}
+	for _, c := range cases {
+		if got := s.Deleted(c.evt); got != c.want {
+			t.Errorf("%s: Deleted = %v, want %v", c.name, got, c.want)
+		}
+	}
+
+	// Bob naming alice's note in a deletion of his own, later, can't
+	// lift her tombstone.
+	if n, err := s.Record(deletion(bob, 200, []string{"e", noteA})); n != 1 || err != nil {
+		t.Fatalf("Record bob = %d, %v", n, err)
+	}
+	if !s.Deleted(nostr.Event{ID: noteA, PubKey: alice, Kind: 1, CreatedAt: 90}) {
+		t.Error("bob's deletion of alice's note replaced her tombstone")
+	}
+}
+
+func TestDeletedByAddress(t *testing.T) {
+	s := open(t, filepath.Join(t.TempDir(), "tombstones.jsonl"))
+	s.Record(deletion(alice, 100,
+		[]string{"a", "30023:" + alice + ":post"},
+		[]string{"a", "0:" + alice + ":"},
+		[]string{"a", "30023:" + bob + ":post"}, // not alice's to delete
+	))
+
+	article := func(pubkey, d string, at int64) nostr.Event {
+		return nostr.Event{ID: noteA, PubKey: pubkey, Kind: 30023, CreatedAt: at, Tags: [][]string{{"d", d}}}
+	}
+	if !s.Deleted(article(alice, "post", 100)) {
+		t.Error("article at the deleted address should be blocked")
+	}
+	if s.Deleted(article(alice, "post", 101)) {
+		t.Error("a newer version of the article should be let in")
+	}
+	if s.Deleted(article(alice, "other", 50)) {
+		t.Error("a different d tag is a different address")
+	}
+	if s.Deleted(article(bob, "post", 50)) {
+		t.Error("a deletion of someone else's address must be ignored")
+	}
+	if !s.Deleted(nostr.Event{ID: noteB, PubKey: alice, Kind: 0, CreatedAt: 99}) {
+		t.Error("replaceable profile at the deleted address should be blocked")
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tombstones.jsonl")
+	s := open(t, path)
+	s.Record(deletion(alice, 100, []string{"e", noteA}))
+	s.Record(deletion(alice, 200, []string{"e", noteA})) // supersedes
+	s.Record(deletion(alice, 150, []string{"e", noteA})) // older: not written
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, _ := os.ReadFile(path)
+	if lines := strings.Count(string(raw), "\n"); lines != 2 {
+		t.Fatalf("file has %d lines, want 2:\n%s", lines, raw)
+	}
+
+	// A line cut short by a crash is skipped.
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	f.WriteString(`{"id":"` + noteB)
+	f.Close()
+
+	s = open(t, path)
+	if s.Fresh() || s.Len() != 1 {
+		t.Fatalf("reopened: fresh=%v len=%d", s.Fresh(), s.Len())
+	}
+	if !s.Deleted(nostr.Event{ID: noteA, PubKey: alice, Kind: 1, CreatedAt: 180}) {
+		t.Fatal("the newest deletion should have been reloaded")
+	}
+
+	// The next line doesn't get glued onto the torn one.
+	s.Record(deletion(bob, 300, []string{"e", noteB}))
+	s.Close()
+	s = open(t, path)
+	if s.Len() != 2 || !s.Deleted(nostr.Event{ID: noteB, PubKey: bob, Kind: 1, CreatedAt: 1}) {
+		t.Fatalf("record after a torn line was lost: len=%d", s.Len())
+	}
+}
+
+func TestGlobal(t *testing.T) {
+	evt := nostr.Event{ID: noteA, PubKey: alice, Kind: 1, CreatedAt: 1}
+	if Deleted(evt) {
+		t.Fatal("nothing is deleted without a store")
+	}
+	s := open(t, filepath.Join(t.TempDir(), "tombstones.jsonl"))
+	SetStore(s)
+	t.Cleanup(func() { SetStore(nil) })
+	Record(deletion(alice, 100, []string{"e", noteA}))
+	if !Deleted(evt) {
+		t.Fatal("package Record/Deleted didn't use the installed store")
+	}
+}
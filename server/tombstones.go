@@ -0,0 +1,67 @@
+package server
+
+import (
+	"slices"
+	"time"
+
+	"github.com/0ceanslim/grain/config"
+	"github.com/0ceanslim/grain/server/db/nostrdb"
+	"github.com/0ceanslim/grain/server/tombstone"
+	nostr "github.com/0ceanslim/grain/server/types"
+	"github.com/0ceanslim/grain/server/utils/log"
+)
+
+// openTombstones opens <data-dir>/tombstones.jsonl and installs it.
+// The first time, it's filled from the deletion requests already in
+// db, so deletions from before tombstones existed stick too. The
+// relay, --import and --sync all go through here.
+func openTombstones(db *nostrdb.NDB) (*tombstone.Store, error) {
+	s, err := tombstone.Open(config.ConfigPath("tombstones.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	if s.Fresh() && db != nil {
+		start := time.Now()
+		deletions, err := db.Export(nostr.Filter{Kinds: []int{tombstone.KindDeletion}}, func(evt nostr.Event) error {
+			_, err := s.Record(evt)
+			return err
+		})
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		log.Tombstone().Info("Tombstones built from stored deletions",
+			"deletions", deletions,
+			"tombstones", s.Len(),
+			"took", time.Since(start).Round(time.Millisecond))
+	}
+	tombstone.SetStore(s)
+	return s, nil
+}
+
+// closeTombstones uninstalls and closes the store.
+func closeTombstones() {
+	if s := tombstone.SetStore(nil); s != nil {
+		if err := s.Close(); err != nil {
+			log.Tombstone().Error("Failed to close tombstones", "error", err)
+		}
+	}
+}
+
+// deletionFilter is what an export or sync of filter also has to
+// carry for deletions to reach the other side: the same authors'
+// deletion requests, since onwards (a deletion is newer than what it
+// deletes). ok is false when filter takes them all in already. Some
+// may match both; filter.MatchesEvent tells which.
+func deletionFilter(filter nostr.Filter) (nostr.Filter, bool) {
+	narrowed := len(filter.IDs) > 0 || len(filter.Tags) > 0 || len(filter.AndTags) > 0 || filter.Until != nil || filter.Search != "" ||
+		(len(filter.Kinds) > 0 && !slices.Contains(filter.Kinds, tombstone.KindDeletion))
+	if !narrowed {
+		return nostr.Filter{}, false
+	}
+	return nostr.Filter{
+		Kinds:   []int{tombstone.KindDeletion},
+		Authors: filter.Authors,
+		Since:   filter.Since,
+	}, true
+}
@@ -11,6 +11,7 @@ type Filter struct {
 	Authors []string            `json:"authors,omitempty"`
 	Kinds   []int               `json:"kinds,omitempty"`
 	Tags    map[string][]string `json:"#,omitempty"` // Fixed: should be "#" for tag filters
+	AndTags map[string][]string `json:"&,omitempty"` // NIP-119: "&t" tag filters, every value required
 	Since   *time.Time          `json:"since,omitempty"`
 	Until   *time.Time          `json:"until,omitempty"`
 	Limit   *int                `json:"limit,omitempty"`
@@ -77,9 +78,14 @@ func (f Filter) MatchesEvent(evt Event) bool {
 	// our own check here for live (post-EOSE) search subscriptions.
 	// This is a substring match, not the tokenized AND-of-words match
 	// nostrdb does at REQ time; consistent with NIP-50's "implementation-
-	// defined" search semantics.
+	// defined" search semantics. Of the search's extensions only
+	// language: can be checked on the event alone.
 	if f.Search != "" {
-		if !strings.Contains(strings.ToLower(evt.Content), strings.ToLower(f.Search)) {
+		q := ParseSearch(f.Search)
+		if q.Terms != "" && !strings.Contains(strings.ToLower(evt.Content), strings.ToLower(q.Terms)) {
+			return false
+		}
+		if q.Language != "" && !HasLanguage(evt, q.Language) {
 			return false
 		}
 	}
@@ -109,6 +115,26 @@ func (f Filter) MatchesEvent(evt Event) bool {
 		}
 	}
 
+	return f.MatchesAndTags(evt)
+}
+
+// MatchesAndTags reports whether evt carries every value of every
+// NIP-119 "&" tag filter.
+func (f Filter) MatchesAndTags(evt Event) bool {
+	for tagName, filterValues := range f.AndTags {
+		for _, fv := range filterValues {
+			found := false
+			for _, tag := range evt.Tags {
+				if len(tag) >= 2 && tag[0] == tagName && tag[1] == fv {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+	}
 	return true
 }
 
@@ -130,6 +156,9 @@ func (f Filter) ToSubscriptionFilter() map[string]interface{} {
 			filter["#"+key] = value
 		}
 	}
+	for key, value := range f.AndTags {
+		filter["&"+key] = value
+	}
 	if f.Since != nil {
 		filter["since"] = f.Since.Unix()
 	}
@@ -0,0 +1,75 @@
+package relay
+
+import "strings"
+
+// SearchQuery is a NIP-50 search string split into the words to look
+// for and the key:value extensions among them.
+type SearchQuery struct {
+	Terms       string   // the search string without its extensions
+	Language    string   // language:<ISO 639-1 code>
+	Domain      string   // domain:<NIP-05 domain of the author>
+	IncludeSpam bool     // include:spam
+	Oldest      bool     // sort:old, oldest first instead of newest
+	Ignored     []string // extensions the relay can't apply, as given
+}
+
+// searchExtensions are the extension keys ParseSearch takes out of a
+// search string: NIP-50's, and sort:. A word with any other key is
+// searched for like the rest.
+var searchExtensions = map[string]bool{
+	"include": true, "domain": true, "language": true, "sentiment": true, "nsfw": true, "sort": true,
+}
+
+// ParseSearch splits s into its terms and extensions. Extensions the
+// relay can't apply (sentiment:, nsfw:, sort: other than new or old,
+// malformed values) end up in Ignored rather than in Terms.
+func ParseSearch(s string) SearchQuery {
+	var q SearchQuery
+	var terms []string
+	for _, word := range strings.Fields(s) {
+		key, value, ok := strings.Cut(word, ":")
+		if !ok || value == "" || !searchExtensions[strings.ToLower(key)] {
+			terms = append(terms, word)
+			continue
+		}
+		value = strings.ToLower(value)
+		switch strings.ToLower(key) {
+		case "language":
+			if len(value) == 2 && strings.Trim(value, "abcdefghijklmnopqrstuvwxyz") == "" {
+				q.Language = value
+				continue
+			}
+		case "domain":
+			q.Domain = strings.TrimSuffix(value, ".")
+			continue
+		case "include":
+			if value == "spam" {
+				q.IncludeSpam = true
+				continue
+			}
+		case "sort":
+			if value == "new" || value == "old" {
+				q.Oldest = value == "old"
+				continue
+			}
+		}
+		q.Ignored = append(q.Ignored, word)
+	}
+	q.Terms = strings.Join(terms, " ")
+	return q
+}
+
+// LanguageLabelNamespace is the NIP-32 label namespace of ISO 639-1
+// language codes.
+const LanguageLabelNamespace = "ISO-639-1"
+
+// HasLanguage reports whether evt is labelled (NIP-32) as written in
+// the ISO 639-1 language code.
+func HasLanguage(evt Event, code string) bool {
+	for _, tag := range evt.Tags {
+		if len(tag) >= 3 && tag[0] == "l" && strings.EqualFold(tag[1], code) && tag[2] == LanguageLabelNamespace {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package relay
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSearch(t *testing.T) {
+	cases := []struct {
+		in   string
+		want SearchQuery
+	}{
+		{"best nostr apps", SearchQuery{Terms: "best nostr apps"}},
+		{"nostr language:EN", SearchQuery{Terms: "nostr", Language: "en"}},
+		{"domain:Example.com. relays", SearchQuery{Terms: "relays", Domain: "example.com"}},
+		{"include:spam sort:old zaps", SearchQuery{Terms: "zaps", IncludeSpam: true, Oldest: true}},
+		{"sort:new zaps", SearchQuery{Terms: "zaps"}},
+		{"sort:hot nsfw:false language:english zaps", SearchQuery{Terms: "zaps", Ignored: []string{"sort:hot", "nsfw:false", "language:english"}}},
+		// Not an extension: searched for as written.
+		{"https://example.com time:now", SearchQuery{Terms: "https://example.com time:now"}},
+		{"language:de", SearchQuery{Language: "de"}},
+	}
+	for _, c := range cases {
+		if got := ParseSearch(c.in); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("ParseSearch(%q) = %+v, want %+v", c.in, got, c.want)
+		}
+	}
+}
+
+func TestFilterMatches_AndTagsAndLanguage(t *testing.T) {
+	evt := Event{Kind: 1, Content: "GM nostr", Tags: [][]string{
+		{"t", "meme"}, {"t", "cat"}, {"L", LanguageLabelNamespace}, {"l", "en", LanguageLabelNamespace},
+	}}
+	cases := []struct {
+		f    Filter
+		want bool
+	}{
+		{Filter{AndTags: map[string][]string{"t": {"meme", "cat"}}}, true},
+		{Filter{AndTags: map[string][]string{"t": {"meme", "dog"}}}, false},
+		{Filter{AndTags: map[string][]string{"t": {"meme"}}, Tags: map[string][]string{"t": {"dog", "cat"}}}, true},
+		{Filter{AndTags: map[string][]string{"t": {"meme"}}, Tags: map[string][]string{"t": {"dog"}}}, false},
+		{Filter{Search: "gm language:en"}, true},
+		{Filter{Search: "gm language:de"}, false},
+		{Filter{Search: "language:en sort:hot"}, true},
+	}
+	for i, c := range cases {
+		if got := c.f.MatchesEvent(evt); got != c.want {
+			t.Errorf("case %d: MatchesEvent = %v, want %v", i, got, c.want)
+		}
+	}
+}
@@ -16,12 +16,17 @@ import (
 // auth requirement without introducing an import cycle into config.
 var AuthRequiredProvider func() bool
 
+// MinPowProvider likewise reports the configured NIP-13 minimum every
+// event needs, limitation.min_pow_difficulty.
+var MinPowProvider func() int
+
 type RelayMetadata struct {
 	Name           string `json:"name"`
 	Description    string `json:"description"`
 	Banner         string `json:"banner"`
 	Icon           string `json:"icon"`
 	Pubkey         string `json:"pubkey"`
+	Self           string `json:"self,omitempty"` // the relay's own pubkey (relay_key); filled in at serve time
 	Contact        string `json:"contact"`
 	SupportedNIPs  []int  `json:"supported_nips"`
 	Software       string `json:"software"`
@@ -33,6 +38,7 @@ type RelayMetadata struct {
 		MaxContentLength    int    `json:"max_content_length"`
 		MaxSubscriptions    int    `json:"max_subscriptions"`
 		MaxLimit            int    `json:"max_limit"`
+		MinPowDifficulty    int    `json:"min_pow_difficulty"`
 		AuthRequired        bool   `json:"auth_required"`
 		PaymentRequired     bool   `json:"payment_required"`
 		RestrictedWrites    bool   `json:"restricted_writes"`
@@ -379,6 +385,13 @@ func RelayInfoHandler(w http.ResponseWriter, r *http.Request) {
 	if AuthRequiredProvider != nil {
 		response.Limitation.AuthRequired = AuthRequiredProvider()
 	}
+	if MinPowProvider != nil {
+		response.Limitation.MinPowDifficulty = MinPowProvider()
+	}
+
+	if self := RelayPubkey(); self != "" {
+		response.Self = self
+	}
 
 	err := json.NewEncoder(w).Encode(response)
 	if err != nil {
@@ -28,6 +28,16 @@ func ClientData() *slog.Logger       { return GetLogger("client-data") }
 func ClientConnection() *slog.Logger { return GetLogger("client-connection") }
 func ClientSession() *slog.Logger    { return GetLogger("client-session") }
 func ClientCache() *slog.Logger      { return GetLogger("client-cache") }
+func Replication() *slog.Logger      { return GetLogger("replication") }
+func Policy() *slog.Logger           { return GetLogger("policy") }
+func Groups() *slog.Logger           { return GetLogger("groups") }
+func Audit() *slog.Logger            { return GetLogger("audit") }
+func Moderation() *slog.Logger       { return GetLogger("moderation") }
+func Backup() *slog.Logger           { return GetLogger("backup") }
+func Storage() *slog.Logger          { return GetLogger("storage") }
+func Quota() *slog.Logger            { return GetLogger("quota") }
+func Tombstone() *slog.Logger        { return GetLogger("tombstone") }
+func Search() *slog.Logger           { return GetLogger("search") }
 
 // GetAllComponents returns a slice of all component names used by the logger functions
 func GetAllComponents() []string {
@@ -57,5 +67,15 @@ func GetAllComponents() []string {
 		"client-connection", // ClientConnection()
 		"client-session",    // ClientSession()
 		"client-cache",      // ClientCache()
+		"replication",       // Replication()
+		"policy",            // Policy()
+		"groups",            // Groups()
+		"audit",             // Audit()
+		"moderation",        // Moderation()
+		"backup",            // Backup()
+		"storage",           // Storage()
+		"quota",             // Quota()
+		"tombstone",         // Tombstone()
+		"search",            // Search()
 	}
 }
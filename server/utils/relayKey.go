@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/0ceanslim/grain/client/core"
+	"github.com/0ceanslim/grain/server/utils/log"
+)
+
+// The relay's own keypair, used to sign events the relay authors
+// itself (NIP-29 group metadata). It's distinct from the owner pubkey
+// in relay_metadata.json: the owner is a person, this key lives on
+// the server. The secret is kept as hex in the data directory next to
+// relay_metadata.json, generated on first start, and the pubkey is
+// advertised as `self` in the NIP-11 document.
+
+var (
+	relayKey   *core.EventSigner
+	relayKeyMu sync.RWMutex
+)
+
+// LoadRelayKey reads the relay secret key from path, generating and
+// saving a new one (mode 0600) if the file doesn't exist yet.
+func LoadRelayKey(path string) (*core.EventSigner, error) {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		secret, err := core.GeneratePrivateKey()
+		if err != nil {
+			return nil, err
+		}
+		if err := suppressAndWrite(path, []byte(secret+"\n"), 0600); err != nil {
+			return nil, fmt.Errorf("write relay key: %w", err)
+		}
+		signer, err := core.NewEventSigner(secret)
+		if err != nil {
+			return nil, err
+		}
+		log.Util().Info("Generated relay signing key", "file", path, "pubkey", signer.GetPublicKey())
+		return signer, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read relay key: %w", err)
+	}
+
+	signer, err := core.NewEventSigner(strings.TrimSpace(string(raw)))
+	if err != nil {
+		return nil, fmt.Errorf("relay key %s: %w", path, err)
+	}
+	return signer, nil
+}
+
+// SetRelayKey installs the relay keypair for RelayKey and NIP-11.
+func SetRelayKey(signer *core.EventSigner) {
+	relayKeyMu.Lock()
+	defer relayKeyMu.Unlock()
+	relayKey = signer
+}
+
+// RelayKey returns the relay keypair, or nil if none was loaded.
+func RelayKey() *core.EventSigner {
+	relayKeyMu.RLock()
+	defer relayKeyMu.RUnlock()
+	return relayKey
+}
+
+// RelayPubkey returns the relay's own hex pubkey, or "".
+func RelayPubkey() string {
+	if signer := RelayKey(); signer != nil {
+		return signer.GetPublicKey()
+	}
+	return ""
+}
@@ -0,0 +1,80 @@
+package validation
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+	"strconv"
+
+	"github.com/0ceanslim/grain/config"
+	cfgType "github.com/0ceanslim/grain/config/types"
+	nostr "github.com/0ceanslim/grain/server/types"
+	"github.com/0ceanslim/grain/server/utils/log"
+)
+
+// PowDifficulty counts the leading zero bits of a hex event id, its
+// NIP-13 difficulty. An id that isn't hex has none.
+func PowDifficulty(id string) int {
+	b, err := hex.DecodeString(id)
+	if err != nil {
+		return 0
+	}
+	n := 0
+	for _, c := range b {
+		if c != 0 {
+			return n + bits.LeadingZeros8(c)
+		}
+		n += 8
+	}
+	return n
+}
+
+// PowTarget returns the difficulty an event's nonce tag commits to,
+// ["nonce", "<nonce>", "<target>"], or (0, false) without one.
+func PowTarget(evt nostr.Event) (int, bool) {
+	for _, tag := range evt.Tags {
+		if len(tag) >= 3 && tag[0] == "nonce" {
+			target, err := strconv.Atoi(tag[2])
+			if err != nil || target < 0 {
+				return 0, false
+			}
+			return target, true
+		}
+	}
+	return 0, false
+}
+
+// EventPow is the work an event can be credited with: its difficulty,
+// capped at the target it committed to. NIP-13 has the commitment so
+// a spammer mining for a low target who gets lucky with a long run of
+// zeros doesn't pass for having done more work.
+func EventPow(evt nostr.Event) int {
+	d := PowDifficulty(evt.ID)
+	if target, ok := PowTarget(evt); ok && target < d {
+		d = target
+	}
+	return d
+}
+
+// CheckPow enforces the pow section of the config: the global, per-kind
+// and non-whitelisted minimums, whichever is highest.
+func CheckPow(evt nostr.Event, cfg *cfgType.ServerConfig) Result {
+	p := cfg.Pow
+	if p.MinDifficulty == 0 && p.NonWhitelisted == 0 && len(p.Kinds) == 0 {
+		return Result{Valid: true}
+	}
+	need := p.Required(evt.Kind, config.IsPubKeyWhitelistedCached(evt.PubKey, true))
+	if need == 0 {
+		return Result{Valid: true}
+	}
+	if got := EventPow(evt); got < need {
+		log.Validation().Info("Event rejected: not enough proof of work",
+			"event_id", evt.ID,
+			"pubkey", evt.PubKey,
+			"kind", evt.Kind,
+			"difficulty", got,
+			"required", need)
+		return Result{Valid: false, Message: fmt.Sprintf("pow: difficulty %d is less than %d", got, need)}
+	}
+	return Result{Valid: true}
+}
@@ -0,0 +1,67 @@
+package validation
+
+import (
+	"strings"
+	"testing"
+
+	cfgType "github.com/0ceanslim/grain/config/types"
+	nostr "github.com/0ceanslim/grain/server/types"
+)
+
+func TestPowDifficulty(t *testing.T) {
+	for id, want := range map[string]int{
+		// NIP-13's example: 21 leading zero bits.
+		"000006d8c378af1779d2feebc7603a125d99eca0ccf1085959b307f64e5dd358": 21,
+		"ff" + strings.Repeat("0", 62):                                     0,
+		"01" + strings.Repeat("f", 62):                                     7,
+		"0f" + strings.Repeat("f", 62):                                     4,
+		strings.Repeat("0", 64):                                            256,
+		"not hex":                                                          0,
+	} {
+		if got := PowDifficulty(id); got != want {
+			t.Errorf("PowDifficulty(%s) = %d, want %d", id, got, want)
+		}
+	}
+}
+
+func TestEventPow_CommittedTarget(t *testing.T) {
+	id := "000006d8c378af1779d2feebc7603a125d99eca0ccf1085959b307f64e5dd358"
+	for name, c := range map[string]struct {
+		tags [][]string
+		want int
+	}{
+		"no nonce":        {nil, 21},
+		"target above":    {[][]string{{"nonce", "776797", "24"}}, 21},
+		"target below":    {[][]string{{"nonce", "776797", "12"}}, 12},
+		"no target":       {[][]string{{"nonce", "776797"}}, 21},
+		"malformed":       {[][]string{{"nonce", "776797", "lots"}}, 21},
+		"target the same": {[][]string{{"nonce", "776797", "21"}}, 21},
+	} {
+		if got := EventPow(nostr.Event{ID: id, Tags: c.tags}); got != c.want {
+			t.Errorf("%s: EventPow = %d, want %d", name, got, c.want)
+		}
+	}
+}
+
+func TestPowRequired(t *testing.T) {
+	p := cfgType.PowConfig{
+		MinDifficulty:  8,
+		NonWhitelisted: 20,
+		Kinds:          []cfgType.KindPowConfig{{Kind: 1, Difficulty: 16}, {Kind: 7, Difficulty: 24}},
+	}
+	for _, c := range []struct {
+		kind        int
+		whitelisted bool
+		want        int
+	}{
+		{0, true, 8},
+		{1, true, 16},
+		{1, false, 20},
+		{7, false, 24},
+		{7, true, 24},
+	} {
+		if got := p.Required(c.kind, c.whitelisted); got != c.want {
+			t.Errorf("Required(%d, %v) = %d, want %d", c.kind, c.whitelisted, got, c.want)
+		}
+	}
+}
@@ -115,3 +115,43 @@ func TestAPIEndpoints(t *testing.T) {
 		})
 	}
 }
+
+func TestMetricsEndpoint(t *testing.T) {
+	// Make sure at least one EVENT has been answered.
+	c := tests.NewTestClient(t)
+	defer c.Close()
+	evt := tests.NewTestKeypair().SignEvent(1, "metrics", nil)
+	c.SendEvent(evt)
+	c.ExpectOK(evt.ID, 3*time.Second)
+
+	resp, err := (&http.Client{Timeout: 5 * time.Second}).Get(tests.TestHTTPURL + "/metrics")
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Fatalf("Expected text/plain content-type, got '%s'", ct)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read response body: %v", err)
+	}
+
+	for _, want := range []string{
+		`grain_events_total{result="accepted",reason=""}`,
+		"# TYPE grain_req_duration_seconds histogram",
+		"# TYPE grain_db_query_duration_seconds histogram",
+		"grain_subscriptions ",
+		"grain_connections ",
+		`grain_ip_bans{type="permanent"}`,
+		"grain_expiration_tracked_events ",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics output is missing %q", want)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package integration
+
+import (
+	"testing"
+	"time"
+
+	"github.com/0ceanslim/grain/tests"
+)
+
+// NIP-119 (draft): "&t" filters need every value, where "#t" needs
+// any one. Topics are fresh random tokens so earlier runs can't match.
+
+func TestNIP119_AndTags(t *testing.T) {
+	kp := tests.NewTestKeypair()
+	c := tests.NewTestClient(t)
+	defer c.Close()
+
+	meme, cat, dog := uniqueToken(t), uniqueToken(t), uniqueToken(t)
+	both := kp.SignEvent(1, "meme and cat", [][]string{{"t", meme}, {"t", cat}})
+	memeOnly := kp.SignEvent(1, "meme", [][]string{{"t", meme}})
+	all := kp.SignEvent(1, "meme, cat and dog", [][]string{{"t", meme}, {"t", cat}, {"t", dog}})
+	publishOK(t, c, both)
+	publishOK(t, c, memeOnly)
+	publishOK(t, c, all)
+
+	sub := tests.RandomSubID()
+	c.Subscribe(sub, map[string]interface{}{"&t": []string{meme, cat}})
+	got := c.ExpectEOSE(sub, 3*time.Second)
+	if len(got) != 2 {
+		t.Fatalf("&t [meme cat]: expected 2 events, got %d", len(got))
+	}
+	for _, evt := range got {
+		if id, _ := evt["id"].(string); id == memeOnly.ID {
+			t.Errorf("&t [meme cat] matched the event tagged meme alone")
+		}
+	}
+
+	// AND and OR together: meme and cat, and any of [dog].
+	sub = tests.RandomSubID()
+	c.Subscribe(sub, map[string]interface{}{"&t": []string{meme, cat}, "#t": []string{dog}})
+	got = c.ExpectEOSE(sub, 3*time.Second)
+	if len(got) != 1 {
+		t.Fatalf("&t [meme cat] #t [dog]: expected 1 event, got %d", len(got))
+	}
+	if id, _ := got[0]["id"].(string); id != all.ID {
+		t.Errorf("&t [meme cat] #t [dog]: got %q", id)
+	}
+}
+
+func TestNIP119_AndTagsLive(t *testing.T) {
+	kp := tests.NewTestKeypair()
+	subscriber := tests.NewTestClient(t)
+	defer subscriber.Close()
+	publisher := tests.NewTestClient(t)
+	defer publisher.Close()
+
+	meme, cat := uniqueToken(t), uniqueToken(t)
+	sub := tests.RandomSubID()
+	subscriber.Subscribe(sub, map[string]interface{}{"&t": []string{meme, cat}})
+	subscriber.ExpectEOSE(sub, 3*time.Second)
+
+	memeOnly := kp.SignEvent(1, "meme", [][]string{{"t", meme}})
+	both := kp.SignEvent(1, "meme and cat", [][]string{{"t", meme}, {"t", cat}})
+	publishOK(t, publisher, memeOnly)
+	publishOK(t, publisher, both)
+
+	msg := subscriber.ReadMessage(3 * time.Second)
+	if len(msg) < 3 || msg[0] != "EVENT" {
+		t.Fatalf("expected a live EVENT, got %v", msg)
+	}
+	if evt, _ := msg[2].(map[string]interface{}); evt["id"] != both.ID {
+		t.Errorf("live &t delivered %v, want the event with both tags", evt["id"])
+	}
+}
@@ -0,0 +1,72 @@
+package integration
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/0ceanslim/grain/tests"
+)
+
+// Runs against grain-auth (port 8186), which has groups enabled.
+
+func TestNIP29_PrivateGroup(t *testing.T) {
+	admin := tests.NewTestKeypair()
+	member := tests.NewTestKeypair()
+	outsider := tests.NewTestKeypair()
+	group := fmt.Sprintf("it-%d", time.Now().UnixNano())
+
+	c := tests.NewTestClientAt(t, tests.AuthRelayURL)
+	defer c.Close()
+	send := func(kp *tests.TestKeypair, kind int, tags ...[]string) (string, bool, string) {
+		evt := kp.SignEvent(kind, "", append([][]string{{"h", group}}, tags...))
+		c.SendEvent(evt)
+		ok, reason := c.ExpectOK(evt.ID, 3*time.Second)
+		return evt.ID, ok, reason
+	}
+
+	if _, ok, reason := send(admin, 9007, []string{"private"}, []string{"closed"}); !ok {
+		t.Fatalf("create-group rejected: %s", reason)
+	}
+	if _, ok, _ := send(member, 9021); ok {
+		t.Fatal("join without an invite code accepted by a closed group")
+	}
+	if _, ok, reason := send(admin, 9000, []string{"p", member.PubKey}); !ok {
+		t.Fatalf("put-user rejected: %s", reason)
+	}
+	postID, ok, reason := send(member, 1)
+	if !ok {
+		t.Fatalf("member post rejected: %s", reason)
+	}
+	if _, ok, _ := send(outsider, 39000, []string{"d", group}); ok {
+		t.Fatal("user-signed group metadata accepted")
+	}
+
+	query := func(kp *tests.TestKeypair, filter map[string]interface{}) []map[string]interface{} {
+		r := tests.NewTestClientAt(t, tests.AuthRelayURL)
+		defer r.Close()
+		if ok, reason := r.PerformAuth(kp, tests.AuthRelayURL, 3*time.Second); !ok {
+			t.Fatalf("AUTH failed: %s", reason)
+		}
+		subID := tests.RandomSubID()
+		r.Subscribe(subID, filter)
+		return r.ExpectEOSE(subID, 3*time.Second)
+	}
+
+	posts := map[string]interface{}{"ids": []string{postID}}
+	if n := len(query(outsider, posts)); n != 0 {
+		t.Errorf("non-member got %d private group posts, want 0", n)
+	}
+	if n := len(query(member, posts)); n != 1 {
+		t.Errorf("member got %d private group posts, want 1", n)
+	}
+
+	members := query(member, map[string]interface{}{"kinds": []int{39002}, "#d": []string{group}})
+	if len(members) != 1 {
+		t.Fatalf("got %d member lists, want 1", len(members))
+	}
+	tags, _ := members[0]["tags"].([]interface{})
+	if len(tags) != 3 { // d + admin + member
+		t.Errorf("member list tags = %v", tags)
+	}
+}
@@ -1,7 +1,11 @@
 package integration
 
 import (
+	"encoding/binary"
+	"encoding/hex"
 	"fmt"
+	"math/bits"
+	"strconv"
 	"testing"
 	"time"
 
@@ -11,6 +15,15 @@ import (
 // expectCount reads frames until a COUNT for subID arrives, then returns
 // (count, approximate). Fails the test on timeout.
 func expectCount(t *testing.T, c *tests.TestClient, subID string, timeout time.Duration) (int, bool) {
+	t.Helper()
+	payload := expectCountPayload(t, c, subID, timeout)
+	cf, _ := payload["count"].(float64)
+	approx, _ := payload["approximate"].(bool)
+	return int(cf), approx
+}
+
+// expectCountPayload is expectCount's whole COUNT object.
+func expectCountPayload(t *testing.T, c *tests.TestClient, subID string, timeout time.Duration) map[string]interface{} {
 	t.Helper()
 	deadline := time.Now().Add(timeout)
 	for time.Now().Before(deadline) {
@@ -24,14 +37,12 @@ func expectCount(t *testing.T, c *tests.TestClient, subID string, timeout time.D
 				if !ok {
 					t.Fatalf("COUNT payload not an object: %v", msg[2])
 				}
-				cf, _ := payload["count"].(float64)
-				approx, _ := payload["approximate"].(bool)
-				return int(cf), approx
+				return payload
 			}
 		}
 	}
 	t.Fatalf("timeout waiting for COUNT %s", subID)
-	return 0, false
+	return nil
 }
 
 func sendCount(c *tests.TestClient, subID string, filters ...map[string]interface{}) {
@@ -85,28 +96,77 @@ func TestNIP45_CountEmptyResult(t *testing.T) {
 	}
 }
 
-func TestNIP45_CountMultiFilterApproximate(t *testing.T) {
+func TestNIP45_CountMultiFilterExact(t *testing.T) {
 	kp := tests.NewTestKeypair()
 	c := tests.NewTestClient(t)
 	defer c.Close()
 
-	evt := kp.SignEvent(1, "multifilter", nil)
-	c.SendEvent(evt)
-	if ok, reason := c.ExpectOK(evt.ID, 3*time.Second); !ok {
-		t.Fatalf("publish rejected: %q", reason)
+	for i, kind := range []int{1, 1, 7} {
+		evt := kp.SignEvent(kind, fmt.Sprintf("multifilter %d", i), nil)
+		c.SendEvent(evt)
+		if ok, reason := c.ExpectOK(evt.ID, 3*time.Second); !ok {
+			t.Fatalf("publish %d rejected: %q", i, reason)
+		}
 	}
 
+	// Both filters match the kind-1 notes; each counts once.
 	subID := tests.RandomSubID()
 	sendCount(c, subID,
 		map[string]interface{}{"authors": []string{kp.PubKey}},
-		map[string]interface{}{"kinds": []int{1}},
+		map[string]interface{}{"authors": []string{kp.PubKey}, "kinds": []int{1}},
 	)
 
 	count, approximate := expectCount(t, c, subID, 3*time.Second)
-	if count < 1 {
-		t.Fatalf("expected >=1 from multi-filter union, got %d", count)
+	if count != 3 || approximate {
+		t.Fatalf("multi-filter union: count %d approximate %v, want exactly 3", count, approximate)
 	}
-	if !approximate {
-		t.Errorf("expected approximate=true for multi-filter request, got exact")
+}
+
+func TestNIP45_CountHLL(t *testing.T) {
+	author := tests.NewTestKeypair()
+	c := tests.NewTestClient(t)
+	defer c.Close()
+
+	note := author.SignEvent(1, "react to me", nil)
+	c.SendEvent(note)
+	if ok, reason := c.ExpectOK(note.ID, 3*time.Second); !ok {
+		t.Fatalf("publish rejected: %q", reason)
+	}
+
+	// NIP-45: the offset is the note id's 33rd hex digit plus 8; each
+	// reactor's pubkey byte there picks a register, which holds the
+	// most leading zeros (plus one) in the 7 bytes after it.
+	offset, _ := strconv.ParseUint(note.ID[32:33], 16, 8)
+	want := make([]byte, 256)
+	for i := 0; i < 5; i++ {
+		reactor := tests.NewTestKeypair()
+		evt := reactor.SignEvent(7, "+", [][]string{{"e", note.ID}, {"p", author.PubKey}})
+		c.SendEvent(evt)
+		if ok, reason := c.ExpectOK(evt.ID, 3*time.Second); !ok {
+			t.Fatalf("reaction %d rejected: %q", i, reason)
+		}
+		pk, _ := hex.DecodeString(reactor.PubKey)
+		w := binary.BigEndian.Uint64(pk[offset : offset+8])
+		if zeros := uint8(bits.LeadingZeros64(w<<8)) + 1; zeros > want[pk[offset]] {
+			want[pk[offset]] = zeros
+		}
+	}
+
+	subID := tests.RandomSubID()
+	sendCount(c, subID, map[string]interface{}{"kinds": []int{7}, "#e": []string{note.ID}})
+
+	payload := expectCountPayload(t, c, subID, 3*time.Second)
+	if count, _ := payload["count"].(float64); count != 5 {
+		t.Fatalf("reaction count %v, want 5", payload["count"])
+	}
+	if got, _ := payload["hll"].(string); got != hex.EncodeToString(want) {
+		t.Fatalf("hll %q, want %q", got, hex.EncodeToString(want))
+	}
+
+	// No hll where NIP-45 doesn't define one.
+	subID = tests.RandomSubID()
+	sendCount(c, subID, map[string]interface{}{"authors": []string{author.PubKey}})
+	if payload := expectCountPayload(t, c, subID, 3*time.Second); payload["hll"] != nil {
+		t.Fatalf("unexpected hll for an authors filter: %v", payload["hll"])
 	}
 }
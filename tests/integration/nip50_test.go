@@ -5,11 +5,13 @@ import (
 	"testing"
 	"time"
 
+	nostr "github.com/0ceanslim/grain/server/types"
 	"github.com/0ceanslim/grain/tests"
 )
 
 // NIP-50 fulltext search. nostrdb indexes content for kinds 1 and
-// 30023 only — these tests use kind 1 throughout. Each test uses a
+// 30023 only — these tests use kind 1, bar the one for the kinds grain
+// indexes itself (search.indexed_kinds). Each test uses a
 // fresh random all-letters token so prior test runs (and the
 // surrounding tests in this file) can't leak matches via nostrdb's
 // tokenizer. A tokenizer that splits on letter↔digit boundaries
@@ -105,7 +107,7 @@ func TestNIP50_CombinedWithAuthor(t *testing.T) {
 }
 
 // Paging beyond nostrdb's MAX_TEXT_SEARCH_RESULTS=128 is handled by
-// pagedTextSearch in handlers/req.go (Until-cursor loop, same shape as
+// pagedTextSearch in handlers/search.go (Until-cursor loop, same shape as
 // CountFiltered and the expiration bootstrap). Verifying it
 // end-to-end requires the test client to consume >128 EVENT frames in
 // rapid succession, which the shared TestClient.ReadMessage helper
@@ -117,3 +119,102 @@ func TestNIP50_CombinedWithAuthor(t *testing.T) {
 //
 // Paging logic remains exercised at runtime by any production client
 // requesting more than 128 search matches.
+
+// publishOK sends evt and fails the test unless it's accepted.
+func publishOK(t *testing.T, c *tests.TestClient, evt nostr.Event) {
+	t.Helper()
+	c.SendEvent(evt)
+	if ok, reason := c.ExpectOK(evt.ID, 3*time.Second); !ok {
+		t.Fatalf("publish rejected: %q", reason)
+	}
+}
+
+func TestNIP50_LanguageExtension(t *testing.T) {
+	kp := tests.NewTestKeypair()
+	c := tests.NewTestClient(t)
+	defer c.Close()
+
+	tok := uniqueToken(t)
+	german := kp.SignEvent(1, "guten tag "+tok, [][]string{{"L", "ISO-639-1"}, {"l", "de", "ISO-639-1"}})
+	unlabelled := kp.SignEvent(1, "hello "+tok, nil)
+	publishOK(t, c, german)
+	publishOK(t, c, unlabelled)
+
+	sub := tests.RandomSubID()
+	c.Subscribe(sub, map[string]interface{}{"search": tok + " language:de"})
+	got := c.ExpectEOSE(sub, 3*time.Second)
+	if len(got) != 1 {
+		t.Fatalf("expected 1 match labelled de, got %d", len(got))
+	}
+	if id, _ := got[0]["id"].(string); id != german.ID {
+		t.Errorf("expected the labelled event, got %q", id)
+	}
+}
+
+func TestNIP50_UnsupportedExtensionsIgnored(t *testing.T) {
+	kp := tests.NewTestKeypair()
+	c := tests.NewTestClient(t)
+	defer c.Close()
+
+	tok := uniqueToken(t)
+	older := kp.SignEventAt(1, "first "+tok, nil, time.Now().Unix()-60)
+	newer := kp.SignEvent(1, "second "+tok, nil)
+	publishOK(t, c, older)
+	publishOK(t, c, newer)
+
+	// sentiment: and nsfw: can't be applied and include:spam changes
+	// nothing; none of them may be searched for as words.
+	sub := tests.RandomSubID()
+	c.Subscribe(sub, map[string]interface{}{"search": tok + " sentiment:positive nsfw:false include:spam"})
+	if got := c.ExpectEOSE(sub, 3*time.Second); len(got) != 2 {
+		t.Fatalf("expected both matches, got %d", len(got))
+	}
+
+	sub = tests.RandomSubID()
+	c.Subscribe(sub, map[string]interface{}{"search": tok + " sort:old"})
+	got := c.ExpectEOSE(sub, 3*time.Second)
+	if len(got) != 2 {
+		t.Fatalf("expected both matches, got %d", len(got))
+	}
+	if id, _ := got[0]["id"].(string); id != older.ID {
+		t.Errorf("sort:old: expected the older event first, got %q", id)
+	}
+}
+
+// Kind 0 isn't in nostrdb's fulltext index; the default test config
+// lists it in search.indexed_kinds, so grain indexes it itself.
+func TestNIP50_IndexedKinds(t *testing.T) {
+	kp := tests.NewTestKeypair()
+	c := tests.NewTestClient(t)
+	defer c.Close()
+
+	tok := uniqueToken(t)
+	now := time.Now().Unix()
+	profile := kp.SignEventAt(0, `{"name":"searcher","about":"runs a `+tok+` relay"}`, nil, now-10)
+	note := kp.SignEventAt(1, "a note about "+tok, nil, now-5)
+	publishOK(t, c, profile)
+	publishOK(t, c, note)
+
+	sub := tests.RandomSubID()
+	c.Subscribe(sub, map[string]interface{}{"kinds": []int{0}, "search": tok})
+	got := c.ExpectEOSE(sub, 3*time.Second)
+	if len(got) != 1 || got[0]["id"] != profile.ID {
+		t.Fatalf("kind 0 search: expected the profile, got %d events", len(got))
+	}
+
+	// Without kinds, both indexes are searched, newest first.
+	sub = tests.RandomSubID()
+	c.Subscribe(sub, map[string]interface{}{"search": tok})
+	got = c.ExpectEOSE(sub, 3*time.Second)
+	if len(got) != 2 || got[0]["id"] != note.ID || got[1]["id"] != profile.ID {
+		t.Fatalf("search of all kinds: expected the note then the profile, got %d events", len(got))
+	}
+
+	// A newer profile without the word replaces the old one.
+	publishOK(t, c, kp.SignEventAt(0, `{"name":"searcher","about":"gone quiet"}`, nil, now))
+	sub = tests.RandomSubID()
+	c.Subscribe(sub, map[string]interface{}{"kinds": []int{0}, "search": tok})
+	if got := c.ExpectEOSE(sub, 3*time.Second); len(got) != 0 {
+		t.Errorf("replaced profile still found: %d events", len(got))
+	}
+}
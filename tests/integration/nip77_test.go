@@ -0,0 +1,155 @@
+package integration
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/0ceanslim/grain/server/negentropy"
+	"github.com/0ceanslim/grain/tests"
+)
+
+// expectNeg reads frames until a NEG-MSG or NEG-ERR for subID arrives.
+// Returns (decoded message, "") or (nil, reason).
+func expectNeg(t *testing.T, c *tests.TestClient, subID string, timeout time.Duration) ([]byte, string) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		msg, err := c.TryReadMessage(time.Until(deadline))
+		if err != nil {
+			t.Fatalf("read failed waiting for NEG-MSG %s: %v", subID, err)
+		}
+		if len(msg) < 3 {
+			continue
+		}
+		if sid, _ := msg[1].(string); sid != subID {
+			continue
+		}
+		switch msg[0] {
+		case "NEG-MSG":
+			s, _ := msg[2].(string)
+			b, err := hex.DecodeString(s)
+			if err != nil {
+				t.Fatalf("NEG-MSG payload not hex: %v", err)
+			}
+			return b, ""
+		case "NEG-ERR":
+			reason, _ := msg[2].(string)
+			return nil, reason
+		}
+	}
+	t.Fatalf("timeout waiting for NEG-MSG %s", subID)
+	return nil, ""
+}
+
+func TestNIP77_ReconcileFindsMissing(t *testing.T) {
+	kp := tests.NewTestKeypair()
+	c := tests.NewTestClient(t)
+	defer c.Close()
+
+	// Publish five events; the "client side" of the sync will claim to
+	// hold only the first two plus one the relay has never seen.
+	var ids []string
+	var createdAt []int64
+	for i := 0; i < 5; i++ {
+		evt := kp.SignEvent(1, fmt.Sprintf("negentropy %d", i), nil)
+		c.SendEvent(evt)
+		if ok, reason := c.ExpectOK(evt.ID, 3*time.Second); !ok {
+			t.Fatalf("publish %d rejected: %q", i, reason)
+		}
+		ids = append(ids, evt.ID)
+		createdAt = append(createdAt, evt.CreatedAt)
+	}
+	local := kp.SignEvent(1, "only on the client", nil)
+
+	vec := negentropy.NewVector(3)
+	for i := 0; i < 2; i++ {
+		if err := vec.InsertHex(createdAt[i], ids[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := vec.InsertHex(local.CreatedAt, local.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := vec.Seal(); err != nil {
+		t.Fatal(err)
+	}
+	ne, err := negentropy.New(vec, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	initial, err := ne.Initiate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subID := tests.RandomSubID()
+	c.SendMessage([]interface{}{"NEG-OPEN", subID,
+		map[string]interface{}{"authors": []string{kp.PubKey}}, hex.EncodeToString(initial)})
+
+	need := make(map[string]bool)
+	have := make(map[string]bool)
+	for rounds := 0; ; rounds++ {
+		if rounds > 10 {
+			t.Fatal("reconciliation did not converge")
+		}
+		reply, reason := expectNeg(t, c, subID, 3*time.Second)
+		if reason != "" {
+			t.Fatalf("NEG-ERR: %s", reason)
+		}
+		next, h, n, err := ne.ReconcileWithIDs(reply)
+		if err != nil {
+			t.Fatalf("reconcile: %v", err)
+		}
+		for _, id := range h {
+			have[hex.EncodeToString(id[:])] = true
+		}
+		for _, id := range n {
+			need[hex.EncodeToString(id[:])] = true
+		}
+		if next == nil {
+			break
+		}
+		c.SendMessage([]interface{}{"NEG-MSG", subID, hex.EncodeToString(next)})
+	}
+	c.SendMessage([]interface{}{"NEG-CLOSE", subID})
+
+	for _, id := range ids[2:] {
+		if !need[id] {
+			t.Errorf("expected relay to report %s as missing on the client", id)
+		}
+	}
+	if len(need) != 3 {
+		t.Errorf("expected 3 needed ids, got %d", len(need))
+	}
+	if !have[local.ID] || len(have) != 1 {
+		t.Errorf("expected exactly the client-only id in have, got %v", have)
+	}
+}
+
+func TestNIP77_UnknownSession(t *testing.T) {
+	c := tests.NewTestClient(t)
+	defer c.Close()
+
+	subID := tests.RandomSubID()
+	c.SendMessage([]interface{}{"NEG-MSG", subID, "61"})
+
+	_, reason := expectNeg(t, c, subID, 3*time.Second)
+	if !tests.ContainsAny(reason, "closed:") {
+		t.Fatalf("expected closed: NEG-ERR for unknown session, got %q", reason)
+	}
+}
+
+func TestNIP77_BadHex(t *testing.T) {
+	c := tests.NewTestClient(t)
+	defer c.Close()
+
+	subID := tests.RandomSubID()
+	c.SendMessage([]interface{}{"NEG-OPEN", subID, map[string]interface{}{}, "not-hex"})
+
+	_, reason := expectNeg(t, c, subID, 3*time.Second)
+	if !tests.ContainsAny(reason, "invalid:") {
+		t.Fatalf("expected invalid: NEG-ERR for bad hex, got %q", reason)
+	}
+}
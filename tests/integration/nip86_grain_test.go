@@ -3,6 +3,7 @@ package integration
 import (
 	"encoding/json"
 	"net/http"
+	"strings"
 	"testing"
 
 	"github.com/0ceanslim/grain/tests"
@@ -292,6 +293,190 @@ func TestNIP86_GrainStatsOverview(t *testing.T) {
 	}
 }
 
+func TestNIP86_GrainStatsDatabase(t *testing.T) {
+	owner := tests.NewDeterministicKeypair(tests.NIP86OwnerSeed)
+	_, env := callNIP86(t, owner, "grain_stats_database", []any{map[string]any{"limit": 5}})
+	if env == nil || env.Error != "" {
+		t.Fatalf("unexpected envelope: %+v", env)
+	}
+	var stats struct {
+		DiskBytes          int64            `json:"disk_bytes"`
+		MapSizeBytes       int64            `json:"map_size_bytes"`
+		Indexes            []map[string]any `json:"indexes"`
+		Categories         map[string]any   `json:"categories"`
+		Kinds              []map[string]any `json:"kinds"`
+		TopAuthorsByEvents []map[string]any `json:"top_authors_by_events"`
+		TopAuthorsByBytes  []map[string]any `json:"top_authors_by_bytes"`
+	}
+	if err := json.Unmarshal(env.Result, &stats); err != nil {
+		t.Fatalf("decode: %v (raw %s)", err, env.Result)
+	}
+	if stats.DiskBytes <= 0 || stats.MapSizeBytes <= 0 {
+		t.Fatalf("expected disk and map sizes: %s", env.Result)
+	}
+	if stats.Indexes == nil || stats.Kinds == nil || stats.TopAuthorsByEvents == nil || stats.TopAuthorsByBytes == nil {
+		t.Fatalf("lists must be [], not null: %s", env.Result)
+	}
+	if len(stats.TopAuthorsByEvents) > 5 || len(stats.TopAuthorsByBytes) > 5 {
+		t.Fatalf("limit not applied: %s", env.Result)
+	}
+
+	_, env = callNIP86(t, owner, "grain_stats_database", []any{map[string]any{"limit": -1}})
+	if env == nil || env.Error == "" {
+		t.Fatalf("negative limit accepted: %+v", env)
+	}
+}
+
+func TestNIP86_GrainPreviewPurge(t *testing.T) {
+	owner := tests.NewDeterministicKeypair(tests.NIP86OwnerSeed)
+	payload := map[string]any{
+		"rules": []any{
+			map[string]any{"name": "articles", "kinds": []any{30023.0}, "forever": true},
+			map[string]any{"name": "notes", "kinds": []any{1.0}, "keep_days": 90.0},
+		},
+	}
+	_, env := callNIP86(t, owner, "grain_previewpurge", []any{payload})
+	if env == nil || env.Error != "" {
+		t.Fatalf("unexpected envelope: %+v", env)
+	}
+	var report struct {
+		DryRun bool  `json:"dry_run"`
+		Cutoff int64 `json:"cutoff"`
+		Rules  []struct {
+			Rule   string `json:"rule"`
+			Purged int    `json:"purged"`
+		} `json:"rules"`
+	}
+	if err := json.Unmarshal(env.Result, &report); err != nil {
+		t.Fatalf("decode: %v (raw %s)", err, env.Result)
+	}
+	if !report.DryRun || report.Cutoff == 0 {
+		t.Fatalf("expected a dry run with a cutoff: %s", env.Result)
+	}
+	if len(report.Rules) != 2 || report.Rules[0].Rule != "articles" || report.Rules[1].Rule != "notes" {
+		t.Fatalf("expected one entry per rule, in order: %s", env.Result)
+	}
+
+	bad := map[string]any{"rules": []any{map[string]any{"authors": "everyone", "keep_days": 1.0}}}
+	_, env = callNIP86(t, owner, "grain_previewpurge", []any{bad})
+	if env == nil || env.Error == "" {
+		t.Fatalf("invalid rule accepted: %+v", env)
+	}
+}
+
+func TestNIP86_GrainReplicationStatus(t *testing.T) {
+	owner := tests.NewDeterministicKeypair(tests.NIP86OwnerSeed)
+	_, env := callNIP86(t, owner, "grain_replicationstatus", nil)
+	if env == nil || env.Error != "" {
+		t.Fatalf("unexpected envelope: %+v", env)
+	}
+	// The test configs leave backup_relay disabled, so this is an
+	// empty list — but it must be a list, not null.
+	var targets []map[string]any
+	if err := json.Unmarshal(env.Result, &targets); err != nil {
+		t.Fatalf("decode: %v (raw %s)", err, env.Result)
+	}
+	if targets == nil {
+		t.Fatalf("expected [], got null")
+	}
+}
+
+func TestNIP86_GrainAuditLog(t *testing.T) {
+	owner := tests.NewDeterministicKeypair(tests.NIP86OwnerSeed)
+	// TEST-NET-3: nobody connects from here, so blocking it is safe.
+	const ip = "203.0.113.77"
+	_, env := callNIP86(t, owner, "blockip", []any{ip, "audit test"})
+	if env == nil || env.Error != "" {
+		t.Fatalf("blockip: %+v", env)
+	}
+	defer callNIP86(t, owner, "unblockip", []any{ip})
+
+	query := map[string]any{"method": "blockip", "signer": owner.PubKey, "limit": 1}
+	_, env = callNIP86(t, owner, "grain_auditlog", []any{query})
+	if env == nil || env.Error != "" {
+		t.Fatalf("grain_auditlog: %+v", env)
+	}
+	var entries []struct {
+		Source  string `json:"source"`
+		Method  string `json:"method"`
+		Signer  string `json:"signer"`
+		Section string `json:"section"`
+		Changes []struct {
+			Path  string `json:"path"`
+			Added []any  `json:"added"`
+		} `json:"changes"`
+	}
+	if err := json.Unmarshal(env.Result, &entries); err != nil {
+		t.Fatalf("decode: %v (raw %s)", err, env.Result)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("want 1 entry, got %d (raw %s)", len(entries), env.Result)
+	}
+	e := entries[0]
+	if e.Source != "nip86" || e.Method != "blockip" || e.Signer != owner.PubKey || e.Section != "config.yml:blacklist" {
+		t.Fatalf("unexpected entry: %+v", e)
+	}
+	found := false
+	for _, c := range e.Changes {
+		if c.Path == "permanent_blocked_ips" {
+			for _, v := range c.Added {
+				found = found || v == ip
+			}
+		}
+	}
+	if !found {
+		t.Fatalf("entry doesn't record %s being added: %+v", ip, e.Changes)
+	}
+}
+
+func TestNIP86_GrainAdminRoles(t *testing.T) {
+	owner := tests.NewDeterministicKeypair(tests.NIP86OwnerSeed)
+	mod := tests.NewDeterministicKeypair("grain-test-nip86-moderator")
+
+	if status, _ := callNIP86(t, mod, "supportedmethods", nil); status != http.StatusForbidden {
+		t.Fatalf("before grain_addadmin: expected 403, got %d", status)
+	}
+	_, env := callNIP86(t, owner, "grain_addadmin", []any{mod.PubKey, "moderator"})
+	if env == nil || env.Error != "" {
+		t.Fatalf("grain_addadmin: %+v", env)
+	}
+	defer callNIP86(t, owner, "grain_removeadmin", []any{mod.PubKey})
+
+	// supportedmethods answers for the caller's role.
+	_, env = callNIP86(t, mod, "supportedmethods", nil)
+	if env == nil || env.Error != "" {
+		t.Fatalf("moderator supportedmethods: %+v", env)
+	}
+	var methods []string
+	if err := json.Unmarshal(env.Result, &methods); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	has := map[string]bool{}
+	for _, m := range methods {
+		has[m] = true
+	}
+	if !has["banpubkey"] || !has["blockip"] || has["grain_updateratelimit"] || has["grain_addadmin"] {
+		t.Fatalf("moderator supportedmethods = %v", methods)
+	}
+
+	// Allowed: a read. Denied: a config write, as an envelope error.
+	if _, env = callNIP86(t, mod, "listbannedpubkeys", nil); env == nil || env.Error != "" {
+		t.Fatalf("moderator listbannedpubkeys: %+v", env)
+	}
+	_, env = callNIP86(t, mod, "grain_updateratelimit", []any{map[string]any{}})
+	if env == nil || !strings.HasPrefix(env.Error, "restricted:") {
+		t.Fatalf("moderator grain_updateratelimit: expected restricted error, got %+v", env)
+	}
+
+	_, env = callNIP86(t, owner, "grain_removeadmin", []any{mod.PubKey})
+	if env == nil || env.Error != "" {
+		t.Fatalf("grain_removeadmin: %+v", env)
+	}
+	if status, _ := callNIP86(t, mod, "supportedmethods", nil); status != http.StatusForbidden {
+		t.Fatalf("after grain_removeadmin: expected 403, got %d", status)
+	}
+}
+
 func TestNIP86_SupportedMethodsIncludesGrainExtensions(t *testing.T) {
 	owner := tests.NewDeterministicKeypair(tests.NIP86OwnerSeed)
 	_, env := callNIP86(t, owner, "supportedmethods", nil)
@@ -318,6 +503,20 @@ func TestNIP86_SupportedMethodsIncludesGrainExtensions(t *testing.T) {
 		"grain_whitelistconfig",
 		"grain_blacklistconfig",
 		"grain_stats_overview",
+		"grain_stats_database",
+		"grain_replicationstatus",
+		"grain_auditlog",
+		"grain_backup",
+		"grain_previewpurge",
+		"grain_listreports",
+		"grain_dismissreports",
+		"grain_listadmins",
+		"grain_addadmin",
+		"grain_removeadmin",
+		"banevent",
+		"allowevent",
+		"listbannedevents",
+		"listeventsneedingmoderation",
 	}
 	for _, want := range required {
 		found := false
@@ -334,3 +533,23 @@ func TestNIP86_SupportedMethodsIncludesGrainExtensions(t *testing.T) {
 }
 
 var _ = http.StatusOK // keep net/http import alive
+
+func TestNIP86_GrainBackup(t *testing.T) {
+	owner := tests.NewDeterministicKeypair(tests.NIP86OwnerSeed)
+	_, env := callNIP86(t, owner, "grain_backup", []any{map[string]any{"compact": true}})
+	if env == nil || env.Error != "" {
+		t.Fatalf("grain_backup: %+v", env)
+	}
+	var info struct {
+		Path      string `json:"path"`
+		Compact   bool   `json:"compact"`
+		SizeBytes int64  `json:"size_bytes"`
+		CreatedAt int64  `json:"created_at"`
+	}
+	if err := json.Unmarshal(env.Result, &info); err != nil {
+		t.Fatalf("decode: %v (raw %s)", err, env.Result)
+	}
+	if !strings.Contains(info.Path, "grain-backup-") || !info.Compact || info.SizeBytes <= 0 || info.CreatedAt == 0 {
+		t.Fatalf("backup result: %+v", info)
+	}
+}
@@ -0,0 +1,182 @@
+package integration
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0ceanslim/grain/tests"
+)
+
+// Event moderation against grain-nip86, which holds events matching
+// the ban word in nip86-blacklist.yml and queues reported events.
+
+type moderationEntry struct {
+	ID     string `json:"id"`
+	Reason string `json:"reason"`
+	Source string `json:"source"`
+}
+
+func listModeration(t *testing.T, owner *tests.TestKeypair, method string) map[string]moderationEntry {
+	t.Helper()
+	_, env := callNIP86(t, owner, method, nil)
+	if env == nil || env.Error != "" {
+		t.Fatalf("%s: %+v", method, env)
+	}
+	var entries []moderationEntry
+	if err := json.Unmarshal(env.Result, &entries); err != nil {
+		t.Fatalf("%s: decode: %v (raw %s)", method, err, env.Result)
+	}
+	out := make(map[string]moderationEntry, len(entries))
+	for _, e := range entries {
+		out[e.ID] = e
+	}
+	return out
+}
+
+func fetchByID(t *testing.T, id string) int {
+	t.Helper()
+	c := tests.NewTestClientAt(t, tests.NIP86RelayURL)
+	defer c.Close()
+	subID := tests.RandomSubID()
+	c.Subscribe(subID, map[string]interface{}{"ids": []string{id}})
+	return len(c.ExpectEOSE(subID, 3*time.Second))
+}
+
+func TestNIP86_HeldEventAllowThenBan(t *testing.T) {
+	owner := tests.NewDeterministicKeypair(tests.NIP86OwnerSeed)
+	author := tests.NewTestKeypair()
+
+	c := tests.NewTestClientAt(t, tests.NIP86RelayURL)
+	defer c.Close()
+	evt := author.SignEvent(1, fmt.Sprintf("grain-test-held-word %d", time.Now().UnixNano()), nil)
+	c.SendEvent(evt)
+	if ok, reason := c.ExpectOK(evt.ID, 3*time.Second); ok || !strings.HasPrefix(reason, "restricted:") {
+		t.Fatalf("ban-word event: ok=%v reason=%q, want held", ok, reason)
+	}
+	if n := fetchByID(t, evt.ID); n != 0 {
+		t.Fatalf("held event is readable before review")
+	}
+
+	queued, ok := listModeration(t, owner, "listeventsneedingmoderation")[evt.ID]
+	if !ok || queued.Source != "ban_word" {
+		t.Fatalf("held event not in the moderation queue: %+v", queued)
+	}
+
+	// allowevent stores it.
+	if _, env := callNIP86(t, owner, "allowevent", []any{evt.ID}); env == nil || env.Error != "" {
+		t.Fatalf("allowevent: %+v", env)
+	}
+	if n := fetchByID(t, evt.ID); n != 1 {
+		t.Fatalf("allowed event: got %d, want 1", n)
+	}
+	if _, still := listModeration(t, owner, "listeventsneedingmoderation")[evt.ID]; still {
+		t.Fatalf("allowed event still queued")
+	}
+
+	// banevent deletes it and keeps it out.
+	if _, env := callNIP86(t, owner, "banevent", []any{evt.ID, "test"}); env == nil || env.Error != "" {
+		t.Fatalf("banevent: %+v", env)
+	}
+	if n := fetchByID(t, evt.ID); n != 0 {
+		t.Fatalf("banned event still readable")
+	}
+	if b, ok := listModeration(t, owner, "listbannedevents")[evt.ID]; !ok || b.Reason != "test" {
+		t.Fatalf("banned event not listed: %+v", b)
+	}
+	c.SendEvent(evt)
+	if ok, reason := c.ExpectOK(evt.ID, 3*time.Second); ok || !strings.HasPrefix(reason, "blocked:") {
+		t.Fatalf("re-publish of banned event: ok=%v reason=%q", ok, reason)
+	}
+}
+
+func TestNIP86_ReportedEventQueued(t *testing.T) {
+	owner := tests.NewDeterministicKeypair(tests.NIP86OwnerSeed)
+	author := tests.NewTestKeypair()
+	reporter := tests.NewTestKeypair()
+
+	c := tests.NewTestClientAt(t, tests.NIP86RelayURL)
+	defer c.Close()
+	evt := author.SignEvent(1, fmt.Sprintf("reported note %d", time.Now().UnixNano()), nil)
+	c.SendEvent(evt)
+	if ok, reason := c.ExpectOK(evt.ID, 3*time.Second); !ok {
+		t.Fatalf("note rejected: %s", reason)
+	}
+	report := reporter.SignEvent(1984, "", [][]string{{"e", evt.ID, "spam"}, {"p", author.PubKey}})
+	c.SendEvent(report)
+	if ok, reason := c.ExpectOK(report.ID, 3*time.Second); !ok {
+		t.Fatalf("report rejected: %s", reason)
+	}
+
+	queued, ok := listModeration(t, owner, "listeventsneedingmoderation")[evt.ID]
+	if !ok || queued.Source != "report" || queued.Reason != "reported: spam" {
+		t.Fatalf("reported event not queued: %+v", queued)
+	}
+	// Flagged, not held: still readable until someone bans it.
+	if n := fetchByID(t, evt.ID); n != 1 {
+		t.Fatalf("reported event: got %d, want 1", n)
+	}
+	if _, env := callNIP86(t, owner, "allowevent", []any{evt.ID}); env == nil || env.Error != "" {
+		t.Fatalf("allowevent: %+v", env)
+	}
+	if _, still := listModeration(t, owner, "listeventsneedingmoderation")[evt.ID]; still {
+		t.Fatalf("allowed event still queued")
+	}
+}
+
+func TestNIP86_TrustedReportQuarantines(t *testing.T) {
+	owner := tests.NewDeterministicKeypair(tests.NIP86OwnerSeed)
+	author := tests.NewTestKeypair()
+
+	c := tests.NewTestClientAt(t, tests.NIP86RelayURL)
+	defer c.Close()
+	evt := author.SignEvent(1, fmt.Sprintf("quarantined note %d", time.Now().UnixNano()), nil)
+	c.SendEvent(evt)
+	if ok, reason := c.ExpectOK(evt.ID, 3*time.Second); !ok {
+		t.Fatalf("note rejected: %s", reason)
+	}
+
+	// The owner is an admin, so one report crosses
+	// quarantine_threshold: 1 and the note is taken down.
+	report := owner.SignEvent(1984, "", [][]string{{"e", evt.ID, "illegal"}, {"p", author.PubKey}})
+	c.SendEvent(report)
+	if ok, reason := c.ExpectOK(report.ID, 3*time.Second); !ok {
+		t.Fatalf("report rejected: %s", reason)
+	}
+	if n := fetchByID(t, evt.ID); n != 0 {
+		t.Fatalf("quarantined event still readable")
+	}
+
+	_, env := callNIP86(t, owner, "grain_listreports", []any{map[string]any{"quarantined": true}})
+	if env == nil || env.Error != "" {
+		t.Fatalf("grain_listreports: %+v", env)
+	}
+	var summaries []struct {
+		Target           string         `json:"target"`
+		TargetType       string         `json:"target_type"`
+		TrustedReporters int            `json:"trusted_reporters"`
+		Types            map[string]int `json:"types"`
+	}
+	if err := json.Unmarshal(env.Result, &summaries); err != nil {
+		t.Fatalf("decode: %v (raw %s)", err, env.Result)
+	}
+	found := false
+	for _, s := range summaries {
+		if s.Target == evt.ID {
+			found = s.TargetType == "event" && s.TrustedReporters == 1 && s.Types["illegal"] == 1
+		}
+	}
+	if !found {
+		t.Fatalf("quarantined event not in grain_listreports: %s", env.Result)
+	}
+
+	// Dismissing the reports puts it back.
+	if _, env := callNIP86(t, owner, "grain_dismissreports", []any{evt.ID}); env == nil || env.Error != "" {
+		t.Fatalf("grain_dismissreports: %+v", env)
+	}
+	if n := fetchByID(t, evt.ID); n != 1 {
+		t.Fatalf("dismissed event: got %d, want 1", n)
+	}
+}
@@ -0,0 +1,51 @@
+package integration
+
+import (
+	"testing"
+	"time"
+
+	"github.com/0ceanslim/grain/tests"
+)
+
+// Runs against grain-auth (port 8186), which has read_policy.dm_privacy
+// on: kind-4 DMs go only to an AUTHed author or p-tagged recipient.
+
+func TestReadPolicy_DMPrivacy(t *testing.T) {
+	alice := tests.NewTestKeypair()
+	bob := tests.NewTestKeypair()
+	carol := tests.NewTestKeypair()
+
+	pub := tests.NewTestClientAt(t, tests.AuthRelayURL)
+	defer pub.Close()
+	dm := alice.SignEvent(4, "ciphertext", [][]string{{"p", bob.PubKey}})
+	pub.SendEvent(dm)
+	if ok, reason := pub.ExpectOK(dm.ID, 3*time.Second); !ok {
+		t.Fatalf("DM rejected: %s", reason)
+	}
+
+	query := func(kp *tests.TestKeypair) int {
+		c := tests.NewTestClientAt(t, tests.AuthRelayURL)
+		defer c.Close()
+		if kp != nil {
+			if ok, reason := c.PerformAuth(kp, tests.AuthRelayURL, 3*time.Second); !ok {
+				t.Fatalf("AUTH failed: %s", reason)
+			}
+		}
+		subID := tests.RandomSubID()
+		c.Subscribe(subID, map[string]interface{}{"ids": []string{dm.ID}})
+		return len(c.ExpectEOSE(subID, 3*time.Second))
+	}
+
+	if n := query(nil); n != 0 {
+		t.Errorf("unauthenticated reader got %d DMs, want 0", n)
+	}
+	if n := query(carol); n != 0 {
+		t.Errorf("third party got %d DMs, want 0", n)
+	}
+	if n := query(bob); n != 1 {
+		t.Errorf("recipient got %d DMs, want 1", n)
+	}
+	if n := query(alice); n != 1 {
+		t.Errorf("author got %d DMs, want 1", n)
+	}
+}
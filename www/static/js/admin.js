@@ -179,6 +179,19 @@
         .map((s) => parseInt(s, 10))
         .filter((n) => Number.isFinite(n));
     }
+    if (shape === "json") {
+      // Free-form JSON for nested structures the list widgets can't
+      // express (backup_relay targets). Empty → null; unparseable
+      // text is sent as-is so the server's decode error surfaces in
+      // the save toast instead of being silently dropped.
+      const v = field.value.trim();
+      if (v === "") return null;
+      try {
+        return JSON.parse(v);
+      } catch (_) {
+        return v;
+      }
+    }
     return field.value;
   }
 
@@ -422,6 +435,11 @@
     }
     throw new Error("signer not connected — try again");
   }
+  // Read-only panels (audit log) sign their own queries.
+  window.adminEnsureSigner = ensureSigner;
+  // Sections that call a method with the unsaved form (the event
+  // purge preview) serialize it the same way Save does.
+  window.adminBlobFromForm = blobFromForm;
 
   async function saveSection(panel) {
     const id = panel.dataset.section;
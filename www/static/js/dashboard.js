@@ -691,12 +691,16 @@ const dashboardManager = {
             ${backupData.enabled ? "Enabled" : "Disabled"}
           </div>
           ${
-            backupData.enabled && backupData.url
-              ? `
-            <div class="text-xs text-text-secondary mt-1">${this.escapeHtml(
-              backupData.url
-            )}</div>
-          `
+            backupData.enabled
+              ? [
+                  ...(backupData.urls || []),
+                  ...(backupData.targets || []).map((t) => t.url + " (filtered)"),
+                ]
+                  .map(
+                    (u) =>
+                      `<div class="text-xs text-text-secondary mt-1">${this.escapeHtml(u)}</div>`
+                  )
+                  .join("")
               : ""
           }
         </div>